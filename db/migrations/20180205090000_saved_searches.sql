@@ -0,0 +1,20 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Saved searches let staff recall frequently used queries.  The category and
+-- folder are zero when the search wasn't scoped to them.
+CREATE TABLE saved_searches (
+  id integer not null primary key,
+  name text not null,
+  term text not null,
+  folder_search boolean not null,
+  category_id integer not null,
+  folder_id integer not null,
+  created_at datetime not null
+);
+
+CREATE INDEX saved_searches_created_at ON saved_searches (created_at);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE saved_searches;
@@ -0,0 +1,79 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE users (
+  id integer not null primary key,
+  login text not null,
+  name text not null,
+  email text not null,
+  created_at datetime not null,
+  last_login_at datetime
+);
+
+CREATE UNIQUE INDEX users_login ON users (login);
+
+-- Sessions tie a random token to a user (or to nobody, for anonymous
+-- sessions) and an expiration date.  Data is opaque to the database layer.
+CREATE TABLE sessions (
+  id integer not null primary key,
+  token text not null,
+  user_id integer not null,
+  data text not null,
+  created_at datetime not null,
+  expires_at datetime not null
+);
+
+CREATE UNIQUE INDEX sessions_token ON sessions (token);
+CREATE INDEX sessions_user_id ON sessions (user_id);
+CREATE INDEX sessions_expires_at ON sessions (expires_at);
+
+-- Saved searches and archive jobs can now be attributed to a user; zero means
+-- the record was created anonymously
+ALTER TABLE saved_searches ADD COLUMN user_id integer not null default 0;
+ALTER TABLE archive_jobs ADD COLUMN user_id integer not null default 0;
+CREATE INDEX saved_searches_user_id ON saved_searches (user_id);
+CREATE INDEX archive_jobs_user_id ON archive_jobs (user_id);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE sessions;
+DROP TABLE users;
+
+CREATE TABLE saved_searches_old (
+  id integer not null primary key,
+  name text not null,
+  term text not null,
+  folder_search boolean not null,
+  category_id integer not null,
+  folder_id integer not null,
+  created_at datetime not null
+);
+
+INSERT INTO saved_searches_old (id, name, term, folder_search, category_id,
+  folder_id, created_at)
+  SELECT id, name, term, folder_search, category_id, folder_id, created_at
+  FROM saved_searches;
+
+DROP TABLE saved_searches;
+ALTER TABLE saved_searches_old RENAME TO saved_searches;
+
+CREATE INDEX saved_searches_created_at ON saved_searches (created_at);
+
+CREATE TABLE archive_jobs_old (
+  id integer not null primary key,
+  created_at datetime not null,
+  next_attempt_at datetime not null,
+  files text not null,
+  notification_emails text not null,
+  processed boolean
+);
+
+INSERT INTO archive_jobs_old (id, created_at, next_attempt_at, files,
+  notification_emails, processed)
+  SELECT id, created_at, next_attempt_at, files, notification_emails, processed
+  FROM archive_jobs;
+
+DROP TABLE archive_jobs;
+ALTER TABLE archive_jobs_old RENAME TO archive_jobs;
+
+CREATE INDEX archive_jobs_created_at ON archive_jobs (created_at);
+CREATE INDEX archive_jobs_next_attempt_at ON archive_jobs (next_attempt_at);
@@ -0,0 +1,22 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Download events record each retrieval of a file, whether directly through
+-- the web app or as part of an archive request
+CREATE TABLE download_events (
+  id integer not null primary key,
+  file_id integer not null,
+  archive_job_id integer not null,
+  user_id integer not null,
+  email text not null,
+  kind text not null,
+  bytes integer not null,
+  created_at datetime not null
+);
+
+CREATE INDEX download_events_file_id ON download_events (file_id);
+CREATE INDEX download_events_created_at ON download_events (created_at);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE download_events;
@@ -0,0 +1,52 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Archive jobs get a status instead of a "processed" flag, and a place to
+-- store the error from the most recent failed attempt.  SQLite can't drop
+-- columns, so we rebuild the table.
+CREATE TABLE archive_jobs_new (
+  id integer not null primary key,
+  user_id integer not null default 0,
+  created_at datetime not null,
+  next_attempt_at datetime not null,
+  files text not null,
+  notification_emails text not null,
+  status text not null default 'pending',
+  last_error text not null default ''
+);
+
+INSERT INTO archive_jobs_new (id, user_id, created_at, next_attempt_at, files, notification_emails, status)
+  SELECT id, user_id, created_at, next_attempt_at, files, notification_emails,
+    CASE WHEN processed THEN 'succeeded' ELSE 'pending' END
+  FROM archive_jobs;
+
+DROP TABLE archive_jobs;
+ALTER TABLE archive_jobs_new RENAME TO archive_jobs;
+
+CREATE INDEX archive_jobs_created_at ON archive_jobs (created_at);
+CREATE INDEX archive_jobs_next_attempt_at ON archive_jobs (next_attempt_at);
+CREATE INDEX archive_jobs_user_id ON archive_jobs (user_id);
+CREATE INDEX archive_jobs_status ON archive_jobs (status);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE archive_jobs_old (
+  id integer not null primary key,
+  user_id integer not null default 0,
+  created_at datetime not null,
+  next_attempt_at datetime not null,
+  files text not null,
+  notification_emails text not null,
+  processed boolean
+);
+
+INSERT INTO archive_jobs_old (id, user_id, created_at, next_attempt_at, files, notification_emails, processed)
+  SELECT id, user_id, created_at, next_attempt_at, files, notification_emails, status = 'succeeded'
+  FROM archive_jobs;
+
+DROP TABLE archive_jobs;
+ALTER TABLE archive_jobs_old RENAME TO archive_jobs;
+
+CREATE INDEX archive_jobs_created_at ON archive_jobs (created_at);
+CREATE INDEX archive_jobs_next_attempt_at ON archive_jobs (next_attempt_at);
+CREATE INDEX archive_jobs_user_id ON archive_jobs (user_id);
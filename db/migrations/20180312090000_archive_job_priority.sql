@@ -0,0 +1,35 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Higher-priority jobs are processed first; jobs of equal priority are
+-- processed oldest first
+ALTER TABLE archive_jobs ADD COLUMN priority integer not null default 0;
+CREATE INDEX archive_jobs_priority ON archive_jobs (priority);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE archive_jobs_old (
+  id integer not null primary key,
+  user_id integer not null default 0,
+  created_at datetime not null,
+  next_attempt_at datetime not null,
+  files text not null,
+  notification_emails text not null,
+  status text not null default 'pending',
+  last_error text not null default '',
+  attempts integer not null default 0
+);
+
+INSERT INTO archive_jobs_old (id, user_id, created_at, next_attempt_at, files,
+  notification_emails, status, last_error, attempts)
+  SELECT id, user_id, created_at, next_attempt_at, files, notification_emails,
+    status, last_error, attempts
+  FROM archive_jobs;
+
+DROP TABLE archive_jobs;
+ALTER TABLE archive_jobs_old RENAME TO archive_jobs;
+
+CREATE INDEX archive_jobs_created_at ON archive_jobs (created_at);
+CREATE INDEX archive_jobs_next_attempt_at ON archive_jobs (next_attempt_at);
+CREATE INDEX archive_jobs_user_id ON archive_jobs (user_id);
+CREATE INDEX archive_jobs_status ON archive_jobs (status);
@@ -0,0 +1,26 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Folder totals hold the number of files and bytes beneath a folder
+-- (including all descendant folders) so we needn't scan the files table to
+-- tell users how big a folder is
+CREATE TABLE folder_totals (
+  id integer not null primary key,
+  folder_id integer not null,
+  file_count integer not null default 0,
+  byte_count integer not null default 0
+);
+CREATE UNIQUE INDEX folder_totals_folder_id ON folder_totals (folder_id);
+
+-- Backfill totals for everything indexed so far
+INSERT INTO folder_totals (folder_id, file_count, byte_count)
+  SELECT fo.id, COUNT(fi.id), COALESCE(SUM(fi.filesize), 0)
+  FROM folders fo
+  LEFT JOIN files fi ON fi.category_id = fo.category_id
+    AND substr(fi.public_path, 1, length(fo.public_path) + 1) = fo.public_path || '/'
+  GROUP BY fo.id;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE folder_totals;
@@ -0,0 +1,42 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Modification times come from inventories which include them; indexed times
+-- are set whenever the indexer writes a file record.  Files indexed before
+-- this migration have neither, and are stored with a zero time.
+ALTER TABLE files ADD COLUMN modified_at datetime not null default '0001-01-01 00:00:00+00:00';
+ALTER TABLE files ADD COLUMN indexed_at datetime not null default '0001-01-01 00:00:00+00:00';
+CREATE INDEX files_modified_at ON files (modified_at);
+CREATE INDEX files_indexed_at ON files (indexed_at);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE files_old (
+  id integer not null primary key,
+  category_id integer not null,
+  inventory_id integer not null,
+  folder_id integer not null,
+  depth integer not null,
+  archive_date text not null,
+  checksum text not null,
+  filesize integer not null,
+  name text not null,
+  full_path text not null,
+  public_path text not null
+);
+
+INSERT INTO files_old (id, category_id, inventory_id, folder_id, depth,
+  archive_date, checksum, filesize, name, full_path, public_path)
+  SELECT id, category_id, inventory_id, folder_id, depth, archive_date,
+    checksum, filesize, name, full_path, public_path
+  FROM files;
+
+DROP TABLE files;
+ALTER TABLE files_old RENAME TO files;
+
+CREATE INDEX files_public_path ON files (public_path);
+CREATE INDEX files_category_id ON files (category_id);
+CREATE INDEX files_folder_id ON files (folder_id);
+CREATE INDEX files_inventory_id ON files (inventory_id);
+CREATE INDEX files_depth ON files (depth);
+CREATE UNIQUE INDEX files_unique ON files (category_id, archive_date, public_path);
@@ -0,0 +1,28 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE saved_searches ADD COLUMN mode text not null default 'like';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE saved_searches_old (
+  id integer not null primary key,
+  name text not null,
+  term text not null,
+  folder_search boolean not null,
+  category_id integer not null,
+  folder_id integer not null,
+  created_at datetime not null,
+  user_id integer not null default 0
+);
+
+INSERT INTO saved_searches_old (id, name, term, folder_search, category_id,
+  folder_id, created_at, user_id)
+  SELECT id, name, term, folder_search, category_id, folder_id, created_at,
+    user_id
+  FROM saved_searches;
+
+DROP TABLE saved_searches;
+ALTER TABLE saved_searches_old RENAME TO saved_searches;
+
+CREATE INDEX saved_searches_created_at ON saved_searches (created_at);
+CREATE INDEX saved_searches_user_id ON saved_searches (user_id);
@@ -0,0 +1,40 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Finished jobs record when they finished (for the retention policy) and the
+-- name of the archive they produced, if any, so it can be cleaned up with the
+-- job
+ALTER TABLE archive_jobs ADD COLUMN finished_at datetime not null default '0001-01-01 00:00:00+00:00';
+ALTER TABLE archive_jobs ADD COLUMN archive_path text not null default '';
+UPDATE archive_jobs SET finished_at = created_at WHERE status IN ('succeeded', 'failed', 'cancelled');
+CREATE INDEX archive_jobs_finished_at ON archive_jobs (finished_at);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE archive_jobs_old (
+  id integer not null primary key,
+  user_id integer not null default 0,
+  created_at datetime not null,
+  next_attempt_at datetime not null,
+  files text not null,
+  notification_emails text not null,
+  status text not null default 'pending',
+  last_error text not null default '',
+  attempts integer not null default 0,
+  priority integer not null default 0
+);
+
+INSERT INTO archive_jobs_old (id, user_id, created_at, next_attempt_at, files,
+  notification_emails, status, last_error, attempts, priority)
+  SELECT id, user_id, created_at, next_attempt_at, files, notification_emails,
+    status, last_error, attempts, priority
+  FROM archive_jobs;
+
+DROP TABLE archive_jobs;
+ALTER TABLE archive_jobs_old RENAME TO archive_jobs;
+
+CREATE INDEX archive_jobs_created_at ON archive_jobs (created_at);
+CREATE INDEX archive_jobs_next_attempt_at ON archive_jobs (next_attempt_at);
+CREATE INDEX archive_jobs_user_id ON archive_jobs (user_id);
+CREATE INDEX archive_jobs_status ON archive_jobs (status);
+CREATE INDEX archive_jobs_priority ON archive_jobs (priority);
@@ -0,0 +1,71 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Restricted files and folders are only visible to staff.  A restricted
+-- folder restricts everything beneath it.
+ALTER TABLE files ADD COLUMN restricted boolean not null default 0;
+ALTER TABLE folders ADD COLUMN restricted boolean not null default 0;
+CREATE INDEX folders_restricted ON folders (restricted);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE files_old (
+  id integer not null primary key,
+  category_id integer not null,
+  inventory_id integer not null,
+  folder_id integer not null,
+  depth integer not null,
+  archive_date text not null,
+  checksum text not null,
+  filesize integer not null,
+  name text not null,
+  full_path text not null,
+  public_path text not null,
+  modified_at datetime not null default '0001-01-01 00:00:00+00:00',
+  indexed_at datetime not null default '0001-01-01 00:00:00+00:00',
+  extension text not null default '',
+  mime_type text not null default ''
+);
+
+INSERT INTO files_old (id, category_id, inventory_id, folder_id, depth,
+  archive_date, checksum, filesize, name, full_path, public_path, modified_at,
+  indexed_at, extension, mime_type)
+  SELECT id, category_id, inventory_id, folder_id, depth, archive_date,
+    checksum, filesize, name, full_path, public_path, modified_at, indexed_at,
+    extension, mime_type
+  FROM files;
+
+DROP TABLE files;
+ALTER TABLE files_old RENAME TO files;
+
+CREATE INDEX files_public_path ON files (public_path);
+CREATE INDEX files_category_id ON files (category_id);
+CREATE INDEX files_folder_id ON files (folder_id);
+CREATE INDEX files_inventory_id ON files (inventory_id);
+CREATE INDEX files_depth ON files (depth);
+CREATE UNIQUE INDEX files_unique ON files (category_id, archive_date, public_path);
+CREATE INDEX files_modified_at ON files (modified_at);
+CREATE INDEX files_indexed_at ON files (indexed_at);
+CREATE INDEX files_extension ON files (extension);
+CREATE INDEX files_mime_type ON files (mime_type);
+
+CREATE TABLE folders_old (
+  id integer not null primary key,
+  category_id integer not null,
+  folder_id integer not null,
+  depth integer not null,
+  name text not null,
+  public_path text not null
+);
+
+INSERT INTO folders_old (id, category_id, folder_id, depth, name, public_path)
+  SELECT id, category_id, folder_id, depth, name, public_path
+  FROM folders;
+
+DROP TABLE folders;
+ALTER TABLE folders_old RENAME TO folders;
+
+CREATE INDEX folders_public_path ON folders (public_path);
+CREATE INDEX folders_folder_id ON folders (folder_id);
+CREATE INDEX folders_depth ON folders (depth);
+CREATE UNIQUE INDEX folders_unique ON folders (category_id, public_path);
@@ -0,0 +1,18 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Category redirects remember the old names of renamed or merged categories so
+-- old URLs keep working and inventories using an old name find the right
+-- category
+CREATE TABLE category_redirects (
+  id integer not null primary key,
+  old_name text not null,
+  category_id integer not null
+);
+
+CREATE UNIQUE INDEX category_redirects_old_name ON category_redirects (old_name);
+CREATE INDEX category_redirects_category_id ON category_redirects (category_id);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE category_redirects;
@@ -0,0 +1,22 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Inventories remember their size and modification time when indexed so the
+-- indexer can tell when one has changed.  Inventories indexed before this
+-- migration have zero values, which the indexer fills in on its next run.
+ALTER TABLE inventories ADD COLUMN filesize integer not null default 0;
+ALTER TABLE inventories ADD COLUMN mod_time datetime not null default '0001-01-01 00:00:00+00:00';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE inventories_old (
+  id integer not null primary key,
+  path text not null
+);
+
+INSERT INTO inventories_old (id, path)
+  SELECT id, path
+  FROM inventories;
+
+DROP TABLE inventories;
+ALTER TABLE inventories_old RENAME TO inventories;
@@ -0,0 +1,22 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Inventories read from BagIt bags keep the bag's bag-info.txt metadata.  It's
+-- empty for every other kind of inventory.
+ALTER TABLE inventories ADD COLUMN bag_info text not null default '';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE inventories_old (
+  id integer not null primary key,
+  path text not null,
+  filesize integer not null default 0,
+  mod_time datetime not null default '0001-01-01 00:00:00+00:00'
+);
+
+INSERT INTO inventories_old (id, path, filesize, mod_time)
+  SELECT id, path, filesize, mod_time
+  FROM inventories;
+
+DROP TABLE inventories;
+ALTER TABLE inventories_old RENAME TO inventories;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Fixity checks record each time a file was re-hashed from the dark archive,
+-- and whether it still matched the checksum we have on record
+CREATE TABLE fixity_checks (
+  id integer not null primary key,
+  file_id integer not null,
+  checked_at datetime not null,
+  status text not null,
+  expected text not null default '',
+  actual text not null default '',
+  message text not null default ''
+);
+
+CREATE INDEX fixity_checks_file_id ON fixity_checks (file_id, checked_at);
+CREATE INDEX fixity_checks_status ON fixity_checks (status);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE fixity_checks;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Index progress checkpoints how far the indexer has gotten through an
+-- inventory, so an interrupted run can pick up where it left off.  Rows only
+-- exist while an inventory is partway done.
+CREATE TABLE index_progress (
+  id integer not null primary key,
+  inventory_id integer not null,
+  filesize integer not null,
+  mod_time datetime not null,
+  lines_done integer not null,
+  bytes_done integer not null,
+  updated_at datetime not null
+);
+
+CREATE UNIQUE INDEX index_progress_inventory_id ON index_progress (inventory_id);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE index_progress;
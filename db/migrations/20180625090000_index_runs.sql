@@ -0,0 +1,23 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Index runs summarize each pass the indexer makes over the dark archive
+CREATE TABLE index_runs (
+  id integer not null primary key,
+  started_at datetime not null,
+  finished_at datetime not null,
+  inventories_indexed integer not null,
+  inventories_skipped integer not null,
+  inventories_failed integer not null,
+  files_added integer not null,
+  files_updated integer not null,
+  files_skipped integer not null,
+  record_errors integer not null,
+  stopped boolean not null
+);
+
+CREATE INDEX index_runs_started_at ON index_runs (started_at);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE index_runs;
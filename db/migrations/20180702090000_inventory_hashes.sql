@@ -0,0 +1,26 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Inventories remember a SHA-256 hash of their contents so that a renamed or
+-- re-copied inventory isn't indexed twice.  An inventory found to duplicate
+-- another is recorded with the original's id so it isn't read again.
+ALTER TABLE inventories ADD COLUMN content_hash text not null default '';
+ALTER TABLE inventories ADD COLUMN duplicate_of integer not null default 0;
+CREATE INDEX inventories_content_hash ON inventories (content_hash);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE inventories_old (
+  id integer not null primary key,
+  path text not null,
+  filesize integer not null default 0,
+  mod_time datetime not null default '0001-01-01 00:00:00+00:00',
+  bag_info text not null default ''
+);
+
+INSERT INTO inventories_old (id, path, filesize, mod_time, bag_info)
+  SELECT id, path, filesize, mod_time, bag_info
+  FROM inventories;
+
+DROP TABLE inventories;
+ALTER TABLE inventories_old RENAME TO inventories;
@@ -0,0 +1,34 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Index runs remember where their error report was written, if anywhere
+ALTER TABLE index_runs ADD COLUMN error_report text not null default '';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE index_runs_old (
+  id integer not null primary key,
+  started_at datetime not null,
+  finished_at datetime not null,
+  inventories_indexed integer not null,
+  inventories_skipped integer not null,
+  inventories_failed integer not null,
+  files_added integer not null,
+  files_updated integer not null,
+  files_skipped integer not null,
+  record_errors integer not null,
+  stopped boolean not null
+);
+
+INSERT INTO index_runs_old (id, started_at, finished_at, inventories_indexed,
+  inventories_skipped, inventories_failed, files_added, files_updated,
+  files_skipped, record_errors, stopped)
+  SELECT id, started_at, finished_at, inventories_indexed, inventories_skipped,
+    inventories_failed, files_added, files_updated, files_skipped,
+    record_errors, stopped
+  FROM index_runs;
+
+DROP TABLE index_runs;
+ALTER TABLE index_runs_old RENAME TO index_runs;
+
+CREATE INDEX index_runs_started_at ON index_runs (started_at);
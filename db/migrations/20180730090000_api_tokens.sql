@@ -0,0 +1,21 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- API tokens let scripts and other systems use the JSON API.  Only a hash of
+-- each token is stored; the token itself is shown once, when it's issued.
+CREATE TABLE api_tokens (
+  id integer not null primary key,
+  name text not null,
+  token_hash text not null,
+  scopes text not null,
+  user_id integer not null default 0,
+  created_at datetime not null,
+  last_used_at datetime not null,
+  revoked_at datetime not null
+);
+
+CREATE UNIQUE INDEX api_tokens_token_hash ON api_tokens (token_hash);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE api_tokens;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Users remember the roles their single sign-on attributes mapped to when
+-- they last logged in
+ALTER TABLE users ADD COLUMN roles text not null default '';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE users_old (
+  id integer not null primary key,
+  login text not null,
+  name text not null,
+  email text not null,
+  created_at datetime not null,
+  last_login_at datetime
+);
+
+INSERT INTO users_old (id, login, name, email, created_at, last_login_at)
+  SELECT id, login, name, email, created_at, last_login_at
+  FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
+
+CREATE UNIQUE INDEX users_login ON users (login);
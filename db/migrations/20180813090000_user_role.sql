@@ -0,0 +1,27 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- The role an admin assigned the user; single sign-on roles and the email
+-- lists can raise it, but never lower it
+ALTER TABLE users ADD COLUMN role text not null default 'viewer';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE users_old (
+  id integer not null primary key,
+  login text not null,
+  name text not null,
+  email text not null,
+  created_at datetime not null,
+  last_login_at datetime,
+  roles text not null default ''
+);
+
+INSERT INTO users_old (id, login, name, email, created_at, last_login_at, roles)
+  SELECT id, login, name, email, created_at, last_login_at, roles
+  FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
+
+CREATE UNIQUE INDEX users_login ON users (login);
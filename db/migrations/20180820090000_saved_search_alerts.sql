@@ -0,0 +1,35 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Users can subscribe to their saved file searches to be emailed about newly
+-- indexed matches.  notified_at is the end of the last period checked;
+-- anything indexed after it hasn't been reported yet.
+ALTER TABLE saved_searches ADD COLUMN subscribed boolean not null default 0;
+ALTER TABLE saved_searches ADD COLUMN notified_at datetime not null default '0001-01-01 00:00:00+00:00';
+CREATE INDEX saved_searches_subscribed ON saved_searches (subscribed);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE saved_searches_old (
+  id integer not null primary key,
+  name text not null,
+  term text not null,
+  folder_search boolean not null,
+  category_id integer not null,
+  folder_id integer not null,
+  created_at datetime not null,
+  user_id integer not null default 0,
+  mode text not null default 'like'
+);
+
+INSERT INTO saved_searches_old (id, name, term, folder_search, category_id,
+  folder_id, created_at, user_id, mode)
+  SELECT id, name, term, folder_search, category_id, folder_id, created_at,
+    user_id, mode
+  FROM saved_searches;
+
+DROP TABLE saved_searches;
+ALTER TABLE saved_searches_old RENAME TO saved_searches;
+
+CREATE INDEX saved_searches_created_at ON saved_searches (created_at);
+CREATE INDEX saved_searches_user_id ON saved_searches (user_id);
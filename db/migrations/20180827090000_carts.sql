@@ -0,0 +1,19 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Each row is one file in someone's bulk download queue.  The owner is
+-- "user:<id>" for logged-in users, whose queues follow them between browsers,
+-- or "session:<key>" for anonymous visitors.
+CREATE TABLE carts (
+  id integer not null primary key,
+  owner text not null,
+  file_id integer not null,
+  added_at datetime not null
+);
+
+CREATE UNIQUE INDEX carts_owner_file_id ON carts (owner, file_id);
+CREATE INDEX carts_added_at ON carts (added_at);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE carts;
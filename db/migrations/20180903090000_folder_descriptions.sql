@@ -0,0 +1,32 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Curators can describe a folder, e.g., "masters only; access copies in
+-- DAMS", for the top of its browse page
+ALTER TABLE folders ADD COLUMN description text not null default '';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE folders_old (
+  id integer not null primary key,
+  category_id integer not null,
+  folder_id integer not null,
+  depth integer not null,
+  name text not null,
+  public_path text not null,
+  restricted boolean not null default 0
+);
+
+INSERT INTO folders_old (id, category_id, folder_id, depth, name, public_path,
+  restricted)
+  SELECT id, category_id, folder_id, depth, name, public_path, restricted
+  FROM folders;
+
+DROP TABLE folders;
+ALTER TABLE folders_old RENAME TO folders;
+
+CREATE INDEX folders_public_path ON folders (public_path);
+CREATE INDEX folders_folder_id ON folders (folder_id);
+CREATE INDEX folders_depth ON folders (depth);
+CREATE UNIQUE INDEX folders_unique ON folders (category_id, public_path);
+CREATE INDEX folders_restricted ON folders (restricted);
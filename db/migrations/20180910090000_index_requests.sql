@@ -0,0 +1,22 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Each row is an admin's request, made in the web app, for the indexer to run
+-- now.  An empty category means a full run.
+CREATE TABLE index_requests (
+  id integer not null primary key,
+  user_id integer not null default 0,
+  category text not null default '',
+  status text not null,
+  created_at datetime not null,
+  started_at datetime,
+  finished_at datetime,
+  last_error text not null default '',
+  index_run_id integer not null default 0
+);
+
+CREATE INDEX index_requests_status ON index_requests (status);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE index_requests;
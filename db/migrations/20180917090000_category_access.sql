@@ -0,0 +1,24 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Hidden categories are left off the public category list, and a category's
+-- access role is the role a user needs to see anything in it at all (empty
+-- means everybody can)
+ALTER TABLE categories ADD COLUMN hidden boolean not null default 0;
+ALTER TABLE categories ADD COLUMN access_role text not null default '';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE categories_old (
+  id integer not null primary key,
+  name text not null
+);
+
+INSERT INTO categories_old (id, name)
+  SELECT id, name
+  FROM categories;
+
+DROP TABLE categories;
+ALTER TABLE categories_old RENAME TO categories;
+
+CREATE INDEX categories_name ON categories (name);
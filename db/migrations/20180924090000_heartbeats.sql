@@ -0,0 +1,16 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Each long-running worker (the indexer and the archiver) regularly records
+-- that it's still alive, so the health check can tell if one has died
+CREATE TABLE heartbeats (
+  id integer not null primary key,
+  worker text not null,
+  beat_at datetime not null
+);
+
+CREATE UNIQUE INDEX heartbeats_worker ON heartbeats (worker);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE heartbeats;
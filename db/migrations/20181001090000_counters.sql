@@ -0,0 +1,16 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Running totals which have to outlive the records they're counted from, such
+-- as the bytes archived by jobs which have since been purged
+CREATE TABLE counters (
+  id integer not null primary key,
+  name text not null,
+  value integer not null default 0
+);
+
+CREATE UNIQUE INDEX counters_name ON counters (name);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE counters;
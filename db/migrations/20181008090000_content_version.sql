@@ -0,0 +1,170 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- The content version goes up, and content_changed_at is set to the current
+-- Unix time, whenever anything shown on browse pages changes, so the web app
+-- knows when a cached page is still good.  Files are only watched for changes
+-- made outside of indexing (restrictions, moves, and deletions); the indexer's
+-- own writes are covered by its updates to inventories and index progress.
+INSERT INTO counters (name, value) VALUES ('content_version', 0);
+INSERT INTO counters (name, value) VALUES ('content_changed_at', CAST(strftime('%s', 'now') AS integer));
+
+-- +goose StatementBegin
+CREATE TRIGGER categories_insert_content AFTER INSERT ON categories
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER categories_update_content AFTER UPDATE ON categories
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER categories_delete_content AFTER DELETE ON categories
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER folders_insert_content AFTER INSERT ON folders
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER folders_update_content AFTER UPDATE ON folders
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER folders_delete_content AFTER DELETE ON folders
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER inventories_insert_content AFTER INSERT ON inventories
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER inventories_update_content AFTER UPDATE ON inventories
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER inventories_delete_content AFTER DELETE ON inventories
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER index_progress_insert_content AFTER INSERT ON index_progress
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER index_progress_update_content AFTER UPDATE ON index_progress
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER index_progress_delete_content AFTER DELETE ON index_progress
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER index_runs_insert_content AFTER INSERT ON index_runs
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER index_runs_update_content AFTER UPDATE ON index_runs
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER index_runs_delete_content AFTER DELETE ON index_runs
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER files_update_content AFTER UPDATE ON files
+WHEN OLD.restricted IS NOT NEW.restricted OR OLD.missing_since IS NOT NEW.missing_since
+  OR OLD.category_id IS NOT NEW.category_id OR OLD.folder_id IS NOT NEW.folder_id
+  OR OLD.public_path IS NOT NEW.public_path
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER files_delete_content AFTER DELETE ON files
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TRIGGER categories_insert_content;
+DROP TRIGGER categories_update_content;
+DROP TRIGGER categories_delete_content;
+DROP TRIGGER folders_insert_content;
+DROP TRIGGER folders_update_content;
+DROP TRIGGER folders_delete_content;
+DROP TRIGGER inventories_insert_content;
+DROP TRIGGER inventories_update_content;
+DROP TRIGGER inventories_delete_content;
+DROP TRIGGER index_progress_insert_content;
+DROP TRIGGER index_progress_update_content;
+DROP TRIGGER index_progress_delete_content;
+DROP TRIGGER index_runs_insert_content;
+DROP TRIGGER index_runs_update_content;
+DROP TRIGGER index_runs_delete_content;
+DROP TRIGGER files_update_content;
+DROP TRIGGER files_delete_content;
+DELETE FROM counters WHERE name IN ('content_version', 'content_changed_at');
@@ -0,0 +1,32 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Folder redirects remember the public paths of folders which were moved or
+-- renamed, so path-based browse URLs from before the move still lead to the
+-- folder
+CREATE TABLE folder_redirects (
+  id integer not null primary key,
+  category_id integer not null,
+  old_path text not null,
+  folder_id integer not null
+);
+
+CREATE UNIQUE INDEX folder_redirects_old_path ON folder_redirects (category_id, old_path);
+CREATE INDEX folder_redirects_folder_id ON folder_redirects (folder_id);
+
+-- Permalink redirects point the ids of files and folders which were merged
+-- into a twin (e.g., by a category merge) at the record that replaced them,
+-- so id-based permalinks survive the merge
+CREATE TABLE permalink_redirects (
+  id integer not null primary key,
+  kind text not null,
+  old_id integer not null,
+  new_id integer not null
+);
+
+CREATE UNIQUE INDEX permalink_redirects_old_id ON permalink_redirects (kind, old_id);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE permalink_redirects;
+DROP TABLE folder_redirects;
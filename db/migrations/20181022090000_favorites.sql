@@ -0,0 +1,19 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Each row is a file or folder one user has starred.  Kind is "file" or
+-- "folder", and item_id is the id in the matching table.
+CREATE TABLE favorites (
+  id integer not null primary key,
+  user_id integer not null,
+  kind text not null,
+  item_id integer not null,
+  added_at datetime not null
+);
+
+CREATE UNIQUE INDEX favorites_user_id_kind_item_id ON favorites (user_id, kind, item_id);
+CREATE INDEX favorites_kind_item_id ON favorites (kind, item_id);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE favorites;
@@ -0,0 +1,43 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Each job gets a secret token for its public status page; only the token's
+-- hash is stored.  Files_done counts how many of the job's files the archiver
+-- has added during the current attempt.
+ALTER TABLE archive_jobs ADD COLUMN status_token_hash text not null default '';
+ALTER TABLE archive_jobs ADD COLUMN files_done integer not null default 0;
+CREATE INDEX archive_jobs_status_token_hash ON archive_jobs (status_token_hash);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE archive_jobs_old (
+  id integer not null primary key,
+  user_id integer not null default 0,
+  created_at datetime not null,
+  next_attempt_at datetime not null,
+  files text not null,
+  notification_emails text not null,
+  status text not null default 'pending',
+  last_error text not null default '',
+  attempts integer not null default 0,
+  priority integer not null default 0,
+  finished_at datetime not null default '0001-01-01 00:00:00+00:00',
+  archive_path text not null default ''
+);
+
+INSERT INTO archive_jobs_old (id, user_id, created_at, next_attempt_at, files,
+  notification_emails, status, last_error, attempts, priority, finished_at,
+  archive_path)
+  SELECT id, user_id, created_at, next_attempt_at, files, notification_emails,
+    status, last_error, attempts, priority, finished_at, archive_path
+  FROM archive_jobs;
+
+DROP TABLE archive_jobs;
+ALTER TABLE archive_jobs_old RENAME TO archive_jobs;
+
+CREATE INDEX archive_jobs_created_at ON archive_jobs (created_at);
+CREATE INDEX archive_jobs_next_attempt_at ON archive_jobs (next_attempt_at);
+CREATE INDEX archive_jobs_user_id ON archive_jobs (user_id);
+CREATE INDEX archive_jobs_status ON archive_jobs (status);
+CREATE INDEX archive_jobs_priority ON archive_jobs (priority);
+CREATE INDEX archive_jobs_finished_at ON archive_jobs (finished_at);
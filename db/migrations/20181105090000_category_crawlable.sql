@@ -0,0 +1,48 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Crawlable categories are opened to search engines by robots.txt and listed
+-- in the sitemap, so long as they're also public
+ALTER TABLE categories ADD COLUMN crawlable boolean not null default 0;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE categories_old (
+  id integer not null primary key,
+  name text not null,
+  hidden boolean not null default 0,
+  access_role text not null default ''
+);
+
+INSERT INTO categories_old (id, name, hidden, access_role)
+  SELECT id, name, hidden, access_role
+  FROM categories;
+
+DROP TABLE categories;
+ALTER TABLE categories_old RENAME TO categories;
+
+CREATE INDEX categories_name ON categories (name);
+
+-- +goose StatementBegin
+CREATE TRIGGER categories_insert_content AFTER INSERT ON categories
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER categories_update_content AFTER UPDATE ON categories
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER categories_delete_content AFTER DELETE ON categories
+BEGIN
+  UPDATE counters SET value = value + 1 WHERE name = 'content_version';
+  UPDATE counters SET value = CAST(strftime('%s', 'now') AS integer) WHERE name = 'content_changed_at';
+END;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- The indexer keeps a single row here up to date while it runs, so the web
+-- app can show how the current run is going
+CREATE TABLE index_run_status (
+  id integer not null primary key,
+  started_at datetime not null,
+  updated_at datetime not null,
+  finished_at datetime not null,
+  inventories_total integer not null default 0,
+  inventories_done integer not null default 0,
+  current_inventory text not null default '',
+  files_processed integer not null default 0,
+  bytes_total integer not null default 0,
+  bytes_done integer not null default 0,
+  estimated_finish datetime not null
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE index_run_status;
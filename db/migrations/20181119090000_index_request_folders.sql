@@ -0,0 +1,32 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- A request with a folder asks the indexer to rebuild just that folder.  The
+-- path is kept so the request can still be described if the folder goes away.
+ALTER TABLE index_requests ADD COLUMN folder_id integer not null default 0;
+ALTER TABLE index_requests ADD COLUMN folder_path text not null default '';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE index_requests_old (
+  id integer not null primary key,
+  user_id integer not null default 0,
+  category text not null default '',
+  status text not null,
+  created_at datetime not null,
+  started_at datetime,
+  finished_at datetime,
+  last_error text not null default '',
+  index_run_id integer not null default 0
+);
+
+INSERT INTO index_requests_old (id, user_id, category, status, created_at,
+  started_at, finished_at, last_error, index_run_id)
+  SELECT id, user_id, category, status, created_at, started_at, finished_at,
+    last_error, index_run_id
+  FROM index_requests;
+
+DROP TABLE index_requests;
+ALTER TABLE index_requests_old RENAME TO index_requests;
+
+CREATE INDEX index_requests_status ON index_requests (status);
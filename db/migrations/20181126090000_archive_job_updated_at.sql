@@ -0,0 +1,46 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Archive jobs record when they were last written to.  The archiver keeps
+-- its in-progress job's time current, so an in-progress job which stops
+-- being updated was orphaned by an archiver that died.
+ALTER TABLE archive_jobs ADD COLUMN updated_at datetime not null default '0001-01-01 00:00:00+00:00';
+UPDATE archive_jobs SET updated_at = created_at;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+CREATE TABLE archive_jobs_old (
+  id integer not null primary key,
+  user_id integer not null default 0,
+  created_at datetime not null,
+  next_attempt_at datetime not null,
+  files text not null,
+  notification_emails text not null,
+  status text not null default 'pending',
+  last_error text not null default '',
+  attempts integer not null default 0,
+  priority integer not null default 0,
+  finished_at datetime not null default '0001-01-01 00:00:00+00:00',
+  archive_path text not null default '',
+  status_token_hash text not null default '',
+  files_done integer not null default 0
+);
+
+INSERT INTO archive_jobs_old (id, user_id, created_at, next_attempt_at, files,
+  notification_emails, status, last_error, attempts, priority, finished_at,
+  archive_path, status_token_hash, files_done)
+  SELECT id, user_id, created_at, next_attempt_at, files, notification_emails,
+    status, last_error, attempts, priority, finished_at, archive_path,
+    status_token_hash, files_done
+  FROM archive_jobs;
+
+DROP TABLE archive_jobs;
+ALTER TABLE archive_jobs_old RENAME TO archive_jobs;
+
+CREATE INDEX archive_jobs_created_at ON archive_jobs (created_at);
+CREATE INDEX archive_jobs_next_attempt_at ON archive_jobs (next_attempt_at);
+CREATE INDEX archive_jobs_user_id ON archive_jobs (user_id);
+CREATE INDEX archive_jobs_status ON archive_jobs (status);
+CREATE INDEX archive_jobs_priority ON archive_jobs (priority);
+CREATE INDEX archive_jobs_finished_at ON archive_jobs (finished_at);
+CREATE INDEX archive_jobs_status_token_hash ON archive_jobs (status_token_hash);
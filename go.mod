@@ -5,9 +5,14 @@ go 1.15
 require (
 	github.com/Nerdmaster/magicsql v0.10.1
 	github.com/alexedwards/scs v1.2.1-0.20171214172540-876a0fdbdd8c
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/go-ldap/ldap/v3 v3.2.4
 	github.com/mattn/go-sqlite3 v1.3.0
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/uoregon-libraries/gopkg v0.6.0
-	golang.org/x/crypto v0.0.0-20171218184859-244f6ce1f09c
-	golang.org/x/net v0.0.0-20171107184841-a337091b0525
+	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9
+	golang.org/x/image v0.0.0-20200927104501-e162460cd6b5
+	golang.org/x/net v0.0.0-20190404232315-eb5bcb51f2a3
+	golang.org/x/text v0.3.7
+	gopkg.in/yaml.v2 v2.4.0
 )
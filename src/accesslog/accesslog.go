@@ -0,0 +1,152 @@
+// Package accesslog records who requested what from the web server, one line
+// per request, apart from the application's own logging.  Entries can be
+// written in Apache's common or combined formats, or as JSON, to a file which
+// rotates itself by size and time so old records can be kept as long as they
+// need to be.
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Format names accepted by New
+const (
+	FormatCommon   = "common"
+	FormatCombined = "combined"
+	FormatJSON     = "json"
+)
+
+// Entry describes one request
+type Entry struct {
+	Time       time.Time
+	RemoteAddr string
+	User       string
+	Method     string
+	URI        string
+	Proto      string
+	Status     int
+	Bytes      int64
+	Duration   time.Duration
+	Referer    string
+	UserAgent  string
+}
+
+// formatter turns an entry into a line of the log, including its newline
+type formatter func(e *Entry) []byte
+
+var formatters = map[string]formatter{
+	FormatCommon:   formatCommon,
+	FormatCombined: formatCombined,
+	FormatJSON:     formatJSON,
+}
+
+// Logger writes entries in a single format.  It's safe to use from many
+// goroutines at once.
+type Logger struct {
+	m      sync.Mutex
+	w      io.WriteCloser
+	format formatter
+}
+
+// New returns a Logger writing to w in the named format
+func New(w io.WriteCloser, format string) (*Logger, error) {
+	var f, ok = formatters[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown access log format %q", format)
+	}
+	return &Logger{w: w, format: f}, nil
+}
+
+// Log writes e to the log
+func (l *Logger) Log(e *Entry) error {
+	var line = l.format(e)
+	l.m.Lock()
+	defer l.m.Unlock()
+	var _, err = l.w.Write(line)
+	return err
+}
+
+// Close closes the underlying writer
+func (l *Logger) Close() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.w.Close()
+}
+
+// orDash returns s, or "-" for an empty value as the Apache formats expect
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// field returns s as a bare field of the Apache formats, or quoted if it has
+// anything in it which would break the line apart
+func field(s string) string {
+	if s == "" {
+		return "-"
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '"' || r == '\\' || r >= 0x7f {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+// formatCommon writes Apache's common log format.  The request line is
+// quoted with Go's escaping, so a request can't forge a line of its own.
+func formatCommon(e *Entry) []byte {
+	var size = "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+	return []byte(fmt.Sprintf("%s - %s [%s] %s %d %s\n",
+		field(e.RemoteAddr), field(e.User), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(e.Method+" "+e.URI+" "+e.Proto), e.Status, size))
+}
+
+// formatCombined adds the referer and user agent to the common format
+func formatCombined(e *Entry) []byte {
+	var line = formatCommon(e)
+	return append(line[:len(line)-1], fmt.Sprintf(" %s %s\n", strconv.Quote(orDash(e.Referer)), strconv.Quote(orDash(e.UserAgent)))...)
+}
+
+// jsonEntry is how an entry is written in the JSON format
+type jsonEntry struct {
+	Time       string  `json:"time"`
+	RemoteAddr string  `json:"remote_addr"`
+	User       string  `json:"user,omitempty"`
+	Method     string  `json:"method"`
+	URI        string  `json:"uri"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+}
+
+// formatJSON writes each entry as a JSON object on its own line
+func formatJSON(e *Entry) []byte {
+	var b, _ = json.Marshal(jsonEntry{
+		Time:       e.Time.Format(time.RFC3339Nano),
+		RemoteAddr: e.RemoteAddr,
+		User:       e.User,
+		Method:     e.Method,
+		URI:        e.URI,
+		Proto:      e.Proto,
+		Status:     e.Status,
+		Bytes:      e.Bytes,
+		DurationMS: e.Duration.Seconds() * 1000,
+		Referer:    e.Referer,
+		UserAgent:  e.UserAgent,
+	})
+	return append(b, '\n')
+}
@@ -0,0 +1,199 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Rotation periods accepted by OpenFile
+const (
+	RotateNever   = "never"
+	RotateHourly  = "hourly"
+	RotateDaily   = "daily"
+	RotateWeekly  = "weekly"
+	RotateMonthly = "monthly"
+)
+
+// File is a log file which moves itself aside and starts over once it grows
+// past a size limit or a new rotation period begins.  Rotated files get the
+// time they were rotated added to their name, down to the microsecond so
+// they sort in order, e.g., "access.log" becomes
+// "access.log.20181119-000000.000042".
+type File struct {
+	m        sync.Mutex
+	path     string
+	maxBytes int64
+	period   string
+	keep     int
+
+	f        *os.File
+	size     int64
+	rotateAt time.Time
+	closed   bool
+}
+
+// OpenFile opens or creates the log at path.  maxBytes of zero turns off
+// size-based rotation, and keep is how many rotated files to hold on to, with
+// zero keeping them all.  A log left over from an earlier period is rotated
+// before anything new is written to it.
+func OpenFile(path string, maxBytes int64, period string, keep int) (*File, error) {
+	switch period {
+	case RotateNever, RotateHourly, RotateDaily, RotateWeekly, RotateMonthly:
+	default:
+		return nil, fmt.Errorf("unknown rotation period %q", period)
+	}
+
+	var lf = &File{path: path, maxBytes: maxBytes, period: period, keep: keep}
+	var err = lf.open()
+	if err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+// open opens the log file for appending and works out when it's next due
+// to rotate, based on when it was last written
+func (lf *File) open() error {
+	var f, err = os.OpenFile(lf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	var info os.FileInfo
+	info, err = f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	var start = time.Now()
+	if info.Size() > 0 {
+		start = info.ModTime()
+	}
+	lf.f = f
+	lf.size = info.Size()
+	lf.rotateAt = nextPeriod(start, lf.period)
+	return nil
+}
+
+// nextPeriod returns when the rotation period after the one holding t
+// begins, or the zero time if logs never rotate by time
+func nextPeriod(t time.Time, period string) time.Time {
+	var y, m, d = t.Date()
+	var day = time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	switch period {
+	case RotateHourly:
+		return day.Add(time.Duration(t.Hour()+1) * time.Hour)
+	case RotateDaily:
+		return day.AddDate(0, 0, 1)
+	case RotateWeekly:
+		return day.AddDate(0, 0, 7-int(t.Weekday()))
+	case RotateMonthly:
+		return time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Time{}
+}
+
+// Write appends p to the log, rotating it first if it's due
+func (lf *File) Write(p []byte) (int, error) {
+	lf.m.Lock()
+	defer lf.m.Unlock()
+
+	if lf.closed {
+		return 0, os.ErrClosed
+	}
+
+	// A failed rotation leaves us without a file, so try again to open one
+	if lf.f == nil {
+		var err = lf.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var now = time.Now()
+	var due = !lf.rotateAt.IsZero() && !now.Before(lf.rotateAt)
+	if lf.maxBytes > 0 && lf.size > 0 && lf.size+int64(len(p)) > lf.maxBytes {
+		due = true
+	}
+	var rotateErr error
+	if due {
+		rotateErr = lf.rotate(now)
+		if lf.f == nil {
+			return 0, fmt.Errorf("unable to rotate %q: %s", lf.path, rotateErr)
+		}
+	}
+
+	var n, err = lf.f.Write(p)
+	lf.size += int64(n)
+	if err == nil && rotateErr != nil {
+		err = fmt.Errorf("unable to remove old rotations of %q: %s", lf.path, rotateErr)
+	}
+	return n, err
+}
+
+// rotate moves the current log aside and opens a fresh one.  Once the new
+// file is open, the only errors left are from pruning old rotations.
+func (lf *File) rotate(now time.Time) error {
+	var err = lf.f.Close()
+	lf.f = nil
+	if err != nil {
+		return err
+	}
+
+	var base = lf.path + "." + now.Format("20060102-150405.000000")
+	var dest = base
+	for i := 2; ; i++ {
+		_, err = os.Stat(dest)
+		if os.IsNotExist(err) {
+			break
+		}
+		dest = fmt.Sprintf("%s-%d", base, i)
+	}
+	err = os.Rename(lf.path, dest)
+	if err != nil {
+		return err
+	}
+
+	err = lf.open()
+	if err != nil {
+		return err
+	}
+	return lf.prune()
+}
+
+// prune removes the oldest rotated logs beyond the number we keep.  Rotated
+// names sort by the time in them, so the oldest come first.
+func (lf *File) prune() error {
+	if lf.keep < 1 {
+		return nil
+	}
+	var old, err = filepath.Glob(lf.path + ".[0-9]*")
+	if err != nil || len(old) <= lf.keep {
+		return err
+	}
+	sort.Strings(old)
+	for _, p := range old[:len(old)-lf.keep] {
+		err = os.Remove(p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the log file
+func (lf *File) Close() error {
+	lf.m.Lock()
+	defer lf.m.Unlock()
+	lf.closed = true
+	if lf.f == nil {
+		return nil
+	}
+	var err = lf.f.Close()
+	lf.f = nil
+	return err
+}
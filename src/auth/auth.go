@@ -0,0 +1,78 @@
+// Package auth verifies people's identities so headlamp can attribute their
+// actions to them and decide what they're allowed to see
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/uoregon-libraries/headlamp/src/config"
+)
+
+// ErrInvalidCredentials is returned when a login and password don't match
+// any account.  Backends don't say which part was wrong.
+var ErrInvalidCredentials = errors.New("invalid login or password")
+
+// ErrNoIdentity is returned when a request doesn't carry a trustworthy
+// identity
+var ErrNoIdentity = errors.New("no identity in request")
+
+// Identity is who a backend says a person is.  Roles are only set by
+// backends which can map directory groups to headlamp's roles.
+type Identity struct {
+	Login string
+	Name  string
+	Email string
+	Roles []string
+}
+
+// A PasswordBackend verifies a login and password against an external
+// directory
+type PasswordBackend interface {
+	Authenticate(login, password string) (*Identity, error)
+}
+
+// A RequestBackend identifies people from something an authenticating proxy
+// put in the request, such as a single sign-on server's attributes
+type RequestBackend interface {
+	Identify(r *http.Request) (*Identity, error)
+}
+
+// NewPasswordBackend returns the backend named by AUTH_BACKEND, or nil if
+// password logins aren't enabled
+func NewPasswordBackend(c *config.Config) (PasswordBackend, error) {
+	switch c.AuthBackend {
+	case "":
+		return nil, nil
+	case config.AuthLDAP:
+		return NewLDAP(c), nil
+	case config.AuthSSO, config.AuthOIDC:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown auth backend %q", c.AuthBackend)
+}
+
+// NewRequestBackend returns the request-based backend named by AUTH_BACKEND,
+// or nil if AUTH_BACKEND doesn't name one
+func NewRequestBackend(c *config.Config) (RequestBackend, error) {
+	switch c.AuthBackend {
+	case "", config.AuthLDAP, config.AuthOIDC:
+		return nil, nil
+	case config.AuthSSO:
+		return NewHeaderSSO(c), nil
+	}
+	return nil, fmt.Errorf("unknown auth backend %q", c.AuthBackend)
+}
+
+// NewOIDCBackend returns an OpenID Connect backend if AUTH_BACKEND calls for
+// one, or nil otherwise
+func NewOIDCBackend(c *config.Config) (*OIDC, error) {
+	switch c.AuthBackend {
+	case "", config.AuthLDAP, config.AuthSSO:
+		return nil, nil
+	case config.AuthOIDC:
+		return NewOIDC(c), nil
+	}
+	return nil, fmt.Errorf("unknown auth backend %q", c.AuthBackend)
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/uoregon-libraries/headlamp/src/config"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// HeaderSSO trusts the identity a single sign-on proxy, such as Apache with
+// mod_shib, sends in request headers after it has validated the person's
+// SAML assertion.  Headers are ignored unless the request came directly from
+// one of the trusted proxies, since anybody else could send them.
+type HeaderSSO struct {
+	LoginHeader   string
+	NameHeader    string
+	EmailHeader   string
+	GroupsHeader  string
+	AdminGroups   []string
+	StaffGroups   []string
+	CuratorGroups []string
+	Trusted       []*net.IPNet
+}
+
+// NewHeaderSSO returns a HeaderSSO backend set up from the SSO_* settings
+func NewHeaderSSO(c *config.Config) *HeaderSSO {
+	return &HeaderSSO{
+		LoginHeader:   c.SSOLoginHeader,
+		NameHeader:    c.SSONameHeader,
+		EmailHeader:   c.SSOEmailHeader,
+		GroupsHeader:  c.SSOGroupsHeader,
+		AdminGroups:   splitList(c.SSOAdminGroups, ","),
+		StaffGroups:   splitList(c.SSOStaffGroups, ","),
+		CuratorGroups: splitList(c.SSOCuratorGroups, ","),
+		Trusted:       c.SSOTrustedProxies,
+	}
+}
+
+// Identify returns the identity in r's headers, or ErrNoIdentity if r didn't
+// come from a trusted proxy or has no login
+func (h *HeaderSSO) Identify(r *http.Request) (*Identity, error) {
+	if !h.trusted(r.RemoteAddr) {
+		return nil, ErrNoIdentity
+	}
+
+	var login = strings.TrimSpace(r.Header.Get(h.LoginHeader))
+	if login == "" {
+		return nil, ErrNoIdentity
+	}
+
+	var ident = &Identity{
+		Login: login,
+		Name:  h.header(r, h.NameHeader),
+		Email: h.header(r, h.EmailHeader),
+	}
+	ident.Roles = groupRoles(splitList(h.header(r, h.GroupsHeader), ";"), h.AdminGroups, h.CuratorGroups, h.StaffGroups)
+
+	return ident, nil
+}
+
+// header returns the first value of the given header, or "" if the header
+// isn't configured.  Shibboleth sends multi-valued attributes separated by
+// semicolons, so only the first value is kept.
+func (h *HeaderSSO) header(r *http.Request, name string) string {
+	if name == "" {
+		return ""
+	}
+	var val = r.Header.Get(name)
+	if name == h.GroupsHeader {
+		return val
+	}
+	return strings.TrimSpace(strings.SplitN(val, ";", 2)[0])
+}
+
+// trusted returns true if the given "host:port" address is in one of the
+// trusted proxy networks
+func (h *HeaderSSO) trusted(addr string) bool {
+	var host, _, err = net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	var ip = net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range h.Trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits s on sep, dropping empty values and surrounding space
+func splitList(s, sep string) []string {
+	var list []string
+	for _, val := range strings.Split(s, sep) {
+		val = strings.TrimSpace(val)
+		if val != "" {
+			list = append(list, val)
+		}
+	}
+	return list
+}
+
+// groupRoles returns the roles a person in the given directory groups gets
+func groupRoles(groups, adminGroups, curatorGroups, staffGroups []string) []string {
+	var roles []string
+	if anyIn(groups, adminGroups) {
+		roles = append(roles, db.RoleAdmin)
+	}
+	if anyIn(groups, curatorGroups) {
+		roles = append(roles, db.RoleCurator)
+	}
+	if anyIn(groups, staffGroups) {
+		roles = append(roles, db.RoleStaff)
+	}
+	return roles
+}
+
+// anyIn returns true if any value in list is also in set
+func anyIn(list, set []string) bool {
+	for _, a := range list {
+		for _, b := range set {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
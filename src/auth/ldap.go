@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/uoregon-libraries/headlamp/src/config"
+)
+
+// LDAP authenticates against an LDAP or Active Directory server: it finds
+// the person's entry with a search (as the configured service account, if
+// any), then binds as that entry with the password they gave
+type LDAP struct {
+	URL          string
+	StartTLS     bool
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	UserFilter   string // UserFilter has a "%s" where the escaped login goes
+	NameAttr     string
+	EmailAttr    string
+}
+
+// NewLDAP returns an LDAP backend set up from the LDAP_* settings
+func NewLDAP(c *config.Config) *LDAP {
+	return &LDAP{
+		URL:          c.LDAPURL,
+		StartTLS:     c.LDAPStartTLS,
+		BindDN:       c.LDAPBindDN,
+		BindPassword: c.LDAPBindPassword,
+		BaseDN:       c.LDAPBaseDN,
+		UserFilter:   c.LDAPUserFilter,
+		NameAttr:     c.LDAPNameAttribute,
+		EmailAttr:    c.LDAPEmailAttribute,
+	}
+}
+
+// Authenticate looks up login and verifies password by binding as the
+// person's entry
+func (l *LDAP) Authenticate(login, password string) (*Identity, error) {
+	login = strings.TrimSpace(login)
+
+	// An empty password is an "unauthenticated bind", which many servers
+	// happily accept for any DN
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	var conn, err = l.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if l.BindDN != "" {
+		err = conn.Bind(l.BindDN, l.BindPassword)
+		if err != nil {
+			return nil, fmt.Errorf("unable to bind as %q: %s", l.BindDN, err)
+		}
+	}
+
+	var req = ldap.NewSearchRequest(
+		l.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(l.UserFilter, ldap.EscapeFilter(login)),
+		[]string{"dn", l.NameAttr, l.EmailAttr}, nil,
+	)
+	var res *ldap.SearchResult
+	res, err = conn.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to search for %q: %s", login, err)
+	}
+	if len(res.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	var entry = res.Entries[0]
+	err = conn.Bind(entry.DN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to bind as %q: %s", entry.DN, err)
+	}
+
+	return &Identity{
+		Login: login,
+		Name:  entry.GetAttributeValue(l.NameAttr),
+		Email: entry.GetAttributeValue(l.EmailAttr),
+	}, nil
+}
+
+// dial connects to the server, upgrading to TLS if StartTLS is set
+func (l *LDAP) dial() (*ldap.Conn, error) {
+	var conn, err = ldap.DialURL(l.URL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %q: %s", l.URL, err)
+	}
+
+	if l.StartTLS {
+		var u, _ = url.Parse(l.URL)
+		err = conn.StartTLS(&tls.Config{ServerName: u.Hostname()})
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to start TLS with %q: %s", l.URL, err)
+		}
+	}
+	return conn, nil
+}
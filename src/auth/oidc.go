@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uoregon-libraries/headlamp/src/config"
+)
+
+// ErrInvalidIDToken is returned when the provider's ID token doesn't belong
+// to this login: it's for another client or issuer, expired, or replayed
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// OIDC logs people in with an OpenID Connect provider such as Keycloak,
+// using the authorization code flow.  The provider's endpoints are
+// discovered on the first login, so headlamp can start while the provider is
+// down.
+//
+// Because the ID token comes straight from the provider's token endpoint, its
+// signature isn't checked; OpenID Connect allows relying on the TLS
+// connection instead, which is why the issuer should be an https URL outside
+// of testing.
+type OIDC struct {
+	IssuerURL     string
+	ClientID      string
+	ClientSecret  string
+	Scopes        []string
+	LoginClaim    string
+	GroupsClaim   string
+	AdminGroups   []string
+	StaffGroups   []string
+	CuratorGroups []string
+	Client        *http.Client
+
+	m         sync.Mutex
+	discovery *oidcDiscovery
+}
+
+// oidcDiscovery holds the parts of a provider's discovery document we use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// NewOIDC returns an OIDC backend set up from the OIDC_* and SSO_*_GROUPS
+// settings
+func NewOIDC(c *config.Config) *OIDC {
+	return &OIDC{
+		IssuerURL:     strings.TrimRight(c.OIDCIssuerURL, "/"),
+		ClientID:      c.OIDCClientID,
+		ClientSecret:  c.OIDCClientSecret,
+		Scopes:        strings.Fields(c.OIDCScopes),
+		LoginClaim:    c.OIDCLoginClaim,
+		GroupsClaim:   c.OIDCGroupsClaim,
+		AdminGroups:   splitList(c.SSOAdminGroups, ","),
+		StaffGroups:   splitList(c.SSOStaffGroups, ","),
+		CuratorGroups: splitList(c.SSOCuratorGroups, ","),
+		Client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Begin starts a login: it returns the provider URL to send the person to,
+// and the state and nonce values which must be kept in their session until
+// the provider sends them back to redirectURL
+func (o *OIDC) Begin(redirectURL string) (authURL, state, nonce string, err error) {
+	var d *oidcDiscovery
+	d, err = o.discover()
+	if err != nil {
+		return "", "", "", err
+	}
+	state, err = randomString()
+	if err == nil {
+		nonce, err = randomString()
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+
+	var u *url.URL
+	u, err = url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid authorization endpoint %q: %s", d.AuthorizationEndpoint, err)
+	}
+	var q = u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", o.ClientID)
+	q.Set("redirect_uri", redirectURL)
+	q.Set("scope", strings.Join(o.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	u.RawQuery = q.Encode()
+
+	return u.String(), state, nonce, nil
+}
+
+// Exchange trades the code the provider sent back for an ID token, and
+// returns the identity in that token.  redirectURL and nonce must be the
+// values used in Begin.
+func (o *OIDC) Exchange(code, redirectURL, nonce string) (*Identity, error) {
+	var d, err = o.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	var form = url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURL)
+	var req *http.Request
+	req, err = http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	var resp *http.Response
+	resp, err = o.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach token endpoint: %s", err)
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token response (HTTP %d): %s", resp.StatusCode, err)
+	}
+	if tokens.Error != "" {
+		return nil, fmt.Errorf("token endpoint returned %q: %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("token endpoint returned HTTP %d without an ID token", resp.StatusCode)
+	}
+
+	return o.identify(tokens.IDToken, d.Issuer, nonce)
+}
+
+// identify validates the ID token's claims and pulls the identity from them
+func (o *OIDC) identify(idToken, issuer, nonce string) (*Identity, error) {
+	var parts = strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%s: not a JWT", ErrInvalidIDToken)
+	}
+	var payload, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidIDToken, err)
+	}
+	var claims map[string]interface{}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidIDToken, err)
+	}
+
+	if stringClaim(claims, "iss") != issuer {
+		return nil, fmt.Errorf("%s: issuer is %q, not %q", ErrInvalidIDToken, stringClaim(claims, "iss"), issuer)
+	}
+	var aud = listClaim(claims, "aud")
+	if !anyIn([]string{o.ClientID}, aud) {
+		return nil, fmt.Errorf("%s: audience %q doesn't include %q", ErrInvalidIDToken, aud, o.ClientID)
+	}
+	if len(aud) > 1 && stringClaim(claims, "azp") != o.ClientID {
+		return nil, fmt.Errorf("%s: authorized party isn't %q", ErrInvalidIDToken, o.ClientID)
+	}
+	var exp, _ = claims["exp"].(float64)
+	if time.Unix(int64(exp), 0).Before(time.Now().Add(-time.Minute)) {
+		return nil, fmt.Errorf("%s: expired", ErrInvalidIDToken)
+	}
+	if stringClaim(claims, "nonce") != nonce {
+		return nil, fmt.Errorf("%s: nonce doesn't match", ErrInvalidIDToken)
+	}
+
+	var ident = &Identity{
+		Login: stringClaim(claims, o.LoginClaim),
+		Name:  stringClaim(claims, "name"),
+		Email: stringClaim(claims, "email"),
+		Roles: groupRoles(listClaim(claims, o.GroupsClaim), o.AdminGroups, o.CuratorGroups, o.StaffGroups),
+	}
+	if ident.Login == "" {
+		return nil, fmt.Errorf("%s: no %q claim", ErrInvalidIDToken, o.LoginClaim)
+	}
+	return ident, nil
+}
+
+// discover fetches and caches the provider's discovery document
+func (o *OIDC) discover() (*oidcDiscovery, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+
+	var resp, err = o.Client.Get(o.IssuerURL + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch OpenID Connect discovery document: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, fmt.Errorf("unable to fetch OpenID Connect discovery document: HTTP %d", resp.StatusCode)
+	}
+
+	var d = &oidcDiscovery{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(d)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read OpenID Connect discovery document: %s", err)
+	}
+	if d.Issuer != o.IssuerURL {
+		return nil, fmt.Errorf("discovery document's issuer %q doesn't match %q", d.Issuer, o.IssuerURL)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" {
+		return nil, fmt.Errorf("discovery document is missing the authorization or token endpoint")
+	}
+
+	o.discovery = d
+	return d, nil
+}
+
+// stringClaim returns the named claim if it's a string, or "" otherwise
+func stringClaim(claims map[string]interface{}, name string) string {
+	var s, _ = claims[name].(string)
+	return s
+}
+
+// listClaim returns the named claim as a list of strings, whether the
+// provider sent a single string or an array
+func listClaim(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// randomString returns a random hex string suitable for state and nonce
+// values
+func randomString() (string, error) {
+	var b = make([]byte, 16)
+	var _, err = rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("unable to generate random value: %s", err)
+	}
+	return hex.EncodeToString(b), nil
+}
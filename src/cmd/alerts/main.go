@@ -0,0 +1,142 @@
+// The alerts command emails users about newly indexed files which match the
+// saved searches they've subscribed to.  It's meant to be run from cron.
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/config"
+	"github.com/uoregon-libraries/headlamp/src/db"
+	"github.com/uoregon-libraries/headlamp/src/mail"
+)
+
+// maxListed is the most new files listed in a single alert; the email links
+// to the search for the rest
+const maxListed = 50
+
+func main() {
+	var conf = getCLI()
+	var dbh = db.New()
+
+	var searches, err = dbh.Operation().SubscribedSearches()
+	if err != nil {
+		logger.Fatalf("Unable to read subscribed searches: %s", err)
+	}
+
+	// Everything is checked against the same cutoff so that files indexed
+	// while we're running are reported next time, not missed
+	var until = time.Now()
+	var sent, failed int
+	for _, s := range searches {
+		var ok bool
+		ok, err = alert(conf, dbh, s, until)
+		if err != nil {
+			logger.Errorf("Unable to send alert for saved search %d (%q): %s", s.ID, s.Name, err)
+			failed++
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+
+	logger.Infof("Checked %d subscribed search(es): %d alert(s) sent, %d failed", len(searches), sent, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// alert emails the search's owner about matches indexed since the last
+// alert, if there are any, and then moves the search's notification time up
+// to until.  Returns true if an email was sent.
+//
+// The search runs with its owner's access, so it only finds what they could
+// find in the web app.  Alerts leave the app, though, so they never mention
+// restricted files, even to staff.  A search whose category or folder the
+// owner can no longer see is skipped rather than run without its scope.
+func alert(conf *config.Config, dbh *db.Database, s *db.SavedSearch, until time.Time) (bool, error) {
+	var u, err = dbh.Operation().FindUserByID(s.UserID)
+	if err != nil {
+		return false, err
+	}
+	if u == nil || u.Email == "" {
+		logger.Warnf("Saved search %d's owner has no email address; skipping it", s.ID)
+		return false, nil
+	}
+
+	var op = dbh.Operation().ForRole(u.EffectiveRole(conf.AdminEmails, conf.StaffEmails)).HideRestricted()
+	var scoped *db.SavedSearch
+	scoped, err = op.FindSavedSearchByID(s.ID)
+	if err != nil {
+		return false, err
+	}
+	if scoped == nil || scoped.Unresolved {
+		logger.Warnf("Saved search %d's category or folder is no longer available to its owner; skipping it", s.ID)
+		return false, nil
+	}
+	s = scoped
+
+	var files []*db.File
+	var total uint64
+	files, total, err = op.NewMatches(s, until, db.Page{Limit: maxListed})
+	if err != nil {
+		return false, err
+	}
+	if total == 0 {
+		return false, op.MarkSavedSearchNotified(s, until)
+	}
+
+	var subject = fmt.Sprintf("%d new file(s) match %q", total, s.Name)
+	err = mail.Send(conf, []string{u.Email}, subject, alertBody(conf, s, files, total))
+	if err != nil {
+		return false, err
+	}
+	return true, op.MarkSavedSearchNotified(s, until)
+}
+
+// alertBody lists the new files and links to the saved search
+func alertBody(conf *config.Config, s *db.SavedSearch, files []*db.File, total uint64) string {
+	var lines = []string{
+		fmt.Sprintf("These files were added to %s since %s and match your saved search %q:",
+			conf.SiteTitle, s.NotifiedAt.Local().Format("2006-01-02 15:04"), s.Name),
+		"",
+	}
+	for _, f := range files {
+		lines = append(lines, "  "+f.Category.Name+"/"+f.PublicPath)
+	}
+	if total > uint64(len(files)) {
+		lines = append(lines, fmt.Sprintf("  ...and %d more", total-uint64(len(files))))
+	}
+	lines = append(lines, "", "See every match at "+searchURL(conf, s), "",
+		"To stop these emails, turn off alerts for the search at "+webURL(conf, "saved-searches")+"/")
+	return strings.Join(lines, "\r\n")
+}
+
+// webURL returns the full URL to the given path in the web app
+func webURL(conf *config.Config, parts ...string) string {
+	var u, _ = url.Parse(conf.WebPath)
+	u.Path = path.Join(append([]string{u.Path}, parts...)...)
+	return u.String()
+}
+
+// searchURL returns the full URL which reruns the saved search
+func searchURL(conf *config.Config, s *db.SavedSearch) string {
+	var v = url.Values{"q": []string{s.Term}}
+	if s.Mode != "" && s.Mode != string(db.SearchLike) {
+		v.Set("mode", s.Mode)
+	}
+	if s.Category == nil {
+		return webURL(conf, "search") + "/?" + v.Encode()
+	}
+	var parts = []string{"search", s.Category.Name}
+	if s.Folder != nil {
+		parts = append(parts, s.Folder.PublicPath)
+	}
+	return webURL(conf, parts...) + "?" + v.Encode()
+}
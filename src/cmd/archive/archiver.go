@@ -4,7 +4,6 @@ import (
 	"archive/tar"
 	"fmt"
 	"io"
-	"net/smtp"
 	"net/url"
 	"os"
 	"path"
@@ -16,29 +15,126 @@ import (
 	"github.com/uoregon-libraries/gopkg/logger"
 	"github.com/uoregon-libraries/headlamp/src/config"
 	"github.com/uoregon-libraries/headlamp/src/db"
+	"github.com/uoregon-libraries/headlamp/src/mail"
 )
 
+// stopGracePeriod is how long the archiver lets the job it's working on keep
+// going after being told to shut down.  Jobs which can't finish in time are
+// put back in the queue so the next archiver can start them over.
+const stopGracePeriod = time.Second * 30
+
+// cancelCheckInterval is how often the archiver looks in the database to see
+// if the job it's working on has been cancelled
+const cancelCheckInterval = time.Second * 5
+
 // Archiver holds the database handle and config to simplify processing
 type Archiver struct {
 	conf *config.Config
 	dbh  *db.Database
+
+	// stopping is closed when the archiver has been told to shut down, and
+	// interrupt is closed once the job in progress has had its grace period
+	stopping  chan struct{}
+	interrupt chan struct{}
+}
+
+// newArchiver returns an Archiver ready to process jobs
+func newArchiver(conf *config.Config, dbh *db.Database) *Archiver {
+	return &Archiver{
+		conf:      conf,
+		dbh:       dbh,
+		stopping:  make(chan struct{}),
+		interrupt: make(chan struct{}),
+	}
+}
+
+// Stop tells the archiver not to start any more jobs, and to give up on the
+// job in progress if it isn't done within stopGracePeriod
+func (a *Archiver) Stop() {
+	close(a.stopping)
+	time.AfterFunc(stopGracePeriod, func() { close(a.interrupt) })
+}
+
+// stopped returns true if Stop has been called
+func (a *Archiver) stopped() bool {
+	select {
+	case <-a.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// interrupted returns true if the job in progress should be abandoned
+func (a *Archiver) interrupted() bool {
+	select {
+	case <-a.interrupt:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait pauses for d, returning early if the archiver is stopped
+func (a *Archiver) wait(d time.Duration) {
+	select {
+	case <-a.stopping:
+	case <-time.After(d):
+	}
 }
 
 // RunPendingArchiveJobs grabs the longest-waiting job and processes it
 func (a *Archiver) RunPendingArchiveJobs() {
 	logger.Debugf("Scanning for pending archive jobs")
+	var policy = db.RetryPolicy{
+		MaxAttempts: a.conf.ArchiveMaxAttempts,
+		BaseDelay:   time.Minute * time.Duration(a.conf.ArchiveRetryMinutes),
+	}
+
 	var pending = true
-	for pending {
+	for pending && !a.stopped() {
 		pending = false
-		var err = a.dbh.Operation().ProcessArchiveJob(func(j *db.ArchiveJob) bool {
+		var job *db.ArchiveJob
+		var err = a.dbh.Operation().ProcessArchiveJob(policy, func(j *db.ArchiveJob) error {
 			pending = true
-			return a.processArchiveJob(j)
+			job = j
+			var err = a.processArchiveJob(j)
+			if err == db.ErrArchiveJobInterrupted {
+				logger.Infof("Shutting down; archive job %d will be started over by the next archiver", j.ID)
+			} else if err != nil && err != db.ErrArchiveJobCancelled {
+				logger.Errorf("Archive job %d failed (attempt %d of %d): %s", j.ID, j.Attempts, policy.MaxAttempts, err)
+			}
+			return err
 		})
 
 		if err != nil {
 			logger.Errorf("Unable to get next job: %s", err)
 			return
 		}
+
+		if job != nil && job.Status == db.JobStatusSucceeded {
+			a.countArchive(job)
+		}
+		if job != nil && job.Status == db.JobStatusFailed {
+			a.notifyAdminsOfFailure(job)
+		}
+	}
+}
+
+// countArchive adds a finished job's archive to the running totals reported
+// by the web app's metrics
+func (a *Archiver) countArchive(j *db.ArchiveJob) {
+	var info, err = os.Stat(filepath.Join(a.conf.ArchiveOutputLocation, j.ArchivePath))
+	if err != nil {
+		logger.Errorf("Unable to read archive size for job %d: %s", j.ID, err)
+		return
+	}
+
+	var op = a.dbh.Operation()
+	op.AddToCounter(db.CounterArchives, 1)
+	err = op.AddToCounter(db.CounterArchivedBytes, info.Size())
+	if err != nil {
+		logger.Errorf("Unable to record archive totals for job %d: %s", j.ID, err)
 	}
 }
 
@@ -85,15 +181,46 @@ func (a *Archiver) CleanOldArchives() {
 	}
 }
 
-func (a *Archiver) processArchiveJob(j *db.ArchiveJob) bool {
+// PurgeOldJobs deletes finished jobs older than the retention policy allows,
+// along with the archives they produced
+func (a *Archiver) PurgeOldJobs() {
+	logger.Debugf("Scanning for old archive jobs to remove")
+
+	var cutoff = time.Now().AddDate(0, 0, -a.conf.ArchiveJobRetentionDays)
+	var op = a.dbh.Operation()
+	var jobs, err = op.FinishedArchiveJobsBefore(cutoff)
+	if err != nil {
+		logger.Errorf("Unable to find old archive jobs: %s", err)
+		return
+	}
+
+	for _, j := range jobs {
+		if j.ArchivePath != "" {
+			var fname = filepath.Join(a.conf.ArchiveOutputLocation, filepath.Base(j.ArchivePath))
+			err = os.Remove(fname)
+			if err != nil && !os.IsNotExist(err) {
+				logger.Errorf("Unable to delete archive %q for job %d: %s", fname, j.ID, err)
+				continue
+			}
+		}
+
+		logger.Infof("Removing archive job %d (%s %s)", j.ID, j.Status, j.FinishedAt.Format("2006-01-02"))
+		err = op.DeleteArchiveJob(j)
+		if err != nil {
+			logger.Errorf("Unable to delete archive job %d: %s", j.ID, err)
+			return
+		}
+	}
+}
+
+func (a *Archiver) processArchiveJob(j *db.ArchiveJob) error {
 	logger.Infof("Processing archive job %d", j.ID)
 
 	var tempFile, err = fileutil.TempFile(a.conf.ArchiveOutputLocation, ".wip-", ".tar")
-	var tempName = tempFile.Name()
 	if err != nil {
-		logger.Errorf("Unable to create temp archive: %s", err)
-		return false
+		return fmt.Errorf("unable to create temp archive: %s", err)
 	}
+	var tempName = tempFile.Name()
 
 	// Most failures are before the rename, so this helps reduce chances of
 	// leaving orphaned files around
@@ -102,36 +229,64 @@ func (a *Archiver) processArchiveJob(j *db.ArchiveJob) bool {
 	var tw = tar.NewWriter(tempFile)
 
 	logger.Debugf("Adding files to archive")
-	for _, fname := range j.FileList() {
-		var p = filepath.Join(a.conf.DARoot, fname)
-		var fn = strings.Replace(fname, string(os.PathSeparator), "__", -1)
-		err = addFileToTar(tw, p, fn)
+	var lastCancelCheck time.Time
+	for i, fname := range j.FileList() {
+		if time.Since(lastCancelCheck) >= cancelCheckInterval {
+			lastCancelCheck = time.Now()
+			var cancelled, err = a.dbh.Operation().ArchiveJobCancelled(j)
+			if err != nil {
+				return fmt.Errorf("unable to check job status: %s", err)
+			}
+			if cancelled {
+				logger.Infof("Job %d was cancelled; stopping", j.ID)
+				return db.ErrArchiveJobCancelled
+			}
+		}
+		if a.interrupted() {
+			return db.ErrArchiveJobInterrupted
+		}
+
+		var rootName, fullPath = db.SplitArchivePath(fname)
+		var root string
+		root, err = a.conf.RootPath(rootName)
 		if err != nil {
-			logger.Errorf("Unable to add %q to archive: %s", fname, err)
-			return false
+			return fmt.Errorf("unable to add %q to archive: %s", fullPath, err)
+		}
+		var p = filepath.Join(root, fullPath)
+		var fn = strings.Replace(fullPath, string(os.PathSeparator), "__", -1)
+		err = addFileToTar(tw, p, fn, a.interrupt)
+		if err == db.ErrArchiveJobInterrupted {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("unable to add %q to archive: %s", fullPath, err)
+		}
+
+		// Progress is only for the requester's status page, so failing to record
+		// it isn't worth failing the job over
+		err = a.dbh.Operation().SetArchiveJobProgress(j, i+1)
+		if err != nil {
+			logger.Warnf("Unable to record progress for archive job %d: %s", j.ID, err)
 		}
 	}
 
 	logger.Debugf("Closing archive")
 	err = tw.Close()
 	if err != nil {
-		logger.Errorf("Error closing tar stream %q: %s", tempName, err)
-		return false
+		return fmt.Errorf("error closing tar stream %q: %s", tempName, err)
 	}
 
 	logger.Debugf("Closing tempfile")
 	err = tempFile.Close()
 	if err != nil {
-		logger.Errorf("Error closing %q: %s", tempName, err)
-		return false
+		return fmt.Errorf("error closing %q: %s", tempName, err)
 	}
 
 	logger.Debugf("Generating new unique filename")
 	var newName string
 	newName, err = fileutil.TempNamedFile(a.conf.ArchiveOutputLocation, "archive-", ".tar")
 	if err != nil {
-		logger.Errorf("Unable to create second temp archive: %s", err)
-		return false
+		return fmt.Errorf("unable to create second temp archive: %s", err)
 	}
 	os.Remove(newName)
 
@@ -142,21 +297,21 @@ func (a *Archiver) processArchiveJob(j *db.ArchiveJob) bool {
 	err = a.notify(to, archiveDownloadURL.String())
 	if err != nil {
 		logger.Criticalf("Unable to notify %q of archive %q being ready: %s", to, archiveDownloadURL, err)
-		return false
+		return fmt.Errorf("unable to send notification email: %s", err)
 	}
 
 	logger.Debugf("Renaming file (via os.Link)")
 	err = os.Link(tempName, newName)
 	if err != nil {
-		logger.Errorf("Error linking %q to %q: %s", tempName, newName, err)
-		return false
+		return fmt.Errorf("error linking %q to %q: %s", tempName, newName, err)
 	}
+	j.ArchivePath = filepath.Base(newName)
 
 	logger.Infof("Job %d completed successfully", j.ID)
-	return true
+	return nil
 }
 
-func addFileToTar(tw *tar.Writer, filePath, flatname string) error {
+func addFileToTar(tw *tar.Writer, filePath, flatname string, interrupt <-chan struct{}) error {
 	var srcFile, err = os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("os.Open(%q): %s", filePath, err)
@@ -174,7 +329,10 @@ func addFileToTar(tw *tar.Writer, filePath, flatname string) error {
 		return fmt.Errorf("writing header for %q: %s", flatname, err)
 	}
 
-	_, err = io.Copy(tw, srcFile)
+	_, err = io.Copy(tw, &interruptibleReader{r: srcFile, interrupt: interrupt})
+	if err == db.ErrArchiveJobInterrupted {
+		return err
+	}
 	if err != nil {
 		return fmt.Errorf("%q io.Copy(): %s", flatname, err)
 	}
@@ -182,9 +340,49 @@ func addFileToTar(tw *tar.Writer, filePath, flatname string) error {
 	return nil
 }
 
+// interruptibleReader stops reading once its interrupt channel is closed, so
+// a shutdown doesn't have to wait for a huge file to be copied
+type interruptibleReader struct {
+	r         io.Reader
+	interrupt <-chan struct{}
+}
+
+func (ir *interruptibleReader) Read(p []byte) (int, error) {
+	select {
+	case <-ir.interrupt:
+		return 0, db.ErrArchiveJobInterrupted
+	default:
+		return ir.r.Read(p)
+	}
+}
+
 func (a *Archiver) notify(to []string, fileURL string) error {
-	var auth = smtp.PlainAuth("", a.conf.SMTPUser, a.conf.SMTPPass, a.conf.SMTPHost)
-	var msg = fmt.Sprintf("Subject: Your archive is ready\r\n\r\nDownload your Headlamp archive at %s\r\n", fileURL)
-	var server = fmt.Sprintf("%s:%d", a.conf.SMTPHost, a.conf.SMTPPort)
-	return smtp.SendMail(server, auth, a.conf.SMTPUser, to, []byte(msg))
+	var body = fmt.Sprintf("Download your %s archive at %s", a.conf.SiteTitle, fileURL)
+	if a.conf.ContactEmail != "" {
+		body += fmt.Sprintf("\r\n\r\nQuestions? Contact %s", a.conf.ContactEmail)
+	}
+	return mail.Send(a.conf, to, "Your archive is ready", body)
+}
+
+// notifyAdminsOfFailure emails the configured admins about a job which won't
+// be retried again.  Errors are logged rather than returned since there's
+// nobody else to tell.
+func (a *Archiver) notifyAdminsOfFailure(j *db.ArchiveJob) {
+	logger.Criticalf("Archive job %d failed permanently after %d attempts: %s", j.ID, j.Attempts, j.LastError)
+
+	if a.conf.AdminEmails == "" {
+		logger.Warnf("No admin emails configured; unable to send notice of job %d's failure", j.ID)
+		return
+	}
+	var to = strings.Split(a.conf.AdminEmails, ",")
+	for i := range to {
+		to[i] = strings.TrimSpace(to[i])
+	}
+
+	var body = fmt.Sprintf("Archive job %d (requested by %s) failed after %d attempts and will not be "+
+		"retried.\r\n\r\nLast error: %s", j.ID, j.NotificationEmails, j.Attempts, j.LastError)
+	var err = mail.Send(a.conf, to, fmt.Sprintf("Archive job %d failed", j.ID), body)
+	if err != nil {
+		logger.Criticalf("Unable to notify admins of job %d's failure: %s", j.ID, err)
+	}
 }
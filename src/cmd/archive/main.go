@@ -3,18 +3,53 @@ package main
 import (
 	"time"
 
+	"github.com/uoregon-libraries/gopkg/interrupts"
+	"github.com/uoregon-libraries/gopkg/logger"
 	"github.com/uoregon-libraries/headlamp/src/db"
 )
 
 func main() {
-	var a = &Archiver{
-		conf: getCLI(),
-		dbh:  db.New(),
+	var a = newArchiver(getCLI(), db.New())
+
+	var n, err = a.dbh.Operation().ResetArchiveJobs()
+	if err != nil {
+		logger.Errorf("Unable to requeue interrupted archive jobs: %s", err)
+	} else if n > 0 {
+		logger.Infof("Requeued %d archive job(s) interrupted by the last shutdown", n)
 	}
 
-	for {
+	interrupts.TrapIntTerm(a.Stop)
+	go heartbeat(a.dbh)
+	for !a.stopped() {
 		a.RunPendingArchiveJobs()
+		if a.stopped() {
+			break
+		}
 		a.CleanOldArchives()
-		time.Sleep(time.Minute * 5)
+		a.PurgeOldJobs()
+		a.wait(time.Minute * 5)
+	}
+
+	err = a.dbh.Close()
+	if err != nil {
+		logger.Errorf("Unable to close database: %s", err)
+	}
+	logger.Infof("Archiver stopped")
+}
+
+// heartbeat regularly records that the archiver, and the job it's working on,
+// are alive.  It runs on its own so that heartbeats continue while a large
+// archive is being built.
+func heartbeat(dbh *db.Database) {
+	for {
+		var err = dbh.Operation().Heartbeat(db.WorkerArchiver)
+		if err != nil {
+			logger.Errorf("Unable to record heartbeat: %s", err)
+		}
+		err = dbh.Operation().TouchArchiveJobs()
+		if err != nil {
+			logger.Errorf("Unable to record in-progress jobs' heartbeat: %s", err)
+		}
+		time.Sleep(db.HeartbeatInterval)
 	}
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/uoregon-libraries/gopkg/wordutils"
+	"github.com/uoregon-libraries/headlamp/src/config"
+)
+
+var spaces = regexp.MustCompile(`\s+`)
+
+func perrraw(s string) {
+	fmt.Fprintln(os.Stderr, s)
+}
+
+func perr(s string) {
+	s = strings.TrimSpace(s)
+	s = spaces.ReplaceAllString(s, " ")
+	perrraw(wordutils.Wrap(s, 80))
+}
+func perrf(s string, args ...interface{}) {
+	perr(fmt.Sprintf(s, args...))
+}
+
+// options holds the command-line flags
+type options struct {
+	sample   int
+	category string
+}
+
+func usage(msg string) {
+	var status = 0
+	if msg != "" {
+		perr(msg)
+		perr("")
+		status = 1
+	}
+
+	perrf("Usage: %s <settings file> [-sample=N] [-category=name]", os.Args[0])
+	perrraw("")
+	perr("Re-hashes files from the dark archive and compares them to the stored " +
+		"checksums.  With -sample, only the N files which have gone longest without " +
+		"a check are hashed; otherwise every file is.  -category limits the check " +
+		"to a single category.  Exits with status 2 if any file is missing or " +
+		"doesn't match.")
+
+	os.Exit(status)
+}
+
+func getCLI() (*config.Config, *options) {
+	if len(os.Args) < 2 {
+		usage("You must specify a settings file")
+	}
+
+	var opts = &options{}
+	var fs = flag.NewFlagSet("fixity", flag.ContinueOnError)
+	fs.IntVar(&opts.sample, "sample", 0, "number of files to check (0 checks everything)")
+	fs.StringVar(&opts.category, "category", "", "only check files in this category")
+	fs.Usage = func() { usage("") }
+	var err = fs.Parse(os.Args[2:])
+	if err != nil {
+		usage(err.Error())
+	}
+	if fs.NArg() > 0 {
+		usage("Too many arguments")
+	}
+	if opts.sample < 0 {
+		usage("-sample must not be negative")
+	}
+
+	var c *config.Config
+	c, err = config.Read(os.Args[1])
+	if err != nil {
+		perrf("Invalid configuration: %s", err)
+		os.Exit(1)
+	}
+
+	return c, opts
+}
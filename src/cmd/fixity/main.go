@@ -0,0 +1,182 @@
+// The fixity command re-hashes archived files and compares them to the
+// checksums recorded when they were indexed, to catch bit rot and files which
+// have gone missing from the dark archive
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/uoregon-libraries/gopkg/interrupts"
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/config"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// report collects the results of a fixity run
+type report struct {
+	checked    int
+	ok         int
+	mismatched []*problem
+	missing    []*problem
+	errors     []*problem
+}
+
+// problem pairs a file with its failed check
+type problem struct {
+	file  *db.File
+	check *db.FixityCheck
+}
+
+func main() {
+	var conf, opts = getCLI()
+	var dbh = db.New()
+	var op = dbh.Operation()
+
+	var c *db.Category
+	if opts.category != "" {
+		var err error
+		c, err = op.FindCategoryByName(opts.category)
+		if err != nil {
+			logger.Fatalf("Unable to look up category %q: %s", opts.category, err)
+		}
+		if c == nil {
+			logger.Fatalf("Category %q does not exist", opts.category)
+		}
+	}
+
+	var ids, err = op.FixityFileIDs(c, opts.sample)
+	if err != nil {
+		logger.Fatalf("Unable to find files to check: %s", err)
+	}
+	logger.Infof("Checking %d file(s)", len(ids))
+
+	var stop int32
+	interrupts.TrapIntTerm(func() {
+		atomic.StoreInt32(&stop, 1)
+	})
+
+	var r = &report{}
+	for _, id := range ids {
+		if atomic.LoadInt32(&stop) == 1 {
+			logger.Warnf("Interrupted; reporting on the files checked so far")
+			break
+		}
+
+		var f *db.File
+		f, err = op.FindFileByID(id)
+		if err != nil {
+			logger.Fatalf("Unable to read file %d: %s", id, err)
+		}
+		if f == nil {
+			continue
+		}
+
+		var check = checkFile(conf, f)
+		err = op.WriteFixityCheck(check)
+		if err != nil {
+			logger.Fatalf("Unable to record fixity check for file %d: %s", id, err)
+		}
+		r.add(f, check)
+
+		if r.checked%1000 == 0 {
+			logger.Infof("Checked %d of %d file(s)", r.checked, len(ids))
+		}
+	}
+
+	r.print(os.Stdout)
+	if len(r.mismatched) > 0 || len(r.missing) > 0 {
+		os.Exit(2)
+	}
+}
+
+// checkFile hashes the file from the dark archive and compares the result
+// to its stored checksum
+func checkFile(conf *config.Config, f *db.File) *db.FixityCheck {
+	var check = &db.FixityCheck{FileID: f.ID, Expected: f.Checksum}
+	var root, err = conf.RootPath(f.Root)
+	if err != nil {
+		check.Status = db.FixityError
+		check.Message = err.Error()
+		return check
+	}
+
+	var sum string
+	sum, err = hashFile(filepath.Join(root, f.FullPath))
+	switch {
+	case os.IsNotExist(err):
+		check.Status = db.FixityMissing
+	case err != nil:
+		check.Status = db.FixityError
+		check.Message = err.Error()
+	case db.ChecksumsMatch(f.Checksum, sum):
+		check.Status = db.FixityOK
+		check.Actual = sum
+	default:
+		check.Status = db.FixityMismatch
+		check.Actual = sum
+	}
+	return check
+}
+
+// hashFile returns the hex-encoded SHA-256 sum of the file at path
+func hashFile(path string) (string, error) {
+	var f, err = os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var h = sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// add files the check's result in the report
+func (r *report) add(f *db.File, check *db.FixityCheck) {
+	r.checked++
+	var p = &problem{f, check}
+	switch check.Status {
+	case db.FixityOK:
+		r.ok++
+	case db.FixityMismatch:
+		r.mismatched = append(r.mismatched, p)
+	case db.FixityMissing:
+		r.missing = append(r.missing, p)
+	default:
+		r.errors = append(r.errors, p)
+	}
+}
+
+// print writes a summary of the run, followed by every file which failed
+func (r *report) print(w io.Writer) {
+	fmt.Fprintf(w, "Checked %d file(s): %d ok, %d mismatched, %d missing, %d unreadable\n",
+		r.checked, r.ok, len(r.mismatched), len(r.missing), len(r.errors))
+
+	if len(r.mismatched) > 0 {
+		fmt.Fprintln(w, "\nMismatched checksums:")
+		for _, p := range r.mismatched {
+			fmt.Fprintf(w, "  %s (expected %s, got %s)\n", p.file.FullPath, p.check.Expected, p.check.Actual)
+		}
+	}
+	if len(r.missing) > 0 {
+		fmt.Fprintln(w, "\nMissing files:")
+		for _, p := range r.missing {
+			fmt.Fprintf(w, "  %s\n", p.file.FullPath)
+		}
+	}
+	if len(r.errors) > 0 {
+		fmt.Fprintln(w, "\nUnreadable files:")
+		for _, p := range r.errors {
+			fmt.Fprintf(w, "  %s: %s\n", p.file.FullPath, p.check.Message)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/accesslog"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// accessLog is where requests are recorded when ACCESS_LOG_FILE is set
+var accessLog *accesslog.Logger
+
+// openAccessLog sets up the access log, if one is configured
+func openAccessLog() error {
+	if conf.AccessLogFile == "" {
+		return nil
+	}
+
+	var f, err = accesslog.OpenFile(conf.AccessLogFile, int64(conf.AccessLogMaxMB)<<20, conf.AccessLogRotate, conf.AccessLogKeep)
+	if err != nil {
+		return err
+	}
+	accessLog, err = accesslog.New(f, conf.AccessLogFormat)
+	if err != nil {
+		f.Close()
+	}
+	return err
+}
+
+// accessTokenKey is the request context key holding where requireScope
+// leaves the name of the API token a request used, so it can be logged
+type accessTokenKey struct{}
+
+// noteAccessToken records the API token a request was authenticated with
+func noteAccessToken(r *http.Request, t *db.APIToken) {
+	var name, ok = r.Context().Value(accessTokenKey{}).(*string)
+	if ok {
+		*name = "token:" + t.Name
+	}
+}
+
+// logAccess wraps h to write each request to the access log.  The user is
+// whoever the session belonged to when the request came in, or, for a login,
+// whoever it belongs to afterward; API requests are logged with their token's
+// name.  Sizes are counted before compression.
+func logAccess(h http.Handler) http.Handler {
+	if accessLog == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start = time.Now()
+		var token string
+		r = r.WithContext(context.WithValue(r.Context(), accessTokenKey{}, &token))
+		var u = currentUser(r)
+
+		var sr = &statusRecorder{ResponseWriter: w}
+		defer func() {
+			var p = recover()
+			if p != nil && sr.status == 0 {
+				sr.status = http.StatusInternalServerError
+			}
+			writeAccessLog(r, sr, start, u, token)
+			if p != nil {
+				panic(p)
+			}
+		}()
+		h.ServeHTTP(sr, r)
+	})
+}
+
+// writeAccessLog records the finished request.  A request which panicked
+// before sending anything is logged as a server error, since that's what
+// net/http will answer with.
+func writeAccessLog(r *http.Request, sr *statusRecorder, start time.Time, u *db.User, token string) {
+	if u == nil {
+		u = currentUser(r)
+	}
+	var e = &accesslog.Entry{
+		Time:       start,
+		RemoteAddr: clientIP(r),
+		Method:     r.Method,
+		URI:        r.URL.RequestURI(),
+		Proto:      r.Proto,
+		Status:     sr.status,
+		Bytes:      sr.bytes,
+		Duration:   time.Since(start),
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+	}
+	if e.Status == 0 {
+		e.Status = http.StatusOK
+	}
+	switch {
+	case token != "":
+		e.User = token
+	case u != nil:
+		e.User = u.Login
+	}
+
+	var err = accessLog.Log(e)
+	if err != nil {
+		logger.Errorf("Unable to write to access log: %s", err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// adminCategoriesHandler lists every category with its stats, along with
+// the forms for renaming, merging, hiding, and limiting access to each
+func adminCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != adminCategoriesPath() {
+		_404(w, r, "Page not found")
+		return
+	}
+
+	var stats, err = dbh.Operation().AllCategoryStats()
+	if err != nil {
+		logger.Errorf("Unable to read category stats: %s", err)
+		_500(w, r, "Error trying to read categories.  Try again or contact support.")
+		return
+	}
+
+	adminCategories.Render(w, r, vars{
+		"Title":      "Headlamp: Categories",
+		"Categories": stats,
+		"Roles":      db.Roles,
+	})
+}
+
+// getPathCategory returns the category whose id is the fourth part of the
+// request path, e.g., /admin/categories/merge/<id>.  If the request is
+// invalid or the category can't be found, an error response is sent and nil
+// is returned.
+func getPathCategory(w http.ResponseWriter, r *http.Request, op *db.Operation) *db.Category {
+	var parts = getPathParts(r)
+	if len(parts) != 4 || r.Method != http.MethodPost {
+		_400(w, r, "Invalid request")
+		return nil
+	}
+
+	var id, err = strconv.Atoi(parts[3])
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return nil
+	}
+
+	var c *db.Category
+	c, err = op.FindCategoryByID(id)
+	if err != nil {
+		logger.Errorf("Unable to look up category id %d: %s", id, err)
+		_500(w, r, "Unable to find the requested category.  Try again or contact support.")
+		return nil
+	}
+	if c == nil {
+		_404(w, r, "Unable to find the requested category")
+		return nil
+	}
+	return c
+}
+
+// adminMergeCategoryHandler moves everything in a category into the one
+// named in the "into" field, then removes it
+func adminMergeCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	var src = getPathCategory(w, r, dbh.Operation())
+	if src == nil {
+		return
+	}
+
+	var destName = r.FormValue("into")
+	var dest *db.Category
+	var report *db.MergeReport
+	var err = dbh.InTransaction(func(op *db.Operation) error {
+		var err error
+		dest, err = op.FindCategoryByName(destName)
+		if err != nil {
+			return err
+		}
+		if dest == nil {
+			return fmt.Errorf("category %q not found", destName)
+		}
+		report, err = op.MergeCategories(src, dest)
+		return err
+	})
+	if err != nil {
+		logger.Warnf("Unable to merge category %q into %q: %s", src.Name, destName, err)
+		setAlert(w, r, fmt.Sprintf("Unable to merge %q into %q: %s", src.Name, destName, err))
+		http.Redirect(w, r, adminCategoriesPath(), http.StatusSeeOther)
+		return
+	}
+
+	logger.Infof("%s merged category %q into %q: %d folder(s) and %d file(s) moved, %d folder(s) combined, %d duplicate file(s) dropped",
+		currentUser(r).Login, src.Name, dest.Name, report.MovedFolders, report.MovedFiles, report.MergedFolders, len(report.DuplicateFiles))
+	var msg = fmt.Sprintf("Merged %q into %q: moved %d folder(s) and %d file(s), combined %d folder(s), and dropped %d duplicate file(s)",
+		src.Name, dest.Name, report.MovedFolders, report.MovedFiles, report.MergedFolders, len(report.DuplicateFiles))
+	if len(report.Conflicts) > 0 {
+		msg += fmt.Sprintf(".  %d duplicate(s) had a different checksum and kept %q's record; see the server log for their paths",
+			len(report.Conflicts), dest.Name)
+		for _, path := range report.Conflicts {
+			logger.Warnf("Checksum mismatch merging %q into %q (kept %q's record): %s", src.Name, dest.Name, dest.Name, path)
+		}
+	}
+	setInfo(w, r, msg)
+	http.Redirect(w, r, adminCategoriesPath(), http.StatusSeeOther)
+}
+
+// adminCategoryAccessHandler sets whether a category is hidden from the
+// public category list and which role is needed to see it
+func adminCategoryAccessHandler(w http.ResponseWriter, r *http.Request) {
+	var op = dbh.Operation()
+	var c = getPathCategory(w, r, op)
+	if c == nil {
+		return
+	}
+
+	var err = op.SetCategoryAccessRole(c, r.FormValue("access_role"))
+	if err == nil {
+		err = op.SetCategoryHidden(c, r.FormValue("hidden") == "1")
+	}
+	if err == nil {
+		err = op.SetCategoryCrawlable(c, r.FormValue("crawlable") == "1")
+	}
+	if err != nil {
+		logger.Warnf("Unable to change access to category %q: %s", c.Name, err)
+		setAlert(w, r, fmt.Sprintf("Unable to change access to %q: %s", c.Name, err))
+		http.Redirect(w, r, adminCategoriesPath(), http.StatusSeeOther)
+		return
+	}
+
+	logger.Infof("%s set category %q to hidden=%t, crawlable=%t, access role %q",
+		currentUser(r).Login, c.Name, c.Hidden, c.Crawlable, c.AccessRole)
+	setInfo(w, r, fmt.Sprintf("Access to %q has been updated", c.Name))
+	http.Redirect(w, r, adminCategoriesPath(), http.StatusSeeOther)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// dashboardErrors is how many recent archive job errors the dashboard lists
+const dashboardErrors = 10
+
+// lowDiskPercent is the free space, as a percentage of a file system's size,
+// below which the dashboard warns about it
+const lowDiskPercent = 10
+
+// jobCount is the number of archive jobs in one status, linked to the jobs
+// page filtered to that status
+type jobCount struct {
+	Status string
+	Count  int
+	URL    string
+}
+
+// diskUsage describes the file system holding one of the locations headlamp
+// reads or writes
+type diskUsage struct {
+	Label string
+	Path  string
+	Total int64
+	Free  int64
+	OK    bool
+}
+
+// FreePercent returns the free space as a percentage of the total
+func (d diskUsage) FreePercent() float64 {
+	if d.Total == 0 {
+		return 0
+	}
+	return float64(d.Free) * 100 / float64(d.Total)
+}
+
+// newDiskUsage reads the space on the file system holding path
+func newDiskUsage(label, path string) diskUsage {
+	var total, free, ok = diskSpace(path)
+	return diskUsage{Label: label, Path: path, Total: int64(total), Free: int64(free), OK: ok}
+}
+
+// adminDashboardHandler summarizes the health of the index, the archive job
+// queue, the database, and the disks headlamp uses
+func adminDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != adminDashboardPath() {
+		_404(w, r, "Page not found")
+		return
+	}
+
+	var op = dbh.Operation()
+	var stats, err = op.Stats()
+	if err != nil {
+		logger.Errorf("Unable to read database stats: %s", err)
+		_500(w, r, "Error trying to read database stats.  Try again or contact support.")
+		return
+	}
+
+	var counts map[string]int
+	counts, err = op.ArchiveJobCounts()
+	if err != nil {
+		logger.Errorf("Unable to count archive jobs: %s", err)
+		_500(w, r, "Error trying to read archive jobs.  Try again or contact support.")
+		return
+	}
+	var jobCounts []jobCount
+	for _, status := range archiveJobStatuses {
+		jobCounts = append(jobCounts, jobCount{Status: status, Count: counts[status], URL: adminJobsPath() + "?status=" + status})
+	}
+
+	var jobErrors []*db.ArchiveJob
+	jobErrors, err = op.RecentArchiveJobErrors(dashboardErrors)
+	if err != nil {
+		logger.Errorf("Unable to read archive job errors: %s", err)
+		_500(w, r, "Error trying to read archive jobs.  Try again or contact support.")
+		return
+	}
+
+	var progress []*db.IndexProgress
+	progress, err = op.AllIndexProgress()
+	if err != nil {
+		logger.Errorf("Unable to read indexer progress: %s", err)
+		_500(w, r, "Error trying to read indexer progress.  Try again or contact support.")
+		return
+	}
+
+	var runs []*db.IndexRun
+	runs, err = op.RecentIndexRuns(recentIndexRuns)
+	if err != nil {
+		logger.Errorf("Unable to read index runs: %s", err)
+		_500(w, r, "Error trying to read index runs.  Try again or contact support.")
+		return
+	}
+	var lastRun *db.IndexRun
+	var runErrors []*db.IndexRun
+	for i, run := range runs {
+		if i == 0 {
+			lastRun = run
+		}
+		if run.Errors() > 0 {
+			runErrors = append(runErrors, run)
+		}
+	}
+
+	var data vars
+	data, err = indexRunData(op)
+	if err != nil {
+		logger.Errorf("Unable to read index run status: %s", err)
+		_500(w, r, "Error trying to read indexer progress.  Try again or contact support.")
+		return
+	}
+
+	var dbSize int64
+	var info os.FileInfo
+	info, err = os.Stat(db.Path)
+	if err == nil {
+		dbSize = info.Size()
+	}
+
+	var disks = []diskUsage{
+		newDiskUsage("Dark archive", conf.DARoot),
+		newDiskUsage("Archive output", conf.ArchiveOutputLocation),
+		newDiskUsage("Database", filepath.Dir(db.Path)),
+	}
+	if conf.ThumbnailCacheDir != "" {
+		disks = append(disks, newDiskUsage("Thumbnail cache", conf.ThumbnailCacheDir))
+	}
+
+	data["Title"] = "Headlamp: Admin Dashboard"
+	data["Warnings"] = dashboardWarnings(counts, lastRun, disks)
+	data["Stats"] = stats
+	data["DBSize"] = dbSize
+	data["JobCounts"] = jobCounts
+	data["JobErrors"] = jobErrors
+	data["Progress"] = progress
+	data["LastRun"] = lastRun
+	data["RunErrors"] = runErrors
+	data["Disks"] = disks
+	adminDashboard.Render(w, r, data)
+}
+
+// dashboardWarnings lists the problems an admin should look into, if any
+func dashboardWarnings(counts map[string]int, lastRun *db.IndexRun, disks []diskUsage) []string {
+	var warnings []string
+	if counts[db.JobStatusFailed] > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d archive job(s) have failed", counts[db.JobStatusFailed]))
+	}
+	if lastRun == nil {
+		warnings = append(warnings, "The indexer has never finished a run")
+	} else {
+		if lastRun.Stopped {
+			warnings = append(warnings, "The last index run was stopped before it finished")
+		}
+		if lastRun.Errors() > 0 {
+			warnings = append(warnings, fmt.Sprintf("The last index run had %d error(s)", lastRun.Errors()))
+		}
+	}
+	for _, d := range disks {
+		if !d.OK {
+			warnings = append(warnings, fmt.Sprintf("Unable to read disk space for %s (%s)", d.Label, d.Path))
+		} else if d.FreePercent() < lowDiskPercent {
+			warnings = append(warnings, fmt.Sprintf("%s disk is %.1f%% free", d.Label, d.FreePercent()))
+		}
+	}
+	return warnings
+}
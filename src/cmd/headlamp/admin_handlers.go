@@ -0,0 +1,547 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// archiveJobStatuses lists the statuses admins can filter on
+var archiveJobStatuses = []string{
+	db.JobStatusPending,
+	db.JobStatusInProgress,
+	db.JobStatusSucceeded,
+	db.JobStatusFailed,
+	db.JobStatusCancelled,
+}
+
+// adminJobsHandler shows the archive job queue, optionally filtered by status
+// and notification email
+func adminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	var q = r.URL.Query()
+	var status = q.Get("status")
+	var email = q.Get("email")
+	var page, _ = strconv.Atoi(q.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	var jobs, total, err = dbh.Operation().ListArchiveJobs(status, email, page)
+	if err != nil {
+		logger.Errorf("Unable to list archive jobs: %s", err)
+		_500(w, r, "Error trying to read archive jobs.  Try again or contact support.")
+		return
+	}
+
+	var progress []*db.IndexProgress
+	progress, err = dbh.Operation().AllIndexProgress()
+	if err != nil {
+		logger.Errorf("Unable to read indexer progress: %s", err)
+		_500(w, r, "Error trying to read indexer progress.  Try again or contact support.")
+		return
+	}
+
+	var runs []*db.IndexRun
+	runs, err = dbh.Operation().RecentIndexRuns(recentIndexRuns)
+	if err != nil {
+		logger.Errorf("Unable to read index runs: %s", err)
+		_500(w, r, "Error trying to read index runs.  Try again or contact support.")
+		return
+	}
+
+	// Build the paging links so they keep the current filters
+	var pageLink = func(p int) string {
+		var v = url.Values{}
+		if status != "" {
+			v.Set("status", status)
+		}
+		if email != "" {
+			v.Set("email", email)
+		}
+		v.Set("page", strconv.Itoa(p))
+		return adminJobsPath() + "?" + v.Encode()
+	}
+	var prev, next string
+	if page > 1 {
+		prev = pageLink(page - 1)
+	}
+	if uint64(page*db.ArchiveJobsPerPage) < total {
+		next = pageLink(page + 1)
+	}
+
+	adminJobs.Render(w, r, vars{
+		"Title":    "Headlamp: Archive Jobs",
+		"Jobs":     jobs,
+		"Total":    total,
+		"Page":     page,
+		"PrevPage": prev,
+		"NextPage": next,
+		"Status":   status,
+		"Email":    email,
+		"Statuses": archiveJobStatuses,
+		"Progress": progress,
+		"Runs":     runs,
+	})
+}
+
+// recentIndexRuns is how many index run summaries the jobs page shows
+const recentIndexRuns = 10
+
+// adminRequeueJobHandler puts a failed, cancelled, or stuck archive job back in
+// the queue
+func adminRequeueJobHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	if len(parts) != 4 || r.Method != http.MethodPost {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var id, err = strconv.Atoi(parts[3])
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	err = dbh.Operation().RequeueArchiveJob(id)
+	if err != nil {
+		logger.Warnf("Unable to requeue archive job %d: %s", id, err)
+		setAlert(w, r, fmt.Sprintf("Unable to requeue job %d: %s", id, err))
+		http.Redirect(w, r, adminJobsPath(), http.StatusSeeOther)
+		return
+	}
+
+	setInfo(w, r, fmt.Sprintf("Job %d has been requeued", id))
+	http.Redirect(w, r, adminJobsPath(), http.StatusSeeOther)
+}
+
+// adminRenameCategoryHandler renames a category and sends the admin to its
+// new home, or back to the page given in the "next" field
+func adminRenameCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	if len(parts) != 4 || r.Method != http.MethodPost {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var id, err = strconv.Atoi(parts[3])
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var c *db.Category
+	var oldName string
+	err = dbh.InTransaction(func(op *db.Operation) error {
+		var err error
+		c, err = op.FindCategoryByID(id)
+		if err != nil || c == nil {
+			return err
+		}
+		oldName = c.Name
+		return op.RenameCategory(c, r.FormValue("name"))
+	})
+	if err == nil && c == nil {
+		_404(w, r, "Unable to find the requested category")
+		return
+	}
+	if err != nil {
+		logger.Warnf("Unable to rename category %d: %s", id, err)
+		setAlert(w, r, fmt.Sprintf("Unable to rename the category: %s", err))
+		http.Redirect(w, r, nextOr(r, joinPaths("browse", oldName)), http.StatusSeeOther)
+		return
+	}
+
+	setInfo(w, r, fmt.Sprintf("Category %q has been renamed to %q", oldName, c.Name))
+	http.Redirect(w, r, nextOr(r, browseCategoryPath(c)), http.StatusSeeOther)
+}
+
+// nextOr returns the safe form of the request's "next" field, or p if the
+// field wasn't given
+func nextOr(r *http.Request, p string) string {
+	if r.FormValue("next") == "" {
+		return p
+	}
+	return safeRedirect(r.FormValue("next"))
+}
+
+// adminDescribeFolderHandler sets or clears a folder's description, then
+// sends the curator back to the folder
+func adminDescribeFolderHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	if len(parts) != 4 || r.Method != http.MethodPost {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var id, err = strconv.Atoi(parts[3])
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var op = dbh.Operation()
+	var f *db.Folder
+	f, err = op.FindFolderByID(id)
+	if err == nil && f != nil {
+		f.Category, err = op.FindCategoryByID(f.CategoryID)
+	}
+	if err != nil {
+		logger.Errorf("Unable to look up folder id %d: %s", id, err)
+		_500(w, r, "Unable to find the requested folder.  Try again or contact support.")
+		return
+	}
+	if f == nil || f.Category == nil {
+		_404(w, r, "Unable to find the requested folder")
+		return
+	}
+
+	err = op.SetFolderDescription(f, r.FormValue("description"))
+	if err != nil {
+		logger.Warnf("Unable to describe folder %d: %s", id, err)
+		setAlert(w, r, fmt.Sprintf("Unable to update the folder's description: %s", err))
+		http.Redirect(w, r, browseFolderPath(f), http.StatusSeeOther)
+		return
+	}
+
+	setInfo(w, r, fmt.Sprintf("The description of folder %q has been updated", f.PublicPath))
+	http.Redirect(w, r, browseFolderPath(f), http.StatusSeeOther)
+}
+
+// adminReindexFolderHandler asks the indexer to rebuild the records beneath a
+// folder from its inventories, then sends the admin back to the folder
+func adminReindexFolderHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	if len(parts) != 4 || r.Method != http.MethodPost {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var id, err = strconv.Atoi(parts[3])
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var op = dbh.Operation()
+	var f *db.Folder
+	f, err = op.FindFolderByID(id)
+	if err == nil && f != nil {
+		f.Category, err = op.FindCategoryByID(f.CategoryID)
+	}
+	if err != nil {
+		logger.Errorf("Unable to look up folder id %d: %s", id, err)
+		_500(w, r, "Unable to find the requested folder.  Try again or contact support.")
+		return
+	}
+	if f == nil || f.Category == nil {
+		_404(w, r, "Unable to find the requested folder")
+		return
+	}
+
+	var folderPath = joinPaths("browse", f.Category.Name, sanitizePath(f.PublicPath))
+	var u = currentUser(r)
+	var req *db.IndexRequest
+	var queued bool
+	req, queued, err = op.QueueFolderReindexRequest(u, f)
+	if err != nil {
+		logger.Errorf("Unable to queue reindex of folder id %d: %s", id, err)
+		setAlert(w, r, fmt.Sprintf("Unable to request a reindex of %q: %s", f.PublicPath, err))
+		http.Redirect(w, r, folderPath, http.StatusSeeOther)
+		return
+	}
+
+	if !queued {
+		setInfo(w, r, fmt.Sprintf("A %s is already waiting to start", req))
+		http.Redirect(w, r, folderPath, http.StatusSeeOther)
+		return
+	}
+
+	logger.Infof("%s requested a %s (request %d)", u.Login, req, req.ID)
+	setInfo(w, r, fmt.Sprintf("Requested a %s.  It will start as soon as the indexer picks it up.", req))
+	http.Redirect(w, r, folderPath, http.StatusSeeOther)
+}
+
+// adminRestrictHandler flags or unflags a folder or file as restricted, then
+// sends the admin back to the folder listing they came from
+func adminRestrictHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	if len(parts) != 4 || r.Method != http.MethodPost {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var id, err = strconv.ParseUint(parts[3], 10, 64)
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+	var restricted = r.FormValue("restricted") == "1"
+
+	switch parts[2] {
+	case "folder":
+		restrictFolder(w, r, int(id), restricted)
+	case "file":
+		restrictFile(w, r, id, restricted)
+	default:
+		_400(w, r, "Invalid request")
+	}
+}
+
+// restrictedLabel returns the word used in flash messages after toggling an
+// item's restriction
+func restrictedLabel(restricted bool) string {
+	if restricted {
+		return "restricted"
+	}
+	return "unrestricted"
+}
+
+func restrictFolder(w http.ResponseWriter, r *http.Request, id int, restricted bool) {
+	var op = dbh.Operation()
+	var f, err = op.FindFolderByID(id)
+	if err == nil && f != nil {
+		f.Category, err = op.FindCategoryByID(f.CategoryID)
+	}
+	if err != nil {
+		logger.Errorf("Unable to look up folder id %d: %s", id, err)
+		_500(w, r, "Unable to find the requested folder.  Try again or contact support.")
+		return
+	}
+	if f == nil || f.Category == nil {
+		_404(w, r, "Unable to find the requested folder")
+		return
+	}
+
+	err = op.SetFolderRestricted(f, restricted)
+	if err != nil {
+		logger.Errorf("Unable to change restriction on folder id %d: %s", id, err)
+		_500(w, r, "Unable to update the folder.  Try again or contact support.")
+		return
+	}
+
+	setInfo(w, r, fmt.Sprintf("Folder %q is now %s", f.PublicPath, restrictedLabel(restricted)))
+	var parent = filepath.Dir(f.PublicPath)
+	if parent == "." {
+		parent = ""
+	}
+	http.Redirect(w, r, joinPaths("browse", f.Category.Name, sanitizePath(parent)), http.StatusSeeOther)
+}
+
+func restrictFile(w http.ResponseWriter, r *http.Request, id uint64, restricted bool) {
+	var op = dbh.Operation()
+	var f, err = op.FindFileByID(id)
+	if err == nil && f != nil {
+		f.Category, err = op.FindCategoryByID(f.CategoryID)
+	}
+	if err != nil {
+		logger.Errorf("Unable to look up file id %d: %s", id, err)
+		_500(w, r, "Unable to find the requested file.  Try again or contact support.")
+		return
+	}
+	if f == nil || f.Category == nil {
+		_404(w, r, "Unable to find the requested file")
+		return
+	}
+
+	err = op.SetFileRestricted(f, restricted)
+	if err != nil {
+		logger.Errorf("Unable to change restriction on file id %d: %s", id, err)
+		_500(w, r, "Unable to update the file.  Try again or contact support.")
+		return
+	}
+
+	setInfo(w, r, fmt.Sprintf("File %q is now %s", f.PublicPath, restrictedLabel(restricted)))
+	http.Redirect(w, r, browseContainingFolderPath(f), http.StatusSeeOther)
+}
+
+// adminMissingHandler reports the files which reindexed inventories no
+// longer list, either as per-category totals or, given a category, as a full
+// listing of that category's missing files
+func adminMissingHandler(w http.ResponseWriter, r *http.Request) {
+	var op = dbh.Operation()
+	var totals, err = op.MissingFileTotals()
+	if err != nil {
+		logger.Errorf("Unable to count missing files: %s", err)
+		_500(w, r, "Error trying to read missing files.  Try again or contact support.")
+		return
+	}
+
+	var c *db.Category
+	var files []*db.File
+	var name = r.URL.Query().Get("category")
+	if name != "" {
+		c, err = op.FindCategoryByName(name)
+		if err == nil && c == nil {
+			_404(w, r, "Unable to find the requested category.")
+			return
+		}
+		if err == nil {
+			files, err = op.MissingFiles(c)
+		}
+		if err != nil {
+			logger.Errorf("Unable to list missing files for category %q: %s", name, err)
+			_500(w, r, "Error trying to read missing files.  Try again or contact support.")
+			return
+		}
+	}
+
+	adminMissing.Render(w, r, vars{
+		"Title":    "Headlamp: Missing Files",
+		"Totals":   totals,
+		"Category": c,
+		"Files":    files,
+	})
+}
+
+// adminAPITokensHandler lists the API tokens which have been issued, with a
+// form for issuing new ones
+func adminAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	renderAPITokens(w, r, nil, "")
+}
+
+// renderAPITokens shows the API token list.  When a token has just been
+// issued, newToken is the secret, which is shown only this once.
+func renderAPITokens(w http.ResponseWriter, r *http.Request, issued *db.APIToken, newToken string) {
+	var tokens, err = dbh.Operation().AllAPITokens()
+	if err != nil {
+		logger.Errorf("Unable to list API tokens: %s", err)
+		_500(w, r, "Error trying to read API tokens.  Try again or contact support.")
+		return
+	}
+
+	adminAPITokens.Render(w, r, vars{
+		"Title":    "Headlamp: API Tokens",
+		"Tokens":   tokens,
+		"Scopes":   db.APIScopes,
+		"Issued":   issued,
+		"NewToken": newToken,
+	})
+}
+
+// adminCreateAPITokenHandler issues a new API token and shows it to the admin
+func adminCreateAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		_400(w, r, "Invalid request")
+		return
+	}
+	r.ParseForm()
+
+	var u = currentUser(r)
+	var t, token, err = dbh.Operation().CreateAPIToken(u, r.FormValue("name"), r.PostForm["scope"])
+	if err != nil {
+		setAlert(w, r, fmt.Sprintf("Unable to issue the API token: %s", err))
+		http.Redirect(w, r, adminAPITokensPath(), http.StatusSeeOther)
+		return
+	}
+
+	logger.Infof("%s issued API token %d (%q) with scopes %s", u.Login, t.ID, t.Name, t.Scopes)
+	renderAPITokens(w, r, t, token)
+}
+
+// adminRevokeAPITokenHandler revokes an API token so it can no longer be used
+func adminRevokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	if len(parts) != 4 || r.Method != http.MethodPost {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var id, err = strconv.Atoi(parts[3])
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	err = dbh.Operation().RevokeAPIToken(id)
+	if err != nil {
+		logger.Warnf("Unable to revoke API token %d: %s", id, err)
+		setAlert(w, r, fmt.Sprintf("Unable to revoke token %d: %s", id, err))
+		http.Redirect(w, r, adminAPITokensPath(), http.StatusSeeOther)
+		return
+	}
+
+	logger.Infof("%s revoked API token %d", currentUser(r).Login, id)
+	setInfo(w, r, fmt.Sprintf("Token %d has been revoked", id))
+	http.Redirect(w, r, adminAPITokensPath(), http.StatusSeeOther)
+}
+
+// adminUser pairs a user with the role they effectively have, which can be
+// more than the role assigned here
+type adminUser struct {
+	*db.User
+	EffectiveRole string
+}
+
+// adminUsersHandler lists everybody who has logged in, with their roles
+func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var users, err = dbh.Operation().AllUsers()
+	if err != nil {
+		logger.Errorf("Unable to list users: %s", err)
+		_500(w, r, "Error trying to read users.  Try again or contact support.")
+		return
+	}
+
+	var list = make([]adminUser, len(users))
+	for i, u := range users {
+		list[i] = adminUser{User: u, EffectiveRole: userRole(u)}
+	}
+	adminUsers.Render(w, r, vars{
+		"Title": "Headlamp: Users",
+		"Users": list,
+		"Roles": db.Roles,
+	})
+}
+
+// adminSetUserRoleHandler assigns a user's role.  Admins can't change their
+// own role, so nobody can accidentally lock out the last admin.
+func adminSetUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	if len(parts) != 4 || r.Method != http.MethodPost {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var id, err = strconv.Atoi(parts[3])
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var op = dbh.Operation()
+	var u *db.User
+	u, err = op.FindUserByID(id)
+	if err != nil {
+		logger.Errorf("Unable to look up user %d: %s", id, err)
+		_500(w, r, "Error trying to read the user.  Try again or contact support.")
+		return
+	}
+	if u == nil {
+		_404(w, r, "User not found")
+		return
+	}
+
+	var admin = currentUser(r)
+	if u.ID == admin.ID {
+		setAlert(w, r, "You can't change your own role")
+		http.Redirect(w, r, adminUsersPath(), http.StatusSeeOther)
+		return
+	}
+
+	var role = r.FormValue("role")
+	err = op.SetUserRole(u, role)
+	if err != nil {
+		logger.Warnf("Unable to set role for user %d: %s", id, err)
+		setAlert(w, r, fmt.Sprintf("Unable to change %s's role: %s", u.Login, err))
+		http.Redirect(w, r, adminUsersPath(), http.StatusSeeOther)
+		return
+	}
+
+	logger.Infof("%s set %s's role to %s", admin.Login, u.Login, role)
+	setInfo(w, r, fmt.Sprintf("%s is now assigned the %s role", u.Login, role))
+	http.Redirect(w, r, adminUsersPath(), http.StatusSeeOther)
+}
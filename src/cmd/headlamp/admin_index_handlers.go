@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// recentIndexRequests is how many index requests the indexing page lists
+const recentIndexRequests = 20
+
+// indexStatusRefresh is how often, in milliseconds, the indexing page
+// refreshes its status while a request is waiting or running
+const indexStatusRefresh = 5000
+
+// adminIndexHandler shows the form for requesting an index run and the
+// status of recent requests
+func adminIndexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != adminIndexPath() {
+		_404(w, r, "Page not found")
+		return
+	}
+
+	var op = dbh.Operation()
+	var categories, err = op.AllCategories()
+	if err != nil {
+		logger.Errorf("Unable to read categories: %s", err)
+		_500(w, r, "Error trying to read categories.  Try again or contact support.")
+		return
+	}
+
+	var requests []*db.IndexRequest
+	requests, err = op.RecentIndexRequests(recentIndexRequests)
+	if err != nil {
+		logger.Errorf("Unable to read index requests: %s", err)
+		_500(w, r, "Error trying to read index requests.  Try again or contact support.")
+		return
+	}
+
+	var progress []*db.IndexProgress
+	progress, err = op.AllIndexProgress()
+	if err != nil {
+		logger.Errorf("Unable to read indexer progress: %s", err)
+		_500(w, r, "Error trying to read indexer progress.  Try again or contact support.")
+		return
+	}
+
+	var active bool
+	for _, req := range requests {
+		if req.Active() {
+			active = true
+		}
+	}
+
+	adminIndex.Render(w, r, vars{
+		"Title":      "Headlamp: Indexing",
+		"Categories": categories,
+		"Requests":   requests,
+		"Progress":   progress,
+		"Active":     active,
+		"Refresh":    indexStatusRefresh,
+	})
+}
+
+// adminQueueIndexHandler asks the indexer for a full run, or a run limited
+// to the chosen category
+func adminQueueIndexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var op = dbh.Operation()
+	var cname = r.FormValue("category")
+	if cname != "" {
+		var c, err = op.FindCategoryByName(cname)
+		if err != nil {
+			logger.Errorf("Unable to read category %q: %s", cname, err)
+			_500(w, r, fmt.Sprintf("Error trying to find category %q.  Try again or contact support.", cname))
+			return
+		}
+		if c == nil {
+			setAlert(w, r, fmt.Sprintf("Category %q not found", cname))
+			http.Redirect(w, r, adminIndexPath(), http.StatusSeeOther)
+			return
+		}
+		cname = c.Name
+	}
+
+	var u = currentUser(r)
+	var req, queued, err = op.QueueIndexRequest(u, cname)
+	if err != nil {
+		logger.Errorf("Unable to queue index request for category %q: %s", cname, err)
+		setAlert(w, r, fmt.Sprintf("Unable to request an index run: %s", err))
+		http.Redirect(w, r, adminIndexPath(), http.StatusSeeOther)
+		return
+	}
+
+	if !queued {
+		setInfo(w, r, fmt.Sprintf("A %s is already waiting to start", req))
+		http.Redirect(w, r, adminIndexPath(), http.StatusSeeOther)
+		return
+	}
+
+	logger.Infof("%s requested a %s (request %d)", u.Login, req, req.ID)
+	setInfo(w, r, fmt.Sprintf("Requested a %s.  It will start as soon as the indexer picks it up.", req))
+	http.Redirect(w, r, adminIndexPath(), http.StatusSeeOther)
+}
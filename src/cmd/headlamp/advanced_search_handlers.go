@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// advancedSearchHandler shows a single form combining every search criterion
+// and, once the form has been submitted, the matching files
+func advancedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	var op = userOperation(r)
+	var categories, err = op.AllCategories()
+	if err != nil {
+		logger.Errorf("Error trying to read categories from the database: %s", err)
+		_500(w, r, "Error trying to load the search form.  Try again or contact support.")
+		return
+	}
+
+	var q = r.URL.Query()
+	var data = vars{
+		"Title":        "Headlamp: Advanced Search",
+		"Categories":   categories,
+		"SearchTerm":   q.Get("q"),
+		"CategoryName": q.Get("category"),
+		"FolderPath":   q.Get("folder"),
+		"Sort":         q.Get("sort"),
+		"Filters":      getFilterParams(r),
+	}
+	if len(q) == 0 {
+		advancedSearch.Render(w, r, data)
+		return
+	}
+
+	var fq, msg = getFileQuery(w, r, op)
+	if fq == nil {
+		if msg != "" {
+			setAlert(w, r, msg)
+			w.WriteHeader(http.StatusBadRequest)
+			advancedSearch.Render(w, r, data)
+		}
+		return
+	}
+
+	var files []*db.File
+	var total uint64
+	files, total, err = op.QueryFiles(*fq)
+	if err != nil {
+		logger.Errorf("Error running advanced search %q: %s", r.URL.RawQuery, err)
+		_500(w, r, "Error trying to search for files.  Try again or contact support.")
+		return
+	}
+
+	data["Searched"] = true
+	data["SearchMode"] = fq.Mode
+	data["Category"] = fq.Category
+	data["Folder"] = fq.Folder
+	data["Files"] = files
+	data["Sort"] = fq.Sort.String()
+	data["SortLinks"] = sortLinks(r, fq.Sort)
+	data["Pager"] = newPager(r, fq.Page.Offset/fq.Page.Limit+1, fq.Page.Limit, total)
+	data["ExportPath"] = exportResultsPath(r, nil, nil)
+	if fq.Term != "" {
+		data["Highlight"] = newHighlighter(fq.Mode, fq.Term)
+	}
+	advancedSearch.Render(w, r, data)
+}
+
+// getFileQuery builds a file query from the advanced search form.  If the
+// form is invalid, the returned query is nil and the message says what's
+// wrong.  A nil query with an empty message means an error response has
+// already been sent.
+func getFileQuery(w http.ResponseWriter, r *http.Request, op *db.Operation) (*db.FileQuery, string) {
+	var q = r.URL.Query()
+	var fq = &db.FileQuery{Term: q.Get("q")}
+	var err error
+
+	fq.Mode, err = db.ParseSearchMode(q.Get("mode"))
+	if err == nil {
+		err = fq.Mode.Validate(fq.Term)
+	}
+	if err != nil {
+		return nil, fmt.Sprintf("Invalid search: %s", err)
+	}
+
+	fq.Filter, err = getFilterParams(r).FileFilter()
+	if err != nil {
+		return nil, fmt.Sprintf("Invalid search: %s", err)
+	}
+	fq.Sort, err = db.ParseFileSort(q.Get("sort"))
+	if err != nil {
+		return nil, fmt.Sprintf("Invalid search: %s", err)
+	}
+
+	var page, perPage uint64
+	page, perPage, err = getPage(r)
+	if err != nil {
+		return nil, fmt.Sprintf("Invalid search: %s", err)
+	}
+	fq.Page = db.Page{Offset: (page - 1) * perPage, Limit: perPage}
+
+	var cname, fpath = q.Get("category"), strings.Trim(q.Get("folder"), "/")
+	if cname == "" {
+		if fpath != "" {
+			return nil, "You must choose a category to search within a folder"
+		}
+		return fq, ""
+	}
+
+	fq.Category, err = op.FindCategoryByName(cname)
+	if err != nil {
+		logger.Errorf("Error trying to read category %q from the database: %s", cname, err)
+		_500(w, r, fmt.Sprintf("Error trying to find category %q.  Try again or contact support.", cname))
+		return nil, ""
+	}
+	if fq.Category == nil {
+		return nil, fmt.Sprintf("Category %q not found", cname)
+	}
+	if fpath == "" {
+		return fq, ""
+	}
+
+	fq.Folder, err = op.FindFolderByPath(fq.Category, fpath)
+	if err != nil {
+		logger.Errorf("Error trying to read folder %q (in category %q) from the database: %s", fpath, cname, err)
+		_500(w, r, fmt.Sprintf("Error trying to find folder %q.  Try again or contact support.", fpath))
+		return nil, ""
+	}
+	if fq.Folder == nil {
+		return nil, fmt.Sprintf("Folder %q not found in category %q", fpath, cname)
+	}
+	return fq, ""
+}
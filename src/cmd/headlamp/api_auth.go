@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// apiTokenKey is the request context key holding the API token a request
+// was authenticated with
+type apiTokenKey struct{}
+
+// requireScope wraps an API handler so that it's only reachable with an
+// "Authorization: Bearer <token>" header naming a token which holds the
+// given scope.  Requests without the header are allowed through for the read
+// scope, where they get the same access as the web app's session would give.
+func requireScope(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var header = r.Header.Get("Authorization")
+		if header == "" {
+			if scope == db.ScopeRead {
+				h(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="headlamp"`)
+			apiError(w, http.StatusUnauthorized, "You must provide an API token to do that")
+			return
+		}
+
+		var fields = strings.Fields(header)
+		if len(fields) != 2 || !strings.EqualFold(fields[0], "Bearer") {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="headlamp", error="invalid_request"`)
+			apiError(w, http.StatusUnauthorized, "Invalid Authorization header; expected a Bearer token")
+			return
+		}
+
+		var op = dbh.Operation()
+		var t, err = op.FindAPIToken(fields[1])
+		if err != nil {
+			logger.Errorf("Unable to look up API token: %s", err)
+			apiError(w, http.StatusInternalServerError, "Unable to verify your API token")
+			return
+		}
+		if t == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="headlamp", error="invalid_token"`)
+			apiError(w, http.StatusUnauthorized, "Invalid or revoked API token")
+			return
+		}
+		if !t.HasScope(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="headlamp", error="insufficient_scope", scope=%q`, scope))
+			apiError(w, http.StatusForbidden, fmt.Sprintf("This API token doesn't have the %q scope", scope))
+			return
+		}
+
+		err = op.TouchAPIToken(t)
+		if err != nil {
+			logger.Warnf("Unable to record use of API token %d: %s", t.ID, err)
+		}
+		noteAccessToken(r, t)
+		h(w, r.WithContext(context.WithValue(r.Context(), apiTokenKey{}, t)))
+	}
+}
+
+// requestAPIToken returns the token the request was authenticated with, or
+// nil if it didn't use one
+func requestAPIToken(r *http.Request) *db.APIToken {
+	var t, _ = r.Context().Value(apiTokenKey{}).(*db.APIToken)
+	return t
+}
+
+// apiStaff returns true if an API request gets staff access: its token has
+// the admin scope, or it has no token and the session belongs to staff
+func apiStaff(r *http.Request) bool {
+	var t = requestAPIToken(r)
+	if t != nil {
+		return t.HasScope(db.ScopeAdmin)
+	}
+	return isStaff(currentUser(r))
+}
+
+// apiOperation is the API's version of userOperation.  Tokens with the admin
+// scope see everything, and other tokens only see what anonymous visitors
+// can; requests without a token get their session user's access.
+func apiOperation(r *http.Request) *db.Operation {
+	var op = dbh.Operation()
+	var t = requestAPIToken(r)
+	switch {
+	case t != nil && t.HasScope(db.ScopeAdmin):
+	case t != nil:
+		op.HideRestricted()
+	default:
+		op.ForRole(userRole(currentUser(r)))
+	}
+	return op
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// apiVersion is the path element all API routes live under, e.g.,
+// "/api/v1/categories"
+const apiVersion = "v1"
+
+// defaultPerPage is the page size API responses use when the request doesn't
+// ask for one; requests can't ask for more than maxFiles per page
+const defaultPerPage = 100
+
+// apiCategory is the JSON representation of a category
+type apiCategory struct {
+	ID   int     `json:"id"`
+	Name string  `json:"name"`
+	URLs apiURLs `json:"urls"`
+}
+
+// apiFolder is the JSON representation of a folder
+type apiFolder struct {
+	ID         int     `json:"id"`
+	Category   string  `json:"category"`
+	Name       string  `json:"name"`
+	PublicPath string  `json:"public_path"`
+	Restricted bool    `json:"restricted,omitempty"`
+	URLs       apiURLs `json:"urls"`
+}
+
+// apiFile is the JSON representation of a file.  The dark archive location
+// is only included for staff.
+type apiFile struct {
+	ID           uint64     `json:"id"`
+	Category     string     `json:"category"`
+	Name         string     `json:"name"`
+	PublicPath   string     `json:"public_path"`
+	ArchiveDate  string     `json:"archive_date"`
+	Checksum     string     `json:"checksum"`
+	Size         int64      `json:"size"`
+	Extension    string     `json:"extension"`
+	MimeType     string     `json:"mime_type"`
+	FormatFamily string     `json:"format_family"`
+	ModifiedAt   *time.Time `json:"modified_at,omitempty"`
+	IndexedAt    *time.Time `json:"indexed_at,omitempty"`
+	Missing      bool       `json:"missing"`
+	Restricted   bool       `json:"restricted,omitempty"`
+	Root         string     `json:"root,omitempty"`
+	FullPath     string     `json:"full_path,omitempty"`
+	URLs         apiURLs    `json:"urls"`
+}
+
+// apiURLs links a resource to its API and HTML representations
+type apiURLs struct {
+	API      string `json:"api,omitempty"`
+	HTML     string `json:"html,omitempty"`
+	Download string `json:"download,omitempty"`
+}
+
+// apiPage describes which part of a larger list a response holds.  Next is
+// the URL of the following page, if there is one.
+type apiPage struct {
+	Page    uint64 `json:"page"`
+	PerPage uint64 `json:"per_page"`
+	Total   uint64 `json:"total"`
+	Next    string `json:"next,omitempty"`
+}
+
+// apiResponse holds everything a browse or search response can contain;
+// unused parts are left out of the JSON.  Folders and Files are pointers so
+// an empty list can be told apart from one which doesn't apply.
+type apiResponse struct {
+	Category *apiCategory  `json:"category,omitempty"`
+	Folder   *apiFolder    `json:"folder,omitempty"`
+	Query    string        `json:"query,omitempty"`
+	Mode     db.SearchMode `json:"mode,omitempty"`
+	Folders  *[]apiFolder  `json:"folders,omitempty"`
+	Files    *[]apiFile    `json:"files,omitempty"`
+	Page     *apiPage      `json:"page,omitempty"`
+}
+
+// setFolders converts folders for the response
+func (resp *apiResponse) setFolders(folders []*db.Folder) {
+	var list = []apiFolder{}
+	for _, f := range folders {
+		list = append(list, *newAPIFolder(f))
+	}
+	resp.Folders = &list
+}
+
+// setFiles converts files for the response; staff see the files' dark
+// archive locations
+func (resp *apiResponse) setFiles(files []*db.File, staff bool) {
+	var list = []apiFile{}
+	for _, f := range files {
+		list = append(list, *newAPIFile(f, staff))
+	}
+	resp.Files = &list
+}
+
+// apiPath returns the API URL built from the given parts, escaped so it can
+// be used as-is by clients
+func apiPath(parts ...string) string {
+	parts = append([]string{"api", apiVersion}, parts...)
+	return escapePath(joinPaths(parts...))
+}
+
+// escapePath returns p in the form it would take in a URL
+func escapePath(p string) string {
+	var u = url.URL{Path: p}
+	return u.EscapedPath()
+}
+
+func newAPICategory(c *db.Category) *apiCategory {
+	return &apiCategory{
+		ID:   c.ID,
+		Name: c.Name,
+		URLs: apiURLs{
+			API:  apiPath("browse", c.Name),
+			HTML: escapePath(browseCategoryPath(c)),
+		},
+	}
+}
+
+func newAPIFolder(f *db.Folder) *apiFolder {
+	return &apiFolder{
+		ID:         f.ID,
+		Category:   f.Category.Name,
+		Name:       f.Name,
+		PublicPath: sanitizePath(f.PublicPath),
+		Restricted: f.Restricted,
+		URLs: apiURLs{
+			API:  apiPath("browse", pathify(f.Category, f)),
+			HTML: escapePath(browseFolderPath(f)),
+		},
+	}
+}
+
+func newAPIFile(f *db.File, staff bool) *apiFile {
+	var af = &apiFile{
+		ID:           f.ID,
+		Category:     f.Category.Name,
+		Name:         f.Name,
+		PublicPath:   sanitizePath(f.PublicPath),
+		ArchiveDate:  f.ArchiveDate,
+		Checksum:     f.Checksum,
+		Size:         f.Filesize,
+		Extension:    f.Extension,
+		MimeType:     f.MimeType,
+		FormatFamily: f.FormatFamily,
+		ModifiedAt:   apiTime(f.ModifiedAt),
+		IndexedAt:    apiTime(f.IndexedAt),
+		Missing:      f.Missing(),
+		Restricted:   f.Restricted,
+		URLs: apiURLs{
+			API:      apiPath("files", strconv.FormatUint(f.ID, 10)),
+			HTML:     escapePath(viewFilePath(f)),
+			Download: escapePath(downloadFilePath(f)),
+		},
+	}
+	if staff {
+		af.Root = f.Root
+		af.FullPath = f.FullPath
+	}
+	return af
+}
+
+// apiTime returns nil for zero times so they're left out of the JSON
+func apiTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// apiJSON sends data to the client as JSON with the given status
+func apiJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	var enc = json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	var err = enc.Encode(data)
+	if err != nil {
+		logger.Errorf("Unable to write API response: %s", err)
+	}
+}
+
+// apiError sends a JSON error message with the given status
+func apiError(w http.ResponseWriter, status int, msg string) {
+	apiJSON(w, status, map[string]string{"error": msg})
+}
+
+// getAPIPage reads the "page" and "per_page" query parameters.  Pages start
+// at 1.
+func getAPIPage(r *http.Request) (*apiPage, error) {
+	var q = r.URL.Query()
+	var p = &apiPage{Page: 1, PerPage: defaultPerPage}
+	var err error
+	if q.Get("page") != "" {
+		p.Page, err = strconv.ParseUint(q.Get("page"), 10, 64)
+		if err != nil || p.Page == 0 {
+			return nil, fmt.Errorf("invalid page %q", q.Get("page"))
+		}
+	}
+	if q.Get("per_page") != "" {
+		p.PerPage, err = strconv.ParseUint(q.Get("per_page"), 10, 64)
+		if err != nil || p.PerPage == 0 || p.PerPage > maxFiles {
+			return nil, fmt.Errorf("invalid per_page %q: must be between 1 and %d", q.Get("per_page"), maxFiles)
+		}
+	}
+	return p, nil
+}
+
+// dbPage returns the rows this page covers
+func (p *apiPage) dbPage() db.Page {
+	return db.Page{Offset: (p.Page - 1) * p.PerPage, Limit: p.PerPage}
+}
+
+// setTotal records the total number of items, and sets up the next page's
+// URL if there are items beyond this page
+func (p *apiPage) setTotal(r *http.Request, total uint64) {
+	p.Total = total
+	if p.Page*p.PerPage >= total {
+		return
+	}
+
+	var u = *r.URL
+	var q = u.Query()
+	q.Set("page", strconv.FormatUint(p.Page+1, 10))
+	u.RawQuery = q.Encode()
+	p.Next = u.RequestURI()
+}
+
+// apiNotFoundHandler answers any API request we don't have a route for
+func apiNotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	apiError(w, http.StatusNotFound, "Unknown API endpoint")
+}
+
+// apiCategoriesHandler lists all categories
+func apiCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	var categories, err = apiOperation(r).AllCategories()
+	if err != nil {
+		logger.Errorf("Unable to find categories: %s", err)
+		apiError(w, http.StatusInternalServerError, "Error trying to find category list")
+		return
+	}
+
+	var list = []apiCategory{}
+	for _, c := range categories {
+		list = append(list, *newAPICategory(c))
+	}
+	apiJSON(w, http.StatusOK, map[string][]apiCategory{"categories": list})
+}
+
+// getAPIBrowseSearchData is the API's version of getBrowseSearchData: it
+// pulls the category and folder from a path like
+// "api/v1/browse/<category>/<folder path>", sending JSON errors instead of
+// HTML.  Old category names are looked up rather than redirected.
+func getAPIBrowseSearchData(w http.ResponseWriter, r *http.Request) browseSearchData {
+	var bsd browseSearchData
+	var bsde = browseSearchData{hadError: true}
+
+	// Strip "api" and the version so the parts are laid out just like the HTML
+	// routes' parts
+	var parts = getPathParts(r)[2:]
+
+	bsd.op = apiOperation(r)
+
+	var err error
+	bsd.filters = getFilterParams(r)
+	bsd.fileFilter, err = bsd.filters.FileFilter()
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return bsde
+	}
+
+	if len(parts) < 2 || parts[1] == "" {
+		return bsd
+	}
+
+	bsd.pName = parts[1]
+	bsd.folderPath = filepath.Join(parts[2:]...)
+
+	bsd.category, err = bsd.op.FindCategoryByName(bsd.pName)
+	if err == nil && bsd.category == nil {
+		bsd.category, err = bsd.op.FindCategoryByOldName(bsd.pName)
+	}
+	if err != nil {
+		logger.Errorf("Error trying to read category %q from the database: %s", bsd.pName, err)
+		apiError(w, http.StatusInternalServerError, fmt.Sprintf("Error trying to find category %q", bsd.pName))
+		return bsde
+	}
+	if bsd.category == nil {
+		apiError(w, http.StatusNotFound, fmt.Sprintf("Category %q not found", bsd.pName))
+		return bsde
+	}
+
+	if bsd.folderPath != "" {
+		bsd.folder, err = bsd.op.FindFolderByPath(bsd.category, bsd.folderPath)
+		if err != nil {
+			logger.Errorf("Error trying to read folder %q (in category %q) from the database: %s",
+				bsd.folderPath, bsd.pName, err)
+			apiError(w, http.StatusInternalServerError, fmt.Sprintf("Error trying to find folder %q", bsd.folderPath))
+			return bsde
+		}
+		if bsd.folder == nil {
+			apiError(w, http.StatusNotFound, fmt.Sprintf("Folder %q not found", bsd.folderPath))
+			return bsde
+		}
+		bsd.folder.Category = bsd.category
+	}
+
+	return bsd
+}
+
+// apiResponse returns the API response with the category and folder filled in
+func (bsd browseSearchData) apiResponse() apiResponse {
+	var resp apiResponse
+	if bsd.category != nil {
+		resp.Category = newAPICategory(bsd.category)
+	}
+	if bsd.folder != nil {
+		resp.Folder = newAPIFolder(bsd.folder)
+	}
+	return resp
+}
+
+// apiBrowseHandler lists a category's or folder's subfolders and a page of
+// its files
+func apiBrowseHandler(w http.ResponseWriter, r *http.Request) {
+	var page, err = getAPIPage(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var bsd = getAPIBrowseSearchData(w, r)
+	if bsd.hadError {
+		return
+	}
+	if bsd.category == nil {
+		apiError(w, http.StatusBadRequest, "You must specify a category to browse")
+		return
+	}
+
+	var folders []*db.Folder
+	folders, err = bsd.op.GetFolders(bsd.category, bsd.folder)
+	if err != nil {
+		logger.Errorf("Error trying to read folders under %q (in category %q) from the database: %s",
+			bsd.folderPath, bsd.pName, err)
+		apiError(w, http.StatusInternalServerError, fmt.Sprintf("Error trying to read folder %q", bsd.folderPath))
+		return
+	}
+
+	var fs db.FileSort
+	fs, err = db.ParseFileSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var files []*db.File
+	var total uint64
+	files, total, err = bsd.op.GetFiles(bsd.category, bsd.folder, bsd.fileFilter, fs, page.dbPage())
+	if err != nil {
+		logger.Errorf("Error trying to read files under %q (in category %q) from the database: %s",
+			bsd.folderPath, bsd.pName, err)
+		apiError(w, http.StatusInternalServerError, fmt.Sprintf("Error trying to read folder %q", bsd.folderPath))
+		return
+	}
+
+	var resp = bsd.apiResponse()
+	resp.setFolders(folders)
+	resp.setFiles(files, apiStaff(r))
+	page.setTotal(r, total)
+	resp.Page = page
+	apiJSON(w, http.StatusOK, resp)
+}
+
+// apiSearchHandler searches files (the "q" parameter) or folders ("fq") under
+// the given category and folder, if any
+func apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	var page, err = getAPIPage(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var bsd = getAPIBrowseSearchData(w, r)
+	if bsd.hadError {
+		return
+	}
+
+	var q = r.URL.Query().Get("q")
+	var fq = r.URL.Query().Get("fq")
+	if q == "" && fq == "" {
+		apiError(w, http.StatusBadRequest, "You must provide a search term")
+		return
+	}
+
+	var mode db.SearchMode
+	mode, err = db.ParseSearchMode(r.URL.Query().Get("mode"))
+	if err == nil {
+		err = mode.Validate(q + fq)
+	}
+	if err != nil {
+		apiError(w, http.StatusBadRequest, fmt.Sprintf("Invalid search: %s", err))
+		return
+	}
+
+	var resp = bsd.apiResponse()
+	resp.Mode = mode
+	var total uint64
+	if fq != "" {
+		var folders []*db.Folder
+		folders, total, err = bsd.op.SearchFolders(bsd.category, bsd.folder, fq, mode, page.dbPage())
+		resp.Query = fq
+		resp.setFolders(folders)
+	} else {
+		var files []*db.File
+		files, total, err = bsd.op.SearchFiles(bsd.category, bsd.folder, q, mode, bsd.fileFilter, page.dbPage())
+		resp.Query = q
+		resp.setFiles(files, apiStaff(r))
+	}
+	if err != nil {
+		logger.Errorf("Error trying to search under %q (in category %q) from the database: %s",
+			bsd.folderPath, bsd.pName, err)
+		apiError(w, http.StatusInternalServerError, "Error trying to search")
+		return
+	}
+
+	page.setTotal(r, total)
+	resp.Page = page
+	apiJSON(w, http.StatusOK, resp)
+}
+
+// apiFileHandler returns a single file's details
+func apiFileHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	var id, err = strconv.ParseUint(parts[len(parts)-1], 10, 64)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "Invalid file id")
+		return
+	}
+
+	var op = apiOperation(r)
+	var file *db.File
+	file, err = op.FindFileByID(id)
+	if err != nil {
+		logger.Errorf("Error trying to find file id %d: %s", id, err)
+		apiError(w, http.StatusInternalServerError, "Unable to read the specified file's data")
+		return
+	}
+	if file == nil {
+		apiError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	err = op.PopulateCategories([]*db.File{file}, nil)
+	if err != nil {
+		logger.Errorf("Error trying to find category for file id %d: %s", id, err)
+		apiError(w, http.StatusInternalServerError, "Unable to read the specified file's data")
+		return
+	}
+
+	apiJSON(w, http.StatusOK, newAPIFile(file, apiStaff(r)))
+}
+
+// apiArchiveJob is the JSON representation of an archive job.  Notification
+// addresses aren't included.
+type apiArchiveJob struct {
+	ID         int        `json:"id"`
+	Status     string     `json:"status"`
+	Files      int        `json:"files"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	URLs       apiURLs    `json:"urls"`
+}
+
+func newAPIArchiveJob(j *db.ArchiveJob) *apiArchiveJob {
+	return &apiArchiveJob{
+		ID:         j.ID,
+		Status:     j.Status,
+		Files:      len(j.FileList()),
+		CreatedAt:  j.CreatedAt.UTC(),
+		FinishedAt: apiTime(j.FinishedAt),
+		LastError:  j.LastError,
+		URLs:       apiURLs{API: apiPath("archive-jobs", strconv.Itoa(j.ID))},
+	}
+}
+
+// apiArchiveRequest is the JSON body for queueing an archive job
+type apiArchiveRequest struct {
+	FileIDs []uint64 `json:"file_ids"`
+	Emails  string   `json:"emails"`
+}
+
+// apiArchiveJobsHandler queues a new archive job on POST, or reports on an
+// existing job given its id
+func apiArchiveJobsHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	var last = parts[len(parts)-1]
+	if r.Method == http.MethodPost && last == "" {
+		apiQueueArchiveJob(w, r)
+		return
+	}
+	if r.Method != http.MethodGet || last == "" {
+		apiError(w, http.StatusMethodNotAllowed, "Archive jobs are created with POST and read with GET")
+		return
+	}
+
+	var id, err = strconv.Atoi(last)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "Invalid archive job id")
+		return
+	}
+
+	var j *db.ArchiveJob
+	j, err = dbh.Operation().FindArchiveJobByID(id)
+	if err != nil {
+		logger.Errorf("Unable to look up archive job %d: %s", id, err)
+		apiError(w, http.StatusInternalServerError, "Unable to read the archive job")
+		return
+	}
+	if j == nil {
+		apiError(w, http.StatusNotFound, "Archive job not found")
+		return
+	}
+	apiJSON(w, http.StatusOK, newAPIArchiveJob(j))
+}
+
+// apiQueueArchiveJob reads an apiArchiveRequest and queues the archive job it
+// describes
+func apiQueueArchiveJob(w http.ResponseWriter, r *http.Request) {
+	var req apiArchiveRequest
+	var err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, fmt.Sprintf("Invalid archive request: %s", err))
+		return
+	}
+	if len(req.FileIDs) == 0 {
+		apiError(w, http.StatusBadRequest, "You must list at least one file id")
+		return
+	}
+
+	var addrs []*mail.Address
+	addrs, err = parseNotificationEmails(req.Emails)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var files []*db.File
+	files, err = apiOperation(r).GetFilesByIDsInOrder(req.FileIDs)
+	if err != nil {
+		logger.Errorf("Unable to load files for archive request: %s", err)
+		apiError(w, http.StatusInternalServerError, "Unable to read the requested files")
+		return
+	}
+	if len(files) != len(req.FileIDs) {
+		apiError(w, http.StatusBadRequest, fmt.Sprintf("%d of the requested files could not be found",
+			len(req.FileIDs)-len(files)))
+		return
+	}
+
+	var j *db.ArchiveJob
+	var token string
+	j, token, err = dbh.Operation().QueueArchiveJob(nil, addrs, files)
+	if err != nil {
+		logger.Errorf("Error trying to queue new archive: %s", err)
+		apiError(w, http.StatusInternalServerError, "Unable to queue the archive creation")
+		return
+	}
+	logger.Infof("API token %d queued archive job %d", requestAPIToken(r).ID, j.ID)
+	sendArchiveConfirmation(j, token, files)
+
+	// The status page's token is only known now, so its link is only part of
+	// this response
+	var aj = newAPIArchiveJob(j)
+	aj.URLs.HTML = archiveStatusPath(token)
+	apiJSON(w, http.StatusCreated, aj)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// archiveStatusPollInterval is how often the archive queue is checked for
+// changes while anybody is watching a status page.  The archiver runs as a
+// separate process, so the database is the only place to see its progress.
+const archiveStatusPollInterval = time.Second * 2
+
+// archiveStatusWatcher runs a single check of the archive queue for everybody
+// watching a status page, however many there are, and tells each watcher
+// when something changed.  It only runs while there's at least one watcher.
+type archiveStatusWatcher struct {
+	sync.Mutex
+	watchers map[chan struct{}]int
+	running  bool
+}
+
+var archiveWatcher = &archiveStatusWatcher{watchers: make(map[chan struct{}]int)}
+
+// watch returns a channel which gets a value whenever the given job, or the
+// queue ahead of it, may have changed.  Callers must pass the channel to
+// unwatch when they're done.
+func (aw *archiveStatusWatcher) watch(jobID int) chan struct{} {
+	var ch = make(chan struct{}, 1)
+	aw.Lock()
+	aw.watchers[ch] = jobID
+	if !aw.running {
+		aw.running = true
+		go aw.run()
+	}
+	aw.Unlock()
+	return ch
+}
+
+func (aw *archiveStatusWatcher) unwatch(ch chan struct{}) {
+	aw.Lock()
+	delete(aw.watchers, ch)
+	aw.Unlock()
+}
+
+// jobIDs returns the ids of all watched jobs, or nil if nobody's watching, in
+// which case the watcher is marked as stopped
+func (aw *archiveStatusWatcher) jobIDs() []int {
+	aw.Lock()
+	defer aw.Unlock()
+
+	if len(aw.watchers) == 0 {
+		aw.running = false
+		return nil
+	}
+	var seen = make(map[int]bool)
+	var ids []int
+	for _, id := range aw.watchers {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// notify tells every watcher to take another look.  A watcher which hasn't
+// gotten to its last notice yet isn't sent another.
+func (aw *archiveStatusWatcher) notify() {
+	aw.Lock()
+	for ch := range aw.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	aw.Unlock()
+}
+
+func (aw *archiveStatusWatcher) run() {
+	var last string
+	var ticker = time.NewTicker(archiveStatusPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var ids = aw.jobIDs()
+		if ids == nil {
+			return
+		}
+		var snapshot, err = dbh.Operation().ArchiveQueueSnapshot(ids)
+		if err != nil {
+			logger.Errorf("Unable to check the archive queue for status pages: %s", err)
+			continue
+		}
+		if snapshot != last {
+			last = snapshot
+			aw.notify()
+		}
+	}
+}
+
+// archiveStatusEventsPath returns the path to the event stream for the job
+// with the given status token
+func archiveStatusEventsPath(token string) string {
+	return joinPaths("archive-status", token, "events")
+}
+
+// archiveStatusEventsHandler streams a job's status to its status page as
+// server-sent events.  Each event is the page's freshly rendered status
+// section, sent only when it changes; a "done" event follows once the job
+// reaches a final status, and the stream ends.
+func archiveStatusEventsHandler(w http.ResponseWriter, r *http.Request, token string) {
+	var j, err = dbh.Operation().FindArchiveJobByStatusToken(token)
+	if err != nil {
+		logger.Errorf("Unable to look up archive job by status token: %s", err)
+		http.Error(w, "Unable to read the archive request", http.StatusInternalServerError)
+		return
+	}
+	if j == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var es = newEventStream(w)
+	if es == nil {
+		http.Error(w, "Streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+
+	var changes = archiveWatcher.watch(j.ID)
+	defer archiveWatcher.unwatch(changes)
+	var keepAlive = time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	var id = j.ID
+	var last string
+	for {
+		var html string
+		html, j, err = renderArchiveStatus(id, token)
+		if err != nil {
+			logger.Errorf("Unable to render status of archive job %d: %s", id, err)
+			return
+		}
+		if j == nil {
+			es.send("done", "")
+			return
+		}
+		if html != last {
+			last = html
+			err = es.send("", html)
+			if err != nil {
+				return
+			}
+		}
+		if j.Status != db.JobStatusPending && j.Status != db.JobStatusInProgress {
+			es.send("done", "")
+			return
+		}
+
+	wait:
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-shuttingDown:
+				return
+			case <-changes:
+				break wait
+			case <-keepAlive.C:
+				err = es.keepAlive()
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// renderArchiveStatus reads the given job and renders its status page's
+// details section.  The job is returned as well, or nil if it's gone.
+func renderArchiveStatus(id int, token string) (string, *db.ArchiveJob, error) {
+	var op = dbh.Operation()
+	var j, err = op.FindArchiveJobByID(id)
+	if err != nil || j == nil {
+		return "", j, err
+	}
+
+	var data vars
+	data, err = archiveStatusData(op, j, token)
+	if err != nil {
+		return "", j, err
+	}
+	var buf bytes.Buffer
+	err = archiveStatus.ExecuteTemplate(&buf, "archiveStatusDetails", data)
+	return buf.String(), j, err
+}
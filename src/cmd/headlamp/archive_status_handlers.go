@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+	"github.com/uoregon-libraries/headlamp/src/mail"
+)
+
+// archiveStatusPath returns the path to the public status page for the job
+// with the given status token
+func archiveStatusPath(token string) string {
+	return joinPaths("archive-status", token)
+}
+
+// archiveStatusHandler shows where an archive request stands to anybody with
+// its status link: its place in the queue, how far along the archiver is,
+// and, once it's done, where to download it.  No login is needed, since the
+// link is only ever sent to the request's notification addresses.
+func archiveStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	if len(parts) == 3 && parts[1] != "" && parts[2] == "events" {
+		archiveStatusEventsHandler(w, r, parts[1])
+		return
+	}
+	if len(parts) != 2 || parts[1] == "" {
+		_404(w, r, "Unable to find the requested archive request")
+		return
+	}
+
+	var op = dbh.Operation()
+	var j, err = op.FindArchiveJobByStatusToken(parts[1])
+	if err != nil {
+		logger.Errorf("Unable to look up archive job by status token: %s", err)
+		_500(w, r, "Unable to read the archive request.  Try again or contact support.")
+		return
+	}
+	if j == nil {
+		_404(w, r, "Unable to find the requested archive request.  Finished requests are removed after a while.")
+		return
+	}
+
+	var data vars
+	data, err = archiveStatusData(op, j, parts[1])
+	if err != nil {
+		logger.Errorf("Unable to find queue position for archive job %d: %s", j.ID, err)
+		_500(w, r, "Unable to read the archive request.  Try again or contact support.")
+		return
+	}
+	data["Title"] = "Headlamp: Archive Request Status"
+	archiveStatus.Render(w, r, data)
+}
+
+// archiveStatusData returns what the status page needs to describe the job,
+// which is shared by the page itself and its live updates
+func archiveStatusData(op *db.Operation, j *db.ArchiveJob, token string) (vars, error) {
+	var position, err = op.ArchiveJobQueuePosition(j)
+	if err != nil {
+		return nil, err
+	}
+
+	var data = vars{
+		"Job":        j,
+		"Position":   position,
+		"FileCount":  len(j.FileList()),
+		"EventsPath": archiveStatusEventsPath(token),
+	}
+	if j.Status == db.JobStatusSucceeded && j.ArchivePath != "" {
+		var expires = j.FinishedAt.Add(time.Hour * 24 * time.Duration(conf.ArchiveLifetimeDays))
+		data["ExpiresAt"] = expires
+		if time.Now().Before(expires) {
+			data["DownloadURL"] = joinPaths("archives", path.Base(j.ArchivePath))
+		}
+	}
+	return data, nil
+}
+
+// confirmationMaxFiles is how many of the requested files a confirmation
+// email lists before summarizing the rest
+const confirmationMaxFiles = 25
+
+// sendArchiveConfirmation emails the job's notification addresses to say the
+// request was received, listing what was requested and linking to its status
+// page.  Mail is sent in the background so a slow mail server doesn't hold up
+// the request; failures are only logged, as the archive itself is
+// unaffected.
+func sendArchiveConfirmation(j *db.ArchiveJob, token string, files []*db.File) {
+	var to = j.Emails()
+	var body = archiveConfirmationBody(token, files)
+	go func() {
+		var err = mail.Send(conf, to, "Your archive request has been received", body)
+		if err != nil {
+			logger.Errorf("Unable to send confirmation of archive job %d to %q: %s", j.ID, to, err)
+		}
+	}()
+}
+
+// archiveConfirmationBody builds the text of a confirmation email.  The size
+// is an estimate since the archive adds a little overhead to each file.
+func archiveConfirmationBody(token string, files []*db.File) string {
+	var total int64
+	for _, f := range files {
+		total += f.Filesize
+	}
+
+	var lines = []string{
+		"Your archive request has been received.  You'll get another email when it's ready to download.",
+		"",
+		fmt.Sprintf("Files requested: %d", len(files)),
+		fmt.Sprintf("Estimated archive size: %s", humanFilesize(total)),
+		"",
+	}
+	for i, f := range files {
+		if i == confirmationMaxFiles {
+			lines = append(lines, fmt.Sprintf("  ...and %d more", len(files)-confirmationMaxFiles))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("  %s (%s)", f.ArchivePath(), humanFilesize(f.Filesize)))
+	}
+	lines = append(lines, "", "Follow its progress at "+absoluteURL(archiveStatusPath(token)))
+	if conf.ContactEmail != "" {
+		lines = append(lines, "", "Questions? Contact "+conf.ContactEmail)
+	}
+	return strings.Join(lines, "\r\n")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/uoregon-libraries/gopkg/webutil"
+)
+
+// builtinSiteTitle is the name page titles are written with, and which
+// SITE_TITLE replaces everywhere they're shown
+const builtinSiteTitle = "Headlamp"
+
+// siteTitle returns the configured name of the site
+func siteTitle() string {
+	return conf.SiteTitle
+}
+
+// pageTitle translates a page's title and puts the site's configured name in
+// place of the built-in one, so "Headlamp: Saved Searches" becomes, e.g.,
+// "Example Archives: Saved Searches".  Titles are translated first so
+// deployments keep the existing catalogs' translations.
+func pageTitle(l *locale, title string) string {
+	var t = translate(l, title)
+	if strings.HasPrefix(t, builtinSiteTitle) {
+		t = conf.SiteTitle + strings.TrimPrefix(t, builtinSiteTitle)
+	}
+	return t
+}
+
+// logoURL returns the URL of the configured logo, or an empty string if
+// there isn't one.  Paths which aren't full URLs are under the static
+// directory, so a theme can supply the image.
+func logoURL() string {
+	var p = conf.LogoPath
+	if p == "" || strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://") || strings.HasPrefix(p, "/") {
+		return p
+	}
+	return webutil.StaticPath("", p)
+}
+
+func institutionName() string {
+	return conf.InstitutionName
+}
+
+func footerText() string {
+	return conf.FooterText
+}
+
+func contactEmail() string {
+	return conf.ContactEmail
+}
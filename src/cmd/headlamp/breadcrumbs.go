@@ -10,6 +10,14 @@ import (
 	"github.com/uoregon-libraries/headlamp/src/db"
 )
 
+// maxCrumbs is the most breadcrumbs shown before the middle of the trail is
+// collapsed into an overflow list, and tailCrumbs is how many of the deepest
+// crumbs stay visible when that happens
+const (
+	maxCrumbs  = 6
+	tailCrumbs = 3
+)
+
 type breadCrumb struct {
 	label string
 	url   string
@@ -18,9 +26,10 @@ type breadCrumb struct {
 func (c *breadCrumb) li(last bool) string {
 	var aria = ""
 	if last {
-		aria = `aria-current="page"`
+		aria = ` aria-current="page"`
 	}
-	return fmt.Sprintf(`<li><a href="%s"%s>%s</a></li>`, c.url, aria, c.label)
+	return fmt.Sprintf(`<li><a href="%s"%s>%s</a></li>`,
+		template.HTMLEscapeString(c.url), aria, template.HTMLEscapeString(c.label))
 }
 
 type breadCrumbs struct {
@@ -31,10 +40,20 @@ func (c *breadCrumbs) add(label, url string) {
 	c.list = append(c.list, &breadCrumb{label: label, url: url})
 }
 
+// nav returns the breadcrumb trail.  Long trails keep the first crumb and the
+// last few, and the rest go into a collapsed list so deep folders don't
+// overflow the page header.
 func (c *breadCrumbs) nav() template.HTML {
 	var crumbStrings []string
-	for i, crumb := range c.list {
-		crumbStrings = append(crumbStrings, crumb.li(i == len(c.list)-1))
+	var last = len(c.list) - 1
+	for i := 0; i <= last; i++ {
+		if i == 1 && len(c.list) > maxCrumbs {
+			var hidden = c.list[1 : len(c.list)-tailCrumbs]
+			crumbStrings = append(crumbStrings, overflowLI(hidden))
+			i += len(hidden) - 1
+			continue
+		}
+		crumbStrings = append(crumbStrings, c.list[i].li(i == last))
 	}
 
 	var wrapperOpen = `<nav aria-label="Breadcrumb"><ol class="breadcrumb">`
@@ -42,8 +61,21 @@ func (c *breadCrumbs) nav() template.HTML {
 	return template.HTML(wrapperOpen + strings.Join(crumbStrings, "") + wrapperClose)
 }
 
-// breadcrumbs displays the category (if any) and each path element of the
-// current folder (if any), each as a clickable location for easier navigation
+// overflowLI returns a single breadcrumb which expands to show the given
+// crumbs.  It uses a details element so it works without any JavaScript.
+func overflowLI(crumbs []*breadCrumb) string {
+	var items []string
+	for _, crumb := range crumbs {
+		items = append(items, crumb.li(false))
+	}
+	return fmt.Sprintf(`<li class="breadcrumb-overflow"><details><summary title="Show %d more folders">&hellip;</summary><ul>%s</ul></details></li>`,
+		len(crumbs), strings.Join(items, ""))
+}
+
+// breadcrumbs displays the category (if any) and each folder leading to the
+// current folder (if any), each as a clickable location for easier
+// navigation.  Folders are labeled with their names, using the parents
+// loaded by db.LoadFolderAncestors.
 func breadcrumbs(c *db.Category, f *db.Folder) template.HTML {
 	if c == nil {
 		return template.HTML("")
@@ -51,15 +83,31 @@ func breadcrumbs(c *db.Category, f *db.Folder) template.HTML {
 
 	var crumbs = &breadCrumbs{}
 	crumbs.add(c.Name, browseCategoryPath(c))
-	var folderPathParts []string
-	if f != nil {
-		folderPathParts = strings.Split(f.PublicPath, string(os.PathSeparator))
-	}
-	var dummyFolder = &db.Folder{Category: c}
-	for _, part := range folderPathParts {
-		dummyFolder.PublicPath = filepath.Join(dummyFolder.PublicPath, part)
-		crumbs.add(part, browseFolderPath(dummyFolder))
+	for _, folder := range folderTrail(c, f) {
+		crumbs.add(folder.Name, browseFolderPath(folder))
 	}
 
 	return crumbs.nav()
 }
+
+// folderTrail returns f and its ancestors, top-level folder first.  If the
+// ancestors weren't all loaded, the missing ones are filled in from f's path
+// so the trail is never incomplete.
+func folderTrail(c *db.Category, f *db.Folder) []*db.Folder {
+	var trail []*db.Folder
+	for folder := f; folder != nil; folder = folder.Folder {
+		trail = append([]*db.Folder{folder}, trail...)
+	}
+	if len(trail) == 0 || trail[0].FolderID == 0 {
+		return trail
+	}
+
+	var parts = strings.Split(filepath.Dir(trail[0].PublicPath), string(os.PathSeparator))
+	var missing []*db.Folder
+	var parentPath string
+	for _, part := range parts {
+		parentPath = filepath.Join(parentPath, part)
+		missing = append(missing, &db.Folder{Category: c, Name: part, PublicPath: parentPath})
+	}
+	return append(missing, trail...)
+}
@@ -3,16 +3,24 @@ package main
 import (
 	"fmt"
 	"html/template"
+	"net/http"
 
+	"github.com/uoregon-libraries/gopkg/humanize"
 	"github.com/uoregon-libraries/headlamp/src/db"
 )
 
 // seenFile just gives us a zero-length value for the FileIDs map
 var seenFile struct{}
 
-// BulkFileQueue represents a user's queued files for download
+// BulkFileQueue represents a user's queued files for download.  Queues are
+// stored in the database's carts table so they survive server restarts; this
+// is just a snapshot of one.
 type BulkFileQueue struct {
 	FileIDs map[uint64]struct{} // struct{} has no size, so this is the most efficient map of "ids I have indexed"
+
+	// TotalBytes is the sum of the queued files' sizes as recorded when they
+	// were indexed, so it's an estimate of what an archive would hold
+	TotalBytes int64
 }
 
 // NewBulkFileQueue initializes an empty queue
@@ -20,43 +28,111 @@ func NewBulkFileQueue() *BulkFileQueue {
 	return &BulkFileQueue{FileIDs: make(map[uint64]struct{})}
 }
 
+// cartKeySession is the session key holding an anonymous visitor's cart key
+const cartKeySession = "CartKey"
+
+// cartOwner returns the owner key the current visitor's queue is stored
+// under: their user's cart if they're logged in, otherwise their session's.
+// If the session has no cart yet, one is only created when create is true;
+// otherwise an empty string is returned.
+func cartOwner(w http.ResponseWriter, r *http.Request, create bool) (string, error) {
+	var u = currentUser(r)
+	if u != nil {
+		return db.UserCartOwner(u), nil
+	}
+
+	var s = sessionManager.Load(r)
+	var key, err = s.GetString(cartKeySession)
+	if err != nil || key != "" || !create {
+		return sessionCartOwner(key), err
+	}
+
+	key, err = db.NewCartKey()
+	if err == nil {
+		err = s.PutString(w, cartKeySession, key)
+	}
+	return sessionCartOwner(key), err
+}
+
+// sessionCartOwner returns the owner key for a session's cart key, or an
+// empty string if there's no key
+func sessionCartOwner(key string) string {
+	if key == "" {
+		return ""
+	}
+	return db.SessionCartOwner(key)
+}
+
+// loadBulkFileQueue reads the current visitor's queue from the database
+func loadBulkFileQueue(r *http.Request) (*BulkFileQueue, error) {
+	var q = NewBulkFileQueue()
+	var owner, err = cartOwner(nil, r, false)
+	if err != nil || owner == "" {
+		return q, err
+	}
+
+	var op = dbh.Operation()
+	var ids []uint64
+	ids, err = op.CartFileIDs(owner)
+	for _, id := range ids {
+		q.FileIDs[id] = seenFile
+	}
+	_, q.TotalBytes, err = op.CartSize(owner)
+	return q, err
+}
+
+// mergeSessionCart moves an anonymous visitor's queue into their user's cart
+// once they log in.  This must be called before the session is renewed.
+func mergeSessionCart(r *http.Request, u *db.User) error {
+	var key, err = sessionManager.Load(r).GetString(cartKeySession)
+	if err != nil || key == "" {
+		return err
+	}
+	return dbh.Operation().MergeCart(db.SessionCartOwner(key), db.UserCartOwner(u))
+}
+
 // HasFile returns true if the queue has the given file's id
 func (q *BulkFileQueue) HasFile(f *db.File) bool {
 	var _, ok = q.FileIDs[f.ID]
 	return ok
 }
 
-// AddFile puts the given file's id into this queue
-func (q *BulkFileQueue) AddFile(f *db.File) {
-	q.FileIDs[f.ID] = seenFile
-}
-
-// RemoveFile takes the given file's id out of this queue
-func (q *BulkFileQueue) RemoveFile(f *db.File) {
-	delete(q.FileIDs, f.ID)
+// Summary returns a short description of the queue's size, e.g., for the
+// navigation bar
+func (q *BulkFileQueue) Summary() string {
+	switch len(q.FileIDs) {
+	case 0:
+		return "empty"
+	case 1:
+		return fmt.Sprintf("1 file, %s", humanFilesize(q.TotalBytes))
+	}
+	return fmt.Sprintf("%d files, %s", len(q.FileIDs), humanFilesize(q.TotalBytes))
 }
 
 // Files attempts to load all db.File instances from the database and return
 // them.  If a queue is huge, this could of course take a very long time.
-func (q *BulkFileQueue) Files() ([]*db.File, error) {
+// Files op can't see, such as files restricted after they were queued, are
+// silently skipped.
+func (q *BulkFileQueue) Files(op *db.Operation) ([]*db.File, error) {
 	var ids []uint64
 	for k := range q.FileIDs {
 		ids = append(ids, k)
 	}
-	return dbh.Operation().GetFilesByIDs(ids)
+	return op.GetFilesByIDs(ids)
 }
 
 // QueuePresenter adds some pre-calculated data for more human-friendly output
 type QueuePresenter struct {
 	BulkFileQueue *BulkFileQueue
 	Files         []*db.File
+	TotalBytes    int64
 	TotalFilesize string
 }
 
 // NewQueuePresenter attempts to wrap a BulkFileQueue with presenter-specific data.
 // This can have errors if we aren't able to look up data in the database.
-func NewQueuePresenter(q *BulkFileQueue) (*QueuePresenter, error) {
-	var files, err = q.Files()
+func NewQueuePresenter(op *db.Operation, q *BulkFileQueue) (*QueuePresenter, error) {
+	var files, err = q.Files(op)
 	if err != nil {
 		return nil, fmt.Errorf("file lookup error: %s", err)
 	}
@@ -66,11 +142,63 @@ func NewQueuePresenter(q *BulkFileQueue) (*QueuePresenter, error) {
 		totalFilesize += f.Filesize
 	}
 
-	return &QueuePresenter{BulkFileQueue: q, Files: files, TotalFilesize: humanFilesize(totalFilesize)}, nil
+	return &QueuePresenter{BulkFileQueue: q, Files: files, TotalBytes: totalFilesize, TotalFilesize: humanFilesize(totalFilesize)}, nil
+}
+
+// ZipAllowed returns true if the queue is small enough to be streamed as a
+// ZIP file rather than built by an archive job
+func (q *QueuePresenter) ZipAllowed() bool {
+	return zipAllowed(q.TotalBytes)
+}
+
+// zipAllowed returns true if ZIP streaming is enabled and the given number of
+// bytes is within ZIP_STREAM_MAX_MB
+func zipAllowed(bytes int64) bool {
+	return conf.ZipStreamMaxMB > 0 && bytes <= int64(conf.ZipStreamMaxMB)*1024*1024
+}
+
+// queueMaxBytes returns the QUEUE_MAX_GB setting in bytes, or 0 if queues
+// aren't limited
+func queueMaxBytes() int64 {
+	return int64(conf.QueueMaxGB) * humanize.Gigabyte
+}
+
+// queueTooLarge returns true if a queue totaling the given number of bytes
+// would be over the QUEUE_MAX_GB limit
+func queueTooLarge(bytes int64) bool {
+	var max = queueMaxBytes()
+	return max > 0 && bytes > max
+}
+
+// queueLimitMessage explains why a queue can't hold the given number of bytes
+func queueLimitMessage(bytes int64) string {
+	return fmt.Sprintf("That would bring your queue to %s, which is over the %s limit.  "+
+		"Remove some files or build an archive from what's queued now, then try again.",
+		humanFilesize(bytes), queueMaxSize())
+}
+
+// queueMaxSize returns the human-friendly QUEUE_MAX_GB limit
+func queueMaxSize() string {
+	return humanFilesize(queueMaxBytes())
+}
+
+// Summary returns the wrapped queue's summary
+func (q *QueuePresenter) Summary() string {
+	return q.BulkFileQueue.Summary()
+}
+
+// TooLarge returns true if the queue is over the QUEUE_MAX_GB limit, which
+// can happen if the limit is lowered after files are queued
+func (q *QueuePresenter) TooLarge() bool {
+	return queueTooLarge(q.TotalBytes)
 }
 
 // Status returns the HTML for displaying the queue's status
 func (q *QueuePresenter) Status() template.HTML {
-	return template.HTML(fmt.Sprintf("Your current queue consists of %d files totaling %s.",
-		len(q.Files), q.TotalFilesize))
+	var status = fmt.Sprintf("Your current queue consists of %d files totaling %s.",
+		len(q.Files), q.TotalFilesize)
+	if queueMaxBytes() > 0 {
+		status += fmt.Sprintf("  Queues may hold up to %s.", queueMaxSize())
+	}
+	return template.HTML(template.HTMLEscapeString(status))
 }
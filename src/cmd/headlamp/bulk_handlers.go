@@ -1,9 +1,16 @@
 package main
 
 import (
+	"archive/zip"
+	"fmt"
+	"io"
 	"net/http"
 	"net/mail"
+	"os"
+	"path"
+	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/uoregon-libraries/gopkg/logger"
 	"github.com/uoregon-libraries/gopkg/webutil"
@@ -28,7 +35,7 @@ func bulkQueueHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify that the file exists
-	var op = dbh.Operation()
+	var op = userOperation(r)
 	var f *db.File
 	f, err = op.FindFileByID(fileID)
 	if err != nil {
@@ -41,12 +48,11 @@ func bulkQueueHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Grab the session data that holds our queue
-	var s = sessionManager.Load(r)
-	var q = NewBulkFileQueue()
-	err = s.GetObject("Queue", q)
+	// Find the cart that holds our queue
+	var owner string
+	owner, err = cartOwner(w, r, true)
 	if err != nil {
-		logger.Errorf("Unable to load user's bulk file queue: %s", err)
+		logger.Errorf("Unable to find user's bulk file queue: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -54,37 +60,54 @@ func bulkQueueHandler(w http.ResponseWriter, r *http.Request) {
 	// Make sure we have a valid operation
 	switch operation {
 	case "add":
-		q.AddFile(f)
+		var q *BulkFileQueue
+		q, err = loadBulkFileQueue(r)
+		if err != nil {
+			logger.Errorf("Unable to load user's bulk file queue: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !q.HasFile(f) && queueTooLarge(q.TotalBytes+f.Filesize) {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(queueLimitMessage(q.TotalBytes + f.Filesize)))
+			return
+		}
+		err = dbh.Operation().AddToCart(owner, f.ID)
 	case "remove":
-		q.RemoveFile(f)
+		err = dbh.Operation().RemoveFromCart(owner, f.ID)
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-
-	err = s.PutObject(w, "Queue", q)
 	if err != nil {
 		logger.Errorf("Unable to save user's bulk file queue: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+
+	var q *BulkFileQueue
+	q, err = loadBulkFileQueue(r)
+	if err != nil {
+		logger.Errorf("Unable to reload user's bulk file queue after modification: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	var qp *QueuePresenter
-	qp, err = NewQueuePresenter(q)
+	qp, err = NewQueuePresenter(op, q)
 	if err != nil {
 		logger.Errorf("Unable to reload user's bulk file queue after modification: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("X-Queue-Summary", q.Summary())
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(qp.Status()))
 }
 
 func bulkDownloadHandler(w http.ResponseWriter, r *http.Request) {
-	// Grab the session data that holds our queue
 	var s = sessionManager.Load(r)
-	var q = NewBulkFileQueue()
-	var err = s.GetObject("Queue", q)
+	var q, err = loadBulkFileQueue(r)
 	if err != nil {
 		logger.Errorf("Unable to load user's bulk file queue: %s", err)
 		_500(w, r, "Unable to load your bulk download queue.  Try again or contact support.")
@@ -98,25 +121,72 @@ func bulkDownloadHandler(w http.ResponseWriter, r *http.Request) {
 	var emails, _ = s.GetString("emails")
 
 	var qp *QueuePresenter
-	qp, err = NewQueuePresenter(q)
+	qp, err = NewQueuePresenter(userOperation(r), q)
 	if err != nil {
 		logger.Errorf("Unable to set up bulk file queue presenter: %s", err)
 		_500(w, r, "Unable to load your bulk download queue.  Try again or contact support.")
 		return
 	}
 
+	var jobs []*db.ArchiveJob
+	jobs, err = dbh.Operation().FindArchiveJobsByIDs(sessionArchiveJobIDs(r))
+	if err != nil {
+		logger.Errorf("Unable to look up user's archive jobs: %s", err)
+		_500(w, r, "Unable to load your archive requests.  Try again or contact support.")
+		return
+	}
+
 	bulk.Render(w, r, vars{
-		"Title":  "Headlamp: Bulk Download",
-		"Queue":  qp,
-		"Emails": emails,
+		"Title":       "Headlamp: Bulk Download",
+		"Queue":       qp,
+		"Emails":      emails,
+		"MaxEmails":   maxNotificationEmails,
+		"ArchiveJobs": jobs,
 	})
 }
 
+// bulkCancelArchiveHandler cancels one of the archive jobs requested in the
+// current session
+func bulkCancelArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	if len(parts) != 3 || r.Method != http.MethodPost {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var id, err = strconv.Atoi(parts[2])
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var found = false
+	for _, jobID := range sessionArchiveJobIDs(r) {
+		if jobID == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		_404(w, r, "Unable to find the requested archive job")
+		return
+	}
+
+	err = dbh.Operation().CancelArchiveJob(id)
+	if err != nil {
+		logger.Warnf("Unable to cancel archive job %d: %s", id, err)
+		setAlert(w, r, "Unable to cancel your archive request; it may have already finished.")
+		http.Redirect(w, r, viewBulkQueuePath(), http.StatusSeeOther)
+		return
+	}
+
+	setInfo(w, r, "Your archive request has been cancelled.")
+	http.Redirect(w, r, viewBulkQueuePath(), http.StatusSeeOther)
+}
+
 func bulkCreateArchiveHandler(w http.ResponseWriter, r *http.Request) {
-	// Grab the session data that holds our queue
 	var s = sessionManager.Load(r)
-	var q = NewBulkFileQueue()
-	var err = s.GetObject("Queue", q)
+	var q, err = loadBulkFileQueue(r)
 	if err != nil {
 		logger.Errorf("Unable to load user's bulk file queue: %s", err)
 		_500(w, r, "Unable to load your bulk download queue.  Try again or contact support.")
@@ -131,7 +201,7 @@ func bulkCreateArchiveHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Pull files so we have their paths
 	var files []*db.File
-	files, err = q.Files()
+	files, err = q.Files(userOperation(r))
 	if err != nil {
 		logger.Errorf("Unable to load files from the database: %s", err)
 		_500(w, r, "Unable to load your bulk download queue.  Try again or contact support.")
@@ -144,29 +214,161 @@ func bulkCreateArchiveHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if emails == "" {
-		setAlert(w, r, "You must enter at least one valid notification email address")
+	var total int64
+	for _, f := range files {
+		total += f.Filesize
+	}
+	if queueTooLarge(total) {
+		setAlert(w, r, fmt.Sprintf("Your queue totals %s, which is over the %s limit.  Remove some files and try again.",
+			humanFilesize(total), queueMaxSize()))
 		http.Redirect(w, r, viewBulkQueuePath(), http.StatusTemporaryRedirect)
 		return
 	}
 
 	var addrs []*mail.Address
-	addrs, err = mail.ParseAddressList(emails)
+	addrs, err = parseNotificationEmails(emails)
 	if err != nil {
-		setAlert(w, r, "One or more addresses are invalid - please re-enter and try again")
+		setAlert(w, r, err.Error())
 		http.Redirect(w, r, viewBulkQueuePath(), http.StatusTemporaryRedirect)
 		return
 	}
 
-	err = dbh.Operation().QueueArchiveJob(addrs, files)
+	var job *db.ArchiveJob
+	var token string
+	job, token, err = dbh.Operation().QueueArchiveJob(currentUser(r), addrs, files)
 	if err != nil {
 		logger.Errorf("Error trying to queue new archive: %s", err)
 		setAlert(w, r, "Unable to queue the archive creation.  Please try again or contact support.")
 		http.Redirect(w, r, viewBulkQueuePath(), http.StatusTemporaryRedirect)
 		return
 	}
+	sendArchiveConfirmation(job, token, files)
+	err = addSessionArchiveJob(w, r, job)
+	if err != nil {
+		logger.Errorf("Unable to store archive job %d in user's session: %s", job.ID, err)
+	}
 
-	s.Remove(w, "Queue")
-	setInfo(w, r, "Your archive is now being generated, and your bulk file queue has been emptied.")
+	var owner string
+	owner, err = cartOwner(w, r, false)
+	if err == nil && owner != "" {
+		err = dbh.Operation().ClearCart(owner)
+	}
+	if err != nil {
+		logger.Errorf("Unable to empty user's bulk file queue after queueing archive %d: %s", job.ID, err)
+	}
+	setInfo(w, r, "Your archive is now being generated, and your bulk file queue has been emptied.  "+
+		"A confirmation email with a link to follow its progress is on its way.")
 	http.Redirect(w, r, webutil.Webroot, http.StatusTemporaryRedirect)
 }
+
+// bulkZipHandler streams the queued files to the browser as a ZIP file, for
+// queues small enough that an archive job isn't worth the wait.  Every file
+// is checked before anything is sent, since errors can't be reported once the
+// ZIP has started.  The queue is left alone so a failed download can simply
+// be retried.
+func bulkZipHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var q, err = loadBulkFileQueue(r)
+	if err != nil {
+		logger.Errorf("Unable to load user's bulk file queue: %s", err)
+		_500(w, r, "Unable to load your bulk download queue.  Try again or contact support.")
+		return
+	}
+
+	var files []*db.File
+	files, err = q.Files(userOperation(r))
+	if err != nil {
+		logger.Errorf("Unable to load files from the database: %s", err)
+		_500(w, r, "Unable to load your bulk download queue.  Try again or contact support.")
+		return
+	}
+	if len(files) == 0 {
+		setAlert(w, r, "You don't have any files to download!")
+		http.Redirect(w, r, viewBulkQueuePath(), http.StatusSeeOther)
+		return
+	}
+
+	var total int64
+	var paths = make([]string, len(files))
+	for i, f := range files {
+		total += f.Filesize
+		paths[i], err = realPath(f)
+		if err == nil {
+			_, err = os.Stat(paths[i])
+		}
+		if err != nil {
+			logger.Errorf("Unable to read file id %d for ZIP download: %s", f.ID, err)
+			setAlert(w, r, fmt.Sprintf("Unable to read %q.  Try again or contact support.", f.FullPath))
+			http.Redirect(w, r, viewBulkQueuePath(), http.StatusSeeOther)
+			return
+		}
+	}
+	if !zipAllowed(total) {
+		setAlert(w, r, fmt.Sprintf("Your queue is too large to download directly (the limit is %d MB).  Please build an archive instead.", conf.ZipStreamMaxMB))
+		http.Redirect(w, r, viewBulkQueuePath(), http.StatusSeeOther)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=headlamp-%s.zip", time.Now().Format("2006-01-02-150405")))
+
+	var u = currentUser(r)
+	var op = dbh.Operation()
+	var zw = zip.NewWriter(w)
+	for i, f := range files {
+		var n int64
+		n, err = addFileToZip(zw, paths[i], zipEntryName(f))
+		if err != nil {
+			logger.Errorf("Unable to stream file id %d in ZIP download: %s", f.ID, err)
+			return
+		}
+		err = op.RecordDownload(f, u, db.DownloadKindZip, n)
+		if err != nil {
+			logger.Errorf("Unable to record download of file id %d: %s", f.ID, err)
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		logger.Errorf("Unable to finish ZIP download: %s", err)
+	}
+}
+
+// zipEntryName returns where f goes in a ZIP download: its path within its
+// dark archive root, so files keep the layout they have on disk.  Files from
+// a named root go beneath a directory named for it, so they can't collide
+// with files at the same path in another root.
+func zipEntryName(f *db.File) string {
+	return path.Join(f.Root, filepath.ToSlash(f.FullPath))
+}
+
+// addFileToZip copies the file at filePath into the ZIP under the given name,
+// returning how many bytes were copied.  Files are stored without
+// compression: masters are large and usually compressed already, so deflating
+// them would cost a lot of CPU for little gain.
+func addFileToZip(zw *zip.Writer, filePath, name string) (int64, error) {
+	var f, err = os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var info os.FileInfo
+	info, err = f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var fw io.Writer
+	var header = &zip.FileHeader{Name: name, Method: zip.Store}
+	header.Modified = info.ModTime()
+	fw, err = zw.CreateHeader(header)
+	if err != nil {
+		return 0, fmt.Errorf("writing header for %q: %s", name, err)
+	}
+	return io.Copy(fw, f)
+}
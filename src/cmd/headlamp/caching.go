@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// serverStarted goes into every page ETag, so pages cached before a restart,
+// which may have brought new templates or a new theme, aren't reused
+var serverStarted = time.Now().UTC().Truncate(time.Second)
+
+// staticMaxAge is how long browsers may use a static asset without checking
+// whether it has changed
+const staticMaxAge = time.Minute * 10
+
+// pageCacheInfo is what a cached page depends on
+type pageCacheInfo struct {
+	etag         string
+	modified     time.Time
+	personalized bool
+}
+
+// pageCache works out the ETag and modification time for the requested page
+// as the current visitor would see it.  Pages depend on the indexed content,
+// but also on who's looking, their language, what's in their queue and
+// favorites, and the CSRF token embedded in their forms.
+// False is returned if the page shouldn't be cached at all, such as when
+// there's a message waiting to be shown.
+func pageCache(r *http.Request) (*pageCacheInfo, bool) {
+	var s = sessionManager.Load(r)
+	var alert, _ = s.GetString("Alert")
+	var info, _ = s.GetString("Info")
+	if alert != "" || info != "" {
+		return nil, false
+	}
+
+	var op = dbh.Operation()
+	var version, changed, err = op.ContentVersion()
+	if err != nil {
+		logger.Errorf("Unable to read content version: %s", err)
+		return nil, false
+	}
+
+	var owner string
+	var queued []uint64
+	owner, err = cartOwner(nil, r, false)
+	if err == nil && owner != "" {
+		queued, err = op.CartFileIDs(owner)
+	}
+	if err != nil {
+		logger.Errorf("Unable to read visitor's queue: %s", err)
+		return nil, false
+	}
+
+	var u = currentUser(r)
+	var favs *Favorites
+	favs, err = loadFavorites(r)
+	if err != nil {
+		logger.Errorf("Unable to read visitor's favorites: %s", err)
+		return nil, false
+	}
+
+	var lang, _ = s.GetString(languageSession)
+	var h = sha1.New()
+	fmt.Fprintf(h, "%d\n%d\n%s\n", serverStarted.Unix(), version, r.URL.RequestURI())
+	if u != nil {
+		fmt.Fprintf(h, "%d %s\n%v\n%v\n", u.ID, userRole(u), favs.FileIDs, favs.FolderIDs)
+	}
+	fmt.Fprintf(h, "%s\n%v\n%s\n", requestLocale(r).Tag, queued, sessionCSRFToken(r))
+
+	var modified = changed
+	if serverStarted.After(modified) {
+		modified = serverStarted
+	}
+	return &pageCacheInfo{
+		etag:         fmt.Sprintf(`W/"%x"`, h.Sum(nil)),
+		modified:     modified,
+		personalized: u != nil || len(queued) > 0 || lang != "",
+	}, true
+}
+
+// notModified sets the caching headers for a page built from indexed content
+// and returns true if the visitor's copy is still current, in which case a
+// 304 has already been sent.  Browsers are told to check back every time, so
+// a page is never out of date, but checking is far cheaper than rebuilding a
+// listing of thousands of files.
+func notModified(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	var pc, ok = pageCache(r)
+	if !ok {
+		w.Header().Set("Cache-Control", "no-store")
+		return false
+	}
+
+	var h = w.Header()
+	h.Set("ETag", pc.etag)
+	h.Set("Last-Modified", pc.modified.Format(http.TimeFormat))
+	h.Set("Cache-Control", "private, no-cache")
+	h.Add("Vary", "Cookie")
+	h.Add("Vary", "Accept-Language")
+
+	if etagMatches(r.Header.Get("If-None-Match"), pc.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+
+	// The modification time only describes the content, not anything about
+	// the visitor, so it's only good enough on its own for anonymous visitors
+	// with an empty queue
+	if r.Header.Get("If-None-Match") == "" && !pc.personalized {
+		var since, err = http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err == nil && !pc.modified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// etagMatches does a weak comparison of etag against the list in an
+// If-None-Match header
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	var want = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheStatic wraps the static file server so assets get an ETag built from
+// their size and modification time, and can be reused for staticMaxAge
+// before the browser checks again.  The file server itself answers
+// conditional requests once the ETag is set.
+func cacheStatic(fs http.FileSystem, prefix string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var name = path.Clean("/" + strings.TrimPrefix(r.URL.Path, prefix))
+		var f, err = fs.Open(name)
+		if err == nil {
+			var info, err = f.Stat()
+			if err == nil && !info.IsDir() {
+				w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+				w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(staticMaxAge.Seconds())))
+			}
+			f.Close()
+		}
+		h.ServeHTTP(w, r)
+	})
+}
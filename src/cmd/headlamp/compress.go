@@ -0,0 +1,174 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressibleTypes are the content types worth compressing: pages, API
+// responses, exports, and OAI-PMH feeds.  Everything else we serve, like
+// images and archives, is either already compressed or passed through as-is.
+var compressibleTypes = []string{"text/html", "application/json", "text/csv", "text/xml", "application/xml"}
+
+var gzipWriters = sync.Pool{New: func() interface{} {
+	return gzip.NewWriter(nil)
+}}
+
+// zlibWriters serve "deflate", which HTTP defines as a zlib stream rather
+// than raw DEFLATE data
+var zlibWriters = sync.Pool{New: func() interface{} {
+	return zlib.NewWriter(nil)
+}}
+
+// compressor is the part of gzip.Writer and zlib.Writer we need
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// compressWriter compresses the response if, once the handler has set its
+// headers, it turns out to be a compressible type
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	cw       compressor
+	decided  bool
+}
+
+// decide looks at the response headers and sets up compression if it's
+// appropriate.  The first bytes of the body are used to guess the content
+// type when the handler didn't set one, just as net/http would.
+func (w *compressWriter) decide(status int, data []byte) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+
+	var h = w.Header()
+	if h.Get("Content-Type") == "" && len(data) > 0 {
+		h.Set("Content-Type", http.DetectContentType(data))
+	}
+	h.Add("Vary", "Accept-Encoding")
+
+	// Byte ranges and conditional responses refer to the uncompressed body, so
+	// anything involving them is left alone
+	if status == http.StatusPartialContent || status == http.StatusNotModified || status == http.StatusNoContent ||
+		h.Get("Content-Encoding") != "" || h.Get("Accept-Ranges") != "" || !compressible(h.Get("Content-Type")) {
+		return
+	}
+
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	if w.encoding == "gzip" {
+		w.cw = gzipWriters.Get().(compressor)
+	} else {
+		w.cw = zlibWriters.Get().(compressor)
+	}
+	w.cw.Reset(w.ResponseWriter)
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	w.decide(status, nil)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.decide(http.StatusOK, data)
+	}
+	if w.cw == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.cw.Write(data)
+}
+
+// Flush sends whatever has been compressed so far, so streamed responses
+// still arrive a piece at a time
+func (w *compressWriter) Flush() {
+	if w.cw != nil {
+		w.cw.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close finishes the compressed stream and returns its writer to the pool
+func (w *compressWriter) close() {
+	if w.cw == nil {
+		return
+	}
+	w.cw.Close()
+	if w.encoding == "gzip" {
+		gzipWriters.Put(w.cw)
+	} else {
+		zlibWriters.Put(w.cw)
+	}
+	w.cw = nil
+}
+
+// compressible returns true if the given Content-Type header is one of the
+// compressibleTypes
+func compressible(contentType string) bool {
+	var mediaType = strings.TrimSpace(strings.ToLower(strings.SplitN(contentType, ";", 2)[0]))
+	for _, t := range compressibleTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptedEncoding returns "gzip" or "deflate" if the client accepts one of
+// them (preferring gzip), or "" if it accepts neither
+func acceptedEncoding(r *http.Request) string {
+	var q = make(map[string]float64)
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		var fields = strings.Split(part, ";")
+		var name = strings.TrimSpace(strings.ToLower(fields[0]))
+		var quality = 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				var v, err = strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					quality = v
+				}
+			}
+		}
+		q[name] = quality
+	}
+
+	for _, enc := range []string{"gzip", "deflate"} {
+		var quality, ok = q[enc]
+		if !ok {
+			quality, ok = q["*"]
+		}
+		if ok && quality > 0 {
+			return enc
+		}
+	}
+	return ""
+}
+
+// compressResponses wraps h so that HTML, JSON, CSV, and XML responses are
+// gzipped or deflated for clients which accept it
+func compressResponses(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var enc = acceptedEncoding(r)
+		if enc == "" || r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		var cw = &compressWriter{ResponseWriter: w, encoding: enc}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// csrfSession is the session key holding the session's CSRF token
+const csrfSession = "CSRFToken"
+
+// csrfField and csrfHeader are where a form or script sends the token back
+const (
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// csrfToken returns the session's CSRF token, creating one if the session
+// doesn't have one yet.  An empty string is returned if a token can't be
+// created, which no request will ever match.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	var s = sessionManager.Load(r)
+	var token, _ = s.GetString(csrfSession)
+	if token != "" {
+		return token
+	}
+
+	var buf = make([]byte, 32)
+	var _, err = rand.Read(buf)
+	if err != nil {
+		logger.Errorf("Unable to generate CSRF token: %s", err)
+		return ""
+	}
+	token = hex.EncodeToString(buf)
+	err = s.PutString(w, csrfSession, token)
+	if err != nil {
+		logger.Errorf("Unable to store CSRF token: %s", err)
+		return ""
+	}
+	return token
+}
+
+// sessionCSRFToken returns the session's CSRF token without creating one
+func sessionCSRFToken(r *http.Request) string {
+	var token, _ = sessionManager.Load(r).GetString(csrfSession)
+	return token
+}
+
+// csrfSafe returns true if the request can't change anything, so it doesn't
+// need a token
+func csrfSafe(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// bearerAPIRequest returns true if r is for the API and carries a bearer
+// token.  Browsers add Basic or Negotiate credentials to cross-site requests
+// on their own, but never a bearer token.
+func bearerAPIRequest(r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, apiPrefix) {
+		return false
+	}
+	var fields = strings.Fields(r.Header.Get("Authorization"))
+	return len(fields) == 2 && strings.EqualFold(fields[0], "Bearer")
+}
+
+// checkCSRF wraps h so that any request which could change something has to
+// send back the session's CSRF token, either as a form field or, for
+// scripts, a header.  API requests with a bearer token are exempt; every
+// API handler which can change something is behind requireScope, which
+// refuses the request before the handler runs unless the token is valid.
+// Other kinds of credentials don't earn an exemption, since browsers send
+// those along with forged requests.
+func checkCSRF(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if csrfSafe(r) || bearerAPIRequest(r) || validCSRFToken(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		logger.Warnf("Rejecting %s %s from %s: missing or invalid CSRF token", r.Method, r.URL.Path, clientIP(r))
+		_403(w, r, "Your session has expired or the form was out of date; please reload the page and try again")
+	})
+}
+
+// validCSRFToken returns true if the request sent back the session's token
+func validCSRFToken(r *http.Request) bool {
+	var want = sessionCSRFToken(r)
+	var got = r.Header.Get(csrfHeader)
+	if got == "" {
+		got = r.PostFormValue(csrfField)
+	}
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
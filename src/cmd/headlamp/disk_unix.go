@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "syscall"
+
+// diskSpace returns the total and available bytes on the file system holding
+// path, or false if they can't be read
+func diskSpace(path string) (total, free uint64, ok bool) {
+	var st syscall.Statfs_t
+	var err = syscall.Statfs(path, &st)
+	if err != nil {
+		return 0, 0, false
+	}
+	return st.Blocks * uint64(st.Bsize), st.Bavail * uint64(st.Bsize), true
+}
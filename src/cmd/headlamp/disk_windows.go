@@ -0,0 +1,7 @@
+package main
+
+// diskSpace always returns false, as file system sizes aren't read on
+// Windows
+func diskSpace(path string) (total, free uint64, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// eventKeepAliveInterval is how often an idle event stream gets a comment, so
+// proxies and browsers don't give up on it
+const eventKeepAliveInterval = time.Second * 30
+
+// shuttingDown is closed once the server begins shutting down.  Shutdown
+// doesn't cancel requests in progress, so streams, which would otherwise run
+// until the visitor leaves, watch this and end themselves instead of holding
+// the server up.
+var shuttingDown = make(chan struct{})
+
+// eventStream writes server-sent events to a response, flushing each one so
+// it reaches the browser right away
+type eventStream struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+// newEventStream sets up w for server-sent events.  It returns nil if the
+// response can't be flushed a piece at a time, in which case nothing has been
+// written yet.
+func newEventStream(w http.ResponseWriter) *eventStream {
+	var f, ok = w.(http.Flusher)
+	if !ok {
+		return nil
+	}
+
+	var h = w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Stops nginx from buffering the stream, which would defeat the purpose
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	return &eventStream{w: w, f: f}
+}
+
+// send writes an event with the given name and data.  An empty name sends a
+// plain "message" event.
+func (es *eventStream) send(event, data string) error {
+	var buf bytes.Buffer
+	if event != "" {
+		fmt.Fprintf(&buf, "event: %s\n", event)
+	}
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+	return es.write(buf.Bytes())
+}
+
+// keepAlive writes a comment, which clients ignore
+func (es *eventStream) keepAlive() error {
+	return es.write([]byte(": keep-alive\n\n"))
+}
+
+func (es *eventStream) write(data []byte) error {
+	var _, err = es.w.Write(data)
+	es.f.Flush()
+	return err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// exportCategoryHandler sends the full inventory of a category as a CSV
+// download
+func exportCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	var bsd = getBrowseSearchData(w, r)
+	if bsd.hadError {
+		return
+	}
+	if bsd.category == nil || bsd.folder != nil {
+		_400(w, r, "Invalid export request.  Try again or contact support.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", bsd.category.Name+".csv"))
+	var err = bsd.op.ExportCategory(bsd.category, w)
+	if err != nil {
+		// Headers (and possibly some data) are already sent, so all we can do is
+		// log the problem and leave the user with a truncated file
+		logger.Errorf("Unable to export category %q: %s", bsd.category.Name, err)
+	}
+}
+
+// exportResultsHandler sends every file matching a search as a CSV download.
+// It takes the same parameters as the advanced search, and ignores paging.
+func exportResultsHandler(w http.ResponseWriter, r *http.Request) {
+	var op = userOperation(r)
+	var fq, msg = getFileQuery(w, r, op)
+	if fq == nil {
+		if msg != "" {
+			_400(w, r, msg)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=search-results.csv")
+	var err = op.ExportQuery(*fq, w)
+	if err != nil {
+		// As with category exports, the headers are already sent
+		logger.Errorf("Unable to export search results for %q: %s", r.URL.RawQuery, err)
+	}
+}
+
+// exportResultsPath returns the URL for exporting the current search's
+// results.  The category and folder are only needed for searches which take
+// them from the path rather than the query string.
+func exportResultsPath(r *http.Request, c *db.Category, f *db.Folder) string {
+	var q = r.URL.Query()
+	q.Del("page")
+	q.Del("per_page")
+	if c != nil {
+		q.Set("category", c.Name)
+	}
+	if f != nil {
+		q.Set("folder", f.PublicPath)
+	}
+	return joinPaths("export-results") + "?" + q.Encode()
+}
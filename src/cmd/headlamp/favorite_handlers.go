@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// Favorites holds the ids of the files and folders the current user has
+// starred, so templates can show each item's star state
+type Favorites struct {
+	FileIDs   map[uint64]bool
+	FolderIDs map[uint64]bool
+}
+
+// loadFavorites reads the current user's favorites.  Anonymous visitors get
+// an empty set.
+func loadFavorites(r *http.Request) (*Favorites, error) {
+	var favs = &Favorites{FileIDs: make(map[uint64]bool), FolderIDs: make(map[uint64]bool)}
+	var u = currentUser(r)
+	if u == nil {
+		return favs, nil
+	}
+
+	var op = dbh.Operation()
+	var fileIDs, folderIDs []uint64
+	var err error
+	fileIDs, err = op.FavoriteIDs(u, db.FavoriteFile)
+	if err == nil {
+		folderIDs, err = op.FavoriteIDs(u, db.FavoriteFolder)
+	}
+	for _, id := range fileIDs {
+		favs.FileIDs[id] = true
+	}
+	for _, id := range folderIDs {
+		favs.FolderIDs[id] = true
+	}
+	return favs, err
+}
+
+// HasFile returns true if the user has starred f
+func (favs *Favorites) HasFile(f *db.File) bool {
+	return favs != nil && favs.FileIDs[f.ID]
+}
+
+// HasFolder returns true if the user has starred f
+func (favs *Favorites) HasFolder(f *db.Folder) bool {
+	return favs != nil && f != nil && favs.FolderIDs[uint64(f.ID)]
+}
+
+// favoritesHandler lists the current user's favorites or, for a POST to
+// "favorites/<add|remove>/<file|folder>/<id>", stars or unstars an item
+func favoritesHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	if len(parts) == 4 && r.Method == http.MethodPost {
+		changeFavorite(w, r, parts[1], parts[2], parts[3])
+		return
+	}
+	if len(parts) > 2 || len(parts) == 2 && parts[1] != "" {
+		_404(w, r, "Unable to find the requested resource")
+		return
+	}
+
+	var u = currentUser(r)
+	var op = userOperation(r)
+	var folders, err = op.FavoriteFolders(u)
+	var files []*db.File
+	if err == nil {
+		files, err = op.FavoriteFiles(u)
+	}
+	if err != nil {
+		logger.Errorf("Unable to read favorites for user %d: %s", u.ID, err)
+		_500(w, r, "Error trying to find your favorites.  Try again or contact support.")
+		return
+	}
+
+	favorites.Render(w, r, vars{"Title": "Headlamp: Favorites", "Folders": folders, "Files": files, "Sort": ""})
+}
+
+// changeFavorite adds the given item to the current user's favorites, or
+// removes it, then sends them back to the page they came from.  Only items
+// the user can see may be starred.
+func changeFavorite(w http.ResponseWriter, r *http.Request, action, kind, idString string) {
+	if action != "add" && action != "remove" {
+		_400(w, r, "Invalid request")
+		return
+	}
+	var id, err = strconv.ParseUint(idString, 10, 64)
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var op = userOperation(r)
+	var name string
+	switch kind {
+	case db.FavoriteFile:
+		var f *db.File
+		f, err = op.FindFileByID(id)
+		if f != nil {
+			name = f.Name
+		}
+	case db.FavoriteFolder:
+		var f *db.Folder
+		f, err = op.FindFolderByPermalink(int(id))
+		if f != nil {
+			id = uint64(f.ID)
+			name = f.Name
+		}
+	default:
+		_400(w, r, "Invalid request")
+		return
+	}
+	if err != nil {
+		logger.Errorf("Unable to look up %s id %d: %s", kind, id, err)
+		_500(w, r, "Unable to update your favorites.  Try again or contact support.")
+		return
+	}
+	if name == "" {
+		_404(w, r, fmt.Sprintf("Unable to find the requested %s", kind))
+		return
+	}
+
+	var u = currentUser(r)
+	if action == "add" {
+		err = op.AddFavorite(u, kind, id)
+	} else {
+		err = op.RemoveFavorite(u, kind, id)
+	}
+	if err != nil {
+		logger.Errorf("Unable to %s favorite %s id %d for user %d: %s", action, kind, id, u.ID, err)
+		_500(w, r, "Unable to update your favorites.  Try again or contact support.")
+		return
+	}
+
+	if action == "add" {
+		setInfo(w, r, fmt.Sprintf("%q has been added to your favorites", name))
+	} else {
+		setInfo(w, r, fmt.Sprintf("%q has been removed from your favorites", name))
+	}
+	http.Redirect(w, r, nextOr(r, favoritesPath()), http.StatusSeeOther)
+}
@@ -8,27 +8,39 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/uoregon-libraries/gopkg/fileutil"
 	"github.com/uoregon-libraries/gopkg/logger"
 	"github.com/uoregon-libraries/headlamp/src/db"
+	"github.com/uoregon-libraries/headlamp/src/thumbnail"
 )
 
-// getFile returns an *os.File retrieved using the id in the last path element,
-// or nil if no file was retrieved.  If nil is returned, the caller shouldn't
-// render or output anything; 400, 500, and 404 errors will already have been
-// sent to the browser.
-func getFile(w http.ResponseWriter, r *http.Request) *os.File {
+// realPath returns the file's location on disk
+func realPath(file *db.File) (string, error) {
+	var root, err = conf.RootPath(file.Root)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, file.FullPath), nil
+}
+
+// getFile returns the db.File and its *os.File retrieved using the id in the
+// last path element, or nils if no file was retrieved.  If nil is returned,
+// the caller shouldn't render or output anything; 400, 500, and 404 errors
+// will already have been sent to the browser.
+func getFile(w http.ResponseWriter, r *http.Request) (*db.File, *os.File) {
 	var fileID uint64
 	var err error
-	var op = dbh.Operation()
+	var op = userOperation(r)
 
 	var parts = getPathParts(r)
 	var idString = parts[len(parts)-1]
 	fileID, err = strconv.ParseUint(idString, 10, 64)
 	if err != nil {
 		_400(w, r, "Invalid request")
-		return nil
+		return nil, nil
 	}
 
 	var file *db.File
@@ -36,19 +48,25 @@ func getFile(w http.ResponseWriter, r *http.Request) *os.File {
 	if err != nil {
 		logger.Errorf("Error trying to find file id %d: %s", fileID, err)
 		_500(w, r, "Unable to read the specified file's data.  Try again or contact support.")
-		return nil
+		return nil, nil
 	}
 
 	if file == nil {
 		_404(w, r, "Unable to find the requested file.  Try again or contact support.")
-		return nil
+		return nil, nil
 	}
 
-	var fullPath = filepath.Join(conf.DARoot, file.FullPath)
+	var fullPath string
+	fullPath, err = realPath(file)
+	if err != nil {
+		logger.Errorf("File id %d can't be read: %s", file.ID, err)
+		_500(w, r, "Unable to read the specified file's data.  Try again or contact support.")
+		return nil, nil
+	}
 	if !fileutil.IsFile(fullPath) {
-		logger.Errorf("File id %d describes a file I cannot find: %q / %q", file.ID, conf.DARoot, file.FullPath)
+		logger.Errorf("File id %d describes a file I cannot find: %q", file.ID, fullPath)
 		_500(w, r, fmt.Sprintf("Unable to find %q.  Try again or contact support.", file.FullPath))
-		return nil
+		return nil, nil
 	}
 
 	var fh *os.File
@@ -56,7 +74,7 @@ func getFile(w http.ResponseWriter, r *http.Request) *os.File {
 	if err != nil {
 		logger.Errorf("Error trying to Open file %q: %s", file.FullPath, err)
 		_500(w, r, fmt.Sprintf("Unable to open %q.  Try again or contact support.", file.FullPath))
-		return nil
+		return nil, nil
 	}
 
 	// Get mimetype via a modified version of golang's FileServer code
@@ -69,30 +87,258 @@ func getFile(w http.ResponseWriter, r *http.Request) *os.File {
 		if err != nil {
 			logger.Errorf("Error trying to Seek() on file %q: %s", file.FullPath, err)
 			_500(w, r, fmt.Sprintf("Unable to read %q.  Try again or contact support.", file.FullPath))
-			return nil
+			return nil, nil
 		}
 	}
 	w.Header().Set("Content-Type", mimeType)
 
-	return fh
+	return file, fh
+}
+
+// sendFile streams fh to the client and records the download event, with
+// the current user if someone's logged in.  Range and conditional requests
+// are honored, so media players and download managers can fetch just the
+// part they need.  Only full responses and ranges starting at the first byte
+// are recorded as downloads, so a player seeking around or a download manager
+// fetching pieces counts once; HEAD, not-modified, and unsatisfiable range
+// requests aren't recorded at all.
+func sendFile(w http.ResponseWriter, r *http.Request, file *db.File, fh *os.File, kind string) {
+	defer fh.Close()
+
+	var modTime time.Time
+	var info, err = fh.Stat()
+	if err == nil {
+		modTime = info.ModTime()
+	}
+
+	var sr = &statusRecorder{ResponseWriter: w}
+	http.ServeContent(sr, r, filepath.Base(fh.Name()), modTime, fh)
+	if r.Method == http.MethodHead || !downloadStarted(r, sr.status) {
+		return
+	}
+
+	err = dbh.Operation().RecordDownload(file, currentUser(r), kind, sr.bytes)
+	if err != nil {
+		logger.Errorf("Unable to record download of file id %d: %s", file.ID, err)
+	}
+}
+
+// downloadStarted returns true if a response with the given status sent the
+// file from its beginning: either the whole thing, or a range starting at
+// byte zero
+func downloadStarted(r *http.Request, status int) bool {
+	switch status {
+	case http.StatusOK:
+		return true
+	case http.StatusPartialContent:
+		return strings.HasPrefix(strings.TrimSpace(r.Header.Get("Range")), "bytes=0-")
+	}
+	return false
+}
+
+// fileInfoHandler shows a file's details, including the inventory record it
+// came from, so staff can trace it back to its manifest
+func fileInfoHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	var fileID, err = strconv.ParseUint(parts[len(parts)-1], 10, 64)
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var op = userOperation(r)
+	var file *db.File
+	file, err = op.FindFileByID(fileID)
+	if err != nil {
+		logger.Errorf("Error trying to find file id %d: %s", fileID, err)
+		_500(w, r, "Unable to read the specified file's data.  Try again or contact support.")
+		return
+	}
+	if file == nil {
+		_404(w, r, "Unable to find the requested file.  Try again or contact support.")
+		return
+	}
+
+	var p *db.Provenance
+	var fixity *db.FixityCheck
+	file.Category, err = op.FindCategoryByID(file.CategoryID)
+	if err == nil {
+		p, err = op.FileProvenance(file)
+	}
+	if err == nil {
+		fixity, err = op.LatestFixityCheck(file)
+	}
+	if err != nil {
+		logger.Errorf("Error trying to read details for file id %d: %s", fileID, err)
+		_500(w, r, "Unable to read the specified file's data.  Try again or contact support.")
+		return
+	}
+
+	fileInfo.Render(w, r, vars{
+		"Title":      "Headlamp: File Information",
+		"File":       file,
+		"Provenance": p,
+		"Fixity":     fixity,
+	})
+}
+
+// Kinds of in-browser previews
+const (
+	previewImage     = "image"
+	previewThumbnail = "thumbnail"
+	previewPDF       = "pdf"
+	previewAudio     = "audio"
+	previewVideo     = "video"
+)
+
+// browserImageTypes are the image formats browsers can display on their own
+var browserImageTypes = map[string]bool{
+	"image/jpeg":    true,
+	"image/png":     true,
+	"image/gif":     true,
+	"image/webp":    true,
+	"image/svg+xml": true,
+}
+
+// previewKind returns how the file can be previewed, or "" if it can't.
+// Images browsers can't display, like TIFF masters, are previewed with their
+// thumbnail when thumbnails are on.
+func previewKind(file *db.File) string {
+	switch {
+	case browserImageTypes[file.MimeType]:
+		return previewImage
+	case hasThumbnail(file):
+		return previewThumbnail
+	case file.MimeType == "application/pdf":
+		return previewPDF
+	case file.FormatFamily == db.FamilyAudio:
+		return previewAudio
+	case file.FormatFamily == db.FamilyVideo:
+		return previewVideo
+	}
+	return ""
+}
+
+// previewHandler shows a file in the browser, streamed from the dark archive,
+// so staff can check what it is before requesting an archive
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	var fileID, err = strconv.ParseUint(parts[len(parts)-1], 10, 64)
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var op = userOperation(r)
+	var file *db.File
+	file, err = op.FindFileByID(fileID)
+	if err == nil && file != nil {
+		file.Category, err = op.FindCategoryByID(file.CategoryID)
+	}
+	if err != nil {
+		logger.Errorf("Error trying to find file id %d: %s", fileID, err)
+		_500(w, r, "Unable to read the specified file's data.  Try again or contact support.")
+		return
+	}
+	if file == nil {
+		_404(w, r, "Unable to find the requested file.  Try again or contact support.")
+		return
+	}
+
+	preview.Render(w, r, vars{
+		"Title":   "Headlamp: Preview " + file.Name,
+		"File":    file,
+		"Preview": previewKind(file),
+	})
 }
 
 func viewFileHandler(w http.ResponseWriter, r *http.Request) {
-	var fh = getFile(w, r)
+	var file, fh = getFile(w, r)
 	if fh == nil {
 		return
 	}
 
 	w.Header().Set("Content-Disposition", fmt.Sprintf("filename=%s", filepath.Base(fh.Name())))
-	io.Copy(w, fh)
+	sendFile(w, r, file, fh, db.DownloadKindView)
 }
 
 func downloadFileHandler(w http.ResponseWriter, r *http.Request) {
-	var fh = getFile(w, r)
+	var file, fh = getFile(w, r)
 	if fh == nil {
 		return
 	}
 
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filepath.Base(fh.Name())))
-	io.Copy(w, fh)
+	sendFile(w, r, file, fh, db.DownloadKindDownload)
+}
+
+// thumbnails generates and caches image thumbnails, or is nil if thumbnails
+// are turned off
+var thumbnails *thumbnail.Cache
+
+// hasThumbnail returns true if a thumbnail can be shown for the file
+func hasThumbnail(file *db.File) bool {
+	return thumbnails != nil && thumbnail.Supported(file.MimeType) && !file.Missing()
+}
+
+// thumbnailHandler sends a file's thumbnail, generating it if it isn't
+// already cached.  Thumbnails aren't recorded as downloads.
+func thumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	if thumbnails == nil {
+		_404(w, r, "Thumbnails aren't enabled")
+		return
+	}
+
+	var parts = getPathParts(r)
+	var fileID, err = strconv.ParseUint(parts[len(parts)-1], 10, 64)
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var file *db.File
+	file, err = userOperation(r).FindFileByID(fileID)
+	if err != nil {
+		logger.Errorf("Error trying to find file id %d: %s", fileID, err)
+		_500(w, r, "Unable to read the specified file's data.  Try again or contact support.")
+		return
+	}
+	if file == nil || !hasThumbnail(file) {
+		_404(w, r, "No thumbnail is available for the requested file")
+		return
+	}
+
+	var src, thumbPath string
+	src, err = realPath(file)
+	if err == nil {
+		thumbPath, err = thumbnails.Get(strconv.FormatUint(file.ID, 10), src)
+	}
+	if err == thumbnail.ErrUnsupported || err == thumbnail.ErrTooLarge || os.IsNotExist(err) {
+		_404(w, r, "No thumbnail is available for the requested file")
+		return
+	}
+	if err != nil {
+		logger.Errorf("Unable to make thumbnail for file id %d: %s", file.ID, err)
+		_500(w, r, "Unable to make a thumbnail for the requested file")
+		return
+	}
+
+	var fh *os.File
+	fh, err = os.Open(thumbPath)
+	if err != nil {
+		logger.Errorf("Unable to open thumbnail %q: %s", thumbPath, err)
+		_500(w, r, "Unable to make a thumbnail for the requested file")
+		return
+	}
+	defer fh.Close()
+
+	var modTime time.Time
+	var info os.FileInfo
+	info, err = fh.Stat()
+	if err == nil {
+		modTime = info.ModTime()
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, filepath.Base(thumbPath), modTime, fh)
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/humanize"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// dateParamFormat is the format for all date query parameters, which matches
+// what browsers send for "date" inputs
+const dateParamFormat = "2006-01-02"
+
+// filterParams holds the raw filter query parameters so forms can redisplay
+// them
+type filterParams struct {
+	ModifiedAfter  string
+	ModifiedBefore string
+	IndexedAfter   string
+	IndexedBefore  string
+	MinSize        string
+	MaxSize        string
+	Extensions     string
+	MimeType       string
+	Family         string
+}
+
+// getFilterParams pulls the file filters from the request's query string
+func getFilterParams(r *http.Request) filterParams {
+	var q = r.URL.Query()
+	return filterParams{
+		ModifiedAfter:  q.Get("modified_after"),
+		ModifiedBefore: q.Get("modified_before"),
+		IndexedAfter:   q.Get("indexed_after"),
+		IndexedBefore:  q.Get("indexed_before"),
+		MinSize:        q.Get("min_size"),
+		MaxSize:        q.Get("max_size"),
+		Extensions:     strings.Join(q["ext"], ","),
+		MimeType:       q.Get("mime"),
+		Family:         q.Get("family"),
+	}
+}
+
+// FileFilter converts the parameters to a db.FileFilter.  Extensions may be
+// separated by commas and/or spaces.
+func (p filterParams) FileFilter() (db.FileFilter, error) {
+	var dr, err = p.DateRange()
+	var sr db.SizeRange
+	if err == nil {
+		sr, err = p.SizeRange()
+	}
+	if err == nil && p.Family != "" && !validFamily(p.Family) {
+		err = fmt.Errorf("unknown format family %q", p.Family)
+	}
+	var ff = db.FileFilter{Dates: dr, Sizes: sr, MimeType: p.MimeType, Family: p.Family, Extensions: p.extensionList()}
+	return ff, err
+}
+
+// SizeRange converts the size parameters to a db.SizeRange
+func (p filterParams) SizeRange() (db.SizeRange, error) {
+	var sr db.SizeRange
+	var err error
+
+	sr.Min, err = parseSizeParam("min_size", p.MinSize)
+	if err == nil {
+		sr.Max, err = parseSizeParam("max_size", p.MaxSize)
+	}
+	if err == nil && sr.Max > 0 && sr.Min > sr.Max {
+		err = fmt.Errorf("min_size can't be larger than max_size")
+	}
+	return sr, err
+}
+
+// extensionList splits the extensions parameter on commas and spaces
+func (p filterParams) extensionList() []string {
+	return strings.FieldsFunc(p.Extensions, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// HasExtension returns true if ext is one of the extensions being filtered
+// on, so the browse page's extension checkboxes can redisplay what was chosen
+func (p filterParams) HasExtension(ext string) bool {
+	ext = db.NormalizeExtension(ext)
+	for _, e := range p.extensionList() {
+		if db.NormalizeExtension(e) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// validFamily returns true if family is one of the known format families
+func validFamily(family string) bool {
+	for _, f := range db.FormatFamilies {
+		if f == family {
+			return true
+		}
+	}
+	return false
+}
+
+// Families returns the format families for the filter form's select box
+func (p filterParams) Families() []string {
+	return db.FormatFamilies
+}
+
+// DateRange converts the parameters to a db.DateRange.  "After" dates include
+// the day given, as do "before" dates, so a range of 2018-01-01 to 2018-01-01
+// finds everything on that day.
+func (p filterParams) DateRange() (db.DateRange, error) {
+	var dr db.DateRange
+	var err error
+
+	dr.ModifiedAfter, err = parseDateParam("modified_after", p.ModifiedAfter, 0)
+	if err == nil {
+		dr.ModifiedBefore, err = parseDateParam("modified_before", p.ModifiedBefore, 1)
+	}
+	if err == nil {
+		dr.IndexedAfter, err = parseDateParam("indexed_after", p.IndexedAfter, 0)
+	}
+	if err == nil {
+		dr.IndexedBefore, err = parseDateParam("indexed_before", p.IndexedBefore, 1)
+	}
+	return dr, err
+}
+
+// parseDateParam parses val, offsetting it by the given number of days.  An
+// empty value returns a zero time.
+func parseDateParam(name, val string, days int) (time.Time, error) {
+	if val == "" {
+		return time.Time{}, nil
+	}
+
+	var t, err = time.Parse(dateParamFormat, val)
+	if err != nil {
+		return t, fmt.Errorf("%s must be a date formatted as YYYY-MM-DD", name)
+	}
+	return t.AddDate(0, 0, days), nil
+}
+
+// sizeUnits maps the unit suffixes size parameters may use to their
+// multipliers.  Units are powers of 1024 to match how sizes are displayed.
+var sizeUnits = map[string]float64{
+	"":   1,
+	"b":  1,
+	"k":  humanize.Kilobyte,
+	"kb": humanize.Kilobyte,
+	"m":  humanize.Megabyte,
+	"mb": humanize.Megabyte,
+	"g":  humanize.Gigabyte,
+	"gb": humanize.Gigabyte,
+	"t":  humanize.Terabyte,
+	"tb": humanize.Terabyte,
+}
+
+// parseSizeParam parses a file size such as "5 GB", "1.5m", or "1024".  An
+// empty value returns zero.
+func parseSizeParam(name, val string) (int64, error) {
+	val = strings.ToLower(strings.TrimSpace(val))
+	if val == "" {
+		return 0, nil
+	}
+
+	var i = strings.IndexFunc(val, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(val)
+	}
+	var n, err = strconv.ParseFloat(val[:i], 64)
+	var mult, ok = sizeUnits[strings.TrimSpace(val[i:])]
+	if err != nil || !ok || n < 0 {
+		return 0, fmt.Errorf("%s must be a size such as 500, 20 MB, or 1.5 GB", name)
+	}
+	return int64(n * mult), nil
+}
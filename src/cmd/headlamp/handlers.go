@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"strings"
 
@@ -10,8 +11,9 @@ import (
 	"github.com/uoregon-libraries/headlamp/src/db"
 )
 
-// maxFiles tells the app how many files to display on at once; if there are
-// more than this many, we let the user know to do a different search
+// maxFiles is the most files (or folders) the app will display at once; it's
+// the largest page size browse, search, and the API allow, and pages without
+// paging, like "what's new", are cut off at this many results
 const maxFiles = 1000
 
 type vars map[string]interface{}
@@ -37,14 +39,40 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func renderHome(w http.ResponseWriter, r *http.Request) {
-	var categories, err = dbh.Operation().AllCategories()
+	var categories, err = userOperation(r).ListedCategories()
 	if err != nil {
 		logger.Errorf("Unable to find categories: %s", err)
 		_500(w, r, "Error trying to find category list.  Try again or contact support.")
 		return
 	}
 
-	home.Render(w, r, vars{"Title": "Headlamp", "Categories": categories})
+	home.Render(w, r, vars{
+		"Title":         "Headlamp",
+		"Categories":    categories,
+		"Filters":       getFilterParams(r),
+		"RecentFolders": recentFolders(r),
+	})
+}
+
+// recentFolders returns the folders the current user viewed most recently,
+// skipping any which have since been removed or which they can no longer see
+func recentFolders(r *http.Request) []*db.Folder {
+	var op = userOperation(r)
+	var folders []*db.Folder
+	for _, id := range sessionRecentFolderIDs(r) {
+		var f, err = op.FindFolderByPermalink(id)
+		if err == nil && f != nil {
+			f.Category, err = op.FindCategoryByID(f.CategoryID)
+		}
+		if err != nil {
+			logger.Errorf("Unable to look up recently viewed folder %d: %s", id, err)
+			return folders
+		}
+		if f != nil && f.Category != nil {
+			folders = append(folders, f)
+		}
+	}
+	return folders
 }
 
 type browseSearchData struct {
@@ -53,6 +81,8 @@ type browseSearchData struct {
 	category   *db.Category
 	folderPath string
 	folder     *db.Folder
+	filters    filterParams
+	fileFilter db.FileFilter
 	hadError   bool
 }
 
@@ -67,7 +97,15 @@ func getBrowseSearchData(w http.ResponseWriter, r *http.Request) browseSearchDat
 	var parts = getPathParts(r)
 
 	// We're doing a lot, so let's grab a single operation for all this lovely work
-	bsd.op = dbh.Operation()
+	bsd.op = userOperation(r)
+
+	var err error
+	bsd.filters = getFilterParams(r)
+	bsd.fileFilter, err = bsd.filters.FileFilter()
+	if err != nil {
+		_400(w, r, err.Error())
+		return bsde
+	}
 
 	if len(parts) < 2 {
 		return bsd
@@ -82,7 +120,6 @@ func getBrowseSearchData(w http.ResponseWriter, r *http.Request) browseSearchDat
 		return bsd
 	}
 
-	var err error
 	bsd.category, err = bsd.op.FindCategoryByName(bsd.pName)
 	if err != nil {
 		logger.Errorf("Error trying to read category %q from the database: %s", bsd.pName, err)
@@ -90,7 +127,7 @@ func getBrowseSearchData(w http.ResponseWriter, r *http.Request) browseSearchDat
 		return bsde
 	}
 	if bsd.category == nil {
-		_404(w, r, fmt.Sprintf("Category %q not found", bsd.pName))
+		redirectOldCategory(w, r, bsd.op, parts)
 		return bsde
 	}
 
@@ -103,7 +140,15 @@ func getBrowseSearchData(w http.ResponseWriter, r *http.Request) browseSearchDat
 			return bsde
 		}
 		if bsd.folder == nil {
-			_404(w, r, fmt.Sprintf("Folder %q not found", bsd.folderPath))
+			redirectOldFolder(w, r, bsd, parts)
+			return bsde
+		}
+		bsd.folder.Category = bsd.category
+		err = bsd.op.LoadFolderAncestors(bsd.folder)
+		if err != nil {
+			logger.Errorf("Error trying to read ancestors of folder %q (in category %q) from the database: %s",
+				bsd.folderPath, bsd.pName, err)
+			_500(w, r, fmt.Sprintf("Error trying to find folder %q.  Try again or contact support.", bsd.folderPath))
 			return bsde
 		}
 	}
@@ -111,12 +156,73 @@ func getBrowseSearchData(w http.ResponseWriter, r *http.Request) browseSearchDat
 	return bsd
 }
 
+// redirectOldCategory sends the browser to the current URL for a category
+// which has been renamed or merged away, or a 404 if the category in parts
+// never existed
+func redirectOldCategory(w http.ResponseWriter, r *http.Request, op *db.Operation, parts []string) {
+	var c, err = op.FindCategoryByOldName(parts[1])
+	if err != nil {
+		logger.Errorf("Error trying to look up old category name %q: %s", parts[1], err)
+		_500(w, r, fmt.Sprintf("Error trying to find category %q.  Try again or contact support.", parts[1]))
+		return
+	}
+	if c == nil {
+		_404(w, r, fmt.Sprintf("Category %q not found", parts[1]))
+		return
+	}
+
+	var newParts = append([]string{parts[0], c.Name}, parts[2:]...)
+	var u = *r.URL
+	u.Path = joinPaths(newParts...)
+	u.RawPath = ""
+	if strings.HasSuffix(r.URL.Path, "/") {
+		u.Path += "/"
+	}
+	http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+}
+
+// redirectOldFolder sends the browser to the current URL for a folder which
+// has been moved or renamed, or a 404 if the folder in bsd never existed
+func redirectOldFolder(w http.ResponseWriter, r *http.Request, bsd browseSearchData, parts []string) {
+	var f, err = bsd.op.FindFolderByOldPath(bsd.category, bsd.folderPath)
+	if err != nil {
+		logger.Errorf("Error trying to look up old folder path %q (in category %q): %s", bsd.folderPath, bsd.pName, err)
+		_500(w, r, fmt.Sprintf("Error trying to find folder %q.  Try again or contact support.", bsd.folderPath))
+		return
+	}
+	if f == nil {
+		_404(w, r, fmt.Sprintf("Folder %q not found", bsd.folderPath))
+		return
+	}
+
+	var u = *r.URL
+	u.Path = joinPaths(parts[0], bsd.category.Name, sanitizePath(f.PublicPath))
+	u.RawPath = ""
+	if strings.HasSuffix(r.URL.Path, "/") {
+		u.Path += "/"
+	}
+	http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+}
+
 func browseHandler(w http.ResponseWriter, r *http.Request) {
 	var bsd = getBrowseSearchData(w, r)
 	if bsd.hadError {
 		return
 	}
 
+	// The view is recorded before checking the cache, so revisiting a folder
+	// the browser already has still moves it to the top of the recent list
+	if bsd.folder != nil {
+		var err = addRecentFolder(w, r, bsd.folder)
+		if err != nil {
+			logger.Warnf("Unable to record recently viewed folder %d: %s", bsd.folder.ID, err)
+		}
+	}
+
+	if notModified(w, r) {
+		return
+	}
+
 	var folders, err = bsd.op.GetFolders(bsd.category, bsd.folder)
 	if err != nil {
 		logger.Errorf("Error trying to read folders under %q (in category %q) from the database: %s",
@@ -125,9 +231,22 @@ func browseHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var fs db.FileSort
+	fs, err = db.ParseFileSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		_400(w, r, err.Error())
+		return
+	}
+	var page, perPage uint64
+	page, perPage, err = getPage(r)
+	if err != nil {
+		_400(w, r, err.Error())
+		return
+	}
+
 	var files []*db.File
 	var totalFileCount uint64
-	files, totalFileCount, err = bsd.op.GetFiles(bsd.category, bsd.folder, maxFiles+1)
+	files, totalFileCount, err = bsd.op.GetFiles(bsd.category, bsd.folder, bsd.fileFilter, fs, db.Page{Offset: (page - 1) * perPage, Limit: perPage})
 	if err != nil {
 		logger.Errorf("Error trying to read files under %q (in category %q) from the database: %s",
 			bsd.folderPath, bsd.pName, err)
@@ -135,10 +254,24 @@ func browseHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var tooManyFiles = false
-	if len(files) > maxFiles {
-		files = files[:maxFiles]
-		tooManyFiles = true
+	var exts []*db.ExtensionCount
+	exts, err = bsd.op.FileExtensions(bsd.category, bsd.folder)
+	if err != nil {
+		logger.Errorf("Error trying to read file extensions under %q (in category %q) from the database: %s",
+			bsd.folderPath, bsd.pName, err)
+		_500(w, r, fmt.Sprintf("Error trying to read folder %q.  Try again or contact support.", bsd.folderPath))
+		return
+	}
+
+	var totals *db.FolderTotal
+	if bsd.folder != nil {
+		totals, err = bsd.op.FolderTotals(bsd.folder)
+		if err != nil {
+			logger.Errorf("Error trying to read totals for folder %q (in category %q) from the database: %s",
+				bsd.folderPath, bsd.pName, err)
+			_500(w, r, fmt.Sprintf("Error trying to read folder %q.  Try again or contact support.", bsd.folderPath))
+			return
+		}
 	}
 
 	browse.Render(w, r, vars{
@@ -147,12 +280,81 @@ func browseHandler(w http.ResponseWriter, r *http.Request) {
 		"Folder":       bsd.folder,
 		"Folders":      folders,
 		"Files":        files,
-		"TooManyFiles": tooManyFiles,
-		"MaxFiles":     maxFiles,
-		"TotalFiles":   totalFileCount,
+		"Pager":        newPager(r, page, perPage, totalFileCount),
+		"Sort":         fs.String(),
+		"SortLinks":    sortLinks(r, fs),
+		"FolderTotals": totals,
+		"Filters":      bsd.filters,
+		"Extensions":   exts,
+		"Crawlable":    bsd.category.Discoverable(),
 	})
 }
 
+// withQuery returns the request's URL with the given query parameters set,
+// removing any whose new value is empty
+func withQuery(r *http.Request, params map[string]string) string {
+	var u = *r.URL
+	var q = u.Query()
+	for k, v := range params {
+		if v == "" {
+			q.Del(k)
+		} else {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+// tidyQuery wraps a handler so that GET requests whose query string has empty
+// parameters, as any submitted form with optional fields does, are
+// redirected to the same URL without them.  This keeps result URLs short
+// enough to paste into an email while still encoding everything needed to
+// rebuild the page.  It belongs outside any rate limit, so the redirect
+// doesn't count as a second search.
+func tidyQuery(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.RawQuery == "" {
+			h(w, r)
+			return
+		}
+
+		var q = r.URL.Query()
+		var tidy = make(url.Values)
+		for k, vals := range q {
+			for _, v := range vals {
+				if v != "" {
+					tidy.Add(k, v)
+				}
+			}
+		}
+		var encoded = tidy.Encode()
+		if encoded == q.Encode() {
+			h(w, r)
+			return
+		}
+
+		var u = *r.URL
+		u.RawQuery = encoded
+		http.Redirect(w, r, u.RequestURI(), http.StatusFound)
+	}
+}
+
+// sortLinks returns the URL for sorting the current page by each sortable
+// field.  The current sort field's link flips its direction.  Changing the
+// sort goes back to the first page.
+func sortLinks(r *http.Request, current db.FileSort) map[string]string {
+	var links = make(map[string]string)
+	for _, field := range db.FileSortFields {
+		var fs = db.FileSort{Field: field}
+		if current.Field == field && !current.Desc {
+			fs.Desc = true
+		}
+		links[field] = withQuery(r, map[string]string{"sort": fs.String(), "page": ""})
+	}
+	return links
+}
+
 func searchHandler(w http.ResponseWriter, r *http.Request) {
 	var bsd = getBrowseSearchData(w, r)
 	if bsd.hadError {
@@ -162,27 +364,65 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 	var q = r.URL.Query().Get("q")
 	var fq = r.URL.Query().Get("fq")
 	if q == "" && fq == "" {
-		setAlert(w, r, "You must provide a search term")
-		w.WriteHeader(http.StatusBadRequest)
-
-		if bsd.pName == "" {
-			renderHome(w, r)
-			return
-		}
+		searchError(w, r, bsd, "You must provide a search term")
+		return
+	}
 
-		browseHandler(w, r)
+	var mode, err = db.ParseSearchMode(r.URL.Query().Get("mode"))
+	if err == nil {
+		err = mode.Validate(q + fq)
+	}
+	if err != nil {
+		searchError(w, r, bsd, fmt.Sprintf("Invalid search: %s", err))
 		return
 	}
 
 	if fq != "" {
-		folderSearch(w, r, bsd, fq)
+		folderSearch(w, r, bsd, fq, mode)
+		return
+	}
+	fileSearch(w, r, bsd, q, mode)
+}
+
+// searchError reports a bad search request to the user and falls back to
+// the page they searched from
+func searchError(w http.ResponseWriter, r *http.Request, bsd browseSearchData, msg string) {
+	setAlert(w, r, msg)
+	w.WriteHeader(http.StatusBadRequest)
+
+	if bsd.pName == "" {
+		renderHome(w, r)
 		return
 	}
-	fileSearch(w, r, bsd, q)
+
+	browseHandler(w, r)
 }
 
-func fileSearch(w http.ResponseWriter, r *http.Request, bsd browseSearchData, term string) {
-	var files, totalFileCount, err = bsd.op.SearchFiles(bsd.category, bsd.folder, term, maxFiles+1)
+func fileSearch(w http.ResponseWriter, r *http.Request, bsd browseSearchData, term string, mode db.SearchMode) {
+	var page, perPage, err = getPage(r)
+	if err != nil {
+		searchError(w, r, bsd, fmt.Sprintf("Invalid search: %s", err))
+		return
+	}
+
+	var fs db.FileSort
+	fs, err = db.ParseFileSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		searchError(w, r, bsd, fmt.Sprintf("Invalid search: %s", err))
+		return
+	}
+
+	var files []*db.File
+	var totalFileCount uint64
+	files, totalFileCount, err = bsd.op.QueryFiles(db.FileQuery{
+		Category: bsd.category,
+		Folder:   bsd.folder,
+		Term:     term,
+		Mode:     mode,
+		Filter:   bsd.fileFilter,
+		Sort:     fs,
+		Page:     db.Page{Offset: (page - 1) * perPage, Limit: perPage},
+	})
 	if err != nil {
 		logger.Errorf("Error trying to search for files under %q (in category %q) from the database: %s",
 			bsd.folderPath, bsd.pName, err)
@@ -190,26 +430,32 @@ func fileSearch(w http.ResponseWriter, r *http.Request, bsd browseSearchData, te
 		return
 	}
 
-	var tooManyFiles = false
-	if len(files) > maxFiles {
-		files = files[:maxFiles]
-		tooManyFiles = true
-	}
-
 	search.Render(w, r, vars{
-		"Title":        "Headlamp: File Search",
-		"SearchTerm":   term,
-		"Category":     bsd.category,
-		"Folder":       bsd.folder,
-		"Files":        files,
-		"TooManyFiles": tooManyFiles,
-		"MaxFiles":     maxFiles,
-		"TotalFiles":   totalFileCount,
+		"Title":      "Headlamp: File Search",
+		"SearchTerm": term,
+		"SearchMode": mode,
+		"Category":   bsd.category,
+		"Folder":     bsd.folder,
+		"Files":      files,
+		"Sort":       fs.String(),
+		"SortLinks":  sortLinks(r, fs),
+		"Highlight":  newHighlighter(mode, term),
+		"Pager":      newPager(r, page, perPage, totalFileCount),
+		"Filters":    bsd.filters,
+		"ExportPath": exportResultsPath(r, bsd.category, bsd.folder),
 	})
 }
 
-func folderSearch(w http.ResponseWriter, r *http.Request, bsd browseSearchData, term string) {
-	var folders, totalFolderCount, err = bsd.op.SearchFolders(bsd.category, bsd.folder, term, maxFiles+1)
+func folderSearch(w http.ResponseWriter, r *http.Request, bsd browseSearchData, term string, mode db.SearchMode) {
+	var page, perPage, err = getPage(r)
+	if err != nil {
+		searchError(w, r, bsd, fmt.Sprintf("Invalid search: %s", err))
+		return
+	}
+
+	var folders []*db.Folder
+	var totalFolderCount uint64
+	folders, totalFolderCount, err = bsd.op.SearchFolders(bsd.category, bsd.folder, term, mode, db.Page{Offset: (page - 1) * perPage, Limit: perPage})
 	if err != nil {
 		logger.Errorf("Error trying to search for folders under %q (in category %q) from the database: %s",
 			bsd.folderPath, bsd.pName, err)
@@ -217,21 +463,16 @@ func folderSearch(w http.ResponseWriter, r *http.Request, bsd browseSearchData,
 		return
 	}
 
-	var tooManyFolders = false
-	if len(folders) > maxFiles {
-		folders = folders[:maxFiles]
-		tooManyFolders = true
-	}
-
 	search.Render(w, r, vars{
 		"Title":            "Headlamp: Folder Search",
 		"FolderSearchTerm": term,
+		"SearchMode":       mode,
 		"Category":         bsd.category,
 		"Folder":           bsd.folder,
 		"Folders":          folders,
-		"TooManyFolders":   tooManyFolders,
-		"MaxFolders":       maxFiles,
-		"TotalFolders":     totalFolderCount,
+		"Highlight":        newHighlighter(mode, term),
+		"FolderPager":      newPager(r, page, perPage, totalFolderCount),
+		"Filters":          bsd.filters,
 	})
 }
 
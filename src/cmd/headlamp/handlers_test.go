@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+func TestAuthRoutes(t *testing.T) {
+	var srv = newTestServer(t)
+	var clients = map[string]*http.Client{
+		"anonymous":    newClient(t, srv, "", ""),
+		db.RoleViewer:  newClient(t, srv, "viewer", ""),
+		db.RoleStaff:   newClient(t, srv, "staff", db.RoleStaff),
+		db.RoleCurator: newClient(t, srv, "curator", db.RoleCurator),
+		db.RoleAdmin:   newClient(t, srv, "admin", db.RoleAdmin),
+	}
+
+	var tests = []struct {
+		path    string
+		allowed []string
+	}{
+		{"/saved-searches/", []string{db.RoleViewer, db.RoleStaff, db.RoleCurator, db.RoleAdmin}},
+		{"/favorites/", []string{db.RoleViewer, db.RoleStaff, db.RoleCurator, db.RoleAdmin}},
+		{"/export/Photos", []string{db.RoleCurator, db.RoleAdmin}},
+		{"/admin/jobs/", []string{db.RoleAdmin}},
+		{"/admin/users/", []string{db.RoleAdmin}},
+	}
+
+	for _, tc := range tests {
+		for who, c := range clients {
+			var allowed bool
+			for _, role := range tc.allowed {
+				allowed = allowed || role == who
+			}
+			var resp, _ = mustGet(t, c, srv, tc.path, nil)
+			switch {
+			case allowed && resp.StatusCode != http.StatusOK:
+				t.Errorf("%s: expected %s to get status 200, got %d", tc.path, who, resp.StatusCode)
+			case !allowed && resp.StatusCode != http.StatusForbidden:
+				t.Errorf("%s: expected %s to get status 403, got %d", tc.path, who, resp.StatusCode)
+			}
+		}
+	}
+}
+
+// warmUp visits a page so the session's CSRF token exists.  The first page
+// rendered after logging in creates the token, which changes every ETag, so
+// tests comparing ETags start after that.
+func warmUp(t *testing.T, c *http.Client, srv *httptest.Server) {
+	var resp, _ = mustGet(t, c, srv, "/browse/Photos/", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 browsing Photos, got %d", resp.StatusCode)
+	}
+}
+
+func TestBrowseNotModified(t *testing.T) {
+	var srv = newTestServer(t)
+	var c = newClient(t, srv, "viewer", "")
+	warmUp(t, c, srv)
+
+	var resp, _ = mustGet(t, c, srv, "/browse/Photos/events", nil)
+	var etag = resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("Expected status 200 with an ETag, got %d with %q", resp.StatusCode, etag)
+	}
+
+	resp, _ = mustGet(t, c, srv, "/browse/Photos/events", map[string]string{"If-None-Match": etag})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected status 304 for a current ETag, got %d", resp.StatusCode)
+	}
+
+	// Somebody else's view of the same page never matches
+	resp, _ = mustGet(t, newClient(t, srv, "", ""), srv, "/browse/Photos/events", map[string]string{"If-None-Match": etag})
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 for another visitor's ETag, got %d", resp.StatusCode)
+	}
+
+	// Any change to indexed content invalidates every page
+	var op = dbh.Operation()
+	var photos, err = op.FindCategoryByName("Photos")
+	if err != nil || photos == nil {
+		t.Fatalf("Unable to find Photos (error: %v)", err)
+	}
+	var f *db.Folder
+	f, err = op.FindFolderByPath(photos, "portraits")
+	if err != nil || f == nil {
+		t.Fatalf("Unable to find portraits (error: %v)", err)
+	}
+	err = op.SetFolderRestricted(f, true)
+	if err != nil {
+		t.Fatalf("Unable to restrict portraits: %s", err)
+	}
+	resp, _ = mustGet(t, c, srv, "/browse/Photos/events", map[string]string{"If-None-Match": etag})
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 after the content changed, got %d", resp.StatusCode)
+	}
+}
+
+func TestBrowseNotModifiedRecordsRecentFolder(t *testing.T) {
+	var srv = newTestServer(t)
+	var c = newClient(t, srv, "viewer", "")
+	warmUp(t, c, srv)
+
+	var resp, _ = mustGet(t, c, srv, "/browse/Photos/events", nil)
+	var etag = resp.Header.Get("ETag")
+	mustGet(t, c, srv, "/browse/Photos/portraits", nil)
+	resp, _ = mustGet(t, c, srv, "/browse/Photos/events", map[string]string{"If-None-Match": etag})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("Expected status 304 revisiting events, got %d", resp.StatusCode)
+	}
+
+	var _, home = mustGet(t, c, srv, "/", nil)
+	var events = strings.Index(home, "/browse/Photos/events")
+	var portraits = strings.Index(home, "/browse/Photos/portraits")
+	if events < 0 || portraits < 0 || events > portraits {
+		t.Errorf("Expected events, then portraits, in the recent folders (positions %d and %d)", events, portraits)
+	}
+}
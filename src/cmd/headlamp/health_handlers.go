@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/config"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// mountCheckTimeout is how long the health check waits on a dark archive
+// root before calling it inaccessible; a hung network mount would otherwise
+// hang the check along with it
+const mountCheckTimeout = time.Second * 5
+
+// heartbeatMaxAge is how old a worker's last heartbeat can be before the
+// health check considers the worker dead
+const heartbeatMaxAge = db.HeartbeatInterval * 5
+
+// healthCheck is one component's part of the health report
+type healthCheck struct {
+	Name          string     `json:"name"`
+	OK            bool       `json:"ok"`
+	Error         string     `json:"error,omitempty"`
+	LastHeartbeat *time.Time `json:"last_heartbeat,omitempty"`
+}
+
+// healthReport is the /healthz response
+type healthReport struct {
+	Status   string        `json:"status"`
+	Database healthCheck   `json:"database"`
+	Roots    []healthCheck `json:"roots"`
+	Workers  []healthCheck `json:"workers"`
+}
+
+// healthHandler reports whether the database can be read, the dark archive
+// roots are accessible, and the indexer and archiver are alive.  It responds
+// with a 200 if everything is fine and a 503 otherwise, for load balancers
+// and monitoring.  Problems are described briefly, and logged in full, since
+// anybody can reach this endpoint.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	var report = healthReport{Status: "ok"}
+	var healthy = true
+	var fail = func(c *healthCheck, short string, err error) {
+		logger.Warnf("Health check: %s: %s", c.Name, err)
+		c.OK = false
+		c.Error = short
+		healthy = false
+	}
+
+	var op = dbh.Operation()
+	report.Database = healthCheck{Name: "database", OK: true}
+	var err = op.Ping()
+	if err != nil {
+		fail(&report.Database, "unable to query the database", err)
+	}
+
+	for _, root := range conf.Roots {
+		var c = healthCheck{Name: rootLabel(root), OK: true}
+		err = checkMount(root.Path)
+		if err != nil {
+			fail(&c, "dark archive root is not accessible", err)
+		}
+		report.Roots = append(report.Roots, c)
+	}
+
+	var beats map[string]time.Time
+	if report.Database.OK {
+		beats, err = op.LastHeartbeats()
+		if err != nil {
+			fail(&report.Database, "unable to query the database", err)
+		}
+	}
+	for _, worker := range db.Workers {
+		var c = healthCheck{Name: worker, OK: true}
+		var t, ok = beats[worker]
+		switch {
+		case !ok:
+			fail(&c, "no heartbeat recorded", fmt.Errorf("worker has never recorded a heartbeat"))
+		case time.Since(t) > heartbeatMaxAge:
+			fail(&c, "heartbeat is stale", fmt.Errorf("last heartbeat was at %s", t.Format(time.RFC3339)))
+		}
+		if ok {
+			c.LastHeartbeat = apiTime(t)
+		}
+		report.Workers = append(report.Workers, c)
+	}
+
+	var status = http.StatusOK
+	if !healthy {
+		report.Status = "error"
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	apiJSON(w, status, report)
+}
+
+// rootLabel names a dark archive root in the health report
+func rootLabel(root config.Root) string {
+	if root.Name == "" {
+		return "main"
+	}
+	return root.Name
+}
+
+// checkMount makes sure the directory at path can be listed, giving up after
+// mountCheckTimeout
+func checkMount(path string) error {
+	var done = make(chan error, 1)
+	go func() {
+		var f, err = os.Open(path)
+		if err != nil {
+			done <- err
+			return
+		}
+		_, err = f.Readdirnames(1)
+		f.Close()
+		if err == io.EOF {
+			err = nil
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(mountCheckTimeout):
+		return fmt.Errorf("no response from %q after %s", path, mountCheckTimeout)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uoregon-libraries/headlamp/src/auth"
+	"github.com/uoregon-libraries/headlamp/src/config"
+	"github.com/uoregon-libraries/headlamp/src/db"
+	"github.com/uoregon-libraries/headlamp/src/db/fixtures"
+)
+
+// testSettings is a minimal settings file; the paths are filled in with
+// fmt.Sprintf
+const testSettings = `
+BIND_ADDRESS=":0"
+WEBPATH="http://localhost"
+APPROOT=%q
+DARK_ARCHIVE_PATH=%q
+ARCHIVE_PATH_FORMAT="category/date"
+INVENTORY_FILE_GLOB="*/*/INVENTORY/*.csv"
+ARCHIVE_OUTPUT_LOCATION=%q
+ARCHIVE_LIFETIME_DAYS=7
+SMTP_USER="headlamp@example.org"
+SMTP_PASS="password"
+SMTP_HOST="localhost"
+SMTP_PORT=25
+`
+
+// testLoginPath is served next to the app by newTestServer so tests can log
+// in without a real auth backend
+const testLoginPath = "/test-login"
+
+// newTestServer serves the full app, routes and middleware included, from an
+// in-memory database holding the db package's archive fixtures.  Visiting
+// testLoginPath with a login and an optional role logs that user in.
+func newTestServer(t *testing.T) *httptest.Server {
+	var dir = t.TempDir()
+	var approot, err = filepath.Abs("../../..")
+	if err != nil {
+		t.Fatalf("Unable to find the app root: %s", err)
+	}
+	var daRoot = filepath.Join(dir, "dark-archive")
+	var archives = filepath.Join(dir, "archives")
+	for _, d := range []string{daRoot, archives} {
+		err = os.Mkdir(d, 0700)
+		if err != nil {
+			t.Fatalf("Unable to create %q: %s", d, err)
+		}
+	}
+
+	var settings = filepath.Join(dir, "settings")
+	err = ioutil.WriteFile(settings, []byte(fmt.Sprintf(testSettings, approot, daRoot, archives)), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write settings: %s", err)
+	}
+	conf, err = config.Read(settings)
+	if err != nil {
+		t.Fatalf("Unable to read settings: %s", err)
+	}
+
+	dbh, err = db.NewMemory("../../../db/migrations")
+	if err != nil {
+		t.Fatalf("Unable to create in-memory database: %s", err)
+	}
+	t.Cleanup(func() { dbh.Close() })
+	err = fixtures.LoadFile(dbh, "../../db/fixtures/testdata/archive.yml")
+	if err != nil {
+		t.Fatalf("Unable to load fixtures: %s", err)
+	}
+
+	var mux = http.NewServeMux()
+	mux.Handle("/", newHandler())
+	mux.Handle(testLoginPath, sessionManager.Use(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ident = &auth.Identity{
+			Login: r.FormValue("login"),
+			Email: r.FormValue("login") + "@example.org",
+			Roles: r.Form["role"],
+		}
+		var err = startUserSession(w, r, ident)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})))
+
+	var srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newClient returns a client with its own cookies, logged in as the given
+// user with the given role unless login is empty.  Redirects aren't followed,
+// so tests see exactly what each handler sent.
+func newClient(t *testing.T, srv *httptest.Server, login, role string) *http.Client {
+	var jar, _ = cookiejar.New(nil)
+	var c = &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if login == "" {
+		return c
+	}
+
+	var v = url.Values{"login": {login}}
+	if role != "" {
+		v.Set("role", role)
+	}
+	var resp, err = c.Get(srv.URL + testLoginPath + "?" + v.Encode())
+	if err != nil {
+		t.Fatalf("Unable to log in as %q: %s", login, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unable to log in as %q: got status %d", login, resp.StatusCode)
+	}
+	return c
+}
+
+// mustGet requests the path with the given headers, failing the test if the
+// request can't be made.  The response body is returned as a string.
+func mustGet(t *testing.T, c *http.Client, srv *httptest.Server, path string, headers map[string]string) (*http.Response, string) {
+	var req, err = http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	if err != nil {
+		t.Fatalf("Unable to build request for %q: %s", path, err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	var resp *http.Response
+	resp, err = c.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to get %q: %s", path, err)
+	}
+	defer resp.Body.Close()
+
+	var body []byte
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Unable to read %q: %s", path, err)
+	}
+	return resp, string(body)
+}
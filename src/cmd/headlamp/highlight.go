@@ -0,0 +1,83 @@
+package main
+
+import (
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// highlighter marks the part of each search result's path which matched the
+// search.  A nil highlighter marks nothing, so the same templates work for
+// browsing.
+type highlighter struct {
+	re *regexp.Regexp
+}
+
+// newHighlighter returns a highlighter for the given search, or nil if the
+// term can't be turned into an expression (which shouldn't happen for a term
+// the search itself accepted)
+func newHighlighter(mode db.SearchMode, term string) *highlighter {
+	var re, err = mode.Matcher(term)
+	if err != nil {
+		return nil
+	}
+	return &highlighter{re: re}
+}
+
+// mark returns full[start:end], HTML-escaped, with whatever part of it the
+// search matched in full wrapped in a <mark> element
+func (h *highlighter) mark(full string, start, end int) template.HTML {
+	var shown = full[start:end]
+	if h == nil {
+		return template.HTML(template.HTMLEscapeString(shown))
+	}
+
+	var loc = h.re.FindStringIndex(full)
+	if loc == nil || loc[1] <= start || loc[0] >= end || loc[0] == loc[1] {
+		return template.HTML(template.HTMLEscapeString(shown))
+	}
+
+	var lo, hi = loc[0], loc[1]
+	if lo < start {
+		lo = start
+	}
+	if hi > end {
+		hi = end
+	}
+	return template.HTML(template.HTMLEscapeString(full[start:lo]) +
+		"<mark>" + template.HTMLEscapeString(full[lo:hi]) + "</mark>" +
+		template.HTMLEscapeString(full[hi:end]))
+}
+
+// markSuffix marks shown, which is expected to be the end of full.  If it
+// isn't, shown is returned without highlighting.
+func (h *highlighter) markSuffix(full, shown string) template.HTML {
+	if !strings.HasSuffix(full, shown) {
+		return template.HTML(template.HTMLEscapeString(shown))
+	}
+	return h.mark(full, len(full)-len(shown), len(full))
+}
+
+// highlightFileName returns the file's name with the search match marked
+func highlightFileName(h *highlighter, f *db.File) template.HTML {
+	return h.markSuffix(f.PublicPath, f.Name)
+}
+
+// highlightFileFolder returns the file's containing folder, relative to
+// parent, with the search match marked
+func highlightFileFolder(h *highlighter, f *db.File, parent *db.Folder) template.HTML {
+	var dir = f.ContainingFolder()
+	var shown = stripCategoryFolder(parent, dir)
+	if !strings.HasSuffix(dir, shown) {
+		return template.HTML(template.HTMLEscapeString(shown))
+	}
+	return h.mark(f.PublicPath, len(dir)-len(shown), len(dir))
+}
+
+// highlightFolder returns the folder's path, relative to parent, with the
+// search match marked
+func highlightFolder(h *highlighter, f *db.Folder, parent *db.Folder) template.HTML {
+	return h.markSuffix(f.PublicPath, stripCategoryFolder(parent, f.PublicPath))
+}
@@ -2,20 +2,46 @@ package main
 
 import "net/http"
 
+// noCache strips any caching headers a handler set before it hit an error,
+// since error pages must never be revalidated as current
+func noCache(w http.ResponseWriter) {
+	var h = w.Header()
+	h.Del("ETag")
+	h.Del("Last-Modified")
+	h.Set("Cache-Control", "no-store")
+}
+
 func _400(w http.ResponseWriter, r *http.Request, msg string) {
+	noCache(w)
 	w.WriteHeader(http.StatusBadRequest)
 	setAlert(w, r, msg)
 	empty.Render(w, r, vars{"Title": "Invalid Request"})
 }
 
+func _403(w http.ResponseWriter, r *http.Request, msg string) {
+	noCache(w)
+	w.WriteHeader(http.StatusForbidden)
+	setAlert(w, r, msg)
+	empty.Render(w, r, vars{"Title": "Forbidden"})
+}
+
 func _404(w http.ResponseWriter, r *http.Request, msg string) {
+	noCache(w)
 	w.WriteHeader(http.StatusNotFound)
 	setAlert(w, r, msg)
 	empty.Render(w, r, vars{"Title": "Not Found"})
 }
 
 func _500(w http.ResponseWriter, r *http.Request, msg string) {
+	noCache(w)
 	w.WriteHeader(http.StatusInternalServerError)
 	setAlert(w, r, msg)
 	empty.Render(w, r, vars{"Title": "Error"})
 }
+
+func _429(w http.ResponseWriter, r *http.Request, msg string) {
+	noCache(w)
+	w.WriteHeader(http.StatusTooManyRequests)
+	setAlert(w, r, msg)
+	empty.Render(w, r, vars{"Title": "Too Many Requests"})
+}
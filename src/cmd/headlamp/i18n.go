@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// locale is one language the interface can be shown in.  Messages are keyed
+// by their English text, so anything a catalog doesn't translate is simply
+// shown in English.
+type locale struct {
+	Tag      string
+	Name     string
+	messages map[string]string
+}
+
+// english is the built-in locale, which needs no catalog
+var english = &locale{Tag: "en", Name: "English"}
+
+// locales holds every available locale by its lowercased tag, and
+// localeList holds them in the order the language switcher shows them
+var locales = map[string]*locale{"en": english}
+var localeList = []*locale{english}
+
+// languageSession is the session key holding a visitor's chosen language
+const languageSession = "Language"
+
+// catalog is the format of a locales/<tag>.json message catalog
+type catalog struct {
+	Name     string            `json:"name"`
+	Messages map[string]string `json:"messages"`
+}
+
+// loadLocales reads every message catalog in the app's locales directory.
+// A theme's catalogs are read afterward, so they can add languages or
+// replace individual translations.
+func loadLocales() error {
+	var dirs = []string{filepath.Join(conf.Approot, "locales")}
+	if conf.ThemeDir != "" {
+		dirs = append(dirs, filepath.Join(conf.ThemeDir, "locales"))
+	}
+
+	for _, dir := range dirs {
+		var paths, err = filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			err = loadCatalog(p)
+			if err != nil {
+				return fmt.Errorf("unable to read message catalog %q: %s", p, err)
+			}
+		}
+	}
+
+	sort.Slice(localeList, func(i, j int) bool { return localeList[i].Tag < localeList[j].Tag })
+	return nil
+}
+
+// loadCatalog reads a single catalog, named for its language tag (e.g.,
+// "es.json" or "pt-br.json"), into locales
+func loadCatalog(p string) error {
+	var data, err = ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	var c catalog
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return err
+	}
+
+	var tag = strings.ToLower(strings.TrimSuffix(filepath.Base(p), ".json"))
+	var l = locales[tag]
+	if l == nil {
+		l = &locale{Tag: tag, Name: tag}
+		locales[tag] = l
+		localeList = append(localeList, l)
+	}
+	if c.Name != "" {
+		l.Name = c.Name
+	}
+	if l.messages == nil {
+		l.messages = make(map[string]string)
+	}
+	for k, v := range c.Messages {
+		l.messages[k] = v
+	}
+	return nil
+}
+
+// translate returns msg in the given locale, falling back to the English
+// text.  If args are given, the translated message is used as a format
+// string for them.
+func translate(l *locale, msg string, args ...interface{}) string {
+	if l != nil && l.messages[msg] != "" {
+		msg = l.messages[msg]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// requestLocale returns the locale for the current visitor: the language
+// they chose with the switcher, if any, otherwise the best match for their
+// browser's Accept-Language header
+func requestLocale(r *http.Request) *locale {
+	var tag, _ = sessionManager.Load(r).GetString(languageSession)
+	if locales[tag] != nil {
+		return locales[tag]
+	}
+	return acceptedLocale(r.Header.Get("Accept-Language"))
+}
+
+// acceptedLocale parses an Accept-Language header and returns the available
+// locale the browser prefers most.  A tag like "es-MX" matches the "es"
+// locale if there's no "es-mx" locale.
+func acceptedLocale(header string) *locale {
+	var best *locale
+	var bestQ float64
+	for _, part := range strings.Split(header, ",") {
+		var fields = strings.Split(part, ";")
+		var tag = strings.ToLower(strings.TrimSpace(fields[0]))
+		var q = 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				var err error
+				q, err = strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					q = 0
+				}
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+
+		var l = locales[tag]
+		if l == nil {
+			l = locales[strings.SplitN(tag, "-", 2)[0]]
+		}
+		if l != nil {
+			best, bestQ = l, q
+		}
+	}
+
+	if best == nil {
+		return english
+	}
+	return best
+}
+
+// languageHandler stores the visitor's language choice in their session and
+// sends them back where they were
+func languageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var tag = strings.ToLower(r.FormValue("lang"))
+	if locales[tag] == nil {
+		_400(w, r, fmt.Sprintf("Unknown language %q", r.FormValue("lang")))
+		return
+	}
+
+	var err = sessionManager.Load(r).PutString(w, languageSession, tag)
+	if err != nil {
+		logger.Errorf("Unable to store language %q in user's session: %s", tag, err)
+		_500(w, r, "Unable to change your language.  Try again or contact support.")
+		return
+	}
+	http.Redirect(w, r, safeRedirect(r.FormValue("next")), http.StatusSeeOther)
+}
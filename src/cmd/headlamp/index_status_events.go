@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// indexStatusPollInterval is how often a dashboard's event stream rereads
+// the indexer's progress.  It's a single-row read, and only admins watch it.
+const indexStatusPollInterval = time.Second * 2
+
+func adminIndexEventsPath() string {
+	return joinPaths("admin", "index", "events")
+}
+
+// indexRunData returns what the dashboard needs to show the indexer's
+// current run: its progress, how long it has left, and whether the indexer
+// is still alive to finish it
+func indexRunData(op *db.Operation) (vars, error) {
+	var s, err = op.FindIndexRunStatus()
+	if err != nil {
+		return nil, err
+	}
+	var beats map[string]time.Time
+	beats, err = op.LastHeartbeats()
+	if err != nil {
+		return nil, err
+	}
+
+	var data = vars{
+		"RunStatus":    s,
+		"IndexerAlive": time.Since(beats[db.WorkerIndexer]) < heartbeatMaxAge,
+	}
+	if s != nil && !s.Finished() && !s.EstimatedFinish.IsZero() {
+		var left = time.Until(s.EstimatedFinish)
+		if left < time.Minute {
+			left = left.Round(time.Second)
+		} else {
+			left = left.Round(time.Minute)
+		}
+		if left > 0 {
+			data["Remaining"] = left
+		}
+	}
+	return data, nil
+}
+
+// renderIndexRunStatus renders the dashboard's index run section
+func renderIndexRunStatus() (string, error) {
+	var data, err = indexRunData(dbh.Operation())
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = adminDashboard.ExecuteTemplate(&buf, "indexRunStatus", data)
+	return buf.String(), err
+}
+
+// adminIndexEventsHandler streams the dashboard's index run section as
+// server-sent events, sending it again whenever the indexer reports more
+// progress.  The indexer runs on and off indefinitely, so the stream lasts
+// until the browser leaves the page.
+func adminIndexEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var es = newEventStream(w)
+	if es == nil {
+		http.Error(w, "Streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+
+	var poll = time.NewTicker(indexStatusPollInterval)
+	defer poll.Stop()
+	var keepAlive = time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	var last string
+	for {
+		var html, err = renderIndexRunStatus()
+		if err != nil {
+			logger.Errorf("Unable to render index run status: %s", err)
+			return
+		}
+		if html != last {
+			last = html
+			err = es.send("", html)
+			if err != nil {
+				return
+			}
+		}
+
+	wait:
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-shuttingDown:
+				return
+			case <-poll.C:
+				break wait
+			case <-keepAlive.C:
+				err = es.keepAlive()
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/gopkg/webutil"
+	"github.com/uoregon-libraries/headlamp/src/auth"
+)
+
+// authBackend verifies staff logins, or is nil if password logins aren't
+// enabled
+var authBackend auth.PasswordBackend
+
+// ssoBackend reads staff identities from a single sign-on proxy's headers,
+// or is nil if single sign-on isn't enabled
+var ssoBackend auth.RequestBackend
+
+// oidcBackend sends staff to an OpenID Connect provider to log in, or is nil
+// if OpenID Connect isn't enabled
+var oidcBackend *auth.OIDC
+
+// loginEnabled returns true if there's any way to log in
+func loginEnabled() bool {
+	return authBackend != nil || ssoBackend != nil || oidcBackend != nil
+}
+
+// loginHandler shows the login form, and logs the user in when it's posted.
+// With single sign-on, the proxy has already done the login by the time the
+// request gets here, so the user is logged in immediately.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if ssoBackend != nil {
+		ssoLogin(w, r)
+		return
+	}
+	if oidcBackend != nil {
+		oidcLogin(w, r)
+		return
+	}
+	if authBackend == nil {
+		_404(w, r, "Logins aren't enabled")
+		return
+	}
+
+	var next = safeRedirect(r.FormValue("next"))
+	if r.Method != http.MethodPost {
+		login.Render(w, r, vars{"Title": "Headlamp: Log In", "Next": next})
+		return
+	}
+
+	var ident, err = authBackend.Authenticate(r.PostFormValue("login"), r.PostFormValue("password"))
+	if err == auth.ErrInvalidCredentials {
+		logger.Infof("Failed login attempt for %q", r.PostFormValue("login"))
+		setAlert(w, r, "Invalid login or password.  Please try again.")
+		http.Redirect(w, r, loginPath()+"?next="+url.QueryEscape(next), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		logger.Errorf("Unable to authenticate %q: %s", r.PostFormValue("login"), err)
+		setAlert(w, r, "Unable to log you in right now.  Try again or contact support.")
+		http.Redirect(w, r, loginPath()+"?next="+url.QueryEscape(next), http.StatusSeeOther)
+		return
+	}
+
+	err = startUserSession(w, r, ident)
+	if err != nil {
+		logger.Errorf("Unable to start a session for %q: %s", ident.Login, err)
+		_500(w, r, "Unable to log you in right now.  Try again or contact support.")
+		return
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// ssoLogin starts a session for the identity the single sign-on proxy sent
+func ssoLogin(w http.ResponseWriter, r *http.Request) {
+	var ident, err = ssoBackend.Identify(r)
+	if err == auth.ErrNoIdentity {
+		logger.Warnf("Single sign-on login from %s had no trusted identity", r.RemoteAddr)
+		_403(w, r, "Single sign-on didn't identify you.  Contact support if this keeps happening.")
+		return
+	}
+	if err != nil {
+		logger.Errorf("Unable to read single sign-on identity: %s", err)
+		_500(w, r, "Unable to log you in right now.  Try again or contact support.")
+		return
+	}
+
+	err = startUserSession(w, r, ident)
+	if err != nil {
+		logger.Errorf("Unable to start a session for %q: %s", ident.Login, err)
+		_500(w, r, "Unable to log you in right now.  Try again or contact support.")
+		return
+	}
+	http.Redirect(w, r, safeRedirect(r.FormValue("next")), http.StatusSeeOther)
+}
+
+// oidcLogin sends the user to the OpenID Connect provider, remembering what
+// the callback needs to verify their return in the session
+func oidcLogin(w http.ResponseWriter, r *http.Request) {
+	var authURL, state, nonce, err = oidcBackend.Begin(absoluteURL(loginCallbackPath()))
+	if err != nil {
+		logger.Errorf("Unable to start OpenID Connect login: %s", err)
+		_500(w, r, "Unable to log you in right now.  Try again or contact support.")
+		return
+	}
+
+	var s = sessionManager.Load(r)
+	err = s.PutString(w, "OIDCState", state)
+	if err == nil {
+		err = s.PutString(w, "OIDCNonce", nonce)
+	}
+	if err == nil {
+		err = s.PutString(w, "OIDCNext", safeRedirect(r.FormValue("next")))
+	}
+	if err != nil {
+		logger.Errorf("Unable to store OpenID Connect login state: %s", err)
+		_500(w, r, "Unable to log you in right now.  Try again or contact support.")
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallbackHandler finishes an OpenID Connect login when the provider
+// sends the user back
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if oidcBackend == nil {
+		_404(w, r, "OpenID Connect logins aren't enabled")
+		return
+	}
+
+	// The state values are single-use, so they're cleared whether or not this
+	// login works out
+	var s = sessionManager.Load(r)
+	var state, _ = s.PopString(w, "OIDCState")
+	var nonce, _ = s.PopString(w, "OIDCNonce")
+	var next, _ = s.PopString(w, "OIDCNext")
+
+	if state == "" || r.FormValue("state") != state {
+		_400(w, r, "This login link has expired or was already used.  Please try logging in again.")
+		return
+	}
+	if r.FormValue("error") != "" {
+		logger.Infof("OpenID Connect login failed: %s (%s)", r.FormValue("error"), r.FormValue("error_description"))
+		_403(w, r, "Your login was not successful.  Contact support if this keeps happening.")
+		return
+	}
+
+	var ident, err = oidcBackend.Exchange(r.FormValue("code"), absoluteURL(loginCallbackPath()), nonce)
+	if err != nil {
+		logger.Errorf("Unable to complete OpenID Connect login: %s", err)
+		_500(w, r, "Unable to log you in right now.  Try again or contact support.")
+		return
+	}
+
+	err = startUserSession(w, r, ident)
+	if err != nil {
+		logger.Errorf("Unable to start a session for %q: %s", ident.Login, err)
+		_500(w, r, "Unable to log you in right now.  Try again or contact support.")
+		return
+	}
+	http.Redirect(w, r, safeRedirect(next), http.StatusSeeOther)
+}
+
+// startUserSession attaches the identity's user to the session, creating the
+// user on their first login and keeping their name, email, and roles in sync
+// with the backend after that.  The session token is replaced so a token seen
+// before login is useless afterward.
+func startUserSession(w http.ResponseWriter, r *http.Request, ident *auth.Identity) error {
+	var op = dbh.Operation()
+	var u, err = op.FindUserByLogin(ident.Login)
+	if err == nil && u == nil {
+		u, err = op.CreateUser(ident.Login, ident.Name, ident.Email)
+	}
+	if err != nil {
+		return err
+	}
+
+	if ident.Name != "" {
+		u.Name = ident.Name
+	}
+	if ident.Email != "" {
+		u.Email = ident.Email
+	}
+	u.Roles = strings.Join(ident.Roles, ",")
+	err = op.RecordLogin(u)
+	if err != nil {
+		return err
+	}
+
+	err = mergeSessionCart(r, u)
+	if err != nil {
+		logger.Errorf("Unable to move %s's bulk file queue into their account: %s", u.Login, err)
+	}
+
+	var s = sessionManager.Load(r)
+	err = s.RenewToken(w)
+	if err != nil {
+		return err
+	}
+	err = s.PutInt(w, "UserID", u.ID)
+	if err != nil {
+		return err
+	}
+
+	// A new CSRF token goes with the new session token, so a token somebody
+	// saw before logging in is no good afterward
+	err = s.Remove(w, csrfSession)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("%s (%s) logged in", u.Login, u.Email)
+	return nil
+}
+
+// logoutHandler ends the session entirely, which also empties the user's bulk
+// download queue
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var u = currentUser(r)
+	var err = sessionManager.Load(r).Destroy(w)
+	if err != nil {
+		logger.Errorf("Unable to destroy session: %s", err)
+	}
+	if u != nil {
+		logger.Infof("%s logged out", u.Login)
+	}
+	http.Redirect(w, r, webutil.Webroot, http.StatusSeeOther)
+}
+
+// safeRedirect returns p if it's a path within the app, or the app's root
+// otherwise, so a crafted login link can't send people off-site
+func safeRedirect(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.Contains(p, `\`) {
+		return webutil.Webroot
+	}
+	if !strings.HasPrefix(p, basePath) {
+		return webutil.Webroot
+	}
+	return p
+}
@@ -4,89 +4,192 @@ import (
 	"context"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/alexedwards/scs"
-	"github.com/alexedwards/scs/stores/memstore"
 	"github.com/uoregon-libraries/gopkg/interrupts"
 	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/auth"
 	"github.com/uoregon-libraries/headlamp/src/config"
 	"github.com/uoregon-libraries/headlamp/src/db"
+	"github.com/uoregon-libraries/headlamp/src/thumbnail"
 )
 
 // dbh is our global database handle for DA searches
 var dbh = db.New()
 var basePath string
+
+// apiPrefix is where API routes start, including the base path
+var apiPrefix string
 var conf *config.Config
 var sessionManager *scs.Manager
 
+// shutdownTimeout is how long the server waits for in-flight requests when
+// it's told to shut down
+const shutdownTimeout = time.Minute
+
 func main() {
 	conf = getCLI()
 
+	var err error
+	authBackend, err = auth.NewPasswordBackend(conf)
+	if err != nil {
+		logger.Fatalf("Unable to set up logins: %s", err)
+	}
+	ssoBackend, err = auth.NewRequestBackend(conf)
+	if err != nil {
+		logger.Fatalf("Unable to set up single sign-on: %s", err)
+	}
+	oidcBackend, err = auth.NewOIDCBackend(conf)
+	if err != nil {
+		logger.Fatalf("Unable to set up OpenID Connect: %s", err)
+	}
+	if conf.ThumbnailCacheDir != "" {
+		thumbnails = thumbnail.New(conf.ThumbnailCacheDir, conf.ThumbnailSize)
+	}
+
+	err = openAccessLog()
+	if err != nil {
+		logger.Fatalf("Unable to open access log: %s", err)
+	}
+
 	var s = startServer()
+	var done = make(chan struct{})
 	interrupts.TrapIntTerm(func() {
-		var ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(time.Minute))
+		logger.Infof("Shutting down; waiting up to %s for open requests to finish", shutdownTimeout)
+		var ctx, cancel = context.WithTimeout(context.Background(), shutdownTimeout)
 		defer cancel()
-		s.Shutdown(ctx)
-		os.Exit(0)
+		var err = s.Shutdown(ctx)
+		if err != nil {
+			logger.Warnf("Not all requests finished before shutting down: %s", err)
+		}
+		if accessLog != nil {
+			err = accessLog.Close()
+			if err != nil {
+				logger.Errorf("Unable to close access log: %s", err)
+			}
+		}
+		err = dbh.Close()
+		if err != nil {
+			logger.Errorf("Unable to close database: %s", err)
+		}
+		close(done)
 	})
-	for {
-		time.Sleep(time.Second)
-	}
+	<-done
 }
 
 func startServer() *http.Server {
+	var server = &http.Server{Addr: conf.BindAddress, Handler: newHandler()}
+	go pruneSessions()
+
+	server.RegisterOnShutdown(func() { close(shuttingDown) })
+
+	go func() {
+		var err = listen(server)
+		if err == http.ErrServerClosed {
+			logger.Infof("Server terminated")
+			return
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("Unable to start HTTP server: %s", err)
+		}
+	}()
+
+	return server
+}
+
+// newHandler registers every route and wraps them in the session, CSRF,
+// logging, and compression middleware, returning the handler the server
+// uses for all requests
+func newHandler() http.Handler {
 	var mux = http.NewServeMux()
 	var u, _ = url.Parse(conf.WebPath)
 
 	basePath = strings.TrimRight(u.Path, "/")
 	logger.Debugf("Serving root from %q", basePath)
 	mux.HandleFunc(basePath+"/", homeHandler)
-	mux.HandleFunc(basePath+"/browse/", browseHandler)
-	mux.HandleFunc(basePath+"/search/", searchHandler)
-	mux.HandleFunc(basePath+"/view/", viewFileHandler)
-	mux.HandleFunc(basePath+"/download/", downloadFileHandler)
+	mux.HandleFunc(basePath+"/browse/", tidyQuery(browseHandler))
+	mux.HandleFunc(basePath+"/search/", tidyQuery(rateLimit(searchLimiter, searchHandler)))
+	mux.HandleFunc(basePath+"/advanced-search/", tidyQuery(rateLimit(searchLimiter, advancedSearchHandler)))
+	mux.HandleFunc(basePath+"/view/", rateLimit(downloadLimiter, viewFileHandler))
+	mux.HandleFunc(basePath+"/download/", rateLimit(downloadLimiter, downloadFileHandler))
+	mux.HandleFunc(basePath+"/download/file/", rateLimit(downloadLimiter, requireUser(downloadFileHandler)))
+	mux.HandleFunc(basePath+"/file/", filePermalinkHandler)
+	mux.HandleFunc(basePath+"/folder/", folderPermalinkHandler)
+	mux.HandleFunc(basePath+"/thumbnail/", thumbnailHandler)
+	mux.HandleFunc(basePath+"/preview/", requireStaff(previewHandler))
 	mux.HandleFunc(basePath+"/bulk/", bulkQueueHandler)
-	mux.HandleFunc(basePath+"/bulk/create", bulkCreateArchiveHandler)
+	mux.HandleFunc(basePath+"/bulk/create", requireStaff(rateLimit(archiveLimiter, bulkCreateArchiveHandler)))
+	mux.HandleFunc(basePath+"/bulk/cancel/", requireStaff(bulkCancelArchiveHandler))
+	mux.HandleFunc(basePath+"/bulk/zip", requireStaff(rateLimit(downloadLimiter, bulkZipHandler)))
 	mux.HandleFunc(basePath+"/bulk-download/", bulkDownloadHandler)
+	mux.HandleFunc(basePath+"/archive-status/", archiveStatusHandler)
 	mux.HandleFunc(basePath+"/filesystem/", viewRealFoldersHandler)
+	mux.HandleFunc(basePath+"/file-info/", requireStaff(fileInfoHandler))
+	mux.HandleFunc(basePath+"/save-search/", requireUser(saveSearchHandler))
+	mux.HandleFunc(basePath+"/saved-searches/", requireUser(savedSearchesHandler))
+	mux.HandleFunc(basePath+"/favorites/", requireUser(favoritesHandler))
+	mux.HandleFunc(basePath+"/whats-new/", whatsNewHandler)
+	mux.HandleFunc(basePath+"/export/", requireCurator(exportCategoryHandler))
+	mux.HandleFunc(basePath+"/export-results", requireUser(rateLimit(searchLimiter, exportResultsHandler)))
+	mux.HandleFunc(basePath+"/admin/", requireAdmin(adminDashboardHandler))
+	mux.HandleFunc(basePath+"/admin/jobs/", requireAdmin(adminJobsHandler))
+	mux.HandleFunc(basePath+"/admin/jobs/requeue/", requireAdmin(adminRequeueJobHandler))
+	mux.HandleFunc(basePath+"/admin/index/", requireAdmin(adminIndexHandler))
+	mux.HandleFunc(basePath+"/admin/index/queue", requireAdmin(adminQueueIndexHandler))
+	mux.HandleFunc(basePath+"/admin/index/events", requireAdmin(adminIndexEventsHandler))
+	mux.HandleFunc(basePath+"/admin/missing/", requireAdmin(adminMissingHandler))
+	mux.HandleFunc(basePath+"/admin/restrict/", requireAdmin(adminRestrictHandler))
+	mux.HandleFunc(basePath+"/admin/categories/", requireAdmin(adminCategoriesHandler))
+	mux.HandleFunc(basePath+"/admin/categories/rename/", requireCurator(adminRenameCategoryHandler))
+	mux.HandleFunc(basePath+"/admin/categories/merge/", requireAdmin(adminMergeCategoryHandler))
+	mux.HandleFunc(basePath+"/admin/categories/access/", requireAdmin(adminCategoryAccessHandler))
+	mux.HandleFunc(basePath+"/admin/folders/reindex/", requireAdmin(adminReindexFolderHandler))
+	mux.HandleFunc(basePath+"/admin/folders/describe/", requireCurator(adminDescribeFolderHandler))
+	mux.HandleFunc(basePath+"/login", loginHandler)
+	mux.HandleFunc(basePath+"/login/callback", oidcCallbackHandler)
+	mux.HandleFunc(basePath+"/logout", logoutHandler)
+	mux.HandleFunc(basePath+"/language", languageHandler)
+	mux.HandleFunc(basePath+"/oai", oaiHandler)
+	mux.HandleFunc(basePath+"/healthz", healthHandler)
+	mux.HandleFunc("/robots.txt", robotsHandler)
+	mux.HandleFunc(basePath+"/sitemap.xml", sitemapHandler)
+	mux.HandleFunc(basePath+"/metrics", requireScope(db.ScopeAdmin, metricsHandler))
+	apiPrefix = basePath + "/api/"
+	mux.HandleFunc(apiPrefix, apiNotFoundHandler)
+	mux.HandleFunc(basePath+"/api/"+apiVersion+"/categories", requireScope(db.ScopeRead, apiCategoriesHandler))
+	mux.HandleFunc(basePath+"/api/"+apiVersion+"/browse/", requireScope(db.ScopeRead, apiBrowseHandler))
+	mux.HandleFunc(basePath+"/api/"+apiVersion+"/search/", requireScope(db.ScopeRead, rateLimit(searchLimiter, apiSearchHandler)))
+	mux.HandleFunc(basePath+"/api/"+apiVersion+"/files/", requireScope(db.ScopeRead, apiFileHandler))
+	mux.HandleFunc(basePath+"/api/"+apiVersion+"/archive-jobs/", requireScope(db.ScopeRequestArchive, rateLimit(archiveLimiter, apiArchiveJobsHandler)))
+	mux.HandleFunc(basePath+"/admin/api-tokens/", requireAdmin(adminAPITokensHandler))
+	mux.HandleFunc(basePath+"/admin/api-tokens/create", requireAdmin(adminCreateAPITokenHandler))
+	mux.HandleFunc(basePath+"/admin/api-tokens/revoke/", requireAdmin(adminRevokeAPITokenHandler))
+	mux.HandleFunc(basePath+"/admin/users/", requireAdmin(adminUsersHandler))
+	mux.HandleFunc(basePath+"/admin/users/role/", requireAdmin(adminSetUserRoleHandler))
 
-	var staticPath = filepath.Join(conf.Approot, "static")
-	var fileServer = http.FileServer(http.Dir(staticPath))
+	var static = staticFS()
+	var fileServer = http.FileServer(static)
 	var staticPrefix = basePath + "/static/"
-	mux.Handle(staticPrefix, http.StripPrefix(staticPrefix, fileServer))
+	mux.Handle(staticPrefix, cacheStatic(static, staticPrefix, http.StripPrefix(staticPrefix, fileServer)))
 
 	var archiveServer = http.FileServer(http.Dir(conf.ArchiveOutputLocation))
 	var archiveServerPrefix = basePath + "/archives/"
-	mux.Handle(archiveServerPrefix, http.StripPrefix(archiveServerPrefix, archiveServer))
+	mux.Handle(archiveServerPrefix, rateLimit(downloadLimiter, http.StripPrefix(archiveServerPrefix, archiveServer).ServeHTTP))
 
+	var metricsPrefix = basePath
 	if basePath == "" {
 		basePath = "/"
 	}
+	var err = loadLocales()
+	if err != nil {
+		logger.Fatalf("Unable to load translations: %s", err)
+	}
 	initTemplates(basePath)
 
-	// Set up the in-memory session store
-	var store = memstore.New(time.Hour * 24)
-	sessionManager = scs.NewManager(store)
-	sessionManager.Lifetime(time.Hour * 24)
-	sessionManager.HttpOnly(false)
-
-	var server = &http.Server{Addr: conf.BindAddress, Handler: sessionManager.Use(mux)}
-
-	go func() {
-		logger.Infof("Listening for HTTP connections")
-		var err = server.ListenAndServe()
-		if err == http.ErrServerClosed {
-			logger.Infof("Server terminated")
-			return
-		}
-		if err != nil && err != http.ErrServerClosed {
-			logger.Fatalf("Unable to start HTTP server: %s", err)
-		}
-	}()
+	sessionManager = newSessionManager()
 
-	return server
+	var handler = compressResponses(sessionManager.Use(logAccess(renewSessions(checkCSRF(mux)))))
+	return forwardedRequests(instrumentRequests(mux, metricsPrefix, handler))
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+	"github.com/uoregon-libraries/headlamp/src/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounter("headlamp_http_requests_total",
+		"HTTP requests served, by handler, method, and status code", "handler", "method", "code")
+	httpDuration = metrics.NewHistogram("headlamp_http_request_duration_seconds",
+		"Time spent serving HTTP requests", metrics.DefaultBuckets, "handler", "method")
+)
+
+func init() {
+	metrics.NewGaugeFunc("headlamp_archive_jobs", "Archive jobs, by status", func() ([]metrics.Sample, error) {
+		var counts, err = dbh.Operation().ArchiveJobCounts()
+		// Every status is reported, even with no jobs, so graphs don't have gaps
+		var samples []metrics.Sample
+		for _, status := range archiveJobStatuses {
+			samples = append(samples, metrics.Sample{Labels: []string{status}, Value: float64(counts[status])})
+		}
+		return samples, err
+	}, "status")
+
+	metrics.NewCounterFunc("headlamp_archives_total", "Archives built by the archiver", func() ([]metrics.Sample, error) {
+		var n, err = dbh.Operation().Counter(db.CounterArchives)
+		return []metrics.Sample{{Value: float64(n)}}, err
+	})
+
+	metrics.NewCounterFunc("headlamp_archived_bytes_total", "Bytes written to archives by the archiver", func() ([]metrics.Sample, error) {
+		var n, err = dbh.Operation().Counter(db.CounterArchivedBytes)
+		return []metrics.Sample{{Value: float64(n)}}, err
+	})
+
+	metrics.NewCounterFunc("headlamp_index_runs_total", "Index runs recorded by the indexer", func() ([]metrics.Sample, error) {
+		var n, _, err = dbh.Operation().IndexRunTotals()
+		return []metrics.Sample{{Value: float64(n)}}, err
+	})
+
+	metrics.NewCounterFunc("headlamp_index_run_seconds_total", "Time spent on all recorded index runs", func() ([]metrics.Sample, error) {
+		var _, secs, err = dbh.Operation().IndexRunTotals()
+		return []metrics.Sample{{Value: secs}}, err
+	})
+
+	metrics.NewGaugeFunc("headlamp_last_index_run_duration_seconds", "How long the most recent index run took", func() ([]metrics.Sample, error) {
+		var runs, err = dbh.Operation().RecentIndexRuns(1)
+		if len(runs) == 0 {
+			return nil, err
+		}
+		return []metrics.Sample{{Value: runs[0].FinishedAt.Sub(runs[0].StartedAt).Seconds()}}, err
+	})
+
+	metrics.NewGaugeFunc("headlamp_last_index_run_timestamp_seconds", "When the most recent index run finished, as a Unix time", func() ([]metrics.Sample, error) {
+		var runs, err = dbh.Operation().RecentIndexRuns(1)
+		if len(runs) == 0 {
+			return nil, err
+		}
+		return []metrics.Sample{{Value: float64(runs[0].FinishedAt.Unix())}}, err
+	})
+}
+
+// metricsHandler writes every metric in the Prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	var err = metrics.WriteAll(&buf)
+	if err != nil {
+		logger.Errorf("Unable to gather metrics: %s", err)
+		http.Error(w, "Unable to gather metrics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+// statusRecorder remembers the status code a handler sent and how much it
+// wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(data []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	var n, err = sr.ResponseWriter.Write(data)
+	sr.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streamed responses, like zip downloads, keep working
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrumentRequests counts and times every request h serves.  Requests are
+// labeled by the mux pattern they matched, without the base path, so the
+// number of series can't grow with the number of URLs people try.
+func instrumentRequests(mux *http.ServeMux, prefix string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start = time.Now()
+		var sr = &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(sr, r)
+
+		var _, pattern = mux.Handler(r)
+		var handler = strings.TrimPrefix(pattern, prefix)
+		if handler == "" {
+			handler = "none"
+		}
+		var method = metricsMethod(r.Method)
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		httpRequests.Inc(handler, method, strconv.Itoa(sr.status))
+		httpDuration.ObserveDuration(start, handler, method)
+	})
+}
+
+// metricsMethod returns the request method, or "other" for anything a
+// browser or API client wouldn't normally send
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete:
+		return method
+	}
+	return "other"
+}
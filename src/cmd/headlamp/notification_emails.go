@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// maxNotificationEmails is how many addresses one archive request may notify
+const maxNotificationEmails = 10
+
+// emailListSeparators are replaced with commas before an address list is
+// parsed, so people can paste a list separated however their mail client
+// likes
+var emailListSeparators = strings.NewReplacer(";", ",", "\r\n", ",", "\n", ",")
+
+// parseNotificationEmails validates a list of archive notification addresses
+// and returns them with duplicates removed.  The error, if any, is meant to
+// be shown to the requester as-is.
+func parseNotificationEmails(list string) ([]*mail.Address, error) {
+	list = strings.Trim(strings.TrimSpace(emailListSeparators.Replace(list)), ",")
+	if list == "" {
+		return nil, fmt.Errorf("You must enter at least one notification email address")
+	}
+
+	var addrs, err = mail.ParseAddressList(list)
+	if err != nil {
+		return nil, addressListError(list)
+	}
+
+	var seen = make(map[string]bool)
+	var unique []*mail.Address
+	for _, a := range addrs {
+		err = validateNotificationAddress(a)
+		if err != nil {
+			return nil, err
+		}
+		var key = strings.ToLower(a.Address)
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, a)
+		}
+	}
+
+	if len(unique) > maxNotificationEmails {
+		return nil, fmt.Errorf("You can list at most %d notification email addresses", maxNotificationEmails)
+	}
+	return unique, nil
+}
+
+// addressListError finds the entry in an unparseable address list which
+// broke it, so the requester knows what to fix
+func addressListError(list string) error {
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var _, err = mail.ParseAddress(part)
+		if err != nil {
+			return fmt.Errorf("%q isn't a valid email address", part)
+		}
+	}
+
+	// Every piece parses on its own, so the problem is likely a name with an
+	// unquoted comma, e.g., Doe, Jane <jdoe@example.org>
+	return fmt.Errorf("The notification addresses couldn't be read; separate them with commas, " +
+		"and put quotes around any names which contain commas")
+}
+
+// validateNotificationAddress rejects addresses which parse but can't
+// receive mail: those without a real domain, and those at a disposable mail
+// service, which are often gone by the time an archive is built
+func validateNotificationAddress(a *mail.Address) error {
+	var at = strings.LastIndex(a.Address, "@")
+	var domain = strings.ToLower(a.Address[at+1:])
+	var labels = strings.Split(domain, ".")
+	if at < 1 || len(labels) < 2 {
+		return fmt.Errorf("%q doesn't have a valid domain", a.Address)
+	}
+	for _, l := range labels {
+		if l == "" || strings.HasPrefix(l, "-") || strings.HasSuffix(l, "-") {
+			return fmt.Errorf("%q doesn't have a valid domain", a.Address)
+		}
+	}
+
+	for _, d := range strings.Split(conf.DisposableEmailDomains, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d != "" && (domain == d || strings.HasSuffix(domain, "."+d)) {
+			return fmt.Errorf("%q is a disposable email address; please use an address you'll still have "+
+				"when the archive is ready", a.Address)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// oaiPageSize is how many records or identifiers an OAI-PMH list response
+// holds before it hands out a resumption token
+const oaiPageSize = 200
+
+// oaiDatestamp is the format for OAI-PMH datestamps at our granularity
+const oaiDatestamp = "2006-01-02T15:04:05Z"
+
+// oaiDay is the format for day-granularity datestamps, which harvesters may
+// send in "from" and "until"
+const oaiDay = "2006-01-02"
+
+// oaiPrefixDC is the only metadata format we offer: simple Dublin Core
+const oaiPrefixDC = "oai_dc"
+
+// oaiSetPrefix begins the setSpec of each category's set, followed by the
+// category's id
+const oaiSetPrefix = "category-"
+
+// oaiVerbArgs lists the arguments each verb allows.  Required arguments are
+// prefixed with "!".  resumptionToken is always exclusive.
+var oaiVerbArgs = map[string][]string{
+	"Identify":            {},
+	"ListMetadataFormats": {"identifier"},
+	"ListSets":            {"resumptionToken"},
+	"GetRecord":           {"!identifier", "!metadataPrefix"},
+	"ListIdentifiers":     {"!metadataPrefix", "from", "until", "set", "resumptionToken"},
+	"ListRecords":         {"!metadataPrefix", "from", "until", "set", "resumptionToken"},
+}
+
+// oaiResponse is the OAI-PMH document; only one of the verb elements or the
+// errors is set
+type oaiResponse struct {
+	XMLName        xml.Name    `xml:"OAI-PMH"`
+	Xmlns          string      `xml:"xmlns,attr"`
+	XSI            string      `xml:"xmlns:xsi,attr"`
+	SchemaLocation string      `xml:"xsi:schemaLocation,attr"`
+	ResponseDate   string      `xml:"responseDate"`
+	Request        oaiRequest  `xml:"request"`
+	Errors         []*oaiError `xml:"error"`
+
+	Identify            *oaiIdentify   `xml:"Identify,omitempty"`
+	ListMetadataFormats *oaiFormatList `xml:"ListMetadataFormats,omitempty"`
+	ListSets            *oaiSetList    `xml:"ListSets,omitempty"`
+	GetRecord           *oaiRecordList `xml:"GetRecord,omitempty"`
+	ListIdentifiers     *oaiHeaderList `xml:"ListIdentifiers,omitempty"`
+	ListRecords         *oaiRecordList `xml:"ListRecords,omitempty"`
+}
+
+// oaiRequest echoes the request's arguments back to the harvester
+type oaiRequest struct {
+	Verb            string `xml:"verb,attr,omitempty"`
+	Identifier      string `xml:"identifier,attr,omitempty"`
+	MetadataPrefix  string `xml:"metadataPrefix,attr,omitempty"`
+	From            string `xml:"from,attr,omitempty"`
+	Until           string `xml:"until,attr,omitempty"`
+	Set             string `xml:"set,attr,omitempty"`
+	ResumptionToken string `xml:"resumptionToken,attr,omitempty"`
+	URL             string `xml:",chardata"`
+}
+
+type oaiError struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+func (e *oaiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func oaiErrorf(code, format string, args ...interface{}) *oaiError {
+	return &oaiError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type oaiIdentify struct {
+	RepositoryName    string   `xml:"repositoryName"`
+	BaseURL           string   `xml:"baseURL"`
+	ProtocolVersion   string   `xml:"protocolVersion"`
+	AdminEmail        []string `xml:"adminEmail"`
+	EarliestDatestamp string   `xml:"earliestDatestamp"`
+	DeletedRecord     string   `xml:"deletedRecord"`
+	Granularity       string   `xml:"granularity"`
+}
+
+type oaiFormat struct {
+	Prefix    string `xml:"metadataPrefix"`
+	Schema    string `xml:"schema"`
+	Namespace string `xml:"metadataNamespace"`
+}
+
+type oaiFormatList struct {
+	Formats []oaiFormat `xml:"metadataFormat"`
+}
+
+type oaiSet struct {
+	Spec string `xml:"setSpec"`
+	Name string `xml:"setName"`
+}
+
+type oaiSetList struct {
+	Sets []oaiSet `xml:"set"`
+}
+
+type oaiHeader struct {
+	Status     string   `xml:"status,attr,omitempty"`
+	Identifier string   `xml:"identifier"`
+	Datestamp  string   `xml:"datestamp"`
+	SetSpecs   []string `xml:"setSpec"`
+}
+
+type oaiRecord struct {
+	Header   oaiHeader    `xml:"header"`
+	Metadata *oaiMetadata `xml:"metadata,omitempty"`
+}
+
+type oaiMetadata struct {
+	DC oaiDC `xml:"oai_dc:dc"`
+}
+
+// oaiDC is a simple Dublin Core record
+type oaiDC struct {
+	XmlnsOAIDC     string   `xml:"xmlns:oai_dc,attr"`
+	XmlnsDC        string   `xml:"xmlns:dc,attr"`
+	XSI            string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	Title          string   `xml:"dc:title"`
+	Identifiers    []string `xml:"dc:identifier"`
+	Date           string   `xml:"dc:date,omitempty"`
+	Format         string   `xml:"dc:format,omitempty"`
+	Type           string   `xml:"dc:type,omitempty"`
+	Relation       string   `xml:"dc:relation,omitempty"`
+}
+
+// oaiResumption is a list's resumption token, with the list's size and how
+// far into it this response starts
+type oaiResumption struct {
+	Token            string `xml:",chardata"`
+	CompleteListSize uint64 `xml:"completeListSize,attr"`
+	Cursor           uint64 `xml:"cursor,attr"`
+}
+
+type oaiHeaderList struct {
+	Headers    []oaiHeader    `xml:"header"`
+	Resumption *oaiResumption `xml:"resumptionToken,omitempty"`
+}
+
+type oaiRecordList struct {
+	Records    []oaiRecord    `xml:"record"`
+	Resumption *oaiResumption `xml:"resumptionToken,omitempty"`
+}
+
+// dcmiTypes maps format families to the DCMI type vocabulary
+var dcmiTypes = map[string]string{
+	db.FamilyImage:    "Image",
+	db.FamilyAudio:    "Sound",
+	db.FamilyVideo:    "MovingImage",
+	db.FamilyDocument: "Text",
+}
+
+// oaiHandler answers OAI-PMH requests, which may come in as GET or POST
+func oaiHandler(w http.ResponseWriter, r *http.Request) {
+	var resp = &oaiResponse{
+		Xmlns:          "http://www.openarchives.org/OAI/2.0/",
+		XSI:            "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.openarchives.org/OAI/2.0/ http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd",
+		ResponseDate:   time.Now().UTC().Format(oaiDatestamp),
+		Request:        oaiRequest{URL: oaiBaseURL()},
+	}
+
+	var args url.Values
+	var err error
+	if r.ParseForm() != nil {
+		err = oaiErrorf("badArgument", "Unable to read the request's arguments")
+	} else {
+		args, err = oaiArgs(r.Form)
+	}
+	if err == nil {
+		resp.Request = oaiRequest{
+			Verb:            args.Get("verb"),
+			Identifier:      args.Get("identifier"),
+			MetadataPrefix:  args.Get("metadataPrefix"),
+			From:            args.Get("from"),
+			Until:           args.Get("until"),
+			Set:             args.Get("set"),
+			ResumptionToken: args.Get("resumptionToken"),
+			URL:             oaiBaseURL(),
+		}
+		err = oaiDispatch(resp, args)
+	}
+
+	if err != nil {
+		var oerr, ok = err.(*oaiError)
+		if !ok {
+			logger.Errorf("Unable to answer OAI-PMH request %q: %s", r.URL.RawQuery, err)
+			http.Error(w, "Unable to process the request", http.StatusInternalServerError)
+			return
+		}
+		// The spec says not to echo a request we couldn't make sense of
+		if oerr.Code == "badVerb" || oerr.Code == "badArgument" {
+			resp.Request = oaiRequest{URL: oaiBaseURL()}
+		}
+		resp.Errors = append(resp.Errors, oerr)
+	}
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	var enc = xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(resp)
+	if err != nil {
+		logger.Errorf("Unable to write OAI-PMH response: %s", err)
+	}
+}
+
+// oaiArgs verifies the verb and its arguments, returning the arguments as a
+// set of single values
+func oaiArgs(form url.Values) (url.Values, error) {
+	var verb = form.Get("verb")
+	var allowed, ok = oaiVerbArgs[verb]
+	if !ok || len(form["verb"]) != 1 {
+		return nil, oaiErrorf("badVerb", "Missing, repeated, or unknown verb %q", verb)
+	}
+
+	var known = map[string]bool{"verb": true}
+	for _, arg := range allowed {
+		known[strings.TrimPrefix(arg, "!")] = true
+	}
+	for key, vals := range form {
+		if !known[key] {
+			return nil, oaiErrorf("badArgument", "Illegal argument %q for verb %s", key, verb)
+		}
+		if len(vals) != 1 {
+			return nil, oaiErrorf("badArgument", "Repeated argument %q", key)
+		}
+	}
+
+	if form.Get("resumptionToken") != "" {
+		if len(form) != 2 {
+			return nil, oaiErrorf("badArgument", "resumptionToken must be the only argument besides verb")
+		}
+		return form, nil
+	}
+	for _, arg := range allowed {
+		if strings.HasPrefix(arg, "!") && form.Get(arg[1:]) == "" {
+			return nil, oaiErrorf("badArgument", "Missing required argument %q", arg[1:])
+		}
+	}
+	return form, nil
+}
+
+func oaiDispatch(resp *oaiResponse, args url.Values) error {
+	var op = dbh.Operation()
+	op.HideRestricted()
+
+	switch args.Get("verb") {
+	case "Identify":
+		return oaiIdentifyVerb(op, resp)
+	case "ListMetadataFormats":
+		return oaiListMetadataFormats(op, resp, args)
+	case "ListSets":
+		return oaiListSets(op, resp, args)
+	case "GetRecord":
+		return oaiGetRecord(op, resp, args)
+	case "ListIdentifiers", "ListRecords":
+		return oaiList(op, resp, args)
+	}
+	return oaiErrorf("badVerb", "Unknown verb")
+}
+
+func oaiIdentifyVerb(op *db.Operation, resp *oaiResponse) error {
+	var earliest, err = op.EarliestIndexedAt()
+	if err != nil {
+		return err
+	}
+	if earliest.IsZero() {
+		earliest = time.Now()
+	}
+
+	var emails []string
+	for _, addr := range strings.Split(conf.AdminEmails, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr != "" {
+			emails = append(emails, addr)
+		}
+	}
+	resp.Identify = &oaiIdentify{
+		RepositoryName:    conf.SiteTitle,
+		BaseURL:           oaiBaseURL(),
+		ProtocolVersion:   "2.0",
+		AdminEmail:        emails,
+		EarliestDatestamp: earliest.UTC().Format(oaiDatestamp),
+		DeletedRecord:     "transient",
+		Granularity:       "YYYY-MM-DDThh:mm:ssZ",
+	}
+	return nil
+}
+
+func oaiListMetadataFormats(op *db.Operation, resp *oaiResponse, args url.Values) error {
+	if args.Get("identifier") != "" {
+		var _, err = oaiFindFile(op, args.Get("identifier"))
+		if err != nil {
+			return err
+		}
+	}
+
+	resp.ListMetadataFormats = &oaiFormatList{Formats: []oaiFormat{{
+		Prefix:    oaiPrefixDC,
+		Schema:    "http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+		Namespace: "http://www.openarchives.org/OAI/2.0/oai_dc/",
+	}}}
+	return nil
+}
+
+// oaiListSets lists one set per category.  There are few enough categories
+// that this list is never split up.
+func oaiListSets(op *db.Operation, resp *oaiResponse, args url.Values) error {
+	if args.Get("resumptionToken") != "" {
+		return oaiErrorf("badResumptionToken", "The set list is never split, so there are no resumption tokens")
+	}
+
+	var categories, err = op.AllCategories()
+	if err != nil {
+		return err
+	}
+	resp.ListSets = &oaiSetList{}
+	for _, c := range categories {
+		resp.ListSets.Sets = append(resp.ListSets.Sets, oaiSet{Spec: oaiSetSpec(c), Name: c.Name})
+	}
+	return nil
+}
+
+func oaiGetRecord(op *db.Operation, resp *oaiResponse, args url.Values) error {
+	if args.Get("metadataPrefix") != oaiPrefixDC {
+		return oaiErrorf("cannotDisseminateFormat", "Only %s metadata is available", oaiPrefixDC)
+	}
+
+	var f, err = oaiFindFile(op, args.Get("identifier"))
+	if err != nil {
+		return err
+	}
+	resp.GetRecord = &oaiRecordList{Records: []oaiRecord{oaiFileRecord(f)}}
+	return nil
+}
+
+// oaiList handles both ListIdentifiers and ListRecords, which only differ in
+// whether metadata is included
+func oaiList(op *db.Operation, resp *oaiResponse, args url.Values) error {
+	var resumed = args.Get("resumptionToken") != ""
+	var offset uint64
+	if resumed {
+		var err error
+		args, offset, err = oaiParseResumption(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.Get("metadataPrefix") != oaiPrefixDC {
+		return oaiErrorf("cannotDisseminateFormat", "Only %s metadata is available", oaiPrefixDC)
+	}
+
+	var hf, err = oaiHarvestFilter(op, args)
+	if err != nil {
+		return err
+	}
+
+	var files []*db.File
+	var total uint64
+	files, total, err = op.HarvestFiles(hf, db.Page{Offset: offset, Limit: oaiPageSize})
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		if resumed {
+			return oaiErrorf("badResumptionToken", "The resumption token is past the end of the list")
+		}
+		return oaiErrorf("noRecordsMatch", "No records match the request")
+	}
+
+	// The last page of a resumed list gets an empty token so the harvester
+	// knows it's done; a list which fits on one page gets no token at all
+	var resumption *oaiResumption
+	var next = offset + uint64(len(files))
+	if next < total {
+		resumption = &oaiResumption{Token: oaiResumptionToken(args, next), CompleteListSize: total, Cursor: offset}
+	} else if resumed {
+		resumption = &oaiResumption{CompleteListSize: total, Cursor: offset}
+	}
+
+	if args.Get("verb") == "ListIdentifiers" {
+		resp.ListIdentifiers = &oaiHeaderList{Resumption: resumption}
+		for _, f := range files {
+			resp.ListIdentifiers.Headers = append(resp.ListIdentifiers.Headers, oaiFileHeader(f))
+		}
+		return nil
+	}
+
+	resp.ListRecords = &oaiRecordList{Resumption: resumption}
+	for _, f := range files {
+		resp.ListRecords.Records = append(resp.ListRecords.Records, oaiFileRecord(f))
+	}
+	return nil
+}
+
+// oaiHarvestFilter converts the set, from, and until arguments
+func oaiHarvestFilter(op *db.Operation, args url.Values) (db.HarvestFilter, error) {
+	var hf db.HarvestFilter
+	var from, until = args.Get("from"), args.Get("until")
+	if from != "" && until != "" && len(from) != len(until) {
+		return hf, oaiErrorf("badArgument", "from and until must have the same granularity")
+	}
+
+	var err error
+	if from != "" {
+		hf.From, err = oaiParseDate(from, false)
+		if err != nil {
+			return hf, err
+		}
+	}
+	if until != "" {
+		hf.Until, err = oaiParseDate(until, true)
+		if err != nil {
+			return hf, err
+		}
+	}
+
+	if args.Get("set") != "" {
+		var id int
+		id, err = strconv.Atoi(strings.TrimPrefix(args.Get("set"), oaiSetPrefix))
+		if err == nil && strings.HasPrefix(args.Get("set"), oaiSetPrefix) {
+			hf.Category, err = op.FindCategoryByID(id)
+			if err != nil {
+				return hf, err
+			}
+		}
+		if hf.Category == nil {
+			return hf, oaiErrorf("noRecordsMatch", "Unknown set %q", args.Get("set"))
+		}
+	}
+	return hf, nil
+}
+
+// oaiParseDate reads a datestamp in either granularity.  For an "until"
+// date, the returned time is just past the end of the day or second it
+// names, since the harvest filter's upper bound is exclusive.
+func oaiParseDate(s string, until bool) (time.Time, error) {
+	var layout, step = oaiDatestamp, time.Second
+	if len(s) == len(oaiDay) {
+		layout, step = oaiDay, time.Hour*24
+	}
+	var t, err = time.Parse(layout, s)
+	if err != nil {
+		return t, oaiErrorf("badArgument", "Invalid datestamp %q", s)
+	}
+	if until {
+		t = t.Add(step)
+	}
+	return t, nil
+}
+
+// oaiResumptionToken encodes everything needed to pick a list back up at
+// the given offset
+func oaiResumptionToken(args url.Values, offset uint64) string {
+	var v = url.Values{}
+	for _, key := range []string{"metadataPrefix", "from", "until", "set"} {
+		if args.Get(key) != "" {
+			v.Set(key, args.Get(key))
+		}
+	}
+	v.Set("offset", strconv.FormatUint(offset, 10))
+	return base64.RawURLEncoding.EncodeToString([]byte(v.Encode()))
+}
+
+// oaiParseResumption decodes a resumption token into the original list
+// arguments and the offset to resume at
+func oaiParseResumption(args url.Values) (url.Values, uint64, error) {
+	var bad = oaiErrorf("badResumptionToken", "Invalid resumption token")
+	var raw, err = base64.RawURLEncoding.DecodeString(args.Get("resumptionToken"))
+	if err != nil {
+		return nil, 0, bad
+	}
+	var v url.Values
+	v, err = url.ParseQuery(string(raw))
+	if err != nil {
+		return nil, 0, bad
+	}
+	var offset uint64
+	offset, err = strconv.ParseUint(v.Get("offset"), 10, 64)
+	if err != nil {
+		return nil, 0, bad
+	}
+	v.Set("verb", args.Get("verb"))
+	return v, offset, nil
+}
+
+// oaiFindFile returns the harvestable file named by an OAI identifier
+func oaiFindFile(op *db.Operation, identifier string) (*db.File, error) {
+	var idString = strings.TrimPrefix(identifier, oaiIdentifierPrefix())
+	var notFound = oaiErrorf("idDoesNotExist", "No record has the identifier %q", identifier)
+	if idString == identifier {
+		return nil, notFound
+	}
+	var id, err = strconv.ParseUint(idString, 10, 64)
+	if err != nil {
+		return nil, notFound
+	}
+
+	var f *db.File
+	f, err = op.FindFileByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, notFound
+	}
+	err = op.PopulateCategories([]*db.File{f}, nil)
+	return f, err
+}
+
+// oaiIdentifierPrefix begins every record identifier, e.g.,
+// "oai:headlamp.example.edu:file/"
+func oaiIdentifierPrefix() string {
+	var u, _ = url.Parse(conf.WebPath)
+	return "oai:" + strings.ToLower(u.Hostname()) + ":file/"
+}
+
+func oaiSetSpec(c *db.Category) string {
+	return oaiSetPrefix + strconv.Itoa(c.ID)
+}
+
+// oaiFileHeader returns the file's record header.  Files which are missing
+// from their inventory are reported as deleted.
+func oaiFileHeader(f *db.File) oaiHeader {
+	var h = oaiHeader{
+		Identifier: oaiIdentifierPrefix() + strconv.FormatUint(f.ID, 10),
+		Datestamp:  f.IndexedAt.UTC().Format(oaiDatestamp),
+		SetSpecs:   []string{oaiSetSpec(f.Category)},
+	}
+	if f.Missing() {
+		h.Status = "deleted"
+	}
+	return h
+}
+
+// oaiFileRecord maps a file to a Dublin Core record
+func oaiFileRecord(f *db.File) oaiRecord {
+	var rec = oaiRecord{Header: oaiFileHeader(f)}
+	if f.Missing() {
+		return rec
+	}
+
+	rec.Metadata = &oaiMetadata{DC: oaiDC{
+		XmlnsOAIDC:     "http://www.openarchives.org/OAI/2.0/oai_dc/",
+		XmlnsDC:        "http://purl.org/dc/elements/1.1/",
+		XSI:            "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.openarchives.org/OAI/2.0/oai_dc/ http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+		Title:          f.Name,
+		Identifiers: []string{
+			absoluteURL(viewFilePath(f)),
+			path.Join(f.Category.Name, sanitizePath(f.PublicPath)),
+		},
+		Date:     f.ArchiveDate,
+		Format:   f.MimeType,
+		Type:     dcmiTypes[f.FormatFamily],
+		Relation: absoluteURL(browseContainingFolderPath(f)),
+	}}
+	return rec
+}
+
+// oaiBaseURL is the full URL harvesters use to reach us
+func oaiBaseURL() string {
+	return absoluteURL(oaiPath())
+}
+
+func oaiPath() string {
+	return joinPaths("oai")
+}
+
+// absoluteURL turns an app path into a full URL using the configured web
+// path's scheme and host
+func absoluteURL(p string) string {
+	var u, _ = url.Parse(conf.WebPath)
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: p}).String()
+}
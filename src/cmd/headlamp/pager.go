@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// perPageChoices are the page sizes offered alongside browse and search
+// results, in addition to the configured default
+var perPageChoices = []uint64{25, 50, 100, 250, 500, maxFiles}
+
+// pageWindow is how many page numbers are linked on either side of the
+// current page; the first and last pages are always linked
+const pageWindow = 2
+
+// pageLink is a single entry in a pager's list of links.  Gaps in the page
+// numbers are represented by a link with no URL.
+type pageLink struct {
+	Label   string
+	URL     string
+	Current bool
+}
+
+// pager describes one page of a larger list of results, with the links for
+// moving between pages and changing the page size.  Links keep the rest of
+// the query string, so sorting and filters carry across pages.
+type pager struct {
+	Page    uint64
+	Pages   uint64
+	PerPage uint64
+	Prev    string
+	Next    string
+	Links   []pageLink
+	Sizes   []pageLink
+
+	// Total is the number of results, and First and Last are the (1-based)
+	// positions of the results shown on this page
+	Total int64
+	First int64
+	Last  int64
+}
+
+// getPage reads the "page" and "per_page" query parameters used by browse
+// and search.  Pages start at 1, and the page size defaults to the PAGE_SIZE
+// setting.
+func getPage(r *http.Request) (page, perPage uint64, err error) {
+	var q = r.URL.Query()
+	page, perPage = 1, uint64(conf.PageSize)
+	if q.Get("page") != "" {
+		page, err = strconv.ParseUint(q.Get("page"), 10, 64)
+		if err != nil || page == 0 {
+			return 0, 0, fmt.Errorf("invalid page %q", q.Get("page"))
+		}
+	}
+	if q.Get("per_page") != "" {
+		perPage, err = strconv.ParseUint(q.Get("per_page"), 10, 64)
+		if err != nil || perPage == 0 || perPage > maxFiles {
+			return 0, 0, fmt.Errorf("invalid per_page %q: must be between 1 and %d", q.Get("per_page"), maxFiles)
+		}
+	}
+	return page, perPage, nil
+}
+
+// newPager returns a pager for the given page of total results
+func newPager(r *http.Request, page, perPage, total uint64) *pager {
+	var p = &pager{Page: page, PerPage: perPage, Total: int64(total)}
+	p.Pages = (total + perPage - 1) / perPage
+	if total > 0 && page <= p.Pages {
+		p.First = int64((page-1)*perPage + 1)
+		p.Last = int64(page * perPage)
+		if p.Last > p.Total {
+			p.Last = p.Total
+		}
+	}
+
+	var pageURL = func(n uint64) string {
+		return withQuery(r, map[string]string{"page": strconv.FormatUint(n, 10)})
+	}
+	if page > 1 {
+		p.Prev = pageURL(page - 1)
+	}
+	if page < p.Pages {
+		p.Next = pageURL(page + 1)
+	}
+
+	var gap bool
+	for n := uint64(1); p.Pages > 1 && n <= p.Pages; n++ {
+		if n != 1 && n != p.Pages && (n+pageWindow < page || n > page+pageWindow) {
+			if !gap {
+				p.Links = append(p.Links, pageLink{Label: "…"})
+			}
+			gap = true
+			continue
+		}
+		gap = false
+		p.Links = append(p.Links, pageLink{Label: strconv.FormatUint(n, 10), URL: pageURL(n), Current: n == page})
+	}
+
+	// Changing the page size goes back to the first page.  There's no point
+	// offering it when everything fits on the smallest page.
+	var sizes = pageSizes()
+	if total <= sizes[0] {
+		return p
+	}
+	for _, size := range sizes {
+		var u = withQuery(r, map[string]string{"per_page": strconv.FormatUint(size, 10), "page": ""})
+		p.Sizes = append(p.Sizes, pageLink{Label: strconv.FormatUint(size, 10), URL: u, Current: size == perPage})
+	}
+
+	return p
+}
+
+// pageSizes returns perPageChoices, plus the configured default if it isn't
+// already one of them, in ascending order
+func pageSizes() []uint64 {
+	var def = uint64(conf.PageSize)
+	var sizes []uint64
+	for _, size := range perPageChoices {
+		if def != 0 && def < size {
+			sizes = append(sizes, def)
+			def = 0
+		}
+		if size == def {
+			def = 0
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// filePermalinkHandler sends the browser to a file by its permanent id.  File
+// ids survive reindexing and folder moves, and a file merged into its twin
+// leads to the twin, so these are the links to paste into finding aids.
+func filePermalinkHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	var id, err = strconv.ParseUint(parts[len(parts)-1], 10, 64)
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var file *db.File
+	file, err = userOperation(r).FindFileByPermalink(id)
+	if err != nil {
+		logger.Errorf("Error trying to find file for permalink %d: %s", id, err)
+		_500(w, r, "Unable to read the specified file's data.  Try again or contact support.")
+		return
+	}
+	if file == nil {
+		_404(w, r, "Unable to find the requested file.  It may have been removed from the archive.")
+		return
+	}
+
+	http.Redirect(w, r, viewFilePath(file), http.StatusFound)
+}
+
+// folderPermalinkHandler sends the browser to a folder's browse page by its
+// permanent id, wherever the folder lives now
+func folderPermalinkHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	var id, err = strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var op = userOperation(r)
+	var folder *db.Folder
+	folder, err = op.FindFolderByPermalink(id)
+	if err == nil && folder != nil {
+		folder.Category, err = op.FindCategoryByID(folder.CategoryID)
+	}
+	if err != nil {
+		logger.Errorf("Error trying to find folder for permalink %d: %s", id, err)
+		_500(w, r, "Unable to read the specified folder's data.  Try again or contact support.")
+		return
+	}
+	if folder == nil || folder.Category == nil {
+		_404(w, r, "Unable to find the requested folder.  It may have been removed from the archive.")
+		return
+	}
+
+	http.Redirect(w, r, browseFolderPath(folder), http.StatusFound)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedAddress returns true if the given address, with or without a port,
+// is in one of SSO_TRUSTED_PROXIES
+func trustedAddress(addr string) bool {
+	var host, _, err = net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	var ip = net.ParseIP(strings.TrimSpace(host))
+	if ip == nil {
+		return false
+	}
+	for _, n := range conf.SSOTrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address the request came from.  For requests relayed
+// by trusted proxies, that's the address X-Forwarded-For says the first of
+// them heard from: addresses are read from the end of the header, skipping
+// any which are themselves trusted proxies, so a chain such as the campus
+// proxy passing requests to a local Apache still finds the visitor.
+func clientIP(r *http.Request) string {
+	var host, _, err = net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	var fwd = r.Header.Get("X-Forwarded-For")
+	if fwd == "" || !trustedAddress(host) {
+		return host
+	}
+	var addrs = strings.Split(fwd, ",")
+	for i := len(addrs) - 1; i >= 0; i-- {
+		var addr = strings.TrimSpace(addrs[i])
+		if addr == "" {
+			continue
+		}
+		host = addr
+		if !trustedAddress(addr) {
+			break
+		}
+	}
+	return host
+}
+
+// forwardedProto returns the protocol, "http" or "https", a trusted proxy
+// says the visitor used, or an empty string if the request didn't come
+// through one or it didn't say
+func forwardedProto(r *http.Request) string {
+	if !trustedAddress(r.RemoteAddr) {
+		return ""
+	}
+	var proto = r.Header.Get("X-Forwarded-Proto")
+	return strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+}
+
+// forwardedRequests wraps h to undo what a reverse proxy does to requests on
+// their way in.  With PROXY_STRIPS_PATH, the proxy has removed WEBPATH's path
+// from the front of each request, so it's put back before routing.  And where
+// the site is meant to be served over HTTPS, a trusted proxy's word that a
+// visitor came in over plain HTTP sends them to the HTTPS site instead.
+func forwardedRequests(h http.Handler) http.Handler {
+	var prefix = strings.TrimSuffix(basePath, "/")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conf.ProxyStripsPath && prefix != "" {
+			r.URL.Path = prefix + "/" + strings.TrimPrefix(r.URL.Path, "/")
+			if r.URL.RawPath != "" {
+				r.URL.RawPath = prefix + "/" + strings.TrimPrefix(r.URL.RawPath, "/")
+			}
+		}
+		if r.TLS == nil && forwardedProto(r) == "http" && strings.HasPrefix(conf.WebPath, "https:") {
+			redirectToHTTPS(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// Each kind of expensive request gets its own limiter, so a burst of
+// searches doesn't stop somebody from downloading what they found
+var (
+	searchLimiter   = newRateLimiter("search")
+	downloadLimiter = newRateLimiter("download")
+	archiveLimiter  = newRateLimiter("archive request")
+)
+
+// bucket is a token bucket: it holds up to a minute's worth of requests and
+// refills continuously
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter tracks how many requests each visitor has made recently
+type rateLimiter struct {
+	name      string
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+func newRateLimiter(name string) *rateLimiter {
+	return &rateLimiter{name: name, buckets: make(map[string]*bucket)}
+}
+
+// allow spends a token from the key's bucket, whose capacity is perMinute,
+// returning true if there was one to spend.  If not, it returns how long
+// until there will be.
+func (l *rateLimiter) allow(key string, perMinute int) (bool, time.Duration) {
+	var now = time.Now()
+	var capacity = float64(perMinute)
+	var perSecond = capacity / 60
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+	var b = l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops buckets nobody has used for a minute, since they'd be full
+// again anyway, so the map doesn't grow forever
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > time.Minute {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimit wraps a handler so that each visitor can only call it so often.
+// Requests made with an API token or by a logged-in user are counted against
+// RATE_LIMIT_PER_USER, and anonymous requests against the client's IP address
+// and RATE_LIMIT_PER_IP.
+func rateLimit(l *rateLimiter, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var key, limit = rateLimitKey(r)
+		if limit == 0 {
+			h(w, r)
+			return
+		}
+
+		var ok, wait = l.allow(key, limit)
+		if ok {
+			h(w, r)
+			return
+		}
+
+		logger.Warnf("Rate limit: too many %ss from %s", l.name, key)
+		var secs = int(math.Ceil(wait.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		var msg = fmt.Sprintf("Too many requests; please wait %d second(s) and try again", secs)
+		if strings.HasPrefix(r.URL.Path, joinPaths("api")+"/") {
+			apiError(w, http.StatusTooManyRequests, msg)
+			return
+		}
+		_429(w, r, msg)
+	}
+}
+
+// rateLimitKey returns who the request should be counted against and their
+// per-minute limit
+func rateLimitKey(r *http.Request) (string, int) {
+	var t = requestAPIToken(r)
+	if t != nil {
+		return fmt.Sprintf("token %d", t.ID), conf.RateLimitPerUser
+	}
+	var u = currentUser(r)
+	if u != nil {
+		return fmt.Sprintf("user %q", u.Login), conf.RateLimitPerUser
+	}
+	return "ip " + clientIP(r), conf.RateLimitPerIP
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// sitemapMaxURLs is the most URLs the sitemap protocol allows in one file;
+// bigger sitemaps are split into pages listed by a sitemap index
+const sitemapMaxURLs = 50000
+
+// robotsHandler tells crawlers what they may crawl: the browse pages of
+// discoverable categories and nothing else.  Everything is closed by
+// default, since most collections must never show up in a search engine.
+// When the site lives under a base path, only that path is closed off, so
+// it's up to the proxy to pass "/robots.txt" along.
+func robotsHandler(w http.ResponseWriter, r *http.Request) {
+	var categories, err = dbh.Operation().ForRole("").DiscoverableCategories()
+	if err != nil {
+		logger.Errorf("Unable to read crawlable categories for robots.txt: %s", err)
+		http.Error(w, "Unable to process the request", http.StatusInternalServerError)
+		return
+	}
+
+	var lines = []string{"User-agent: *"}
+	for _, c := range categories {
+		var p = escapePath(browseCategoryPath(c))
+		lines = append(lines, "Allow: "+p+"$", "Allow: "+p+"/")
+	}
+	lines = append(lines, "Disallow: "+escapePath(strings.TrimSuffix(basePath, "/"))+"/")
+	if conf.SitemapEnabled && len(categories) > 0 {
+		lines = append(lines, "", "Sitemap: "+absoluteURL(sitemapPath()))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(strings.Join(lines, "\n") + "\n"))
+}
+
+func sitemapPath() string {
+	return joinPaths("sitemap.xml")
+}
+
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// sitemapHandler lists the browse page of every discoverable category and of
+// each folder in them which anonymous visitors can see.  A site with more
+// URLs than one sitemap can hold gets a sitemap index instead, pointing to
+// "sitemap.xml?page=N" for each piece.
+func sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	if !conf.SitemapEnabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	var op = dbh.Operation().ForRole("")
+	var categories, err = op.DiscoverableCategories()
+	var total uint64
+	if err == nil {
+		_, total, err = op.SitemapFolders(categories, db.Page{Limit: 1})
+	}
+	if err != nil {
+		logger.Errorf("Unable to read sitemap data: %s", err)
+		http.Error(w, "Unable to process the request", http.StatusInternalServerError)
+		return
+	}
+
+	var numCategories = uint64(len(categories))
+	total += numCategories
+	var pages = (total + sitemapMaxURLs - 1) / sitemapMaxURLs
+	var pageString = r.URL.Query().Get("page")
+	if pageString == "" && pages > 1 {
+		var index sitemapIndex
+		for p := uint64(1); p <= pages; p++ {
+			index.Sitemaps = append(index.Sitemaps, sitemapURL{Loc: absoluteURL(sitemapPath()) + "?page=" + strconv.FormatUint(p, 10)})
+		}
+		writeSitemap(w, index)
+		return
+	}
+
+	var page uint64 = 1
+	if pageString != "" {
+		page, err = strconv.ParseUint(pageString, 10, 64)
+		if err != nil || page < 1 || page > pages {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	// The URL list is the categories followed by their folders; this page's
+	// slice of it may include some of each
+	var start, end = (page - 1) * sitemapMaxURLs, page * sitemapMaxURLs
+	var set sitemapURLSet
+	for i := start; i < end && i < numCategories; i++ {
+		set.URLs = append(set.URLs, sitemapURL{Loc: absoluteURL(browseCategoryPath(categories[i]))})
+	}
+	if end > numCategories {
+		var first = start
+		if first < numCategories {
+			first = numCategories
+		}
+		var folders []*db.Folder
+		folders, _, err = op.SitemapFolders(categories, db.Page{Offset: first - numCategories, Limit: end - first})
+		if err != nil {
+			logger.Errorf("Unable to read sitemap folders: %s", err)
+			http.Error(w, "Unable to process the request", http.StatusInternalServerError)
+			return
+		}
+		for _, f := range folders {
+			set.URLs = append(set.URLs, sitemapURL{Loc: absoluteURL(browseFolderPath(f))})
+		}
+	}
+	writeSitemap(w, set)
+}
+
+func writeSitemap(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	var enc = xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	var err = enc.Encode(data)
+	if err != nil {
+		logger.Errorf("Unable to write sitemap: %s", err)
+	}
+}
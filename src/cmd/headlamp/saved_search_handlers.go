@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// saveSearchHandler stores the search described by the form data, scoped to
+// the category and folder in the URL
+func saveSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var bsd = getBrowseSearchData(w, r)
+	if bsd.hadError {
+		return
+	}
+
+	var term = r.FormValue("q")
+	var folderSearch = false
+	if term == "" {
+		term = r.FormValue("fq")
+		folderSearch = true
+	}
+	if term == "" {
+		setAlert(w, r, "You must provide a search term to save")
+		http.Redirect(w, r, savedSearchesPath(), http.StatusSeeOther)
+		return
+	}
+
+	var mode, err = db.ParseSearchMode(r.FormValue("mode"))
+	if err != nil {
+		_400(w, r, err.Error())
+		return
+	}
+
+	_, err = bsd.op.SaveSearch(currentUser(r), r.FormValue("name"), term, mode, folderSearch, bsd.category, bsd.folder)
+	if err != nil {
+		logger.Errorf("Unable to save search %q: %s", term, err)
+		_500(w, r, "Unable to save your search.  Try again or contact support.")
+		return
+	}
+
+	setInfo(w, r, "Your search has been saved")
+	http.Redirect(w, r, savedSearchesPath(), http.StatusSeeOther)
+}
+
+// savedSearchesHandler lists the current user's saved searches or, for a
+// POST to "saved-searches/delete/<id>", removes one.  A POST to
+// "saved-searches/alerts/<id>" turns the search's email alerts on or off.
+func savedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	var parts = getPathParts(r)
+	if len(parts) == 3 && parts[1] == "delete" && r.Method == http.MethodPost {
+		deleteSavedSearch(w, r, parts[2])
+		return
+	}
+	if len(parts) == 3 && parts[1] == "alerts" && r.Method == http.MethodPost {
+		setSavedSearchAlerts(w, r, parts[2])
+		return
+	}
+	if len(parts) > 2 || len(parts) == 2 && parts[1] != "" {
+		_404(w, r, "Unable to find the requested resource")
+		return
+	}
+
+	var searches, err = userOperation(r).UserSavedSearches(currentUser(r))
+	if err != nil {
+		logger.Errorf("Unable to read saved searches: %s", err)
+		_500(w, r, "Error trying to find saved searches.  Try again or contact support.")
+		return
+	}
+
+	savedSearches.Render(w, r, vars{"Title": "Headlamp: Saved Searches", "SavedSearches": searches})
+}
+
+// deleteSavedSearch removes a saved search.  Only the search's owner can
+// remove it.
+func deleteSavedSearch(w http.ResponseWriter, r *http.Request, idString string) {
+	var id, err = strconv.Atoi(idString)
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var op = userOperation(r)
+	var s *db.SavedSearch
+	s, err = op.FindSavedSearchByID(id)
+	if err != nil {
+		logger.Errorf("Unable to look up saved search id %d: %s", id, err)
+		_500(w, r, "Unable to delete the saved search.  Try again or contact support.")
+		return
+	}
+	if s == nil {
+		_404(w, r, "Unable to find the requested saved search")
+		return
+	}
+
+	var u = currentUser(r)
+	if u == nil || u.ID != s.UserID {
+		_403(w, r, "You can only remove your own saved searches")
+		return
+	}
+
+	err = op.DeleteSavedSearch(s)
+	if err != nil {
+		logger.Errorf("Unable to delete saved search id %d: %s", id, err)
+		_500(w, r, "Unable to delete the saved search.  Try again or contact support.")
+		return
+	}
+
+	setInfo(w, r, "Saved search removed")
+	http.Redirect(w, r, savedSearchesPath(), http.StatusSeeOther)
+}
+
+// setSavedSearchAlerts subscribes the current user to a saved search's
+// alerts, or unsubscribes them, based on the "subscribed" form value.  Only
+// the search's owner can change its alerts.
+func setSavedSearchAlerts(w http.ResponseWriter, r *http.Request, idString string) {
+	var id, err = strconv.Atoi(idString)
+	if err != nil {
+		_400(w, r, "Invalid request")
+		return
+	}
+
+	var op = userOperation(r)
+	var s *db.SavedSearch
+	s, err = op.FindSavedSearchByID(id)
+	if err != nil {
+		logger.Errorf("Unable to look up saved search id %d: %s", id, err)
+		_500(w, r, "Unable to update the saved search.  Try again or contact support.")
+		return
+	}
+	if s == nil {
+		_404(w, r, "Unable to find the requested saved search")
+		return
+	}
+
+	var u = currentUser(r)
+	if u == nil || u.ID != s.UserID {
+		_403(w, r, "You can only change alerts for your own saved searches")
+		return
+	}
+
+	var subscribed = r.FormValue("subscribed") == "1"
+	if subscribed && u.Email == "" {
+		setAlert(w, r, "Your account has no email address, so alerts can't be sent to you")
+		http.Redirect(w, r, savedSearchesPath(), http.StatusSeeOther)
+		return
+	}
+	if subscribed && s.FolderSearch {
+		setAlert(w, r, "Alerts are only available for file searches")
+		http.Redirect(w, r, savedSearchesPath(), http.StatusSeeOther)
+		return
+	}
+
+	err = op.SetSavedSearchSubscription(s, subscribed)
+	if err != nil {
+		logger.Errorf("Unable to update alerts for saved search id %d: %s", id, err)
+		_500(w, r, "Unable to update the saved search.  Try again or contact support.")
+		return
+	}
+
+	if subscribed {
+		setInfo(w, r, fmt.Sprintf("You'll be emailed at %s when new files match %q", u.Email, s.Name))
+	} else {
+		setInfo(w, r, fmt.Sprintf("Alerts for %q turned off", s.Name))
+	}
+	http.Redirect(w, r, savedSearchesPath(), http.StatusSeeOther)
+}
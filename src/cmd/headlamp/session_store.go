@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/alexedwards/scs"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// sessionCookie is the name of the cookie holding the visitor's session token
+const sessionCookie = "headlamp_session"
+
+// sessionRenewInterval is how often an active session's idle deadline is
+// pushed back.  Renewing on every request would mean a database write for
+// every page view.
+const sessionRenewInterval = time.Minute * 5
+
+// lastSeenSession is the session key recording when the session was last
+// renewed
+const lastSeenSession = "LastSeen"
+
+// sessionLifetime is how long a session lasts before the visitor has to log
+// in again (and, for anonymous visitors, before their queue is lost)
+func sessionLifetime() time.Duration {
+	return time.Hour * time.Duration(conf.SessionLifetimeHours)
+}
+
+// sessionIdleTimeout is how long a session can go unused before it expires,
+// or zero if sessions only expire at the end of their lifetime
+func sessionIdleTimeout() time.Duration {
+	return time.Minute * time.Duration(conf.SessionIdleMinutes)
+}
+
+// dbSessionStore keeps session data in the sessions table, so sessions
+// survive a restart of the web server
+type dbSessionStore struct{}
+
+// Find returns the data for the session with the given token
+func (dbSessionStore) Find(token string) ([]byte, bool, error) {
+	var s, err = dbh.Operation().FindSessionByToken(token)
+	if err != nil || s == nil {
+		return nil, false, err
+	}
+	return []byte(s.Data), true, nil
+}
+
+// Save writes the session's data, noting its user so the sessions table
+// shows who's logged in
+func (dbSessionStore) Save(token string, b []byte, expiry time.Time) error {
+	var aux struct {
+		Data struct {
+			UserID int
+		} `json:"data"`
+	}
+	var err = json.Unmarshal(b, &aux)
+	if err != nil {
+		logger.Warnf("Unable to read user from session data: %s", err)
+	}
+	return dbh.Operation().StoreSession(token, aux.Data.UserID, string(b), expiry)
+}
+
+// Delete removes the session with the given token
+func (dbSessionStore) Delete(token string) error {
+	return dbh.Operation().DeleteSessionByToken(token)
+}
+
+// newSessionManager sets up the session manager every part of the app shares
+// for logins, queues, and flash messages.  Cookies are only sent over HTTPS
+// when the app is served over HTTPS, and are never visible to scripts.
+func newSessionManager() *scs.Manager {
+	var m = scs.NewManager(dbSessionStore{})
+	m.Name(sessionCookie)
+	m.Lifetime(sessionLifetime())
+	m.IdleTimeout(sessionIdleTimeout())
+	m.HttpOnly(true)
+	m.Path(basePath)
+
+	var u, err = url.Parse(conf.WebPath)
+	m.Secure(err == nil && u.Scheme == "https")
+	return m
+}
+
+// renewSessions wraps h so that, when sessions have an idle timeout, each
+// visit pushes the session's idle deadline back.  Sessions which hold
+// nothing yet are left alone, so anonymous visitors who never log in or
+// queue a file don't each get a row in the database.
+func renewSessions(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sessionIdleTimeout() > 0 {
+			renewSession(w, r)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func renewSession(w http.ResponseWriter, r *http.Request) {
+	var s = sessionManager.Load(r)
+	var keys, err = s.Keys()
+	if err != nil || len(keys) == 0 {
+		return
+	}
+
+	var last, _ = s.GetTime(lastSeenSession)
+	if time.Since(last) < sessionRenewInterval {
+		return
+	}
+	err = s.PutTime(w, lastSeenSession, time.Now())
+	if err != nil {
+		logger.Errorf("Unable to renew session: %s", err)
+	}
+}
+
+// pruneSessions periodically removes expired sessions, and the anonymous
+// carts which belonged to them
+func pruneSessions() {
+	for {
+		var n, err = dbh.Operation().DeleteExpiredSessions()
+		if err != nil {
+			logger.Errorf("Unable to remove expired sessions: %s", err)
+		} else if n > 0 {
+			logger.Infof("Removed %d expired session(s)", n)
+		}
+
+		n, err = dbh.Operation().DeleteStaleSessionCarts(time.Now().Add(-sessionLifetime()))
+		if err != nil {
+			logger.Errorf("Unable to remove stale session carts: %s", err)
+		} else if n > 0 {
+			logger.Infof("Removed %d file(s) from stale session carts", n)
+		}
+		time.Sleep(time.Hour)
+	}
+}
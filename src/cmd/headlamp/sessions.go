@@ -1,6 +1,11 @@
 package main
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
 
 func setAlert(w http.ResponseWriter, r *http.Request, val string) {
 	var s = sessionManager.Load(r)
@@ -11,3 +16,158 @@ func setInfo(w http.ResponseWriter, r *http.Request, val string) {
 	var s = sessionManager.Load(r)
 	s.PutString(w, "Info", val)
 }
+
+// sessionArchiveJobIDs returns the ids of archive jobs requested in this session
+func sessionArchiveJobIDs(r *http.Request) []int {
+	var s = sessionManager.Load(r)
+	var ids []int
+	s.GetObject("ArchiveJobs", &ids)
+	return ids
+}
+
+// addSessionArchiveJob remembers the job in the session so the requester can
+// see and manage it later
+func addSessionArchiveJob(w http.ResponseWriter, r *http.Request, j *db.ArchiveJob) error {
+	var s = sessionManager.Load(r)
+	var ids = append(sessionArchiveJobIDs(r), j.ID)
+	return s.PutObject(w, "ArchiveJobs", ids)
+}
+
+// maxRecentFolders is how many recently viewed folders a session remembers
+const maxRecentFolders = 10
+
+// recentFoldersSession is the session key holding the ids of the folders a
+// user viewed most recently, newest first
+const recentFoldersSession = "RecentFolders"
+
+// sessionRecentFolderIDs returns the ids of the folders viewed most recently
+// in this session, newest first
+func sessionRecentFolderIDs(r *http.Request) []int {
+	var s = sessionManager.Load(r)
+	var ids []int
+	s.GetObject(recentFoldersSession, &ids)
+	return ids
+}
+
+// addRecentFolder puts f at the top of the session's recently viewed folders.
+// Only logged-in users' views are tracked, so anonymous browsing never needs
+// a session.
+func addRecentFolder(w http.ResponseWriter, r *http.Request, f *db.Folder) error {
+	if currentUser(r) == nil {
+		return nil
+	}
+
+	var old = sessionRecentFolderIDs(r)
+	if len(old) > 0 && old[0] == f.ID {
+		return nil
+	}
+	var ids = []int{f.ID}
+	for _, id := range old {
+		if id != f.ID && len(ids) < maxRecentFolders {
+			ids = append(ids, id)
+		}
+	}
+	return sessionManager.Load(r).PutObject(w, recentFoldersSession, ids)
+}
+
+// currentUser returns the user logged in to this session, or nil if the
+// session is anonymous
+func currentUser(r *http.Request) *db.User {
+	var s = sessionManager.Load(r)
+	var id, err = s.GetInt("UserID")
+	if err != nil || id == 0 {
+		return nil
+	}
+
+	var u *db.User
+	u, err = dbh.Operation().FindUserByID(id)
+	if err != nil {
+		logger.Errorf("Unable to look up session user %d: %s", id, err)
+		return nil
+	}
+	return u
+}
+
+// requireUser wraps a handler so that it's only reachable by logged-in users
+func requireUser(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if currentUser(r) == nil {
+			_403(w, r, "You must be logged in to do that")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// userRole returns u's effective role: the most powerful of the role an admin
+// assigned, the roles single sign-on granted, and the roles implied by the
+// ADMIN_EMAILS and STAFF_EMAILS lists.  Anonymous users have no role.
+func userRole(u *db.User) string {
+	if u == nil {
+		return ""
+	}
+	return u.EffectiveRole(conf.AdminEmails, conf.StaffEmails)
+}
+
+// hasRole returns true if u's effective role is at least the given role
+func hasRole(u *db.User, role string) bool {
+	return u != nil && db.RoleRank(userRole(u)) >= db.RoleRank(role)
+}
+
+// isAdmin returns true if u can manage jobs, users, and restrictions
+func isAdmin(u *db.User) bool {
+	return hasRole(u, db.RoleAdmin)
+}
+
+// isCurator returns true if u can edit categories
+func isCurator(u *db.User) bool {
+	return hasRole(u, db.RoleCurator)
+}
+
+// isStaff returns true if u can request archives and see restricted files
+// and folders
+func isStaff(u *db.User) bool {
+	return hasRole(u, db.RoleStaff)
+}
+
+// roleDescriptions is how a role is named in "you must be a ..." messages
+var roleDescriptions = map[string]string{
+	db.RoleViewer:  "logged in",
+	db.RoleStaff:   "a staff member",
+	db.RoleCurator: "a curator",
+	db.RoleAdmin:   "an administrator",
+}
+
+// requireRole wraps a handler so that it's only reachable by users whose
+// effective role is at least the given role
+func requireRole(role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasRole(currentUser(r), role) {
+			_403(w, r, "You must be "+roleDescriptions[role]+" to do that")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// requireStaff wraps a handler so that it's only reachable by staff
+func requireStaff(h http.HandlerFunc) http.HandlerFunc {
+	return requireRole(db.RoleStaff, h)
+}
+
+// requireCurator wraps a handler so that it's only reachable by curators
+func requireCurator(h http.HandlerFunc) http.HandlerFunc {
+	return requireRole(db.RoleCurator, h)
+}
+
+// userOperation returns a database operation suited to the current user:
+// restricted items are hidden from everybody but staff, and categories are
+// hidden from anybody without the role their access rules require
+func userOperation(r *http.Request) *db.Operation {
+	return dbh.Operation().ForRole(userRole(currentUser(r)))
+}
+
+// requireAdmin wraps a handler so that it's only reachable by admins
+func requireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return requireRole(db.RoleAdmin, h)
+}
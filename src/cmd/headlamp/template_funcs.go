@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"html/template"
+	"net/url"
 	"os"
 	"path"
 	"strconv"
@@ -20,17 +21,74 @@ var localTemplateFuncs = tmpl.FuncMap{
 	"AddToQueueButton":           addToQueueButton,
 	"RemoveFromQueueButton":      removeFromQueueButton,
 	"ViewBulkQueuePath":          viewBulkQueuePath,
+	"FavoritesPath":              favoritesPath,
+	"FavoriteFilePath":           favoriteFilePath,
+	"FavoriteFolderPath":         favoriteFolderPath,
 	"BrowseCategoryPath":         browseCategoryPath,
 	"BrowseFolderPath":           browseFolderPath,
 	"BrowseContainingFolderPath": browseContainingFolderPath,
 	"ViewFilePath":               viewFilePath,
+	"FilePermalink":              filePermalink,
+	"FolderPermalink":            folderPermalink,
 	"ViewRealFoldersPath":        viewRealFoldersPath,
 	"DownloadFilePath":           downloadFilePath,
+	"FileInfoPath":               fileInfoPath,
 	"BulkDownloadCreatePath":     bulkDownloadCreatePath,
+	"BulkZipPath":                bulkZipPath,
+	"HasThemeCSS":                hasThemeCSS,
+	"SiteTitle":                  siteTitle,
+	"PageTitle":                  pageTitle,
+	"LogoURL":                    logoURL,
+	"InstitutionName":            institutionName,
+	"FooterText":                 footerText,
+	"ContactEmail":               contactEmail,
+	"T":                          translate,
+	"LanguagePath":               languagePath,
+	"QueueMaxSize":               queueMaxSize,
+	"HasThumbnail":               hasThumbnail,
+	"ThumbnailPath":              thumbnailPath,
+	"PreviewPath":                previewPath,
+	"SortHeader":                 sortHeader,
+	"HighlightFileName":          highlightFileName,
+	"HighlightFileFolder":        highlightFileFolder,
+	"HighlightFolder":            highlightFolder,
+	"CancelArchiveJobPath":       cancelArchiveJobPath,
+	"SaveSearchPath":             saveSearchPath,
+	"SavedSearchesPath":          savedSearchesPath,
+	"SavedSearchPath":            savedSearchPath,
+	"AdvancedSearchPath":         advancedSearchPath,
+	"DeleteSavedSearchPath":      deleteSavedSearchPath,
+	"SavedSearchAlertsPath":      savedSearchAlertsPath,
+	"WhatsNewPath":               whatsNewPath,
+	"LoginPath":                  loginPath,
+	"LogoutPath":                 logoutPath,
+	"ExportCategoryPath":         exportCategoryPath,
+	"AdminDashboardPath":         adminDashboardPath,
+	"AdminJobsPath":              adminJobsPath,
+	"AdminIndexPath":             adminIndexPath,
+	"AdminQueueIndexPath":        adminQueueIndexPath,
+	"AdminIndexEventsPath":       adminIndexEventsPath,
+	"AdminMissingPath":           adminMissingPath,
+	"AdminMissingCategoryPath":   adminMissingCategoryPath,
+	"AdminRequeueJobPath":        adminRequeueJobPath,
+	"AdminRestrictFolderPath":    adminRestrictFolderPath,
+	"AdminDescribeFolderPath":    adminDescribeFolderPath,
+	"AdminRestrictFilePath":      adminRestrictFilePath,
+	"AdminCategoriesPath":        adminCategoriesPath,
+	"AdminRenameCategoryPath":    adminRenameCategoryPath,
+	"AdminMergeCategoryPath":     adminMergeCategoryPath,
+	"AdminCategoryAccessPath":    adminCategoryAccessPath,
+	"AdminReindexFolderPath":     adminReindexFolderPath,
+	"AdminAPITokensPath":         adminAPITokensPath,
+	"AdminCreateAPITokenPath":    adminCreateAPITokenPath,
+	"AdminRevokeAPITokenPath":    adminRevokeAPITokenPath,
+	"AdminUsersPath":             adminUsersPath,
+	"AdminSetUserRolePath":       adminSetUserRolePath,
 	"Pathify":                    pathify,
 	"GenericPath":                joinPaths,
 	"stripCategoryFolder":        stripCategoryFolder,
 	"humanFilesize":              humanFilesize,
+	"humanCount":                 humanCount,
 	"VersionString":              versionString,
 }
 
@@ -56,6 +114,148 @@ func searchPath(category *db.Category, folder *db.Folder) string {
 	return joinPaths("search", pathify(category, folder))
 }
 
+// saveSearchPath returns the URL for saving a search in the given category
+// and folder context
+func saveSearchPath(category *db.Category, folder *db.Folder) string {
+	if category == nil {
+		return joinPaths("save-search") + "/"
+	}
+	return joinPaths("save-search", pathify(category, folder))
+}
+
+func savedSearchesPath() string {
+	return joinPaths("saved-searches") + "/"
+}
+
+func exportCategoryPath(category *db.Category) string {
+	return joinPaths("export", category.Name)
+}
+
+func adminMissingPath() string {
+	return joinPaths("admin", "missing") + "/"
+}
+
+func adminMissingCategoryPath(c *db.Category) string {
+	return adminMissingPath() + "?category=" + url.QueryEscape(c.Name)
+}
+
+func adminDashboardPath() string {
+	return joinPaths("admin") + "/"
+}
+
+func adminJobsPath() string {
+	return joinPaths("admin", "jobs") + "/"
+}
+
+func adminIndexPath() string {
+	return joinPaths("admin", "index") + "/"
+}
+
+func adminQueueIndexPath() string {
+	return joinPaths("admin", "index", "queue")
+}
+
+func adminRequeueJobPath(j *db.ArchiveJob) string {
+	return joinPaths("admin", "jobs", "requeue", strconv.Itoa(j.ID))
+}
+
+func adminRestrictFolderPath(f *db.Folder) string {
+	return joinPaths("admin", "restrict", "folder", strconv.Itoa(f.ID))
+}
+
+func adminRestrictFilePath(f *db.File) string {
+	return joinPaths("admin", "restrict", "file", strconv.FormatUint(f.ID, 10))
+}
+
+func adminCategoriesPath() string {
+	return joinPaths("admin", "categories") + "/"
+}
+
+func adminRenameCategoryPath(c *db.Category) string {
+	return joinPaths("admin", "categories", "rename", strconv.Itoa(c.ID))
+}
+
+func adminMergeCategoryPath(c *db.Category) string {
+	return joinPaths("admin", "categories", "merge", strconv.Itoa(c.ID))
+}
+
+func adminCategoryAccessPath(c *db.Category) string {
+	return joinPaths("admin", "categories", "access", strconv.Itoa(c.ID))
+}
+
+func languagePath() string {
+	return joinPaths("language")
+}
+
+func adminDescribeFolderPath(f *db.Folder) string {
+	return joinPaths("admin", "folders", "describe", strconv.Itoa(f.ID))
+}
+
+func adminReindexFolderPath(f *db.Folder) string {
+	return joinPaths("admin", "folders", "reindex", strconv.Itoa(f.ID))
+}
+
+func adminAPITokensPath() string {
+	return joinPaths("admin", "api-tokens") + "/"
+}
+
+func adminCreateAPITokenPath() string {
+	return joinPaths("admin", "api-tokens", "create")
+}
+
+func adminRevokeAPITokenPath(t *db.APIToken) string {
+	return joinPaths("admin", "api-tokens", "revoke", strconv.Itoa(t.ID))
+}
+
+func adminUsersPath() string {
+	return joinPaths("admin", "users") + "/"
+}
+
+func adminSetUserRolePath(u *db.User) string {
+	return joinPaths("admin", "users", "role", strconv.Itoa(u.ID))
+}
+
+func loginPath() string {
+	return joinPaths("login")
+}
+
+func loginCallbackPath() string {
+	return joinPaths("login", "callback")
+}
+
+func logoutPath() string {
+	return joinPaths("logout")
+}
+
+func advancedSearchPath() string {
+	return joinPaths("advanced-search") + "/"
+}
+
+func whatsNewPath() string {
+	return joinPaths("whats-new") + "/"
+}
+
+// savedSearchPath returns the search URL which reruns the saved search
+func savedSearchPath(s *db.SavedSearch) string {
+	var key = "q"
+	if s.FolderSearch {
+		key = "fq"
+	}
+	var v = url.Values{key: []string{s.Term}}
+	if s.Mode != "" && s.Mode != string(db.SearchLike) {
+		v.Set("mode", s.Mode)
+	}
+	return searchPath(s.Category, s.Folder) + "?" + v.Encode()
+}
+
+func deleteSavedSearchPath(s *db.SavedSearch) string {
+	return joinPaths("saved-searches", "delete", strconv.Itoa(s.ID))
+}
+
+func savedSearchAlertsPath(s *db.SavedSearch) string {
+	return joinPaths("saved-searches", "alerts", strconv.Itoa(s.ID))
+}
+
 func addToQueuePath(file *db.File) string {
 	return joinPaths("bulk", "add", strconv.FormatUint(file.ID, 10))
 }
@@ -77,6 +277,23 @@ func makeButton(val string, classes []string, attrs map[string]string, disabled
 	return template.HTML(fmt.Sprintf("<button %s>%s</button>", strings.Join(attrPairs, " "), val))
 }
 
+// sortHeader returns a column heading which links to sorting by field, with
+// an arrow showing the direction if it's the current sort.  Without links
+// (e.g., on search pages), it's just the label.
+func sortHeader(links map[string]string, current, field, label string) template.HTML {
+	var text = template.HTMLEscapeString(label)
+	if links == nil {
+		return template.HTML(text)
+	}
+	switch current {
+	case field:
+		text += ` <span aria-label="sorted ascending">&#9650;</span>`
+	case "-" + field:
+		text += ` <span aria-label="sorted descending">&#9660;</span>`
+	}
+	return template.HTML(fmt.Sprintf(`<a href="%s">%s</a>`, template.HTMLEscapeString(links[field]), text))
+}
+
 func bulkButtonID(add bool, file *db.File) string {
 	var prefix string
 	if add {
@@ -113,6 +330,29 @@ func viewBulkQueuePath() string {
 	return joinPaths("bulk-download")
 }
 
+func favoritesPath() string {
+	return joinPaths("favorites") + "/"
+}
+
+// favoriteFilePath returns the URL which stars the file or, if it's already
+// one of the user's favorites, unstars it
+func favoriteFilePath(favs *Favorites, f *db.File) string {
+	return joinPaths("favorites", favoriteAction(favs.HasFile(f)), db.FavoriteFile, strconv.FormatUint(f.ID, 10))
+}
+
+// favoriteFolderPath returns the URL which stars the folder or, if it's
+// already one of the user's favorites, unstars it
+func favoriteFolderPath(favs *Favorites, f *db.Folder) string {
+	return joinPaths("favorites", favoriteAction(favs.HasFolder(f)), db.FavoriteFolder, strconv.Itoa(f.ID))
+}
+
+func favoriteAction(starred bool) string {
+	if starred {
+		return "remove"
+	}
+	return "add"
+}
+
 // browseCategoryPath produces the URL to browse the given category's top-level folder
 func browseCategoryPath(category *db.Category) string {
 	return joinPaths("browse", category.Name)
@@ -130,6 +370,17 @@ func viewFilePath(file *db.File) string {
 	return joinPaths("view", strconv.FormatUint(file.ID, 10))
 }
 
+// filePermalink returns the file's permanent, id-based URL, which keeps
+// working even if the file's path changes
+func filePermalink(file *db.File) string {
+	return joinPaths("file", strconv.FormatUint(file.ID, 10))
+}
+
+// folderPermalink returns the folder's permanent, id-based URL
+func folderPermalink(folder *db.Folder) string {
+	return joinPaths("folder", strconv.Itoa(folder.ID))
+}
+
 func viewRealFoldersPath(folder *db.Folder) string {
 	return joinPaths("filesystem", pathify(folder.Category, folder))
 }
@@ -138,10 +389,30 @@ func downloadFilePath(file *db.File) string {
 	return joinPaths("download", strconv.FormatUint(file.ID, 10))
 }
 
+func thumbnailPath(file *db.File) string {
+	return joinPaths("thumbnail", strconv.FormatUint(file.ID, 10))
+}
+
+func previewPath(file *db.File) string {
+	return joinPaths("preview", strconv.FormatUint(file.ID, 10))
+}
+
+func fileInfoPath(file *db.File) string {
+	return joinPaths("file-info", strconv.FormatUint(file.ID, 10))
+}
+
 func bulkDownloadCreatePath() string {
 	return joinPaths("bulk", "create")
 }
 
+func bulkZipPath() string {
+	return joinPaths("bulk", "zip")
+}
+
+func cancelArchiveJobPath(j *db.ArchiveJob) string {
+	return joinPaths("bulk", "cancel", strconv.Itoa(j.ID))
+}
+
 // stripCategoryFolder takes a string representing a path, and strips out the
 // current folder context, if any exists
 func stripCategoryFolder(f *db.Folder, path string) string {
@@ -177,6 +448,23 @@ func humanFilesize(bytes int64) string {
 	return humanize.Bytes(bytes)
 }
 
+// humanCount returns n with commas separating each group of three digits
+func humanCount(n int64) string {
+	var s = strconv.FormatInt(n, 10)
+	var sign string
+	if n < 0 {
+		sign, s = "-", s[1:]
+	}
+
+	var out []string
+	for len(s) > 3 {
+		out = append([]string{s[len(s)-3:]}, out...)
+		s = s[:len(s)-3]
+	}
+	out = append([]string{s}, out...)
+	return sign + strings.Join(out, ",")
+}
+
 // versionString returns a version number for inclusion on web pages so it's
 // clearer what's on staging vs. dev vs. prod, etc.
 func versionString() string {
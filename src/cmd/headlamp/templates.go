@@ -14,25 +14,47 @@ type Template struct {
 	*tmpl.Template
 }
 
-var home, browse, search, bulk, fsinfo, empty *Template
+var home, browse, search, advancedSearch, bulk, fsinfo, savedSearches, favorites, archiveStatus, whatsNew, adminDashboard, adminCategories, adminIndex, adminJobs, adminMissing, adminAPITokens, adminUsers, fileInfo, preview, login, empty *Template
 
 func initTemplates(webroot string) {
 	webutil.Webroot = webroot
-	var root = tmpl.Root("layout", filepath.Join(conf.Approot, "templates"))
+	themeCSS = themeFile("static", filepath.Join("css", "theme.css")) != ""
+
+	// Templates are found by full path so that a theme can override any of
+	// them individually
+	var root = tmpl.Root("layout", "")
 
 	var t = func(name string) *Template {
-		return &Template{root.Clone().MustBuild(name + ".go.html")}
+		return &Template{root.Clone().MustBuild(templatePath(name + ".go.html"))}
 	}
 
 	root.Funcs(tmpl.DefaultTemplateFunctions)
 	root.Funcs(webutil.FuncMap)
 	root.Funcs(localTemplateFuncs)
-	root.MustReadPartials("layout.go.html", "_search_form.go.html", "_tables.go.html")
+	root.MustReadPartials(templatePath("layout.go.html"), templatePath("_search_form.go.html"), templatePath("_tables.go.html"))
+	if themeFile("templates", themePartial) != "" {
+		root.MustReadPartials(themeFile("templates", themePartial))
+	}
 	home = t("home")
 	browse = t("browse")
 	search = t("search")
+	advancedSearch = t("advanced_search")
 	bulk = t("bulk")
 	fsinfo = t("fsinfo")
+	savedSearches = t("saved_searches")
+	favorites = t("favorites")
+	archiveStatus = t("archive_status")
+	whatsNew = t("whats_new")
+	adminDashboard = t("admin_dashboard")
+	adminCategories = t("admin_categories")
+	adminIndex = t("admin_index")
+	adminJobs = t("admin_jobs")
+	adminMissing = t("admin_missing")
+	adminAPITokens = t("admin_api_tokens")
+	adminUsers = t("admin_users")
+	fileInfo = t("file_info")
+	preview = t("preview")
+	login = t("login")
 	empty = &Template{root.Template()}
 }
 
@@ -42,14 +64,29 @@ func (t *Template) Render(w http.ResponseWriter, r *http.Request, data vars) {
 	var s = sessionManager.Load(r)
 	data["Alert"], _ = s.PopString(w, "Alert")
 	data["Info"], _ = s.PopString(w, "Info")
-	var q = NewBulkFileQueue()
-	var err = s.GetObject("Queue", q)
+	var q, err = loadBulkFileQueue(r)
 	if err != nil {
 		logger.Errorf("Unable to load user's bulk file queue: %s", err)
 	}
 	if data["Queue"] == nil {
 		data["Queue"] = q
 	}
+	var u = currentUser(r)
+	if u != nil && data["Favorites"] == nil {
+		data["Favorites"], err = loadFavorites(r)
+		if err != nil {
+			logger.Errorf("Unable to load user's favorites: %s", err)
+		}
+	}
+	data["CurrentUser"] = u
+	data["IsAdmin"] = isAdmin(u)
+	data["IsCurator"] = isCurator(u)
+	data["IsStaff"] = isStaff(u)
+	data["LoginEnabled"] = loginEnabled()
+	data["RequestPath"] = r.URL.RequestURI()
+	data["Locale"] = requestLocale(r)
+	data["Locales"] = localeList
+	data["CSRFToken"] = csrfToken(w, r)
 
 	err = t.Execute(w, data)
 	if err != nil {
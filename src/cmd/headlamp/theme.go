@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// themePartial is the optional template in a theme's templates directory
+// which can redefine the layout's "brand", "header", and "footer" blocks
+// without replacing the whole layout
+const themePartial = "_theme.go.html"
+
+// themeFile returns the path to the named file in the given subdirectory
+// (e.g., "templates") of THEME_DIR, or an empty string if there's no theme or
+// the theme doesn't have that file
+func themeFile(subdir, name string) string {
+	if conf.ThemeDir == "" {
+		return ""
+	}
+	var p = filepath.Join(conf.ThemeDir, subdir, name)
+	var info, err = os.Stat(p)
+	if err != nil || info.IsDir() {
+		return ""
+	}
+	return p
+}
+
+// templatePath returns the path to the named template: the theme's copy if
+// it has one, otherwise the built-in template
+func templatePath(name string) string {
+	var p = themeFile("templates", name)
+	if p != "" {
+		return p
+	}
+	return filepath.Join(conf.Approot, "templates", name)
+}
+
+// themeCSS is true when the theme has a css/theme.css stylesheet
+var themeCSS bool
+
+// hasThemeCSS tells the layout whether to include the theme's stylesheet
+// after the built-in styles
+func hasThemeCSS() bool {
+	return themeCSS
+}
+
+// overlayFS serves each file from the first of its file systems that has it,
+// so a theme's static files take precedence over the built-in ones
+type overlayFS []http.FileSystem
+
+// Open implements http.FileSystem
+func (o overlayFS) Open(name string) (http.File, error) {
+	var err error
+	for _, fs := range o {
+		var f http.File
+		f, err = fs.Open(name)
+		if err == nil {
+			return f, nil
+		}
+	}
+	return nil, err
+}
+
+// staticFS returns the file system static assets are served from: THEME_DIR's
+// static directory layered over the built-in one
+func staticFS() http.FileSystem {
+	var builtin = http.Dir(filepath.Join(conf.Approot, "static"))
+	if conf.ThemeDir == "" {
+		return builtin
+	}
+	return overlayFS{http.Dir(filepath.Join(conf.ThemeDir, "static")), builtin}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// listen serves requests on the configured address, over HTTPS if Headlamp
+// handles TLS itself, and starts the HTTP redirect listener if there is one.
+// Like http.Server's ListenAndServe, it only returns when the server stops.
+func listen(server *http.Server) error {
+	if !conf.TLSEnabled() {
+		logger.Infof("Listening for HTTP connections")
+		return server.ListenAndServe()
+	}
+
+	var redirect http.Handler = http.HandlerFunc(redirectToHTTPS)
+	server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(conf.AutocertDomains) > 0 {
+		var m = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(conf.AutocertDomains...),
+			Cache:      autocert.DirCache(conf.AutocertCacheDir),
+			Email:      conf.AutocertEmail,
+		}
+		server.TLSConfig = m.TLSConfig()
+		server.TLSConfig.MinVersion = tls.VersionTLS12
+		redirect = m.HTTPHandler(redirect)
+	}
+
+	if conf.HTTPRedirectAddress != "" {
+		startRedirectServer(server, redirect)
+	}
+
+	logger.Infof("Listening for HTTPS connections")
+	return server.ListenAndServeTLS(conf.TLSCertFile, conf.TLSKeyFile)
+}
+
+// startRedirectServer listens for plain HTTP requests on
+// HTTP_REDIRECT_ADDRESS, closing when the main server shuts down
+func startRedirectServer(server *http.Server, h http.Handler) {
+	var rs = &http.Server{Addr: conf.HTTPRedirectAddress, Handler: h, ReadTimeout: time.Minute, WriteTimeout: time.Minute}
+	server.RegisterOnShutdown(func() {
+		var err = rs.Close()
+		if err != nil {
+			logger.Warnf("Unable to close HTTP redirect server: %s", err)
+		}
+	})
+
+	go func() {
+		logger.Infof("Listening for HTTP connections to redirect to HTTPS on %q", conf.HTTPRedirectAddress)
+		var err = rs.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("Unable to start HTTP redirect server: %s", err)
+		}
+	}()
+}
+
+// redirectToHTTPS sends the browser to the same path on the HTTPS site.  The
+// host comes from WEBPATH rather than the request, so a forged Host header
+// can't redirect people elsewhere.
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	var u, _ = url.Parse(conf.WebPath)
+	var target = &url.URL{Scheme: "https", Host: u.Host, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+	var status = http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, target.String(), status)
+}
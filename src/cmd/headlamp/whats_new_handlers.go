@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// defaultRecentDays is how far back the "what's new" page looks when the
+// user doesn't say otherwise
+const defaultRecentDays = 7
+
+// maxRecentDays keeps people from asking for the entire archive
+const maxRecentDays = 365
+
+// recentCategory groups recently indexed files under their category for
+// display
+type recentCategory struct {
+	Category *db.Category
+	Files    []*db.File
+}
+
+// whatsNewHandler lists the files indexed in the last N days, grouped by
+// category, so curators can verify that recent deposits made it in
+func whatsNewHandler(w http.ResponseWriter, r *http.Request) {
+	var days = defaultRecentDays
+	var daysParam = r.URL.Query().Get("days")
+	if daysParam != "" {
+		var err error
+		days, err = strconv.Atoi(daysParam)
+		if err != nil || days < 1 || days > maxRecentDays {
+			_400(w, r, fmt.Sprintf("Days must be a number from 1 to %d", maxRecentDays))
+			return
+		}
+	}
+
+	var since = time.Now().AddDate(0, 0, -days)
+	var files, err = userOperation(r).RecentFiles(since, maxFiles+1)
+	if err != nil {
+		logger.Errorf("Unable to read recently indexed files: %s", err)
+		_500(w, r, "Error trying to find recently indexed files.  Try again or contact support.")
+		return
+	}
+
+	var tooManyFiles = false
+	if len(files) > maxFiles {
+		files = files[:maxFiles]
+		tooManyFiles = true
+	}
+
+	// Group files by category, ordering categories by their most recent file
+	var groups []*recentCategory
+	var lookup = make(map[int]*recentCategory)
+	for _, f := range files {
+		var g = lookup[f.CategoryID]
+		if g == nil {
+			g = &recentCategory{Category: f.Category}
+			lookup[f.CategoryID] = g
+			groups = append(groups, g)
+		}
+		g.Files = append(g.Files, f)
+	}
+
+	whatsNew.Render(w, r, vars{
+		"Title":        "Headlamp: What's New",
+		"Days":         days,
+		"Groups":       groups,
+		"TooManyFiles": tooManyFiles,
+		"MaxFiles":     maxFiles,
+	})
+}
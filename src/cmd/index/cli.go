@@ -8,6 +8,7 @@ import (
 
 	"github.com/uoregon-libraries/gopkg/wordutils"
 	"github.com/uoregon-libraries/headlamp/src/config"
+	"github.com/uoregon-libraries/headlamp/src/indexer"
 )
 
 var spaces = regexp.MustCompile(`\s+`)
@@ -33,24 +34,101 @@ func usage(msg string) {
 		status = 1
 	}
 
-	perrf("Usage: %s <settings file>", os.Args[0])
+	perrf("Usage: %s [--watch | --dry-run] [--category NAME | --force INVENTORY] [--links=follow|skip|alias] <settings file>", os.Args[0])
+	perrraw("")
+	perr("With --watch, the indexer also watches the dark archive for new or " +
+		"changed inventory files and indexes them as soon as they stop changing, " +
+		"rather than waiting for the next regular scan.")
+	perrraw("")
+	perr("With --dry-run, the indexer parses inventories once and reports what " +
+		"it would create or change, without writing anything to the database.")
+	perrraw("")
+	perr("With --category, the indexer runs once, indexing only the new or " +
+		"changed inventories which list files in the named category, and then " +
+		"exits.  Inventories are indexed even if they were modified recently, so " +
+		"only use this once a deposit has finished copying.")
+	perrraw("")
+	perr("With --force, the indexer runs once, removing everything previously " +
+		"indexed from the given inventory file (or bag directory) and then " +
+		"indexing it again, even if it hasn't changed, and then exits.  This is " +
+		"for manifests which were corrected in place.")
+	perrraw("")
+	perr("--links says what to do with inventory entries which are symlinks or " +
+		"extra hard links to a file already seen in this run.  \"follow\" (the " +
+		"default) indexes them like any other file without checking the disk, " +
+		"\"skip\" leaves them out of the index, and \"alias\" indexes them but " +
+		"records what they link to.")
 
 	os.Exit(status)
 }
 
-func getCLI() *config.Config {
-	if len(os.Args) < 2 {
+// options holds the flags given before the settings file
+type options struct {
+	watch    bool
+	dryRun   bool
+	category string
+	force    string
+	links    indexer.LinkPolicy
+}
+
+// getCLI reads the settings file and any flags
+func getCLI() (*config.Config, *options) {
+	var opts = &options{}
+	var args = os.Args[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		if strings.HasPrefix(args[0], "--links=") {
+			var err error
+			opts.links, err = indexer.ParseLinkPolicy(strings.TrimPrefix(args[0], "--links="))
+			if err != nil {
+				usage(err.Error())
+			}
+			args = args[1:]
+			continue
+		}
+
+		switch args[0] {
+		case "--category":
+			if len(args) < 2 {
+				usage("--category requires a category name")
+			}
+			opts.category = args[1]
+			args = args[1:]
+		case "--force":
+			if len(args) < 2 {
+				usage("--force requires an inventory path")
+			}
+			opts.force = args[1]
+			args = args[1:]
+		case "--watch":
+			opts.watch = true
+		case "--dry-run":
+			opts.dryRun = true
+		default:
+			usage(fmt.Sprintf("Unknown option %q", args[0]))
+		}
+		args = args[1:]
+	}
+	if opts.watch && opts.dryRun {
+		usage("--watch and --dry-run can't be used together")
+	}
+	if opts.watch && opts.category != "" {
+		usage("--watch and --category can't be used together")
+	}
+	if opts.force != "" && (opts.watch || opts.dryRun || opts.category != "") {
+		usage("--force can't be used with --watch, --dry-run, or --category")
+	}
+	if len(args) < 1 {
 		usage("You must specify a settings file")
 	}
-	if len(os.Args) > 2 {
+	if len(args) > 1 {
 		usage("Too many arguments")
 	}
 
-	var c, err = config.Read(os.Args[1])
+	var c, err = config.Read(args[0])
 	if err != nil {
 		perrf("Invalid configuration: %s", err)
 		os.Exit(1)
 	}
 
-	return c
+	return c, opts
 }
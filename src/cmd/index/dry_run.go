@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/uoregon-libraries/headlamp/src/indexer"
+)
+
+// printDryRun writes a dry run's counts, each with its sample listing
+func printDryRun(w io.Writer, r *indexer.DryRunReport) {
+	var section = func(label string, c indexer.DryRunChanges) {
+		fmt.Fprintf(w, "%s: %d\n", label, c.Count)
+		for _, s := range c.Sample {
+			fmt.Fprintf(w, "    %s\n", s)
+		}
+		if c.Count > len(c.Sample) {
+			fmt.Fprintf(w, "    ... and %d more\n", c.Count-len(c.Sample))
+		}
+	}
+
+	fmt.Fprintln(w, "Dry run: nothing was written to the database")
+	fmt.Fprintln(w)
+	section("New inventories", r.NewInventories)
+	section("Changed inventories", r.ChangedInventories)
+	section("Unreadable inventories", r.FailedInventories)
+	section("Bad inventory lines", r.BadRecords)
+	section("Categories to create", r.Categories)
+	section("Folders to create", r.Folders)
+	section("Files to add", r.NewFiles)
+	section("Files to change", r.ChangedFiles)
+	section("Files no longer listed", r.MissingFiles)
+	fmt.Fprintf(w, "Files already up to date: %d\n", r.UnchangedFiles)
+}
@@ -1,36 +1,96 @@
 package main
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
 	"github.com/uoregon-libraries/headlamp/src/indexer"
 )
 
+// requestPollInterval is how often the runner checks for index runs
+// requested from the web app
+const requestPollInterval = time.Second * 30
+
 type runner struct {
 	indexer  *indexer.Indexer
+	dbh      *db.Database
 	ticker   *time.Ticker
+	poll     *time.Ticker
+	beat     *time.Ticker
 	needStop chan bool
 	sigDone  chan bool
+
+	// minAge is the indexer's usual minimum inventory age, restored after
+	// running a category request
+	minAge time.Duration
+
+	// trigger, if set, requests an immediate reindex whenever it receives a
+	// value, in addition to the regular ticks
+	trigger <-chan struct{}
+
+	// requests holds at most one pending reindex so that requests arriving
+	// while an index is running are neither lost nor piled up, and checks does
+	// the same for looking at the web app's queued requests
+	requests chan struct{}
+	checks   chan struct{}
+
+	// stopping is closed when the runner has been asked to stop, so queued
+	// requests aren't started during shutdown
+	stopping chan struct{}
 }
 
 // start kicks off the ticker, refreshing the dark archive inventory list regularly
 func (r *runner) run() {
 	r.ticker = time.NewTicker(time.Minute * 15)
-	var reindex = func() {
-		var err = r.indexer.Index()
-		if err != nil {
-			logger.Criticalf("Unable to reindex dark archive files: %s", err)
-		}
+	r.poll = time.NewTicker(requestPollInterval)
+	r.beat = time.NewTicker(db.HeartbeatInterval)
+	r.requests = make(chan struct{}, 1)
+	r.checks = make(chan struct{}, 1)
+	r.stopping = make(chan struct{})
+
+	var n, err = r.dbh.Operation().ResetIndexRequests()
+	if err != nil {
+		logger.Errorf("Unable to requeue interrupted index requests: %s", err)
+	} else if n > 0 {
+		logger.Infof("Requeued %d index request(s) interrupted by the last shutdown", n)
 	}
-	go reindex()
+
+	// All indexing happens in this one goroutine, so regular runs and
+	// requested runs never overlap
+	go func() {
+		for {
+			select {
+			case <-r.requests:
+				var err = r.indexer.Index()
+				if err != nil {
+					logger.Criticalf("Unable to reindex dark archive files: %s", err)
+				}
+			case <-r.checks:
+				r.runRequests()
+			}
+		}
+	}()
+	r.requestIndex()
+	r.checkRequests()
+	r.heartbeat()
 
 	for {
 		select {
 		case <-r.ticker.C:
-			go reindex()
+			r.requestIndex()
+		case <-r.trigger:
+			r.requestIndex()
+		case <-r.poll.C:
+			r.checkRequests()
+		case <-r.beat.C:
+			r.heartbeat()
 		case <-r.needStop:
+			close(r.stopping)
 			r.ticker.Stop()
+			r.poll.Stop()
+			r.beat.Stop()
 			r.indexer.Stop()
 			r.indexer.Wait()
 			return
@@ -38,6 +98,115 @@ func (r *runner) run() {
 	}
 }
 
+// requestIndex queues a reindex unless one is already waiting to run
+func (r *runner) requestIndex() {
+	select {
+	case r.requests <- struct{}{}:
+	default:
+	}
+}
+
+// checkRequests queues a look at the web app's requests unless one is
+// already waiting
+func (r *runner) checkRequests() {
+	select {
+	case r.checks <- struct{}{}:
+	default:
+	}
+}
+
+// heartbeat records that the indexer is alive.  The main loop never waits on
+// indexing, so heartbeats continue during long runs.
+func (r *runner) heartbeat() {
+	var err = r.dbh.Operation().Heartbeat(db.WorkerIndexer)
+	if err != nil {
+		logger.Errorf("Unable to record heartbeat: %s", err)
+	}
+}
+
+// runRequests runs each index request queued in the web app, oldest first,
+// until none are left or the runner is stopping
+func (r *runner) runRequests() {
+	for {
+		select {
+		case <-r.stopping:
+			return
+		default:
+		}
+
+		var req, err = r.dbh.Operation().StartIndexRequest()
+		if err != nil {
+			logger.Errorf("Unable to read index requests: %s", err)
+			return
+		}
+		if req == nil {
+			return
+		}
+		r.runRequest(req)
+	}
+}
+
+// runRequest runs the index a single request asked for.  Category requests
+// work like the --category flag: only that category's inventories are
+// indexed, and they don't have to age first.
+func (r *runner) runRequest(req *db.IndexRequest) {
+	logger.Infof("Starting %s requested in the web app (request %d)", req, req.ID)
+	if req.FolderID != 0 {
+		r.runFolderRequest(req)
+		return
+	}
+	if req.Category != "" {
+		r.indexer.SetCategory(req.Category)
+		r.indexer.SetMinAge(0)
+	}
+	var err = r.indexer.Index()
+	r.indexer.SetCategory("")
+	r.indexer.SetMinAge(r.minAge)
+
+	var run = r.indexer.LastRun()
+	if run != nil && run.StartedAt.Before(req.StartedAt) {
+		run = nil
+	}
+
+	var op = r.dbh.Operation()
+	switch {
+	case err == nil && run == nil:
+		err = op.FinishIndexRequest(req, nil, fmt.Errorf("the indexer was already running"))
+	case err == nil && run.Stopped:
+		logger.Infof("Index request %d was stopped before it finished; requeueing it", req.ID)
+		err = op.RequeueIndexRequest(req)
+	default:
+		if err != nil {
+			logger.Errorf("Unable to complete index request %d: %s", req.ID, err)
+		}
+		err = op.FinishIndexRequest(req, run, err)
+	}
+	if err != nil {
+		logger.Errorf("Unable to record the outcome of index request %d: %s", req.ID, err)
+	}
+}
+
+// runFolderRequest rebuilds the folder a request asked for from its
+// inventories
+func (r *runner) runFolderRequest(req *db.IndexRequest) {
+	var op = r.dbh.Operation()
+	var f, err = op.FindFolderByID(req.FolderID)
+	if err == nil && f == nil {
+		err = fmt.Errorf("the folder no longer exists")
+	}
+	if err == nil {
+		_, err = r.indexer.ReindexFolder(f)
+	}
+	if err != nil {
+		logger.Errorf("Unable to complete index request %d: %s", req.ID, err)
+	}
+
+	err = op.FinishIndexRequest(req, nil, err)
+	if err != nil {
+		logger.Errorf("Unable to record the outcome of index request %d: %s", req.ID, err)
+	}
+}
+
 // stop signals the cacher to stop ticking when it can
 func (r *runner) stop() {
 	r.needStop <- true
@@ -1,23 +1,95 @@
 package main
 
 import (
+	"os"
+
 	"github.com/uoregon-libraries/gopkg/interrupts"
+	"github.com/uoregon-libraries/gopkg/logger"
 	"github.com/uoregon-libraries/headlamp/src/db"
 	"github.com/uoregon-libraries/headlamp/src/indexer"
 )
 
 func main() {
-	var config = getCLI()
+	var config, opts = getCLI()
 	var dbh = db.New()
 	var i = indexer.New(dbh, config)
+	i.SetLinkPolicy(opts.links)
+	i.SetProgressReporter(newProgressReporter(dbh))
+	if opts.category != "" {
+		// A curator refreshing their collection has just finished a deposit,
+		// so there's no reason to wait for its inventories to age
+		i.SetCategory(opts.category)
+		i.SetMinAge(0)
+	}
 	var runner = &runner{
 		indexer:  i,
+		dbh:      dbh,
+		minAge:   indexer.DefaultMinAge,
 		needStop: make(chan bool, 1),
 		sigDone:  make(chan bool, 1),
 	}
 
+	if opts.dryRun {
+		var report, err = i.DryRun()
+		if err != nil {
+			logger.Fatalf("Unable to complete dry run: %s", err)
+		}
+		printDryRun(os.Stdout, report)
+		return
+	}
+
+	if opts.category != "" {
+		interrupts.TrapIntTerm(func() {
+			i.Stop()
+		})
+		var err = i.Index()
+		if err != nil {
+			logger.Fatalf("Unable to index category %q: %s", opts.category, err)
+		}
+		return
+	}
+
+	if opts.force != "" {
+		// Forcing an inventory means it's known to be complete, so it doesn't
+		// need to age either
+		var err = i.SetForce(opts.force)
+		if err != nil {
+			logger.Fatalf("Invalid inventory path %q: %s", opts.force, err)
+		}
+		i.SetMinAge(0)
+		interrupts.TrapIntTerm(func() {
+			i.Stop()
+		})
+		err = i.Index()
+		if err != nil {
+			logger.Fatalf("Unable to reindex %q: %s", opts.force, err)
+		}
+		return
+	}
+
+	if opts.watch {
+		var w, err = newWatcher(config)
+		if err != nil {
+			logger.Fatalf("Unable to watch for inventory changes: %s", err)
+		}
+		defer w.close()
+		go w.watch()
+
+		// The watcher only triggers a reindex once files have stopped changing,
+		// so there's no need for the usual hour-long wait; half the quiet period
+		// leaves plenty of slack for clock and timestamp granularity
+		runner.minAge = watchQuietPeriod / 2
+		i.SetMinAge(runner.minAge)
+		runner.trigger = w.trigger
+	}
+
 	interrupts.TrapIntTerm(func() {
 		runner.stop()
 	})
 	runner.run()
+
+	var err = dbh.Close()
+	if err != nil {
+		logger.Errorf("Unable to close database: %s", err)
+	}
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+	"github.com/uoregon-libraries/headlamp/src/indexer"
+)
+
+// progressWriteInterval is the least time between progress writes in the
+// middle of a run, so a run through thousands of tiny inventories doesn't
+// spend its time recording how it's doing
+const progressWriteInterval = time.Second * 2
+
+// dbProgressReporter stores the indexer's progress in the database, which is
+// where the web app reads it from
+type dbProgressReporter struct {
+	dbh       *db.Database
+	status    *db.IndexRunStatus
+	lastWrite time.Time
+}
+
+func newProgressReporter(dbh *db.Database) *dbProgressReporter {
+	return &dbProgressReporter{dbh: dbh}
+}
+
+// ReportProgress implements indexer.ProgressReporter.  The start and end of a
+// run are always written; reports in between are skipped if the last write
+// was too recent.
+func (r *dbProgressReporter) ReportProgress(p indexer.Progress) {
+	var starting = r.status == nil || !r.status.StartedAt.Equal(p.StartedAt)
+	if !starting && !p.Finished && time.Since(r.lastWrite) < progressWriteInterval {
+		return
+	}
+
+	if r.status == nil {
+		r.status = &db.IndexRunStatus{}
+	}
+	r.status.StartedAt = p.StartedAt
+	r.status.InventoriesTotal = p.InventoriesTotal
+	r.status.InventoriesDone = p.InventoriesDone
+	r.status.CurrentInventory = p.CurrentInventory
+	r.status.FilesProcessed = p.FilesProcessed
+	r.status.BytesTotal = p.BytesTotal
+	r.status.BytesDone = p.BytesDone
+	r.status.EstimatedFinish = p.ETA().UTC()
+	r.status.FinishedAt = time.Time{}
+	if p.Finished {
+		r.status.FinishedAt = time.Now().UTC()
+	}
+
+	r.lastWrite = time.Now()
+	var err = r.dbh.Operation().WriteIndexRunStatus(r.status)
+	if err != nil {
+		logger.Warnf("Unable to record index progress: %s", err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/config"
+	"github.com/uoregon-libraries/headlamp/src/indexer"
+)
+
+// watchQuietPeriod is how long an inventory must go without changes before
+// the watcher asks for a reindex.  Inventories are often copied in over
+// several seconds, and indexing half a file helps nobody.
+const watchQuietPeriod = time.Minute
+
+// watcher uses filesystem notifications to spot new or changed inventory
+// files as soon as they land
+type watcher struct {
+	fsw     *fsnotify.Watcher
+	roots   []*watchedRoot
+	trigger chan struct{}
+}
+
+// watchedRoot holds a dark archive root and the full patterns of the
+// inventory files which can be found in it
+type watchedRoot struct {
+	root      string
+	inventory string
+	manifest  string
+	json      string
+	bag       string
+}
+
+// newWatcher sets up notifications on every directory which could contain,
+// or lead to, an inventory file in any dark archive root
+func newWatcher(c *config.Config) (*watcher, error) {
+	var fsw, err = fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	var w = &watcher{fsw: fsw, trigger: make(chan struct{}, 1)}
+	for _, root := range c.Roots {
+		var wr = &watchedRoot{
+			root:      root.Path,
+			inventory: filepath.Join(root.Path, c.InventoryPattern),
+		}
+		if c.ManifestPattern != "" {
+			wr.manifest = filepath.Join(root.Path, c.ManifestPattern)
+		}
+		if c.JSONInventoryPattern != "" {
+			wr.json = filepath.Join(root.Path, c.JSONInventoryPattern)
+		}
+		if c.BagPattern != "" {
+			wr.bag = filepath.Join(root.Path, c.BagPattern, indexer.BagManifestName)
+		}
+		w.roots = append(w.roots, wr)
+	}
+	w.addWatches()
+	return w, nil
+}
+
+// addWatches watches each dark archive root and every directory matching
+// each level of the inventory, manifest, JSON inventory, and bag patterns, so
+// new directories are noticed as well as new files.  Already-watched
+// directories are harmlessly re-added.
+func (w *watcher) addWatches() {
+	var dirs []string
+	for _, wr := range w.roots {
+		dirs = append(dirs, wr.root)
+		for _, pattern := range []string{wr.inventory, wr.manifest, wr.json, wr.bag} {
+			if pattern == "" {
+				continue
+			}
+			var levels = strings.Split(filepath.Dir(strings.TrimPrefix(pattern, wr.root+string(filepath.Separator))), string(filepath.Separator))
+			for n := range levels {
+				var matches, err = filepath.Glob(filepath.Join(wr.root, filepath.Join(levels[:n+1]...)))
+				if err != nil {
+					logger.Errorf("Unable to search for directories to watch: %s", err)
+					return
+				}
+				dirs = append(dirs, matches...)
+			}
+		}
+	}
+
+	for _, dir := range dirs {
+		var info, err = os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		err = w.fsw.Add(dir)
+		if err != nil {
+			logger.Errorf("Unable to watch %q: %s", dir, err)
+		}
+	}
+}
+
+// watch processes filesystem events until the watcher is closed.  Each
+// change to an inventory file restarts the quiet period, and when it runs
+// out, a reindex is requested on the trigger channel.
+func (w *watcher) watch() {
+	var quiet = time.NewTimer(watchQuietPeriod)
+	quiet.Stop()
+
+	for {
+		select {
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			w.handle(ev, quiet)
+
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			logger.Errorf("Filesystem watch error: %s", err)
+
+		case <-quiet.C:
+			logger.Infof("Inventory changes have settled; requesting a reindex")
+			select {
+			case w.trigger <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// handle deals with a single event: new directories get watched, and
+// inventory changes (re)start the quiet period
+func (w *watcher) handle(ev fsnotify.Event, quiet *time.Timer) {
+	if ev.Op&fsnotify.Create != 0 {
+		var info, err = os.Stat(ev.Name)
+		if err == nil && info.IsDir() {
+			w.addWatches()
+			return
+		}
+	}
+
+	if ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) == 0 {
+		return
+	}
+	if !w.isInventory(ev.Name) {
+		return
+	}
+
+	logger.Debugf("Inventory %q changed (%s)", ev.Name, ev.Op)
+	quiet.Reset(watchQuietPeriod)
+}
+
+// isInventory returns true if the indexer would read the given file as an
+// inventory, manifest, JSON inventory, or bag manifest
+func (w *watcher) isInventory(fname string) bool {
+	for _, wr := range w.roots {
+		var matched, _ = filepath.Match(wr.inventory, fname)
+		if matched && !strings.HasSuffix(fname, "manifest.csv") {
+			return true
+		}
+		for _, pattern := range []string{wr.manifest, wr.json, wr.bag} {
+			if pattern == "" {
+				continue
+			}
+			matched, _ = filepath.Match(pattern, fname)
+			if matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// close stops the filesystem notifications
+func (w *watcher) close() {
+	w.fsw.Close()
+}
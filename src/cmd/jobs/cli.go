@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/uoregon-libraries/gopkg/wordutils"
+	"github.com/uoregon-libraries/headlamp/src/config"
+)
+
+var spaces = regexp.MustCompile(`\s+`)
+
+func perrraw(s string) {
+	fmt.Fprintln(os.Stderr, s)
+}
+
+func perr(s string) {
+	s = strings.TrimSpace(s)
+	s = spaces.ReplaceAllString(s, " ")
+	perrraw(wordutils.Wrap(s, 80))
+}
+func perrf(s string, args ...interface{}) {
+	perr(fmt.Sprintf(s, args...))
+}
+
+func usage(msg string) {
+	var status = 0
+	if msg != "" {
+		perr(msg)
+		perr("")
+		status = 1
+	}
+
+	perrf("Usage: %s <settings file> <command> [arguments]", os.Args[0])
+	perrraw("")
+	perrraw("Commands:")
+	for _, c := range commands {
+		perrraw(fmt.Sprintf("  %-24s %s", c.name+" "+c.args, c.help))
+	}
+
+	os.Exit(status)
+}
+
+// getCLI reads the settings file and returns the config, the command name,
+// and the command's arguments
+func getCLI() (*config.Config, string, []string) {
+	if len(os.Args) < 2 {
+		usage("You must specify a settings file")
+	}
+	if len(os.Args) < 3 {
+		usage("You must specify a command")
+	}
+
+	var c, err = config.Read(os.Args[1])
+	if err != nil {
+		perrf("Invalid configuration: %s", err)
+		os.Exit(1)
+	}
+
+	return c, os.Args[2], os.Args[3:]
+}
@@ -0,0 +1,150 @@
+// The jobs command lets operators inspect and manage the archive job queue
+// without hand-editing the database
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// command describes a single jobs subcommand
+type command struct {
+	name string
+	args string
+	help string
+	run  func(op *db.Operation, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"list", "[-status=S] [-email=E] [-page=N]", "List jobs, newest first, optionally filtered", list},
+		{"bump", "<id> [priority]", "Move a pending job to the front of the queue, or set its priority", bump},
+		{"cancel", "<id>", "Cancel a job which hasn't finished", cancel},
+		{"requeue", "<id>", "Retry a failed, cancelled, or stuck job from scratch", requeue},
+	}
+}
+
+func main() {
+	var _, name, args = getCLI()
+	var dbh = db.New()
+
+	for _, c := range commands {
+		if c.name == name {
+			var err = c.run(dbh.Operation(), args)
+			if err != nil {
+				perrf("Error: %s", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	usage(fmt.Sprintf("Unknown command %q", name))
+}
+
+// getJob looks up the job whose id is the first argument
+func getJob(op *db.Operation, args []string) (*db.ArchiveJob, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("you must specify a job id")
+	}
+	var id, err = strconv.Atoi(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid job id %q", args[0])
+	}
+
+	var j *db.ArchiveJob
+	j, err = op.FindArchiveJobByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if j == nil {
+		return nil, fmt.Errorf("job %d not found", id)
+	}
+	return j, nil
+}
+
+func list(op *db.Operation, args []string) error {
+	var fs = flag.NewFlagSet("list", flag.ContinueOnError)
+	var status = fs.String("status", "", "only show jobs with this status")
+	var email = fs.String("email", "", "only show jobs with a notification email containing this text")
+	var page = fs.Int("page", 1, "page of results to show")
+	var err = fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	var jobs []*db.ArchiveJob
+	var total uint64
+	jobs, total, err = op.ListArchiveJobs(*status, *email, *page)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-6s %-16s %-11s %-8s %-8s %-6s %s\n", "ID", "Created", "Status", "Priority", "Attempts", "Files", "Emails")
+	for _, j := range jobs {
+		fmt.Printf("%-6d %-16s %-11s %-8d %-8d %-6d %s\n", j.ID, j.CreatedAt.Format("2006-01-02 15:04"),
+			j.Status, j.Priority, j.Attempts, len(j.FileList()), j.NotificationEmails)
+	}
+	fmt.Printf("\nShowing %d of %d job(s), page %d\n", len(jobs), total, *page)
+	return nil
+}
+
+func bump(op *db.Operation, args []string) error {
+	var j, err = getJob(op, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 1 {
+		var priority int
+		priority, err = strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid priority %q", args[1])
+		}
+		err = op.SetArchiveJobPriority(j, priority)
+	} else {
+		err = op.BumpArchiveJob(j)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Job %d now has priority %d\n", j.ID, j.Priority)
+	return nil
+}
+
+func cancel(op *db.Operation, args []string) error {
+	var j, err = getJob(op, args)
+	if err != nil {
+		return err
+	}
+
+	err = op.CancelArchiveJob(j.ID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Job %d has been cancelled\n", j.ID)
+	return nil
+}
+
+func requeue(op *db.Operation, args []string) error {
+	var j, err = getJob(op, args)
+	if err != nil {
+		return err
+	}
+
+	err = op.RequeueArchiveJob(j.ID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Job %d has been requeued\n", j.ID)
+	return nil
+}
@@ -0,0 +1,282 @@
+// The maint command runs database maintenance and reporting tasks which are
+// too rare or too risky to happen automatically
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/uoregon-libraries/headlamp/src/config"
+	"github.com/uoregon-libraries/headlamp/src/db"
+	"github.com/uoregon-libraries/headlamp/src/indexer"
+)
+
+// command describes a single maint subcommand
+type command struct {
+	name string
+	args string
+	help string
+	run  func(dbh *db.Database, args []string) error
+}
+
+var commands []command
+
+// conf is the configuration read from the settings file
+var conf *config.Config
+
+func init() {
+	commands = []command{
+		{"orphans", "[remove]", "Report records whose parents are missing, deleting them if \"remove\" is given", orphans},
+		{"export", "<category>", "Write every file in the category to stdout as CSV", export},
+		{"merge", "<source> <destination>", "Move everything in the source category into the destination and delete the source", merge},
+		{"move", "<category> <folder> <new path>", "Move or rename a folder, rewriting the public paths beneath it", move},
+		{"missing", "[category]", "Write files no longer listed in their inventory to stdout as CSV", missing},
+		{"reindex", "<category> <folder>", "Rebuild the records beneath a folder from the inventories describing them", reindex},
+		{"normalize", "", "Convert stored category names and public paths to Unicode NFC", normalize},
+	}
+}
+
+func main() {
+	var name string
+	var args []string
+	conf, name, args = getCLI()
+	var dbh = db.New()
+
+	for _, c := range commands {
+		if c.name == name {
+			var err = c.run(dbh, args)
+			if err != nil {
+				perrf("Error: %s", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	usage(fmt.Sprintf("Unknown command %q", name))
+}
+
+func orphans(dbh *db.Database, args []string) error {
+	var remove bool
+	if len(args) > 0 {
+		if args[0] != "remove" {
+			return fmt.Errorf("unknown argument %q", args[0])
+		}
+		remove = true
+	}
+
+	var report *db.OrphanReport
+	var err = dbh.InTransaction(func(op *db.Operation) error {
+		var err error
+		report, err = op.CleanOrphans(remove)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Folders with no category: %d %v\n", len(report.Folders), report.Folders)
+	fmt.Printf("Real folders with no folder: %d %v\n", len(report.RealFolders), report.RealFolders)
+	fmt.Printf("Files with no folder: %d %v\n", len(report.Files), report.Files)
+
+	switch {
+	case report.Total() == 0:
+		fmt.Println("No orphans found")
+	case remove:
+		fmt.Printf("Removed %d orphaned record(s)\n", report.Total())
+	default:
+		fmt.Println("Nothing was removed; run again with \"remove\" to delete these records")
+	}
+	return nil
+}
+
+func export(dbh *db.Database, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("you must specify exactly one category name")
+	}
+
+	var op = dbh.Operation()
+	var c, err = findCategory(op, args[0])
+	if err != nil {
+		return err
+	}
+
+	return op.ExportCategory(c, os.Stdout)
+}
+
+func merge(dbh *db.Database, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("you must specify a source and destination category")
+	}
+
+	var report *db.MergeReport
+	var err = dbh.InTransaction(func(op *db.Operation) error {
+		var src, dest *db.Category
+		var err error
+		src, err = findCategory(op, args[0])
+		if err == nil {
+			dest, err = findCategory(op, args[1])
+		}
+		if err != nil {
+			return err
+		}
+		report, err = op.MergeCategories(src, dest)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Moved %d folder(s) and %d file(s)\n", report.MovedFolders, report.MovedFiles)
+	fmt.Printf("Combined %d folder(s) which existed in both categories\n", report.MergedFolders)
+	fmt.Printf("Dropped %d duplicate file(s) already in %q\n", len(report.DuplicateFiles), args[1])
+	for _, path := range report.Conflicts {
+		fmt.Printf("  Checksum mismatch (kept %q's record): %s\n", args[1], path)
+	}
+	fmt.Printf("Category %q has been removed\n", args[0])
+	return nil
+}
+
+func move(dbh *db.Database, args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("you must specify a category, a folder, and the folder's new path")
+	}
+
+	var newPath = filepath.Clean(args[2])
+	var parentPath, name = filepath.Split(newPath)
+	parentPath = strings.TrimSuffix(parentPath, string(filepath.Separator))
+
+	return dbh.InTransaction(func(op *db.Operation) error {
+		var c, err = findCategory(op, args[0])
+		if err != nil {
+			return err
+		}
+
+		var f, parent *db.Folder
+		f, err = findFolder(op, c, args[1])
+		if err != nil {
+			return err
+		}
+		if parentPath != "" {
+			parent, err = findFolder(op, c, parentPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		var oldPath = f.PublicPath
+		err = op.MoveFolder(f, parent, name)
+		if err == nil {
+			fmt.Printf("Moved %q to %q\n", oldPath, f.PublicPath)
+		}
+		return err
+	})
+}
+
+func missing(dbh *db.Database, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("you may specify at most one category name")
+	}
+
+	var op = dbh.Operation()
+	var c *db.Category
+	if len(args) == 1 {
+		var err error
+		c, err = findCategory(op, args[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	var files, err = op.MissingFiles(c)
+	if err != nil {
+		return err
+	}
+
+	var cw = csv.NewWriter(os.Stdout)
+	cw.Write([]string{"missing_since", "category", "archive_date", "public_path", "full_path"})
+	for _, f := range files {
+		cw.Write([]string{f.MissingSince.Format(time.RFC3339), f.Category.Name, f.ArchiveDate, f.PublicPath, f.FullPath})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func reindex(dbh *db.Database, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("you must specify a category and a folder")
+	}
+
+	var op = dbh.Operation()
+	var c, err = findCategory(op, args[0])
+	if err != nil {
+		return err
+	}
+	var f *db.Folder
+	f, err = findFolder(op, c, args[1])
+	if err != nil {
+		return err
+	}
+
+	var report *indexer.FolderReindexReport
+	report, err = indexer.New(dbh, conf).ReindexFolder(f)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Read %d inventories\n", len(report.Inventories))
+	for _, path := range report.Inventories {
+		fmt.Printf("  %s\n", path)
+	}
+	for _, path := range report.Missing {
+		fmt.Printf("  Not found on disk: %s\n", path)
+	}
+	fmt.Printf("Reindexed %d file(s) and removed %d which are no longer listed beneath %q\n", report.Indexed, report.Removed, f.PublicPath)
+	return nil
+}
+
+func normalize(dbh *db.Database, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("unknown argument %q", args[0])
+	}
+
+	var report *db.NormalizeReport
+	var err = dbh.InTransaction(func(op *db.Operation) error {
+		var err error
+		report, err = op.NormalizeStoredPaths()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Normalized categories: %d, folders: %d, files: %d\n", report.Categories, report.Folders, report.Files)
+	fmt.Printf("Left %d record(s) whose normalized name is already in use\n", len(report.Conflicts))
+	for _, c := range report.Conflicts {
+		fmt.Printf("  %s\n", c)
+	}
+	return nil
+}
+
+// findFolder returns the folder in c with the given public path or an error
+// if it doesn't exist
+func findFolder(op *db.Operation, c *db.Category, path string) (*db.Folder, error) {
+	var f, err = op.FindFolderByPath(c, filepath.Clean(path))
+	if err == nil && f == nil {
+		err = fmt.Errorf("folder %q not found in category %q", path, c.Name)
+	}
+	return f, err
+}
+
+// findCategory returns the named category or an error if it doesn't exist
+func findCategory(op *db.Operation, name string) (*db.Category, error) {
+	var c, err = op.FindCategoryByName(name)
+	if err == nil && c == nil {
+		err = fmt.Errorf("category %q not found", name)
+	}
+	return c, err
+}
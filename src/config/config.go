@@ -2,7 +2,11 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/uoregon-libraries/gopkg/bashconf"
@@ -10,25 +14,181 @@ import (
 
 // Config is used to define the configuration for both the indexer and the web server
 type Config struct {
-	BindAddress           string `setting:"BIND_ADDRESS"`
-	WebPath               string `setting:"WEBPATH" type:"url"`
-	Approot               string `setting:"APPROOT" type:"path"`
-	DARoot                string `setting:"DARK_ARCHIVE_PATH" type:"path"`
-	PathFormat            []PathToken
-	PathFormatString      string `setting:"ARCHIVE_PATH_FORMAT"`
-	InventoryPattern      string `setting:"INVENTORY_FILE_GLOB"`
-	ArchiveOutputLocation string `setting:"ARCHIVE_OUTPUT_LOCATION" type:"path"`
-	ArchiveLifetimeDays   int    `setting:"ARCHIVE_LIFETIME_DAYS" type:"int"`
-	SMTPUser              string `setting:"SMTP_USER"`
-	SMTPPass              string `setting:"SMTP_PASS"`
-	SMTPHost              string `setting:"SMTP_HOST"`
-	SMTPPort              int    `setting:"SMTP_PORT" type:"int"`
+	BindAddress             string `setting:"BIND_ADDRESS"`
+	TLSCertFile             string `setting:"TLS_CERT_FILE"`
+	TLSKeyFile              string `setting:"TLS_KEY_FILE"`
+	AutocertDomains         []string
+	AutocertDomainsString   string `setting:"TLS_AUTOCERT_DOMAINS"`
+	AutocertCacheDir        string `setting:"TLS_AUTOCERT_CACHE_DIR"`
+	AutocertEmail           string `setting:"TLS_AUTOCERT_EMAIL"`
+	HTTPRedirectAddress     string `setting:"HTTP_REDIRECT_ADDRESS"`
+	AccessLogFile           string `setting:"ACCESS_LOG_FILE"`
+	AccessLogFormat         string `setting:"ACCESS_LOG_FORMAT"`
+	AccessLogRotate         string `setting:"ACCESS_LOG_ROTATE"`
+	AccessLogMaxMB          int    `setting:"ACCESS_LOG_MAX_MB" type:"int"`
+	AccessLogKeep           int    `setting:"ACCESS_LOG_KEEP" type:"int"`
+	WebPath                 string `setting:"WEBPATH" type:"url"`
+	ProxyStripsPath         bool   `setting:"PROXY_STRIPS_PATH" type:"bool"`
+	Approot                 string `setting:"APPROOT" type:"path"`
+	DARoot                  string `setting:"DARK_ARCHIVE_PATH" type:"path"`
+	Roots                   []Root
+	ExtraRootsString        string `setting:"EXTRA_DARK_ARCHIVE_PATHS"`
+	PathFormat              []PathToken
+	PathFormatString        string `setting:"ARCHIVE_PATH_FORMAT"`
+	PathRules               []*PathRule
+	PathRulesFile           string `setting:"PATH_RULES_FILE"`
+	InventoryPattern        string `setting:"INVENTORY_FILE_GLOB"`
+	ManifestPattern         string `setting:"MANIFEST_FILE_GLOB"`
+	ManifestColumns         ManifestColumns
+	ManifestColumnsString   string `setting:"MANIFEST_COLUMNS"`
+	JSONInventoryPattern    string `setting:"JSON_INVENTORY_GLOB"`
+	BagPattern              string `setting:"BAG_GLOB"`
+	ExcludeGlobs            []string
+	ExcludeGlobsString      string `setting:"INDEX_EXCLUDE_GLOBS"`
+	IndexErrorReportDir     string `setting:"INDEX_ERROR_REPORT_DIR"`
+	ArchiveOutputLocation   string `setting:"ARCHIVE_OUTPUT_LOCATION" type:"path"`
+	ArchiveLifetimeDays     int    `setting:"ARCHIVE_LIFETIME_DAYS" type:"int"`
+	ZipStreamMaxMB          int    `setting:"ZIP_STREAM_MAX_MB" type:"int"`
+	QueueMaxGB              int    `setting:"QUEUE_MAX_GB" type:"int"`
+	ThumbnailCacheDir       string `setting:"THUMBNAIL_CACHE_DIR"`
+	ThumbnailSize           int    `setting:"THUMBNAIL_SIZE" type:"int"`
+	PageSize                int    `setting:"PAGE_SIZE" type:"int"`
+	ThemeDir                string `setting:"THEME_DIR"`
+	SitemapEnabled          bool   `setting:"SITEMAP_ENABLED" type:"bool"`
+	SiteTitle               string `setting:"SITE_TITLE"`
+	InstitutionName         string `setting:"INSTITUTION_NAME"`
+	LogoPath                string `setting:"LOGO_PATH"`
+	FooterText              string `setting:"FOOTER_TEXT"`
+	ContactEmail            string `setting:"CONTACT_EMAIL"`
+	SMTPUser                string `setting:"SMTP_USER"`
+	SMTPPass                string `setting:"SMTP_PASS"`
+	SMTPHost                string `setting:"SMTP_HOST"`
+	SMTPPort                int    `setting:"SMTP_PORT" type:"int"`
+	ArchiveMaxAttempts      int    `setting:"ARCHIVE_MAX_ATTEMPTS" type:"int"`
+	ArchiveRetryMinutes     int    `setting:"ARCHIVE_RETRY_MINUTES" type:"int"`
+	AdminEmails             string `setting:"ADMIN_EMAILS"`
+	StaffEmails             string `setting:"STAFF_EMAILS"`
+	IndexWorkers            int    `setting:"INDEX_WORKERS" type:"int"`
+	IndexFilesPerSecond     int    `setting:"INDEX_FILES_PER_SECOND" type:"int"`
+	ArchiveJobRetentionDays int    `setting:"ARCHIVE_JOB_RETENTION_DAYS" type:"int"`
+	DisposableEmailDomains  string `setting:"DISPOSABLE_EMAIL_DOMAINS"`
+	RateLimitPerIP          int    `setting:"RATE_LIMIT_PER_IP" type:"int"`
+	RateLimitPerUser        int    `setting:"RATE_LIMIT_PER_USER" type:"int"`
+	SessionLifetimeHours    int    `setting:"SESSION_LIFETIME_HOURS" type:"int"`
+	SessionIdleMinutes      int    `setting:"SESSION_IDLE_MINUTES" type:"int"`
+	AuthBackend             string `setting:"AUTH_BACKEND"`
+	LDAPURL                 string `setting:"LDAP_URL"`
+	LDAPStartTLS            bool   `setting:"LDAP_START_TLS" type:"bool"`
+	LDAPBindDN              string `setting:"LDAP_BIND_DN"`
+	LDAPBindPassword        string `setting:"LDAP_BIND_PASSWORD"`
+	LDAPBaseDN              string `setting:"LDAP_BASE_DN"`
+	LDAPUserFilter          string `setting:"LDAP_USER_FILTER"`
+	LDAPNameAttribute       string `setting:"LDAP_NAME_ATTRIBUTE"`
+	LDAPEmailAttribute      string `setting:"LDAP_EMAIL_ATTRIBUTE"`
+	SSOLoginHeader          string `setting:"SSO_LOGIN_HEADER"`
+	SSONameHeader           string `setting:"SSO_NAME_HEADER"`
+	SSOEmailHeader          string `setting:"SSO_EMAIL_HEADER"`
+	SSOGroupsHeader         string `setting:"SSO_GROUPS_HEADER"`
+	SSOAdminGroups          string `setting:"SSO_ADMIN_GROUPS"`
+	SSOStaffGroups          string `setting:"SSO_STAFF_GROUPS"`
+	SSOCuratorGroups        string `setting:"SSO_CURATOR_GROUPS"`
+	SSOTrustedProxies       []*net.IPNet
+	SSOTrustedProxiesString string `setting:"SSO_TRUSTED_PROXIES"`
+	OIDCIssuerURL           string `setting:"OIDC_ISSUER_URL"`
+	OIDCClientID            string `setting:"OIDC_CLIENT_ID"`
+	OIDCClientSecret        string `setting:"OIDC_CLIENT_SECRET"`
+	OIDCScopes              string `setting:"OIDC_SCOPES"`
+	OIDCLoginClaim          string `setting:"OIDC_LOGIN_CLAIM"`
+	OIDCGroupsClaim         string `setting:"OIDC_GROUPS_CLAIM"`
 }
 
+// AUTH_BACKEND values: AuthLDAP logs people in against an LDAP or Active
+// Directory server, AuthSSO trusts the identity a single sign-on proxy (e.g.,
+// Apache with mod_shib) puts in request headers, and AuthOIDC sends people to
+// an OpenID Connect provider such as Keycloak
+const (
+	AuthLDAP = "ldap"
+	AuthSSO  = "sso"
+	AuthOIDC = "oidc"
+)
+
+// defaults holds the values for optional settings, which are used when a
+// settings file doesn't specify them
+const defaults = `
+PROXY_STRIPS_PATH=false
+TLS_CERT_FILE=""
+TLS_KEY_FILE=""
+TLS_AUTOCERT_DOMAINS=""
+TLS_AUTOCERT_CACHE_DIR=""
+TLS_AUTOCERT_EMAIL=""
+HTTP_REDIRECT_ADDRESS=""
+ACCESS_LOG_FILE=""
+ACCESS_LOG_FORMAT="combined"
+ACCESS_LOG_ROTATE="daily"
+ACCESS_LOG_MAX_MB=100
+ACCESS_LOG_KEEP=0
+ARCHIVE_MAX_ATTEMPTS=5
+ARCHIVE_RETRY_MINUTES=60
+ADMIN_EMAILS=""
+STAFF_EMAILS=""
+INDEX_WORKERS=4
+INDEX_FILES_PER_SECOND=0
+ARCHIVE_JOB_RETENTION_DAYS=30
+DISPOSABLE_EMAIL_DOMAINS="mailinator.com,guerrillamail.com,sharklasers.com,10minutemail.com,temp-mail.org,tempmail.com,yopmail.com,trashmail.com,maildrop.cc,dispostable.com,getnada.com,throwawaymail.com"
+RATE_LIMIT_PER_IP=60
+RATE_LIMIT_PER_USER=300
+SESSION_LIFETIME_HOURS=24
+SESSION_IDLE_MINUTES=0
+ZIP_STREAM_MAX_MB=100
+QUEUE_MAX_GB=500
+THUMBNAIL_CACHE_DIR=""
+THUMBNAIL_SIZE=160
+PAGE_SIZE=100
+SITEMAP_ENABLED=false
+THEME_DIR=""
+SITE_TITLE="Headlamp"
+INSTITUTION_NAME=""
+LOGO_PATH=""
+FOOTER_TEXT=""
+CONTACT_EMAIL=""
+MANIFEST_FILE_GLOB=""
+MANIFEST_COLUMNS="path=path,size=size,sha256=sha256,mtime=mtime"
+JSON_INVENTORY_GLOB=""
+BAG_GLOB=""
+PATH_RULES_FILE=""
+INDEX_EXCLUDE_GLOBS=""
+EXTRA_DARK_ARCHIVE_PATHS=""
+INDEX_ERROR_REPORT_DIR=""
+AUTH_BACKEND=""
+LDAP_URL=""
+LDAP_START_TLS=false
+LDAP_BIND_DN=""
+LDAP_BIND_PASSWORD=""
+LDAP_BASE_DN=""
+LDAP_USER_FILTER="(uid=%s)"
+LDAP_NAME_ATTRIBUTE="cn"
+LDAP_EMAIL_ATTRIBUTE="mail"
+SSO_LOGIN_HEADER="X-Remote-User"
+SSO_NAME_HEADER="displayName"
+SSO_EMAIL_HEADER="mail"
+SSO_GROUPS_HEADER="isMemberOf"
+SSO_ADMIN_GROUPS=""
+SSO_STAFF_GROUPS=""
+SSO_CURATOR_GROUPS=""
+SSO_TRUSTED_PROXIES="127.0.0.1,::1"
+OIDC_ISSUER_URL=""
+OIDC_CLIENT_ID=""
+OIDC_CLIENT_SECRET=""
+OIDC_SCOPES="openid profile email"
+OIDC_LOGIN_CLAIM="preferred_username"
+OIDC_GROUPS_CLAIM="groups"
+`
+
 // Read opens the given file and reads its configuration
 func Read(filename string) (*Config, error) {
 	var conf = bashconf.New()
 	conf.EnvironmentPrefix("HL_")
+	conf.ParseString(defaults)
 
 	var err = conf.ParseFile(filename)
 	if err != nil {
@@ -39,10 +199,126 @@ func Read(filename string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = c.parseRoots()
+	if err != nil {
+		return nil, fmt.Errorf("invalid EXTRA_DARK_ARCHIVE_PATHS %q: %s", c.ExtraRootsString, err)
+	}
 	err = c.parsePathFormat()
 	if err != nil {
 		return nil, fmt.Errorf("invalid ARCHIVE_PATH_FORMAT %q: %s", c.PathFormatString, err)
 	}
+	err = c.readPathRules()
+	if err != nil {
+		return nil, fmt.Errorf("invalid PATH_RULES_FILE %q: %s", c.PathRulesFile, err)
+	}
+	err = c.parseExcludeGlobs()
+	if err != nil {
+		return nil, fmt.Errorf("invalid INDEX_EXCLUDE_GLOBS %q: %s", c.ExcludeGlobsString, err)
+	}
+	err = c.parseManifestColumns()
+	if err != nil {
+		return nil, fmt.Errorf("invalid MANIFEST_COLUMNS %q: %s", c.ManifestColumnsString, err)
+	}
+	if c.ArchiveMaxAttempts < 1 {
+		return nil, fmt.Errorf("invalid ARCHIVE_MAX_ATTEMPTS %d: must be at least 1", c.ArchiveMaxAttempts)
+	}
+	if c.ArchiveRetryMinutes < 1 {
+		return nil, fmt.Errorf("invalid ARCHIVE_RETRY_MINUTES %d: must be at least 1", c.ArchiveRetryMinutes)
+	}
+	if c.ArchiveJobRetentionDays < 1 {
+		return nil, fmt.Errorf("invalid ARCHIVE_JOB_RETENTION_DAYS %d: must be at least 1", c.ArchiveJobRetentionDays)
+	}
+	if c.ZipStreamMaxMB < 0 {
+		return nil, fmt.Errorf("invalid ZIP_STREAM_MAX_MB %d: must not be negative", c.ZipStreamMaxMB)
+	}
+	if c.QueueMaxGB < 0 {
+		return nil, fmt.Errorf("invalid QUEUE_MAX_GB %d: must not be negative", c.QueueMaxGB)
+	}
+	if c.IndexErrorReportDir != "" {
+		var info, err = os.Stat(c.IndexErrorReportDir)
+		if err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("invalid INDEX_ERROR_REPORT_DIR %q: must be a directory", c.IndexErrorReportDir)
+		}
+	}
+	if c.ThumbnailCacheDir != "" {
+		var info, err = os.Stat(c.ThumbnailCacheDir)
+		if err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("invalid THUMBNAIL_CACHE_DIR %q: must be a directory", c.ThumbnailCacheDir)
+		}
+		if c.ThumbnailSize < 16 {
+			return nil, fmt.Errorf("invalid THUMBNAIL_SIZE %d: must be at least 16", c.ThumbnailSize)
+		}
+	}
+	if c.ThemeDir != "" {
+		var info, err = os.Stat(c.ThemeDir)
+		if err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("invalid THEME_DIR %q: must be a directory", c.ThemeDir)
+		}
+	}
+	if c.PageSize < 1 || c.PageSize > 1000 {
+		return nil, fmt.Errorf("invalid PAGE_SIZE %d: must be between 1 and 1000", c.PageSize)
+	}
+	if c.IndexWorkers < 1 {
+		return nil, fmt.Errorf("invalid INDEX_WORKERS %d: must be at least 1", c.IndexWorkers)
+	}
+	if c.IndexFilesPerSecond < 0 {
+		return nil, fmt.Errorf("invalid INDEX_FILES_PER_SECOND %d: must not be negative", c.IndexFilesPerSecond)
+	}
+	if c.RateLimitPerIP < 0 {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_PER_IP %d: must not be negative", c.RateLimitPerIP)
+	}
+	if c.RateLimitPerUser < 0 {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_PER_USER %d: must not be negative", c.RateLimitPerUser)
+	}
+	if c.SessionLifetimeHours < 1 {
+		return nil, fmt.Errorf("invalid SESSION_LIFETIME_HOURS %d: must be at least 1", c.SessionLifetimeHours)
+	}
+	if c.SessionIdleMinutes < 0 {
+		return nil, fmt.Errorf("invalid SESSION_IDLE_MINUTES %d: must not be negative", c.SessionIdleMinutes)
+	}
+	switch c.AccessLogFormat {
+	case "common", "combined", "json":
+	default:
+		return nil, fmt.Errorf("invalid ACCESS_LOG_FORMAT %q: must be \"common\", \"combined\", or \"json\"", c.AccessLogFormat)
+	}
+	switch c.AccessLogRotate {
+	case "never", "hourly", "daily", "weekly", "monthly":
+	default:
+		return nil, fmt.Errorf("invalid ACCESS_LOG_ROTATE %q: must be \"never\", \"hourly\", \"daily\", \"weekly\", or \"monthly\"", c.AccessLogRotate)
+	}
+	if c.AccessLogMaxMB < 0 {
+		return nil, fmt.Errorf("invalid ACCESS_LOG_MAX_MB %d: must not be negative", c.AccessLogMaxMB)
+	}
+	if c.AccessLogKeep < 0 {
+		return nil, fmt.Errorf("invalid ACCESS_LOG_KEEP %d: must not be negative", c.AccessLogKeep)
+	}
+	if c.ProxyStripsPath {
+		var u, _ = url.Parse(c.WebPath)
+		if strings.Trim(u.Path, "/") == "" {
+			return nil, fmt.Errorf("invalid WEBPATH %q: must include a path when PROXY_STRIPS_PATH is true", c.WebPath)
+		}
+	}
+	if strings.TrimSpace(c.SiteTitle) == "" {
+		return nil, fmt.Errorf("invalid SITE_TITLE: must not be empty")
+	}
+	if c.ContactEmail != "" {
+		_, err = mail.ParseAddress(c.ContactEmail)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONTACT_EMAIL %q: %s", c.ContactEmail, err)
+		}
+	}
+	err = c.parseTrustedProxies()
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSO_TRUSTED_PROXIES %q: %s", c.SSOTrustedProxiesString, err)
+	}
+	err = c.validateAuth()
+	if err != nil {
+		return nil, err
+	}
+	err = c.validateTLS()
+	if err != nil {
+		return nil, err
+	}
 
 	return c, nil
 }
@@ -83,3 +359,150 @@ func (c *Config) parsePathFormat() error {
 
 	return nil
 }
+
+// parseExcludeGlobs splits INDEX_EXCLUDE_GLOBS on commas and makes sure each
+// pattern is valid
+func (c *Config) parseExcludeGlobs() error {
+	for _, pattern := range strings.Split(c.ExcludeGlobsString, ",") {
+		pattern = strings.Trim(strings.TrimSpace(pattern), string(os.PathSeparator))
+		if pattern == "" {
+			continue
+		}
+		var _, err = filepath.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("%q: %s", pattern, err)
+		}
+		c.ExcludeGlobs = append(c.ExcludeGlobs, pattern)
+	}
+	return nil
+}
+
+// validateAuth makes sure the settings for the chosen AUTH_BACKEND are usable
+func (c *Config) validateAuth() error {
+	switch c.AuthBackend {
+	case "":
+		return nil
+	case AuthLDAP:
+		if c.LDAPURL == "" {
+			return fmt.Errorf("LDAP_URL must be set when AUTH_BACKEND is %q", AuthLDAP)
+		}
+		if c.LDAPBaseDN == "" {
+			return fmt.Errorf("LDAP_BASE_DN must be set when AUTH_BACKEND is %q", AuthLDAP)
+		}
+		if strings.Count(c.LDAPUserFilter, "%s") != 1 {
+			return fmt.Errorf("invalid LDAP_USER_FILTER %q: must contain exactly one %%s", c.LDAPUserFilter)
+		}
+		return nil
+	case AuthSSO:
+		if c.SSOLoginHeader == "" {
+			return fmt.Errorf("SSO_LOGIN_HEADER must be set when AUTH_BACKEND is %q", AuthSSO)
+		}
+		if len(c.SSOTrustedProxies) == 0 {
+			return fmt.Errorf("SSO_TRUSTED_PROXIES must be set when AUTH_BACKEND is %q", AuthSSO)
+		}
+		return nil
+	case AuthOIDC:
+		var u, err = url.Parse(c.OIDCIssuerURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid OIDC_ISSUER_URL %q: must be an http or https URL", c.OIDCIssuerURL)
+		}
+		if c.OIDCClientID == "" {
+			return fmt.Errorf("OIDC_CLIENT_ID must be set when AUTH_BACKEND is %q", AuthOIDC)
+		}
+		if c.OIDCLoginClaim == "" {
+			return fmt.Errorf("OIDC_LOGIN_CLAIM must be set when AUTH_BACKEND is %q", AuthOIDC)
+		}
+		if !strings.Contains(" "+c.OIDCScopes+" ", " openid ") {
+			return fmt.Errorf("invalid OIDC_SCOPES %q: must include \"openid\"", c.OIDCScopes)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid AUTH_BACKEND %q: must be empty, %q, %q, or %q", c.AuthBackend, AuthLDAP, AuthSSO, AuthOIDC)
+}
+
+// TLSEnabled returns true if the web server should serve HTTPS itself rather
+// than leaving that to a proxy in front of it
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || len(c.AutocertDomains) > 0
+}
+
+// validateTLS checks that the HTTPS settings are complete and don't
+// contradict each other: a certificate and key come as a pair, Let's Encrypt
+// certificates need somewhere to be cached, and WEBPATH has to agree that the
+// site is served over HTTPS
+func (c *Config) validateTLS() error {
+	for _, s := range strings.Split(c.AutocertDomainsString, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s != "" {
+			c.AutocertDomains = append(c.AutocertDomains, s)
+		}
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.TLSCertFile != "" && len(c.AutocertDomains) > 0 {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_AUTOCERT_DOMAINS cannot both be set")
+	}
+	if len(c.AutocertDomains) > 0 {
+		if c.AutocertCacheDir == "" {
+			return fmt.Errorf("TLS_AUTOCERT_CACHE_DIR must be set when TLS_AUTOCERT_DOMAINS is set")
+		}
+		if c.AutocertEmail != "" {
+			var _, err = mail.ParseAddress(c.AutocertEmail)
+			if err != nil {
+				return fmt.Errorf("invalid TLS_AUTOCERT_EMAIL %q: %s", c.AutocertEmail, err)
+			}
+		}
+	}
+	if !c.TLSEnabled() {
+		if c.HTTPRedirectAddress != "" {
+			return fmt.Errorf("HTTP_REDIRECT_ADDRESS requires TLS_CERT_FILE or TLS_AUTOCERT_DOMAINS")
+		}
+		return nil
+	}
+
+	var u, _ = url.Parse(c.WebPath)
+	if u.Scheme != "https" {
+		return fmt.Errorf("invalid WEBPATH %q: must be an https URL when the server handles TLS", c.WebPath)
+	}
+	if len(c.AutocertDomains) > 0 {
+		var host = strings.ToLower(u.Hostname())
+		for _, d := range c.AutocertDomains {
+			if d == host {
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid TLS_AUTOCERT_DOMAINS %q: must include WEBPATH's host, %q", c.AutocertDomainsString, host)
+	}
+	return nil
+}
+
+// parseTrustedProxies splits SSO_TRUSTED_PROXIES on commas into networks.
+// Bare IP addresses are treated as a network of just that address.
+func (c *Config) parseTrustedProxies() error {
+	for _, s := range strings.Split(c.SSOTrustedProxiesString, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			var ip = net.ParseIP(s)
+			if ip == nil {
+				return fmt.Errorf("%q is not an IP address or CIDR range", s)
+			}
+			var bits = 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			c.SSOTrustedProxies = append(c.SSOTrustedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		var _, n, err = net.ParseCIDR(s)
+		if err != nil {
+			return fmt.Errorf("%q is not an IP address or CIDR range", s)
+		}
+		c.SSOTrustedProxies = append(c.SSOTrustedProxies, n)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ManifestColumns names the header of each column the indexer needs from a
+// CSV manifest.  ModTime may be empty for manifests which don't record it.
+type ManifestColumns struct {
+	Path     string
+	Filesize string
+	Checksum string
+	ModTime  string
+}
+
+// parseManifestColumns reads the MANIFEST_COLUMNS setting, a comma-separated
+// list of field=header pairs, such as "path=path,size=size,sha256=sha256"
+func (c *Config) parseManifestColumns() error {
+	var mc = &c.ManifestColumns
+	for _, pair := range strings.Split(c.ManifestColumnsString, ",") {
+		var parts = strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return fmt.Errorf("%q must be formatted as field=header", pair)
+		}
+
+		var field, header = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		var dest *string
+		switch field {
+		case "path":
+			dest = &mc.Path
+		case "size":
+			dest = &mc.Filesize
+		case "sha256":
+			dest = &mc.Checksum
+		case "mtime":
+			dest = &mc.ModTime
+		default:
+			return fmt.Errorf("unknown field %q", field)
+		}
+		if *dest != "" {
+			return fmt.Errorf("%q must be specified only once", field)
+		}
+		*dest = header
+	}
+
+	if mc.Path == "" || mc.Filesize == "" || mc.Checksum == "" {
+		return fmt.Errorf(`"path", "size", and "sha256" must all be specified`)
+	}
+	return nil
+}
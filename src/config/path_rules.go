@@ -0,0 +1,90 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// PathRule is a single custom rule for collapsing a real path into a public
+// path.  Pattern is matched against a file's path relative to the dark
+// archive root, and must capture "date" and "public" groups.  The category
+// name comes from the "category" group unless the rule names a fixed
+// Category.
+type PathRule struct {
+	Pattern  *regexp.Regexp
+	Category string
+}
+
+// pathRuleCategorySep separates a rule's pattern from its optional fixed
+// category name
+const pathRuleCategorySep = " => "
+
+// readPathRules loads the rules in PATH_RULES_FILE, if one is set.  Each
+// non-blank line not starting with "#" is a regular expression, optionally
+// followed by " => " and a category name.
+func (c *Config) readPathRules() error {
+	if c.PathRulesFile == "" {
+		return nil
+	}
+
+	var f, err = os.Open(c.PathRulesFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var s = bufio.NewScanner(f)
+	var lineNum int
+	for s.Scan() {
+		lineNum++
+		var line = strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var rule, err = parsePathRule(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", lineNum, err)
+		}
+		c.PathRules = append(c.PathRules, rule)
+	}
+
+	return s.Err()
+}
+
+// parsePathRule compiles a single rule and makes sure it captures everything
+// the indexer needs
+func parsePathRule(line string) (*PathRule, error) {
+	var rule = &PathRule{}
+	var expr = line
+	var idx = strings.LastIndex(line, pathRuleCategorySep)
+	if idx >= 0 {
+		expr = strings.TrimSpace(line[:idx])
+		rule.Category = strings.TrimSpace(line[idx+len(pathRuleCategorySep):])
+		if rule.Category == "" || strings.ContainsRune(rule.Category, os.PathSeparator) {
+			return nil, fmt.Errorf("invalid category name %q", rule.Category)
+		}
+	}
+
+	var err error
+	rule.Pattern, err = regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups = make(map[string]bool)
+	for _, name := range rule.Pattern.SubexpNames() {
+		groups[name] = true
+	}
+	if !groups["date"] || !groups["public"] {
+		return nil, fmt.Errorf(`pattern %q must capture "date" and "public" groups`, expr)
+	}
+	if groups["category"] == (rule.Category != "") {
+		return nil, fmt.Errorf(`pattern %q must either capture a "category" group or be given a category name, but not both`, expr)
+	}
+
+	return rule, nil
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Root is a dark archive root directory.  The main root, DARK_ARCHIVE_PATH,
+// has no name; extra roots are named in EXTRA_DARK_ARCHIVE_PATHS.  Indexed
+// paths are relative to their root, and the root's name is stored with them.
+type Root struct {
+	Name string
+	Path string
+}
+
+var validRootName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseRoots reads the EXTRA_DARK_ARCHIVE_PATHS setting, a comma-separated
+// list of name=path pairs, such as "pod2=/mnt/pod2,pod3=/mnt/pod3".  The main
+// dark archive root is always first in the list.
+func (c *Config) parseRoots() error {
+	c.Roots = []Root{{Name: "", Path: c.DARoot}}
+	for _, pair := range strings.Split(c.ExtraRootsString, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		var parts = strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%q must be formatted as name=path", pair)
+		}
+
+		var name, path = strings.TrimSpace(parts[0]), filepath.Clean(strings.TrimSpace(parts[1]))
+		if !validRootName.MatchString(name) {
+			return fmt.Errorf("root name %q may only contain letters, numbers, dashes, and underscores", name)
+		}
+		if _, err := c.RootPath(name); err == nil {
+			return fmt.Errorf("root name %q must be specified only once", name)
+		}
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("root %q path %q must be absolute", name, path)
+		}
+		var info, err = os.Stat(path)
+		if err != nil || !info.IsDir() {
+			return fmt.Errorf("root %q path %q must be a directory", name, path)
+		}
+
+		c.Roots = append(c.Roots, Root{Name: name, Path: path})
+	}
+	return nil
+}
+
+// RootPath returns the directory of the named dark archive root
+func (c *Config) RootPath(name string) (string, error) {
+	for _, r := range c.Roots {
+		if r.Name == name {
+			return r.Path, nil
+		}
+	}
+	return "", fmt.Errorf("unknown dark archive root %q", name)
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// API token scopes.  A token may hold any combination of these.
+const (
+	// ScopeRead allows browsing, searching, and reading file details
+	ScopeRead = "read"
+
+	// ScopeRequestArchive allows queueing archive jobs and checking on them
+	ScopeRequestArchive = "request-archive"
+
+	// ScopeAdmin grants every other scope, and lets the token see restricted
+	// items and files' dark archive locations, just like staff
+	ScopeAdmin = "admin"
+)
+
+// APIScopes lists every valid scope, in the order they're presented to admins
+var APIScopes = []string{ScopeRead, ScopeRequestArchive, ScopeAdmin}
+
+// APIToken maps to the api_tokens table.  Only the token's hash is stored;
+// the token itself is given to the admin who issues it and never seen again.
+type APIToken struct {
+	ID        int `sql:",primary"`
+	Name      string
+	TokenHash string
+	Scopes    string // Scopes is a comma-separated list from APIScopes
+	UserID    int    // UserID is the admin who issued the token
+	CreatedAt time.Time
+
+	// LastUsedAt is zero until the token is first used
+	LastUsedAt time.Time
+
+	// RevokedAt is set once a token is revoked; revoked tokens are kept for
+	// the record, but can't be used
+	RevokedAt time.Time
+}
+
+// ScopeList returns the token's scopes
+func (t *APIToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return nil
+	}
+	return strings.Split(t.Scopes, ",")
+}
+
+// HasScope returns true if the token was granted the given scope, or the
+// admin scope, which implies all others
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Revoked returns true if the token has been revoked
+func (t *APIToken) Revoked() bool {
+	return !t.RevokedAt.IsZero()
+}
+
+// hashToken returns the hex-encoded hash we store in place of a secret token
+func hashToken(token string) string {
+	var sum = sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// validScope returns true if s is one of APIScopes
+func validScope(s string) bool {
+	for _, scope := range APIScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIToken issues a new token with the given name and scopes,
+// attributed to u.  The returned string is the token itself, which must be
+// handed to the requester now since only its hash is stored.
+func (op *Operation) CreateAPIToken(u *User, name string, scopes []string) (*APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", fmt.Errorf("no token name given")
+	}
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("a token needs at least one scope")
+	}
+	for _, s := range scopes {
+		if !validScope(s) {
+			return nil, "", fmt.Errorf("unknown scope %q", s)
+		}
+	}
+
+	var token, err = randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var t = &APIToken{
+		Name:      name,
+		TokenHash: hashToken(token),
+		Scopes:    strings.Join(scopes, ","),
+		CreatedAt: time.Now(),
+	}
+	if u != nil {
+		t.UserID = u.ID
+	}
+	op.APITokens.Save(t)
+	return t, token, op.Operation.Err()
+}
+
+// FindAPIToken returns the unrevoked token matching the given token string,
+// or nil if there isn't one
+func (op *Operation) FindAPIToken(token string) (*APIToken, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	var t = &APIToken{}
+	var ok = op.APITokens.Select().Where("token_hash = ?", hashToken(token)).First(t)
+	if !ok || t.Revoked() {
+		return nil, op.Operation.Err()
+	}
+	return t, op.Operation.Err()
+}
+
+// AllAPITokens returns every token, including revoked ones, newest first
+func (op *Operation) AllAPITokens() ([]*APIToken, error) {
+	var tokens []*APIToken
+	op.APITokens.Select().Order("created_at DESC, id DESC").AllObjects(&tokens)
+	return tokens, op.Operation.Err()
+}
+
+// TouchAPIToken records that the token was just used
+func (op *Operation) TouchAPIToken(t *APIToken) error {
+	t.LastUsedAt = time.Now()
+	op.Operation.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", t.LastUsedAt, t.ID)
+	return op.Operation.Err()
+}
+
+// RevokeAPIToken revokes the token with the given id.  It's an error to
+// revoke a token which doesn't exist or was already revoked.
+func (op *Operation) RevokeAPIToken(id int) error {
+	var res = op.Operation.Exec("UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND revoked_at = ?",
+		time.Now(), id, time.Time{})
+	if op.Operation.Err() != nil {
+		return op.Operation.Err()
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("token %d doesn't exist or was already revoked", id)
+	}
+	return nil
+}
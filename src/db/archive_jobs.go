@@ -0,0 +1,589 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Archive job statuses.  A job starts out pending, moves to in-progress when
+// the archiver picks it up, and ends up succeeded, failed, or cancelled.
+const (
+	JobStatusPending    = "pending"
+	JobStatusInProgress = "in_progress"
+	JobStatusSucceeded  = "succeeded"
+	JobStatusFailed     = "failed"
+	JobStatusCancelled  = "cancelled"
+)
+
+// ErrArchiveJobCancelled is returned by archive job callbacks which noticed
+// their job was cancelled and stopped early
+var ErrArchiveJobCancelled = errors.New("archive job was cancelled")
+
+// ErrArchiveJobChanged is returned when a job's status was changed by
+// somebody else between reading the job and updating it
+var ErrArchiveJobChanged = errors.New("archive job was changed by another process")
+
+// ErrArchiveJobInterrupted is returned by archive job callbacks which stopped
+// early because the archiver is shutting down
+var ErrArchiveJobInterrupted = errors.New("archive job was interrupted by a shutdown")
+
+// jobTransitions defines which statuses a job may move to from its current
+// status.  Anything not listed here is an invalid transition.
+var jobTransitions = map[string][]string{
+	JobStatusPending:    {JobStatusInProgress, JobStatusCancelled},
+	JobStatusInProgress: {JobStatusSucceeded, JobStatusFailed, JobStatusPending, JobStatusCancelled},
+
+	// Failed and cancelled jobs only go back to pending when an admin requeues
+	// them
+	JobStatusFailed:    {JobStatusPending},
+	JobStatusCancelled: {JobStatusPending},
+}
+
+// The ArchiveJob structure maps to archive_jobs, storing RS-separated files and
+// comma-separated notification email(s).  The record represents a single
+// archive creation request.
+type ArchiveJob struct {
+	ID                 int `sql:",primary"`
+	UserID             int
+	CreatedAt          time.Time
+	NextAttemptAt      time.Time
+	NotificationEmails string
+	Files              string
+	Status             string
+	LastError          string
+	Attempts           int
+	Priority           int
+
+	// FinishedAt is set when the job reaches a final status
+	FinishedAt time.Time
+
+	// ArchivePath is the filename, relative to the archive output location, of
+	// the archive a successful job produced
+	ArchivePath string
+
+	// StatusTokenHash is the hash of the secret token in the job's status page
+	// URL.  The token itself is only known when the job is queued.
+	StatusTokenHash string
+
+	// FilesDone is how many files the current attempt has added to the archive
+	FilesDone int
+
+	// UpdatedAt is when the job was last written to.  The archiver keeps it
+	// current while the job is in progress; see StuckArchiveJobAge.
+	UpdatedAt time.Time
+}
+
+// StuckArchiveJobAge is how long an in-progress job can go without an update
+// before it's considered stuck.  The archiver touches its in-progress job with
+// every heartbeat, so a job this stale was orphaned by an archiver which died
+// without a chance to put it back in the queue.
+const StuckArchiveJobAge = HeartbeatInterval * 5
+
+// RetryPolicy tells ProcessArchiveJob how many times to attempt a job and how
+// long to wait after the first failure.  Each subsequent wait is twice as long
+// as the last, up to a maximum of one day.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+}
+
+// maxRetryDelay caps the exponential backoff so a job is never put off for
+// longer than a day
+const maxRetryDelay = time.Hour * 24
+
+// Delay returns how long to wait before the next attempt of a job which has
+// failed the given number of times
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	var d = p.BaseDelay
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= maxRetryDelay {
+			return maxRetryDelay
+		}
+	}
+	return d
+}
+
+// Emails parses the email addresses as mail.Addr instances and returns them as
+// a list of strings, ensuring the strings are split properly in cases where
+// the email addressee has a comma in the name, e.g.:
+//
+//	"John Doe, III" <jdoeiii@example.org>, Alice <alice@example.org>
+//
+// Errors are ignored, as the database shouldn't get emails in any way other
+// than from a pre-validated email list
+func (j *ArchiveJob) Emails() []string {
+	var eList, _ = mail.ParseAddressList(j.NotificationEmails)
+	var sList = make([]string, len(eList))
+	for i, e := range eList {
+		sList[i] = e.String()
+	}
+	return sList
+}
+
+// FileList splits the files field and returns them as a list
+func (j *ArchiveJob) FileList() []string {
+	return strings.Split(j.Files, "\x1E")
+}
+
+// Percent returns how much of the job's current attempt is done, from 0 to 100
+func (j *ArchiveJob) Percent() float64 {
+	var total = len(j.FileList())
+	if total == 0 {
+		return 0
+	}
+	return float64(j.FilesDone) * 100 / float64(total)
+}
+
+// Stuck returns true if the job is in progress but hasn't been updated in
+// StuckArchiveJobAge
+func (j *ArchiveJob) Stuck() bool {
+	return j.Status == JobStatusInProgress && time.Since(j.UpdatedAt) > StuckArchiveJobAge
+}
+
+// CanTransition returns true if the job is allowed to move to the given status
+func (j *ArchiveJob) CanTransition(status string) bool {
+	for _, s := range jobTransitions[j.Status] {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// jobFinished returns true if status is one of the final statuses
+func jobFinished(status string) bool {
+	return status == JobStatusSucceeded || status == JobStatusFailed || status == JobStatusCancelled
+}
+
+// transitionArchiveJob moves the job to the new status, storing the fields
+// the archiver works with along the way.  The update only applies if the job
+// still has the status j was read with, so a change somebody else made in the
+// meantime, like a cancellation, isn't overwritten; ErrArchiveJobChanged is
+// returned instead.  j's priority is never written, since admins may change
+// it while the job runs.
+func (op *Operation) transitionArchiveJob(j *ArchiveJob, status string) error {
+	if !j.CanTransition(status) {
+		return fmt.Errorf("archive job %d cannot move from %q to %q", j.ID, j.Status, status)
+	}
+
+	var now = time.Now().UTC()
+	var finished = j.FinishedAt
+	if jobFinished(status) {
+		finished = now
+	}
+	var res = op.Operation.Exec("UPDATE archive_jobs SET status = ?, attempts = ?, files_done = ?, last_error = ?,"+
+		" next_attempt_at = ?, finished_at = ?, archive_path = ?, updated_at = ? WHERE id = ? AND status = ?",
+		status, j.Attempts, j.FilesDone, j.LastError, j.NextAttemptAt, finished, j.ArchivePath, now, j.ID, j.Status)
+	if op.Operation.Err() != nil {
+		return op.Operation.Err()
+	}
+	if res.RowsAffected() == 0 {
+		return ErrArchiveJobChanged
+	}
+
+	j.Status = status
+	j.FinishedAt = finished
+	j.UpdatedAt = now
+	return nil
+}
+
+// QueueArchiveJob creates a new archive job in the database for async
+// processing, attributing it to the given user (if any).  The returned string
+// is the token for the job's status page, which must be given to the
+// requester now since only its hash is stored.
+func (op *Operation) QueueArchiveJob(u *User, addrs []*mail.Address, files []*File) (*ArchiveJob, string, error) {
+	if len(files) == 0 {
+		return nil, "", fmt.Errorf("no files to archive")
+	}
+
+	if len(addrs) == 0 {
+		return nil, "", fmt.Errorf("no notification addresses for archive job")
+	}
+
+	var token, err = randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var filePaths []string
+	for _, f := range files {
+		filePaths = append(filePaths, f.ArchivePath())
+	}
+
+	var emails []string
+	for _, addr := range addrs {
+		emails = append(emails, addr.String())
+	}
+
+	var j = &ArchiveJob{
+		CreatedAt:          time.Now(),
+		UpdatedAt:          time.Now().UTC(),
+		NotificationEmails: strings.Join(emails, ","),
+		Files:              strings.Join(filePaths, "\x1E"),
+		Status:             JobStatusPending,
+		StatusTokenHash:    hashToken(token),
+	}
+	if u != nil {
+		j.UserID = u.ID
+	}
+	op.ArchiveJobs.Save(j)
+	op.recordArchiveRequest(j, files)
+	return j, token, op.Operation.Err()
+}
+
+// FindArchiveJobByStatusToken returns the archive job whose status page uses
+// the given token, or nil if there isn't one
+func (op *Operation) FindArchiveJobByStatusToken(token string) (*ArchiveJob, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	var j = &ArchiveJob{}
+	var ok = op.ArchiveJobs.Select().Where("status_token_hash = ?", hashToken(token)).First(j)
+	if !ok {
+		j = nil
+	}
+	return j, op.Operation.Err()
+}
+
+// ArchiveJobQueuePosition returns where a pending job stands in the queue: 1
+// if it's next, 2 if one job is ahead of it, etc.  Jobs ahead of it are those
+// ProcessArchiveJob would pick first, plus any already in progress.  Zero is
+// returned for jobs which aren't pending.
+func (op *Operation) ArchiveJobQueuePosition(j *ArchiveJob) (int, error) {
+	if j.Status != JobStatusPending {
+		return 0, nil
+	}
+
+	var ahead int
+	var rows = op.Operation.Query("SELECT COUNT(*) FROM archive_jobs WHERE status = ? OR (status = ? AND "+
+		"(priority > ? OR (priority = ? AND created_at < ?)))",
+		JobStatusInProgress, JobStatusPending, j.Priority, j.Priority, j.CreatedAt)
+	if rows.Next() {
+		rows.Scan(&ahead)
+	}
+	rows.Close()
+	return ahead + 1, op.Operation.Err()
+}
+
+// SetArchiveJobProgress records that the job's current attempt has added
+// done files to its archive
+func (op *Operation) SetArchiveJobProgress(j *ArchiveJob, done int) error {
+	j.FilesDone = done
+	j.UpdatedAt = time.Now().UTC()
+	op.Operation.Exec("UPDATE archive_jobs SET files_done = ?, updated_at = ? WHERE id = ?", done, j.UpdatedAt, j.ID)
+	return op.Operation.Err()
+}
+
+// TouchArchiveJobs records that every in-progress job is still being worked
+// on.  The archiver calls this with each heartbeat, even while it's busy
+// adding a single large file, so an in-progress job only goes stale when the
+// archiver working on it is gone.
+func (op *Operation) TouchArchiveJobs() error {
+	op.Operation.Exec("UPDATE archive_jobs SET updated_at = ? WHERE status = ?", time.Now().UTC(), JobStatusInProgress)
+	return op.Operation.Err()
+}
+
+// ArchiveQueueSnapshot summarizes the state of every pending or in-progress
+// archive job, plus the jobs with the given ids whatever their status.  The
+// summary changes whenever any of those jobs moves along, and whenever a
+// pending job's place in line might have changed, so comparing snapshots is
+// a cheap way to tell whether anybody's status page needs an update.
+func (op *Operation) ArchiveQueueSnapshot(ids []int) (string, error) {
+	var where = "status IN (?, ?)"
+	var args = []interface{}{JobStatusPending, JobStatusInProgress}
+	if len(ids) > 0 {
+		where += " OR id IN (" + strings.Repeat("?, ", len(ids)-1) + "?)"
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+
+	var parts []string
+	var rows = op.Operation.Query("SELECT id, status, files_done, attempts, priority FROM archive_jobs WHERE "+
+		where+" ORDER BY id", args...)
+	for rows.Next() {
+		var id, done, attempts, priority int
+		var status string
+		rows.Scan(&id, &status, &done, &attempts, &priority)
+		parts = append(parts, fmt.Sprintf("%d:%s:%d:%d:%d", id, status, done, attempts, priority))
+	}
+	rows.Close()
+	return strings.Join(parts, ","), op.Operation.Err()
+}
+
+// FindArchiveJobByID returns the archive job with the given id, or nil if none
+// is found
+func (op *Operation) FindArchiveJobByID(id int) (*ArchiveJob, error) {
+	var j = &ArchiveJob{}
+	var ok = op.ArchiveJobs.Select().Where("id = ?", id).First(j)
+	if !ok {
+		j = nil
+	}
+	return j, op.Operation.Err()
+}
+
+// FindArchiveJobsByIDs returns the archive jobs with the given ids, newest first
+func (op *Operation) FindArchiveJobsByIDs(ids []int) ([]*ArchiveJob, error) {
+	var jobs []*ArchiveJob
+	if len(ids) == 0 {
+		return jobs, nil
+	}
+
+	var where = "id IN (" + strings.Repeat("?, ", len(ids)-1) + "?)"
+	var args []interface{}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	op.ArchiveJobs.Select().Where(where, args...).Order("created_at DESC").AllObjects(&jobs)
+	return jobs, op.Operation.Err()
+}
+
+// ArchiveJobsPerPage is the number of jobs ListArchiveJobs returns at once
+const ArchiveJobsPerPage = 50
+
+// ListArchiveJobs returns a page of archive jobs, newest first, along with
+// the total number of matching jobs.  Pages start at 1.  If status is
+// non-empty, only jobs with that status are returned; if email is non-empty,
+// only jobs with a notification address containing it are returned.
+func (op *Operation) ListArchiveJobs(status, email string, page int) ([]*ArchiveJob, uint64, error) {
+	if page < 1 {
+		page = 1
+	}
+
+	var where []string
+	var args []interface{}
+	if status != "" {
+		where = append(where, "status = ?")
+		args = append(args, status)
+	}
+	if email != "" {
+		where = append(where, "notification_emails LIKE ?")
+		args = append(args, "%"+email+"%")
+	}
+
+	var sel = op.ArchiveJobs.Select()
+	if len(where) > 0 {
+		sel = sel.Where(strings.Join(where, " AND "), args...)
+	}
+	var total = sel.Count().RowCount()
+
+	var jobs []*ArchiveJob
+	sel = sel.Order("created_at DESC, id DESC").Limit(ArchiveJobsPerPage).Offset(uint64((page - 1) * ArchiveJobsPerPage))
+	sel.AllObjects(&jobs)
+	return jobs, total, op.Operation.Err()
+}
+
+// ProcessArchiveJob pulls the highest-priority pending archive job (the
+// longest-waiting one when priorities are equal), marks it as in progress, and
+// runs the callback with it.  If the callback returns no error, the job is
+// marked as succeeded.  Otherwise the error is recorded on
+// the job, and it goes back to pending to be retried after the policy's
+// backoff delay, unless it has used up its attempts, in which case it's
+// marked as failed.  If the job is cancelled while the callback is running,
+// its cancelled status is left alone.  If the callback returns
+// ErrArchiveJobInterrupted, the job goes back to pending without using up an
+// attempt.  If no job is found, the callback isn't run.
+func (op *Operation) ProcessArchiveJob(p RetryPolicy, cb func(*ArchiveJob) error) error {
+	var j = &ArchiveJob{}
+	var sel = op.ArchiveJobs.Select().Where("next_attempt_at < ? AND status = ?", time.Now(), JobStatusPending)
+	var ok = sel.Order("priority DESC, created_at ASC").Limit(1).First(j)
+	if op.Operation.Err() != nil {
+		return op.Operation.Err()
+	}
+	if !ok {
+		return nil
+	}
+
+	j.Attempts++
+	j.FilesDone = 0
+	var err = op.transitionArchiveJob(j, JobStatusInProgress)
+	if err == ErrArchiveJobChanged {
+		// The job was cancelled between finding it and claiming it
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = op.finishArchiveJob(p, j, cb(j))
+	if err == ErrArchiveJobChanged {
+		return op.reloadArchiveJobStatus(j)
+	}
+	return err
+}
+
+// finishArchiveJob moves a job out of progress according to how its callback
+// went
+func (op *Operation) finishArchiveJob(p RetryPolicy, j *ArchiveJob, jobErr error) error {
+	if jobErr == nil {
+		j.LastError = ""
+		return op.transitionArchiveJob(j, JobStatusSucceeded)
+	}
+
+	if jobErr == ErrArchiveJobInterrupted {
+		j.Attempts--
+		j.NextAttemptAt = time.Now()
+		return op.transitionArchiveJob(j, JobStatusPending)
+	}
+
+	j.LastError = jobErr.Error()
+	if j.Attempts >= p.MaxAttempts {
+		return op.transitionArchiveJob(j, JobStatusFailed)
+	}
+	j.NextAttemptAt = time.Now().Add(p.Delay(j.Attempts))
+	return op.transitionArchiveJob(j, JobStatusPending)
+}
+
+// reloadArchiveJobStatus reads the status somebody else gave j, such as a
+// cancellation, so callers see where the job really ended up
+func (op *Operation) reloadArchiveJobStatus(j *ArchiveJob) error {
+	var current, err = op.FindArchiveJobByID(j.ID)
+	if err != nil || current == nil {
+		return err
+	}
+	j.Status = current.Status
+	j.FinishedAt = current.FinishedAt
+	return nil
+}
+
+// SetArchiveJobPriority changes the given job's priority.  Only pending jobs
+// can be reprioritized, since priority only matters for picking the next job
+// to run.  Just the priority is written, and only if the job is still pending,
+// so nothing the archiver changes at the same time is lost.
+func (op *Operation) SetArchiveJobPriority(j *ArchiveJob, priority int) error {
+	var res = op.Operation.Exec("UPDATE archive_jobs SET priority = ? WHERE id = ? AND status = ?",
+		priority, j.ID, JobStatusPending)
+	if op.Operation.Err() != nil {
+		return op.Operation.Err()
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("archive job %d is no longer pending; only pending jobs can be reprioritized", j.ID)
+	}
+
+	j.Priority = priority
+	return nil
+}
+
+// BumpArchiveJob moves the given job to the front of the queue by giving it a
+// priority one higher than any other pending job
+func (op *Operation) BumpArchiveJob(j *ArchiveJob) error {
+	var top = &ArchiveJob{}
+	var ok = op.ArchiveJobs.Select().Where("status = ? AND id <> ?", JobStatusPending, j.ID).
+		Order("priority DESC").Limit(1).First(top)
+	if op.Operation.Err() != nil {
+		return op.Operation.Err()
+	}
+
+	var priority = j.Priority
+	if ok && top.Priority >= priority {
+		priority = top.Priority + 1
+	}
+	return op.SetArchiveJobPriority(j, priority)
+}
+
+// CancelArchiveJob marks the job with the given id as cancelled.  Only jobs
+// which haven't finished can be cancelled.  A job which is already in progress
+// will be stopped by the archiver the next time it checks for cancellation.
+// The status is changed with a single conditional update, so a cancellation
+// can't be lost to, or undo, the archiver's own changes to the job.
+func (op *Operation) CancelArchiveJob(id int) error {
+	var now = time.Now().UTC()
+	var res = op.Operation.Exec("UPDATE archive_jobs SET status = ?, finished_at = ?, updated_at = ? WHERE id = ? AND status IN (?, ?)",
+		JobStatusCancelled, now, now, id, JobStatusPending, JobStatusInProgress)
+	if op.Operation.Err() != nil {
+		return op.Operation.Err()
+	}
+	if res.RowsAffected() > 0 {
+		return nil
+	}
+
+	var j, err = op.FindArchiveJobByID(id)
+	if err != nil {
+		return err
+	}
+	if j == nil {
+		return fmt.Errorf("archive job %d not found", id)
+	}
+	return fmt.Errorf("archive job %d is %s; only unfinished jobs can be cancelled", id, j.Status)
+}
+
+// RequeueArchiveJob puts a failed, cancelled, or stuck job back in the queue
+// with a clean slate: its attempt count is reset and it's eligible to be
+// picked up immediately.  The last error is kept for reference until the next
+// attempt replaces it.  An in-progress job counts as stuck once it hasn't been
+// updated in StuckArchiveJobAge, which means the archiver running it died;
+// ResetArchiveJobs would put it back when the archiver restarts, but this
+// doesn't have to wait for that.
+//
+// The job is changed with a single conditional update, so it can't be
+// requeued twice, or requeued after something else has moved it on.
+func (op *Operation) RequeueArchiveJob(id int) error {
+	var now = time.Now().UTC()
+	var res = op.Operation.Exec("UPDATE archive_jobs SET status = ?, attempts = 0, next_attempt_at = ?, finished_at = ?,"+
+		" updated_at = ? WHERE id = ? AND (status IN (?, ?) OR (status = ? AND updated_at < ?))",
+		JobStatusPending, time.Now(), time.Time{}, now, id, JobStatusFailed, JobStatusCancelled,
+		JobStatusInProgress, now.Add(-StuckArchiveJobAge))
+	if op.Operation.Err() != nil {
+		return op.Operation.Err()
+	}
+	if res.RowsAffected() > 0 {
+		return nil
+	}
+
+	var j, err = op.FindArchiveJobByID(id)
+	if err != nil {
+		return err
+	}
+	if j == nil {
+		return fmt.Errorf("archive job %d not found", id)
+	}
+	if j.Status == JobStatusInProgress {
+		return fmt.Errorf("archive job %d is still being worked on; in-progress jobs can only be requeued "+
+			"once they've gone %s without an update", id, StuckArchiveJobAge)
+	}
+	return fmt.Errorf("archive job %d is %s; only failed, cancelled, or stuck jobs can be requeued", id, j.Status)
+}
+
+// ResetArchiveJobs puts jobs which were in progress back in the queue.  The
+// archiver calls this at startup, since anything still in progress then was
+// interrupted when the archiver last exited.  The interrupted attempt still
+// counts, in case the job itself is what brought the archiver down.
+func (op *Operation) ResetArchiveJobs() (int64, error) {
+	var res = op.Operation.Exec("UPDATE archive_jobs SET status = ?, updated_at = ? WHERE status = ?",
+		JobStatusPending, time.Now().UTC(), JobStatusInProgress)
+	return res.RowsAffected(), op.Operation.Err()
+}
+
+// FinishedArchiveJobsBefore returns jobs which reached a final status before
+// the given time
+func (op *Operation) FinishedArchiveJobsBefore(t time.Time) ([]*ArchiveJob, error) {
+	var jobs []*ArchiveJob
+	op.ArchiveJobs.Select().Where("status IN (?, ?, ?) AND finished_at < ?",
+		JobStatusSucceeded, JobStatusFailed, JobStatusCancelled, t.UTC()).Order("finished_at").AllObjects(&jobs)
+	return jobs, op.Operation.Err()
+}
+
+// DeleteArchiveJob removes the job from the database.  Only finished jobs can
+// be deleted.
+func (op *Operation) DeleteArchiveJob(j *ArchiveJob) error {
+	if !jobFinished(j.Status) {
+		return fmt.Errorf("archive job %d is %s; only finished jobs can be deleted", j.ID, j.Status)
+	}
+	op.Operation.Exec("DELETE FROM archive_jobs WHERE id = ?", j.ID)
+	return op.Operation.Err()
+}
+
+// ArchiveJobCancelled reads the given job's status from the database and
+// returns true if it has been cancelled
+func (op *Operation) ArchiveJobCancelled(j *ArchiveJob) (bool, error) {
+	var current, err = op.FindArchiveJobByID(j.ID)
+	if err != nil || current == nil {
+		return false, err
+	}
+	return current.Status == JobStatusCancelled, nil
+}
@@ -0,0 +1,257 @@
+package db_test
+
+import (
+	"errors"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// queueJob adds a pending archive job for a single fixture file
+func queueJob(t *testing.T, op *db.Operation) *db.ArchiveJob {
+	var c = mustCategory(t, op, "Photos")
+	var f, err = op.FindFileByPublicPath(c.ID, "2018-01-01", "events/2018/party.tif")
+	if err != nil || f == nil {
+		t.Fatalf("Unable to find party.tif (error: %v)", err)
+	}
+
+	var j *db.ArchiveJob
+	j, _, err = op.QueueArchiveJob(nil, []*mail.Address{{Address: "jdoe@example.org"}}, []*db.File{f})
+	if err != nil {
+		t.Fatalf("Unable to queue archive job: %s", err)
+	}
+	return j
+}
+
+// mustJob reads the job's current state from the database
+func mustJob(t *testing.T, op *db.Operation, id int) *db.ArchiveJob {
+	var j, err = op.FindArchiveJobByID(id)
+	if err != nil || j == nil {
+		t.Fatalf("Unable to find archive job %d (error: %v)", id, err)
+	}
+	return j
+}
+
+var testPolicy = db.RetryPolicy{MaxAttempts: 3}
+
+func TestCancelArchiveJob(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var op = dbh.Operation()
+
+	var j = queueJob(t, op)
+	var err = op.CancelArchiveJob(j.ID)
+	if err != nil {
+		t.Fatalf("Unable to cancel pending job: %s", err)
+	}
+	var stored = mustJob(t, op, j.ID)
+	if stored.Status != db.JobStatusCancelled || stored.FinishedAt.IsZero() {
+		t.Errorf("Expected a cancelled job with a finish time, got %q at %s", stored.Status, stored.FinishedAt)
+	}
+
+	err = op.CancelArchiveJob(j.ID)
+	if err == nil {
+		t.Errorf("Expected an error cancelling a cancelled job")
+	}
+	err = op.CancelArchiveJob(j.ID + 100)
+	if err == nil {
+		t.Errorf("Expected an error cancelling a job which doesn't exist")
+	}
+}
+
+func TestCancelArchiveJobInProgress(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var op = dbh.Operation()
+
+	var queued = queueJob(t, op)
+	var processed *db.ArchiveJob
+	var err = op.ProcessArchiveJob(testPolicy, func(j *db.ArchiveJob) error {
+		processed = j
+		var err = dbh.Operation().CancelArchiveJob(j.ID)
+		if err != nil {
+			t.Errorf("Unable to cancel in-progress job: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to process job: %s", err)
+	}
+	if processed == nil || processed.ID != queued.ID {
+		t.Fatalf("Expected job %d to be processed, got %#v", queued.ID, processed)
+	}
+	if processed.Status != db.JobStatusCancelled {
+		t.Errorf("Expected the processed job to report it was cancelled, got %q", processed.Status)
+	}
+
+	var stored = mustJob(t, op, queued.ID)
+	if stored.Status != db.JobStatusCancelled {
+		t.Errorf("Expected the archiver not to overwrite the cancellation, got %q", stored.Status)
+	}
+}
+
+func TestProcessArchiveJob(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var op = dbh.Operation()
+
+	var queued = queueJob(t, op)
+	var err = op.ProcessArchiveJob(testPolicy, func(j *db.ArchiveJob) error {
+		if j.Status != db.JobStatusInProgress {
+			t.Errorf("Expected the job to be in progress, got %q", j.Status)
+		}
+		j.ArchivePath = "archive-1.tar"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to process job: %s", err)
+	}
+
+	var stored = mustJob(t, op, queued.ID)
+	if stored.Status != db.JobStatusSucceeded || stored.ArchivePath != "archive-1.tar" || stored.Attempts != 1 {
+		t.Errorf("Expected a succeeded job with its archive path, got %#v", stored)
+	}
+
+	var ran bool
+	err = op.ProcessArchiveJob(testPolicy, func(j *db.ArchiveJob) error {
+		ran = true
+		return nil
+	})
+	if err != nil || ran {
+		t.Errorf("Expected no job to run with an empty queue (ran: %v, error: %v)", ran, err)
+	}
+}
+
+func TestSetArchiveJobPriority(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var op = dbh.Operation()
+
+	var j = queueJob(t, op)
+	var err = op.SetArchiveJobPriority(j, 5)
+	if err != nil {
+		t.Fatalf("Unable to reprioritize pending job: %s", err)
+	}
+	if mustJob(t, op, j.ID).Priority != 5 {
+		t.Errorf("Expected priority 5 to be stored")
+	}
+
+	err = op.ProcessArchiveJob(testPolicy, func(*db.ArchiveJob) error {
+		var err = dbh.Operation().SetArchiveJobPriority(j, 10)
+		if err == nil {
+			t.Errorf("Expected an error reprioritizing an in-progress job")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to process job: %s", err)
+	}
+
+	var stored = mustJob(t, op, j.ID)
+	if stored.Priority != 5 || stored.Status != db.JobStatusSucceeded {
+		t.Errorf("Expected a succeeded job with priority 5, got %q with priority %d", stored.Status, stored.Priority)
+	}
+}
+
+func TestRequeueArchiveJob(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var op = dbh.Operation()
+
+	var j = queueJob(t, op)
+	var err = op.RequeueArchiveJob(j.ID)
+	if err == nil {
+		t.Errorf("Expected an error requeueing a pending job")
+	}
+
+	err = op.CancelArchiveJob(j.ID)
+	if err != nil {
+		t.Fatalf("Unable to cancel job: %s", err)
+	}
+	err = op.RequeueArchiveJob(j.ID)
+	if err != nil {
+		t.Fatalf("Unable to requeue cancelled job: %s", err)
+	}
+	var stored = mustJob(t, op, j.ID)
+	if stored.Status != db.JobStatusPending || !stored.FinishedAt.IsZero() {
+		t.Errorf("Expected a pending, unfinished job, got %q finished at %s", stored.Status, stored.FinishedAt)
+	}
+
+	var policy = db.RetryPolicy{MaxAttempts: 1}
+	err = op.ProcessArchiveJob(policy, func(*db.ArchiveJob) error {
+		var err = dbh.Operation().RequeueArchiveJob(j.ID)
+		if err == nil {
+			t.Errorf("Expected an error requeueing an in-progress job")
+		}
+		return errors.New("disk full")
+	})
+	if err != nil {
+		t.Fatalf("Unable to process job: %s", err)
+	}
+	if mustJob(t, op, j.ID).Status != db.JobStatusFailed {
+		t.Fatalf("Expected the job to fail")
+	}
+
+	err = op.RequeueArchiveJob(j.ID)
+	if err != nil {
+		t.Fatalf("Unable to requeue failed job: %s", err)
+	}
+	stored = mustJob(t, op, j.ID)
+	if stored.Status != db.JobStatusPending || stored.Attempts != 0 || stored.LastError != "disk full" {
+		t.Errorf("Expected a pending job with no attempts and its last error, got %#v", stored)
+	}
+
+	err = op.RequeueArchiveJob(j.ID + 100)
+	if err == nil {
+		t.Errorf("Expected an error requeueing a job which doesn't exist")
+	}
+}
+
+func TestRequeueStuckArchiveJob(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var op = dbh.Operation()
+
+	var j = queueJob(t, op)
+	var err = op.ProcessArchiveJob(testPolicy, func(running *db.ArchiveJob) error {
+		var op = dbh.Operation()
+		if running.Stuck() {
+			t.Errorf("Expected a job which was just claimed not to be stuck")
+		}
+		var err = op.TouchArchiveJobs()
+		if err != nil {
+			t.Fatalf("Unable to touch in-progress jobs: %s", err)
+		}
+		err = op.RequeueArchiveJob(j.ID)
+		if err == nil {
+			t.Errorf("Expected an error requeueing a job which is still being updated")
+		}
+
+		// Simulate the archiver dying partway through by backdating the job's
+		// last update past the stuck threshold
+		var stale = time.Now().UTC().Add(-db.StuckArchiveJobAge - time.Minute)
+		op.Operation.Exec("UPDATE archive_jobs SET updated_at = ? WHERE id = ?", stale, j.ID)
+		if op.Operation.Err() != nil {
+			t.Fatalf("Unable to backdate job: %s", op.Operation.Err())
+		}
+		if !mustJob(t, op, j.ID).Stuck() {
+			t.Errorf("Expected a job with no recent updates to be stuck")
+		}
+		err = op.RequeueArchiveJob(j.ID)
+		if err != nil {
+			t.Errorf("Unable to requeue stuck job: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to process job: %s", err)
+	}
+
+	var stored = mustJob(t, op, j.ID)
+	if stored.Status != db.JobStatusPending || stored.Attempts != 0 {
+		t.Errorf("Expected the stuck job to be pending with no attempts, got %q with %d attempts",
+			stored.Status, stored.Attempts)
+	}
+}
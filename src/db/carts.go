@@ -0,0 +1,94 @@
+package db
+
+import (
+	"strconv"
+	"time"
+)
+
+// Cart owner prefixes: carts belong to a user or, for anonymous visitors, to
+// a random key stored in their session
+const (
+	userCartPrefix    = "user:"
+	sessionCartPrefix = "session:"
+)
+
+// UserCartOwner returns the owner key for the given user's cart
+func UserCartOwner(u *User) string {
+	return userCartPrefix + strconv.Itoa(u.ID)
+}
+
+// SessionCartOwner returns the owner key for an anonymous session's cart
+func SessionCartOwner(key string) string {
+	return sessionCartPrefix + key
+}
+
+// NewCartKey generates a random key for an anonymous session's cart
+func NewCartKey() (string, error) {
+	return randomToken()
+}
+
+// CartFileIDs returns the ids of every file in the owner's cart
+func (op *Operation) CartFileIDs(owner string) ([]uint64, error) {
+	var rows = op.Operation.Query("SELECT file_id FROM carts WHERE owner = ?", owner)
+	var ids []uint64
+	for rows.Next() {
+		var id uint64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	return ids, op.Operation.Err()
+}
+
+// CartSize returns the number of files in the owner's cart and the sum of
+// their recorded sizes
+func (op *Operation) CartSize(owner string) (files int, bytes int64, err error) {
+	var rows = op.Operation.Query("SELECT COUNT(*), COALESCE(SUM(f.filesize), 0) FROM carts c "+
+		"JOIN files f ON f.id = c.file_id WHERE c.owner = ?", owner)
+	if rows.Next() {
+		rows.Scan(&files, &bytes)
+	}
+	rows.Close()
+	return files, bytes, op.Operation.Err()
+}
+
+// AddToCart puts the file into the owner's cart.  Adding a file which is
+// already there does nothing.  Times are stored in UTC so that they compare
+// correctly as strings.
+func (op *Operation) AddToCart(owner string, fileID uint64) error {
+	op.Operation.Exec("INSERT OR IGNORE INTO carts (owner, file_id, added_at) VALUES (?, ?, ?)",
+		owner, fileID, time.Now().UTC())
+	return op.Operation.Err()
+}
+
+// RemoveFromCart takes the file out of the owner's cart
+func (op *Operation) RemoveFromCart(owner string, fileID uint64) error {
+	op.Operation.Exec("DELETE FROM carts WHERE owner = ? AND file_id = ?", owner, fileID)
+	return op.Operation.Err()
+}
+
+// ClearCart empties the owner's cart
+func (op *Operation) ClearCart(owner string) error {
+	op.Operation.Exec("DELETE FROM carts WHERE owner = ?", owner)
+	return op.Operation.Err()
+}
+
+// MergeCart moves everything in one owner's cart into another's, e.g., when
+// an anonymous visitor logs in.  Files already in the destination are kept
+// once.
+func (op *Operation) MergeCart(from, to string) error {
+	op.Operation.Exec("UPDATE OR IGNORE carts SET owner = ? WHERE owner = ?", to, from)
+	op.Operation.Exec("DELETE FROM carts WHERE owner = ?", from)
+	return op.Operation.Err()
+}
+
+// DeleteStaleSessionCarts removes anonymous carts which haven't had a file
+// added since before the given time, returning the number of rows removed.
+// Their sessions are long gone, so nobody can reach them anymore.  Users'
+// carts are never removed.
+func (op *Operation) DeleteStaleSessionCarts(before time.Time) (int64, error) {
+	var res = op.Operation.Exec("DELETE FROM carts WHERE owner IN ("+
+		"SELECT owner FROM carts WHERE owner LIKE ? GROUP BY owner HAVING MAX(added_at) < ?)",
+		sessionCartPrefix+"%", before.UTC())
+	return res.RowsAffected(), op.Operation.Err()
+}
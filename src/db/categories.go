@@ -0,0 +1,83 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// CategoryStats summarizes what a category holds
+type CategoryStats struct {
+	*Category
+	Folders      int64
+	Files        int64
+	Bytes        int64
+	MissingFiles int64
+}
+
+// AllCategoryStats returns every category op can see, in name order, along
+// with its folder and file counts
+func (op *Operation) AllCategoryStats() ([]*CategoryStats, error) {
+	var categories, err = op.AllCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	var lookup = make(map[int]*CategoryStats)
+	var list []*CategoryStats
+	for _, c := range categories {
+		var s = &CategoryStats{Category: c}
+		lookup[c.ID] = s
+		list = append(list, s)
+	}
+
+	var rows = op.Operation.Query("SELECT category_id, COUNT(*) FROM folders GROUP BY category_id")
+	for rows.Next() {
+		var id int
+		var n int64
+		rows.Scan(&id, &n)
+		if lookup[id] != nil {
+			lookup[id].Folders = n
+		}
+	}
+	rows.Close()
+
+	rows = op.Operation.Query("SELECT category_id, COUNT(*), COALESCE(SUM(filesize), 0), "+
+		"COALESCE(SUM(missing_since > ?), 0) FROM files GROUP BY category_id", time.Time{})
+	for rows.Next() {
+		var id int
+		var files, bytes, missing int64
+		rows.Scan(&id, &files, &bytes, &missing)
+		if lookup[id] != nil {
+			lookup[id].Files, lookup[id].Bytes, lookup[id].MissingFiles = files, bytes, missing
+		}
+	}
+	rows.Close()
+
+	return list, op.Operation.Err()
+}
+
+// SetCategoryHidden hides the category from, or shows it on, the category
+// list for non-staff users
+func (op *Operation) SetCategoryHidden(c *Category, hidden bool) error {
+	c.Hidden = hidden
+	op.Categories.Save(c)
+	return op.Operation.Err()
+}
+
+// SetCategoryCrawlable opens the category to search engines, or closes it
+func (op *Operation) SetCategoryCrawlable(c *Category, crawlable bool) error {
+	c.Crawlable = crawlable
+	op.Categories.Save(c)
+	return op.Operation.Err()
+}
+
+// SetCategoryAccessRole changes the role a user needs to see anything in the
+// category.  An empty role lets everybody see it.
+func (op *Operation) SetCategoryAccessRole(c *Category, role string) error {
+	if role != "" && RoleRank(role) < 0 {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	c.AccessRole = role
+	op.Categories.Save(c)
+	return op.Operation.Err()
+}
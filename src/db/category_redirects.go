@@ -0,0 +1,72 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CategoryRedirect maps to the category_redirects table, which remembers the
+// names categories used to have
+type CategoryRedirect struct {
+	ID         int `sql:",primary"`
+	OldName    string
+	CategoryID int
+}
+
+// RenameCategory changes the category's name, remembering the old name so
+// that old URLs and future inventories using it still find the category.
+// Nothing else stores category names, so no other records change.
+func (op *Operation) RenameCategory(c *Category, newName string) error {
+	newName = NormalizePath(strings.TrimSpace(newName))
+	if newName == "" || strings.ContainsAny(newName, `/\`) {
+		return fmt.Errorf("invalid category name %q", newName)
+	}
+	if newName == c.Name {
+		return nil
+	}
+
+	var existing, err = op.FindCategoryByName(newName)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("category %q already exists", newName)
+	}
+
+	// The new name may have belonged to some category in the past; it's
+	// c's now
+	op.Operation.Exec("DELETE FROM category_redirects WHERE old_name = ?", newName)
+	op.addCategoryRedirect(c.Name, c)
+
+	c.Name = newName
+	op.Categories.Save(c)
+	return op.Operation.Err()
+}
+
+// addCategoryRedirect points oldName at c, replacing any existing redirect
+// for that name
+func (op *Operation) addCategoryRedirect(oldName string, c *Category) {
+	op.Operation.Exec("INSERT OR REPLACE INTO category_redirects (old_name, category_id) VALUES (?, ?)", oldName, c.ID)
+}
+
+// CategoryOldNames returns every name the category used to have
+func (op *Operation) CategoryOldNames(c *Category) ([]string, error) {
+	var redirects []*CategoryRedirect
+	op.categoryRedirects.Select().Where("category_id = ?", c.ID).AllObjects(&redirects)
+	var names []string
+	for _, r := range redirects {
+		names = append(names, r.OldName)
+	}
+	return names, op.Operation.Err()
+}
+
+// FindCategoryByOldName returns the category which used to be called name, or
+// nil if no category ever was
+func (op *Operation) FindCategoryByOldName(name string) (*Category, error) {
+	var r = &CategoryRedirect{}
+	var ok = op.categoryRedirects.Select().Where("old_name = ?", NormalizePath(name)).First(r)
+	if !ok {
+		return nil, op.Operation.Err()
+	}
+	return op.FindCategoryByID(r.CategoryID)
+}
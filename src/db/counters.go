@@ -0,0 +1,55 @@
+package db
+
+import "time"
+
+// Persistent counters
+const (
+	CounterArchivedBytes = "archived_bytes"
+	CounterArchives      = "archives"
+)
+
+// Counters maintained by database triggers whenever browsable content
+// changes: the version goes up by one, and the change time is a Unix time
+const (
+	CounterContentVersion   = "content_version"
+	CounterContentChangedAt = "content_changed_at"
+)
+
+// AddToCounter adds n to the named counter, creating it if necessary
+func (op *Operation) AddToCounter(name string, n int64) error {
+	op.Operation.Exec("INSERT OR IGNORE INTO counters (name, value) VALUES (?, 0)", name)
+	op.Operation.Exec("UPDATE counters SET value = value + ? WHERE name = ?", n, name)
+	return op.Operation.Err()
+}
+
+// Counter returns the named counter's value, which is zero if nothing has
+// ever been added to it
+func (op *Operation) Counter(name string) (int64, error) {
+	var n int64
+	var rows = op.Operation.Query("SELECT value FROM counters WHERE name = ?", name)
+	for rows.Next() {
+		rows.Scan(&n)
+	}
+	rows.Close()
+	return n, op.Operation.Err()
+}
+
+// ContentVersion returns a number which goes up whenever anything shown on
+// browse pages changes, along with when that last happened
+func (op *Operation) ContentVersion() (version int64, changed time.Time, err error) {
+	var rows = op.Operation.Query("SELECT name, value FROM counters WHERE name IN (?, ?)",
+		CounterContentVersion, CounterContentChangedAt)
+	for rows.Next() {
+		var name string
+		var n int64
+		rows.Scan(&name, &n)
+		switch name {
+		case CounterContentVersion:
+			version = n
+		case CounterContentChangedAt:
+			changed = time.Unix(n, 0).UTC()
+		}
+	}
+	rows.Close()
+	return version, changed, op.Operation.Err()
+}
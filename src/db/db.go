@@ -3,56 +3,124 @@ package db
 import (
 	"database/sql"
 	"fmt"
-	"net/mail"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Nerdmaster/magicsql"
-	_ "github.com/mattn/go-sqlite3" // database/sql requires "side-effect" packages be loaded
 	"github.com/uoregon-libraries/gopkg/logger"
 )
 
 // Database encapsulates the database handle and magicsql table definitions
 type Database struct {
-	dbh           *magicsql.DB
-	mtFiles       *magicsql.MagicTable
-	mtFolders     *magicsql.MagicTable
-	mtRealFolders *magicsql.MagicTable
-	mtCategories  *magicsql.MagicTable
-	mtInventories *magicsql.MagicTable
-	mtArchiveJobs *magicsql.MagicTable
+	dbh             *magicsql.DB
+	mtFiles         *magicsql.MagicTable
+	mtFolders       *magicsql.MagicTable
+	mtRealFolders   *magicsql.MagicTable
+	mtCategories    *magicsql.MagicTable
+	mtInventories   *magicsql.MagicTable
+	mtArchiveJobs   *magicsql.MagicTable
+	mtSavedSearches *magicsql.MagicTable
+	mtUsers         *magicsql.MagicTable
+	mtSessions      *magicsql.MagicTable
+	mtDownloads     *magicsql.MagicTable
+	mtFolderTotals  *magicsql.MagicTable
+	mtRedirects     *magicsql.MagicTable
+	mtFolderRedirs  *magicsql.MagicTable
+	mtFixityChecks  *magicsql.MagicTable
+	mtIndexProgress *magicsql.MagicTable
+	mtIndexRuns     *magicsql.MagicTable
+	mtIndexRequests *magicsql.MagicTable
+	mtIndexStatus   *magicsql.MagicTable
+	mtAPITokens     *magicsql.MagicTable
+
+	// keepalive holds a connection open for in-memory databases, which are
+	// destroyed when their last connection closes
+	keepalive *sql.Conn
 }
 
 // Operation wraps a magicsql Operation with preloaded OperationTable
 // definitions for easy querying
 type Operation struct {
-	Operation   *magicsql.Operation
-	Files       *magicsql.OperationTable
-	Folders     *magicsql.OperationTable
-	RealFolders *magicsql.OperationTable
-	Inventories *magicsql.OperationTable
-	Categories  *magicsql.OperationTable
-	ArchiveJobs *magicsql.OperationTable
+	db             *Database
+	Operation      *magicsql.Operation
+	Files          *magicsql.OperationTable
+	Folders        *magicsql.OperationTable
+	RealFolders    *magicsql.OperationTable
+	Inventories    *magicsql.OperationTable
+	Categories     *magicsql.OperationTable
+	ArchiveJobs    *magicsql.OperationTable
+	SavedSearches  *magicsql.OperationTable
+	Users          *magicsql.OperationTable
+	Sessions       *magicsql.OperationTable
+	DownloadEvents *magicsql.OperationTable
+	FixityChecks   *magicsql.OperationTable
+	IndexProgress  *magicsql.OperationTable
+	IndexRuns      *magicsql.OperationTable
+	IndexRequests  *magicsql.OperationTable
+	IndexRunStatus *magicsql.OperationTable
+	APITokens      *magicsql.OperationTable
+
+	// folderTotals is only maintained internally, via file writes
+	folderTotals *magicsql.OperationTable
+
+	// categoryRedirects is only maintained internally, via category renames
+	// and merges
+	categoryRedirects *magicsql.OperationTable
+
+	// folderRedirects is only maintained internally, via folder moves and
+	// category merges
+	folderRedirects *magicsql.OperationTable
+
+	// hideRestricted is set via HideRestricted
+	hideRestricted bool
+
+	// limitAccess is set via HideRestricted or ForRole, and hides categories
+	// whose access role is more than role
+	limitAccess bool
+	role        string
 }
 
+// Path is the location of the SQLite database, relative to the working
+// directory
+const Path = "db/da.db"
+
 // New sets up a database connection and returns a usable Database
 func New() *Database {
-	var _db, err = sql.Open("sqlite3", "db/da.db")
+	var _db, err = sql.Open(driverName, Path)
 	if err != nil {
 		logger.Fatalf("Unable to open database: %s", err)
 	}
 
+	return wrap(_db)
+}
+
+// wrap builds a Database around the given handle
+func wrap(_db *sql.DB) *Database {
 	return &Database{
-		dbh:           magicsql.Wrap(_db),
-		mtFiles:       magicsql.Table("files", &File{}),
-		mtFolders:     magicsql.Table("folders", &Folder{}),
-		mtRealFolders: magicsql.Table("real_folders", &RealFolder{}),
-		mtCategories:  magicsql.Table("categories", &Category{}),
-		mtInventories: magicsql.Table("inventories", &Inventory{}),
-		mtArchiveJobs: magicsql.Table("archive_jobs", &ArchiveJob{}),
+		dbh:             magicsql.Wrap(_db),
+		mtFiles:         magicsql.Table("files", &File{}),
+		mtFolders:       magicsql.Table("folders", &Folder{}),
+		mtRealFolders:   magicsql.Table("real_folders", &RealFolder{}),
+		mtCategories:    magicsql.Table("categories", &Category{}),
+		mtInventories:   magicsql.Table("inventories", &Inventory{}),
+		mtArchiveJobs:   magicsql.Table("archive_jobs", &ArchiveJob{}),
+		mtSavedSearches: magicsql.Table("saved_searches", &SavedSearch{}),
+		mtUsers:         magicsql.Table("users", &User{}),
+		mtSessions:      magicsql.Table("sessions", &Session{}),
+		mtDownloads:     magicsql.Table("download_events", &DownloadEvent{}),
+		mtFolderTotals:  magicsql.Table("folder_totals", &FolderTotal{}),
+		mtRedirects:     magicsql.Table("category_redirects", &CategoryRedirect{}),
+		mtFolderRedirs:  magicsql.Table("folder_redirects", &FolderRedirect{}),
+		mtFixityChecks:  magicsql.Table("fixity_checks", &FixityCheck{}),
+		mtIndexProgress: magicsql.Table("index_progress", &IndexProgress{}),
+		mtIndexRuns:     magicsql.Table("index_runs", &IndexRun{}),
+		mtIndexRequests: magicsql.Table("index_requests", &IndexRequest{}),
+		mtIndexStatus:   magicsql.Table("index_run_status", &IndexRunStatus{}),
+		mtAPITokens:     magicsql.Table("api_tokens", &APIToken{}),
 	}
 }
 
@@ -60,13 +128,27 @@ func New() *Database {
 func (db *Database) Operation() *Operation {
 	var magicOp = db.dbh.Operation()
 	return &Operation{
-		Operation:   magicOp,
-		Files:       magicOp.OperationTable(db.mtFiles),
-		Folders:     magicOp.OperationTable(db.mtFolders),
-		RealFolders: magicOp.OperationTable(db.mtRealFolders),
-		Inventories: magicOp.OperationTable(db.mtInventories),
-		Categories:  magicOp.OperationTable(db.mtCategories),
-		ArchiveJobs: magicOp.OperationTable(db.mtArchiveJobs),
+		db:                db,
+		Operation:         magicOp,
+		Files:             magicOp.OperationTable(db.mtFiles),
+		Folders:           magicOp.OperationTable(db.mtFolders),
+		RealFolders:       magicOp.OperationTable(db.mtRealFolders),
+		Inventories:       magicOp.OperationTable(db.mtInventories),
+		Categories:        magicOp.OperationTable(db.mtCategories),
+		ArchiveJobs:       magicOp.OperationTable(db.mtArchiveJobs),
+		SavedSearches:     magicOp.OperationTable(db.mtSavedSearches),
+		Users:             magicOp.OperationTable(db.mtUsers),
+		Sessions:          magicOp.OperationTable(db.mtSessions),
+		DownloadEvents:    magicOp.OperationTable(db.mtDownloads),
+		FixityChecks:      magicOp.OperationTable(db.mtFixityChecks),
+		IndexProgress:     magicOp.OperationTable(db.mtIndexProgress),
+		IndexRuns:         magicOp.OperationTable(db.mtIndexRuns),
+		IndexRequests:     magicOp.OperationTable(db.mtIndexRequests),
+		IndexRunStatus:    magicOp.OperationTable(db.mtIndexStatus),
+		APITokens:         magicOp.OperationTable(db.mtAPITokens),
+		folderTotals:      magicOp.OperationTable(db.mtFolderTotals),
+		categoryRedirects: magicOp.OperationTable(db.mtRedirects),
+		folderRedirects:   magicOp.OperationTable(db.mtFolderRedirs),
 	}
 }
 
@@ -92,6 +174,15 @@ func (db *Database) InTransaction(cb func(*Operation) error) error {
 	return nil
 }
 
+// Close waits for running queries to finish and closes every connection, so
+// SQLite can clean up its journal before the process exits
+func (db *Database) Close() error {
+	if db.keepalive != nil {
+		db.keepalive.Close()
+	}
+	return db.dbh.DataSource().Close()
+}
+
 // AllInventories returns all the inventory files which have been indexed
 func (op *Operation) AllInventories() ([]*Inventory, error) {
 	var inventories []*Inventory
@@ -99,24 +190,107 @@ func (op *Operation) AllInventories() ([]*Inventory, error) {
 	return inventories, op.Operation.Err()
 }
 
+// FindInventoryByID returns the inventory with the given id, or nil if none
+// is found
+func (op *Operation) FindInventoryByID(id int) (*Inventory, error) {
+	var inventory = &Inventory{}
+	var ok = op.Inventories.Select().Where("id = ?", id).First(inventory)
+	if !ok {
+		inventory = nil
+	}
+	return inventory, op.Operation.Err()
+}
+
+// FindInventoryByContentHash returns an inventory, other than a duplicate,
+// whose contents had the given hash when it was indexed, or nil if there
+// isn't one
+func (op *Operation) FindInventoryByContentHash(hash string) (*Inventory, error) {
+	var inventory = &Inventory{}
+	var ok = op.Inventories.Select().Where("content_hash = ? AND duplicate_of = 0", hash).Order("id").First(inventory)
+	if !ok {
+		inventory = nil
+	}
+	return inventory, op.Operation.Err()
+}
+
 // WriteInventory stores the given inventory object in the database
 func (op *Operation) WriteInventory(i *Inventory) error {
 	op.Inventories.Save(i)
 	return op.Operation.Err()
 }
 
+// ClearInventory removes an inventory, its checkpoint, and every file
+// attributed to it, so that it can be indexed again from scratch.  The
+// affected categories' folder totals are rebuilt, but folders are left for
+// the reindex to reuse.  Returns the number of files removed.
+func (op *Operation) ClearInventory(inv *Inventory) (int, error) {
+	var categoryIDs []int
+	var rows = op.Operation.Query("SELECT DISTINCT category_id FROM files WHERE inventory_id = ?", inv.ID)
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		categoryIDs = append(categoryIDs, id)
+	}
+	rows.Close()
+
+	op.Operation.Exec("DELETE FROM fixity_checks WHERE file_id IN (SELECT id FROM files WHERE inventory_id = ?)", inv.ID)
+	var res = op.Operation.Exec("DELETE FROM files WHERE inventory_id = ?", inv.ID)
+	if op.Operation.Err() != nil {
+		return 0, op.Operation.Err()
+	}
+	var removed = int(res.RowsAffected())
+	op.Operation.Exec("DELETE FROM index_progress WHERE inventory_id = ?", inv.ID)
+	op.Operation.Exec("DELETE FROM inventories WHERE id = ?", inv.ID)
+	for _, id := range categoryIDs {
+		op.recomputeFolderTotals(&Category{ID: id})
+	}
+	return removed, op.Operation.Err()
+}
+
 // AllCategories returns all categories which have been seen
 func (op *Operation) AllCategories() ([]*Category, error) {
 	var categories []*Category
-	op.Categories.Select().Order("LOWER(name)").AllObjects(&categories)
+	var sel = op.Categories.Select()
+	if op.categoryClause() != "" {
+		sel = sel.Where(op.categoryClause())
+	}
+	sel.Order("LOWER(name)").AllObjects(&categories)
 	return categories, op.Operation.Err()
 }
 
+// ListedCategories returns the categories to list for browsing: all of them,
+// less the hidden ones if op hides restricted items
+func (op *Operation) ListedCategories() ([]*Category, error) {
+	var all, err = op.AllCategories()
+	if err != nil || !op.hideRestricted {
+		return all, err
+	}
+
+	var categories []*Category
+	for _, c := range all {
+		if !c.Hidden {
+			categories = append(categories, c)
+		}
+	}
+	return categories, nil
+}
+
 // FindCategoryByName returns a category if one exists with the given name, and
 // the database error if any occurred
 func (op *Operation) FindCategoryByName(name string) (*Category, error) {
 	var category = &Category{}
-	var ok = op.Categories.Select().Where("name = ?", name).First(category)
+	var ok = op.Categories.Select().Where(op.visibleCategory("name = ?"), NormalizePath(name)).First(category)
+	if !ok {
+		category = nil
+	}
+	return category, op.Operation.Err()
+}
+
+// FindCategoryByID returns a category if one exists with the given id, and
+// the database error if any occurred
+func (op *Operation) FindCategoryByID(id int) (*Category, error) {
+	var category = &Category{}
+	var ok = op.Categories.Select().Where(op.visibleCategory("id = ?"), id).First(category)
 	if !ok {
 		category = nil
 	}
@@ -124,12 +298,16 @@ func (op *Operation) FindCategoryByName(name string) (*Category, error) {
 }
 
 // FindOrCreateCategory stores (or finds) the category by the given name and
-// returns it.  If there are any database errors, they're returned and Category
-// will be undefined.
+// returns it.  A category which has been renamed away from name is found via
+// its redirect.  If there are any database errors, they're returned and
+// Category will be undefined.
 func (op *Operation) FindOrCreateCategory(name string) (*Category, error) {
 	var category, err = op.FindCategoryByName(name)
 	if category == nil && err == nil {
-		category = &Category{Name: name}
+		category, err = op.FindCategoryByOldName(name)
+	}
+	if category == nil && err == nil {
+		category = &Category{Name: NormalizePath(name)}
 		op.Categories.Save(category)
 	}
 	return category, op.Operation.Err()
@@ -138,44 +316,116 @@ func (op *Operation) FindOrCreateCategory(name string) (*Category, error) {
 // FindFolderByPath looks for a folder with the given path under the given category
 func (op *Operation) FindFolderByPath(c *Category, path string) (*Folder, error) {
 	var folder = &Folder{}
-	var ok = op.Folders.Select().Where("category_id = ? AND public_path = ?", c.ID, path).First(folder)
+	var where = "category_id = ? AND public_path = ?"
+	if op.hiding() {
+		where += " AND " + op.hiddenClause("folders")
+	}
+	var ok = op.Folders.Select().Where(where, c.ID, NormalizePath(path)).First(folder)
 	if !ok {
 		folder = nil
 	}
 	return folder, op.Operation.Err()
 }
 
-// FindOrCreateFolder centralizes the creation and DB-save operation for folders
+// FindFolderByID returns the folder with the given id, or nil if none is
+// found.  Category and parent folder data are not populated.
+func (op *Operation) FindFolderByID(id int) (*Folder, error) {
+	var folder = &Folder{}
+	var ok = op.Folders.Select().Where("id = ?", id).First(folder)
+	if !ok {
+		folder = nil
+	}
+	return folder, op.Operation.Err()
+}
+
+// MaxFolderDescription is the longest description a folder can have
+const MaxFolderDescription = 2000
+
+// SetFolderDescription replaces the folder's description.  Leading and
+// trailing whitespace is removed, and an empty description clears it.
+func (op *Operation) SetFolderDescription(f *Folder, desc string) error {
+	desc = strings.TrimSpace(desc)
+	if utf8.RuneCountInString(desc) > MaxFolderDescription {
+		return fmt.Errorf("descriptions can't be longer than %d characters", MaxFolderDescription)
+	}
+	f.Description = desc
+	op.Folders.Save(f)
+	return op.Operation.Err()
+}
+
+// LoadFolderAncestors fills in the parent Folder of f, its parent's parent,
+// and so on up to the top of the category, in one query.  The ancestors get
+// f's Category.  Parents the database doesn't have are left nil.
+func (op *Operation) LoadFolderAncestors(f *Folder) error {
+	if f == nil || f.FolderID == 0 {
+		return nil
+	}
+
+	var parts = strings.Split(f.PublicPath, string(os.PathSeparator))
+	var args = []interface{}{f.CategoryID}
+	for i := 1; i < len(parts); i++ {
+		args = append(args, strings.Join(parts[:i], string(os.PathSeparator)))
+	}
+	if len(args) == 1 {
+		return nil
+	}
+
+	var folders []*Folder
+	var where = "category_id = ? AND public_path IN (" + strings.Repeat("?, ", len(args)-2) + "?)"
+	op.Folders.Select().Where(where, args...).AllObjects(&folders)
+	if op.Operation.Err() != nil {
+		return op.Operation.Err()
+	}
+
+	var lookup = make(map[int]*Folder, len(folders))
+	for _, folder := range folders {
+		folder.Category = f.Category
+		lookup[folder.ID] = folder
+	}
+	for child := f; child.FolderID != 0 && lookup[child.FolderID] != nil; child = child.Folder {
+		child.Folder = lookup[child.FolderID]
+	}
+	return nil
+}
+
+// FindOrCreateFolder centralizes the creation and DB-save operation for
+// folders.  Creation ignores unique-constraint conflicts so that if another
+// indexer creates the same folder first, we just use its record.
 func (op *Operation) FindOrCreateFolder(c *Category, f *Folder, path string) (*Folder, error) {
 	var parentFolderID = 0
 	if f != nil {
 		parentFolderID = f.ID
 	}
+	path = NormalizePath(path)
 	var folder, err = op.FindFolderByPath(c, path)
 	if err != nil {
 		return nil, err
 	}
-	if folder != nil {
-		if folder.FolderID != parentFolderID {
-			return nil, fmt.Errorf("existing record with different parent found")
+
+	if folder == nil {
+		var _, filename = filepath.Split(path)
+		op.insertOrIgnore(op.db.mtFolders, &Folder{
+			FolderID:   parentFolderID,
+			CategoryID: c.ID,
+			Depth:      strings.Count(path, string(os.PathSeparator)),
+			PublicPath: path,
+			Name:       filename,
+		})
+		folder, err = op.FindFolderByPath(c, path)
+		if err != nil {
+			return nil, err
+		}
+		if folder == nil {
+			return nil, fmt.Errorf("folder %q vanished after creation", path)
 		}
-		folder.Folder = f
-		folder.Category = c
-		return folder, nil
 	}
 
-	var _, filename = filepath.Split(path)
-	var newFolder = Folder{
-		Folder:     f,
-		FolderID:   parentFolderID,
-		Category:   c,
-		CategoryID: c.ID,
-		Depth:      strings.Count(path, string(os.PathSeparator)),
-		PublicPath: path,
-		Name:       filename,
+	if folder.FolderID != parentFolderID {
+		return nil, fmt.Errorf("existing record with different parent found")
 	}
-	op.Folders.Save(&newFolder)
-	return &newFolder, op.Operation.Err()
+	folder.Folder = f
+	folder.Category = c
+	return folder, nil
 }
 
 // FindRealFolderByPath looks for a folder with the given path under the given category
@@ -188,31 +438,95 @@ func (op *Operation) FindRealFolderByPath(f *Folder, path string) (*RealFolder,
 	return folder, op.Operation.Err()
 }
 
-// FindOrCreateRealFolder centralizes the creation and DB-save operation for real_folders
+// FindOrCreateRealFolder centralizes the creation and DB-save operation for
+// real_folders, ignoring unique-constraint conflicts the same way
+// FindOrCreateFolder does
 func (op *Operation) FindOrCreateRealFolder(f *Folder, path string) (*RealFolder, error) {
-	var fid = 0
-	if f != nil {
-		fid = f.ID
-	}
 	var folder, err = op.FindRealFolderByPath(f, path)
 	if err != nil {
 		return nil, err
 	}
-	if folder != nil {
-		if folder.FolderID != fid {
-			return nil, fmt.Errorf("existing record with different folder found")
+
+	if folder == nil {
+		op.insertOrIgnore(op.db.mtRealFolders, &RealFolder{FolderID: f.ID, FullPath: path})
+		folder, err = op.FindRealFolderByPath(f, path)
+		if err != nil {
+			return nil, err
+		}
+		if folder == nil {
+			return nil, fmt.Errorf("real folder %q vanished after creation", path)
 		}
-		folder.Folder = f
-		return folder, nil
 	}
 
-	var newFolder = RealFolder{
-		Folder:   f,
-		FolderID: fid,
-		FullPath: path,
+	folder.Folder = f
+	return folder, nil
+}
+
+// UpsertFile stores the given file, or updates the existing record if one
+// already exists with the same category, archive date, and public path.  On
+// success, f.ID is set to the stored record's id, and added says whether the
+// record is new.
+func (op *Operation) UpsertFile(f *File) (added bool, err error) {
+	f.PublicPath = NormalizePath(f.PublicPath)
+	f.Name = NormalizePath(f.Name)
+	var res = op.insertOrIgnore(op.db.mtFiles, f)
+	if op.Operation.Err() != nil {
+		return false, op.Operation.Err()
+	}
+	if res.RowsAffected() > 0 {
+		f.ID = uint64(res.LastInsertId())
+		return true, op.addToFolderTotals(f, 1, f.Filesize)
+	}
+
+	var existing *File
+	existing, err = op.FindFileByPublicPath(f.CategoryID, f.ArchiveDate, f.PublicPath)
+	if existing == nil {
+		return false, fmt.Errorf("unable to find conflicting record for file %q: %v", f.PublicPath, err)
+	}
+	f.ID = existing.ID
+	f.Restricted = existing.Restricted
+
+	// An inventory which doesn't record modification times shouldn't erase
+	// the one we got from an inventory which did
+	if f.ModifiedAt.IsZero() {
+		f.ModifiedAt = existing.ModifiedAt
+	}
+	op.Files.Save(f)
+	if op.Operation.Err() != nil {
+		return false, op.Operation.Err()
+	}
+
+	if existing.FolderID == f.FolderID {
+		return false, op.addToFolderTotals(f, 0, f.Filesize-existing.Filesize)
+	}
+
+	// The file moved to a different folder, so we take it out of the old
+	// folder's totals entirely before adding it to the new one
+	err = op.addToFolderTotals(existing, -1, -existing.Filesize)
+	if err != nil {
+		return false, err
 	}
-	op.RealFolders.Save(&newFolder)
-	return &newFolder, op.Operation.Err()
+	return false, op.addToFolderTotals(f, 1, f.Filesize)
+}
+
+// FindFileByPublicPath returns the file in the given category with the given
+// archive date and public path, or nil if there isn't one.  Restricted files
+// are not hidden here, as this is meant for indexing rather than browsing.
+func (op *Operation) FindFileByPublicPath(categoryID int, archiveDate, publicPath string) (*File, error) {
+	var f = &File{}
+	var ok = op.Files.Select().Where("category_id = ? AND archive_date = ? AND public_path = ?",
+		categoryID, archiveDate, NormalizePath(publicPath)).First(f)
+	if !ok {
+		return nil, op.Operation.Err()
+	}
+	return f, nil
+}
+
+// insertOrIgnore runs an INSERT for obj using the magic table's SQL, but
+// tells SQLite to skip the row rather than fail on a constraint conflict
+func (op *Operation) insertOrIgnore(mt *magicsql.MagicTable, obj interface{}) *magicsql.Result {
+	var sql = strings.Replace(mt.InsertSQL(), "INSERT INTO", "INSERT OR IGNORE INTO", 1)
+	return op.Operation.Exec(sql, mt.InsertArgs(obj)...)
 }
 
 // GetFolders returns all folders with the given category and parent folder.  A
@@ -224,40 +538,53 @@ func (op *Operation) GetFolders(category *Category, folder *Folder) ([]*Folder,
 	return folders, err
 }
 
-// GetFiles returns all files with the given category and parent folder.  A
-// parent folder of nil can be used to pull all top-level files.
-func (op *Operation) GetFiles(category *Category, folder *Folder, limit uint64) ([]*File, uint64, error) {
-	var sel = op.FileSelect(category, folder).Limit(limit)
+// GetFiles returns the given page of files with the given category and parent
+// folder which match the filter, in the given order, and the total number of
+// matches.  A parent folder of nil can be used to pull all top-level files.
+func (op *Operation) GetFiles(category *Category, folder *Folder, ff FileFilter, fs FileSort, page Page) ([]*File, uint64, error) {
+	var sel = op.FileSelect(category, folder).Filter(ff).Sort(fs).Page(page)
 	var files []*File
 	var count, err = sel.AllObjects(&files)
 	return files, count, err
 }
 
+// FileExtensions counts the files with the given category and parent folder
+// by extension, ignoring any filters, so the browse page can offer an
+// extension filter listing only what's actually in the folder
+func (op *Operation) FileExtensions(category *Category, folder *Folder) ([]*ExtensionCount, error) {
+	return op.FileSelect(category, folder).ExtensionCounts()
+}
+
 // SearchFiles finds all files which are *descendents* of the given
-// category/folder and match the term
+// category/folder, match the term, and match the filter.  The term is
+// interpreted according to mode.  The given page of files is returned along
+// with the total number of matches.
 //
 // Note that folder data is *not* filled in on the returns files.  Pulling
 // folders from the database is unnecessary since all folder lookups are via
 // path, so this reduces the amount of information we pull from the database
 // and simplifies the code quite a bit.
-func (op *Operation) SearchFiles(category *Category, folder *Folder, term string, limit uint64) ([]*File, uint64, error) {
-	var sel = op.FileSelect(category, folder).TreeMode(true).Search("public_path LIKE ?", term).Limit(limit)
-	var files []*File
-	var count, err = sel.AllObjects(&files)
-	return files, count, err
+func (op *Operation) SearchFiles(category *Category, folder *Folder, term string, mode SearchMode, ff FileFilter, page Page) ([]*File, uint64, error) {
+	return op.QueryFiles(FileQuery{Category: category, Folder: folder, Term: term, Mode: mode, Filter: ff, Page: page})
 }
 
 // SearchFolders finds all folders which are *descendents* of the given
-// category/folder and match the term
+// category/folder and match the term, interpreted according to mode.  The
+// given page of folders is returned along with the total number of matches.
 //
 // Note that parent folder data is *not* filled in on the returns files.
 // Pulling folders from the database is unnecessary since all folder lookups
 // are via path, so this reduces the amount of information we pull from the
 // database and simplifies the code quite a bit.
-func (op *Operation) SearchFolders(category *Category, folder *Folder, term string, limit uint64) ([]*Folder, uint64, error) {
-	var sel = op.FolderSelect(category, folder).TreeMode(true).Search("name LIKE ?", term).Limit(limit)
+func (op *Operation) SearchFolders(category *Category, folder *Folder, term string, mode SearchMode, page Page) ([]*Folder, uint64, error) {
+	var where, arg, err = mode.clause("name", term)
+	if err != nil {
+		return nil, 0, err
+	}
+	var sel = op.FolderSelect(category, folder).TreeMode(true).Search(where, arg).Page(page)
 	var folders []*Folder
-	var count, err = sel.AllObjects(&folders)
+	var count uint64
+	count, err = sel.AllObjects(&folders)
 	return folders, count, err
 }
 
@@ -265,13 +592,59 @@ func (op *Operation) SearchFolders(category *Category, folder *Folder, term stri
 // found.  Any database errors are passed back to the caller.
 func (op *Operation) FindFileByID(id uint64) (*File, error) {
 	var file = &File{}
-	var ok = op.Files.Select().Where("id = ?", id).First(file)
+	var where = "id = ?"
+	if op.hiding() {
+		where += " AND " + op.hiddenClause("files")
+	}
+	var ok = op.Files.Select().Where(where, id).First(file)
 	if !ok {
 		file = nil
 	}
 	return file, op.Operation.Err()
 }
 
+// EachFile runs the file select and yields each file to cb as it's read,
+// rather than loading the full result set into memory.  Category data is
+// filled in on each file.  If cb returns an error, iteration stops and that
+// error is returned.
+//
+// The *File passed to cb is a new object each time, so callers may hold onto
+// it, but holding onto all of them defeats the purpose of this function.
+func (op *Operation) EachFile(sel *FSelect, cb func(*File) error) error {
+	// Categories are loaded up front so we aren't issuing queries while the
+	// file rows are still open
+	var categoryLookup = make(map[int]*Category)
+	if sel.category != nil {
+		categoryLookup[sel.category.ID] = sel.category
+	} else {
+		var categoryList, err = op.AllCategories()
+		if err != nil {
+			return err
+		}
+		for _, c := range categoryList {
+			categoryLookup[c.ID] = c
+		}
+	}
+
+	var rows = sel.buildSelect().Query()
+	defer rows.Close()
+
+	for rows.Next() {
+		var f = &File{}
+		rows.Scan(op.db.mtFiles.ScanStruct(f)...)
+		if op.Operation.Err() != nil {
+			break
+		}
+		f.Category = categoryLookup[f.CategoryID]
+		var err = cb(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	return op.Operation.Err()
+}
+
 // PopulateCategories fills in the category data for all passed-in files and folders
 func (op *Operation) PopulateCategories(files []*File, folders []*Folder) error {
 	var categoryLookup = make(map[int]*Category)
@@ -291,8 +664,20 @@ func (op *Operation) PopulateCategories(files []*File, folders []*Folder) error
 	return nil
 }
 
+// maxInClauseIDs is how many ids we put into a single "IN" query.  SQLite
+// won't take more than 999 bound parameters in one statement.
+const maxInClauseIDs = 500
+
+// tempTableThreshold is the number of ids above which GetFilesByIDs loads the
+// ids into a temporary table and joins against it rather than running a pile
+// of chunked "IN" queries
+const tempTableThreshold = 2000
+
 func (op *Operation) appendFiles(files []*File, ids []uint64) []*File {
 	var where = "id IN (" + strings.Repeat("?, ", len(ids)-1) + "?)"
+	if op.hiding() {
+		where += " AND " + op.hiddenClause("files")
+	}
 	var args []interface{}
 	for _, id := range ids {
 		args = append(args, id)
@@ -302,23 +687,14 @@ func (op *Operation) appendFiles(files []*File, ids []uint64) []*File {
 	return append(files, tempFiles...)
 }
 
-// GetFilesByIDs returns a list of File instances for the given ids
+// GetFilesByIDs returns a list of File instances for the given ids, sorted by
+// depth and then path
 func (op *Operation) GetFilesByIDs(ids []uint64) ([]*File, error) {
-	var files []*File
-
-	// split ids into chunks that can work in an IN query - I can't find any
-	// details on what a real limit might be, so we just split at 1000 and hope
-	// for the best
-	for len(ids) > 1000 {
-		files = op.appendFiles(files, ids[:1000])
-		ids = ids[1000:]
-	}
-	if len(ids) > 0 {
-		files = op.appendFiles(files, ids)
+	var files, err = op.getFilesByIDs(ids)
+	if err != nil {
+		return nil, err
 	}
 
-	// We have to sort after the fact since we don't know how many passes it took
-	// to get the data
 	sort.Slice(files, func(i, j int) bool {
 		if files[i].Depth != files[j].Depth {
 			return files[i].Depth < files[j].Depth
@@ -326,60 +702,99 @@ func (op *Operation) GetFilesByIDs(ids []uint64) ([]*File, error) {
 		return strings.ToLower(files[i].PublicPath) < strings.ToLower(files[j].PublicPath)
 	})
 
-	op.PopulateCategories(files, nil)
-	return files, op.Operation.Err()
+	return files, nil
 }
 
-// QueueArchiveJob creates a new archive job in the database for async processing
-func (op *Operation) QueueArchiveJob(addrs []*mail.Address, files []*File) error {
-	if len(files) == 0 {
-		return fmt.Errorf("no files to archive")
+// GetFilesByIDsInOrder returns a list of File instances in the same order as
+// the ids were given.  Ids which don't exist in the database are skipped.
+func (op *Operation) GetFilesByIDsInOrder(ids []uint64) ([]*File, error) {
+	var files, err = op.getFilesByIDs(ids)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(addrs) == 0 {
-		return fmt.Errorf("no notification addresses for archive job")
+	var lookup = make(map[uint64]*File, len(files))
+	for _, f := range files {
+		lookup[f.ID] = f
 	}
 
-	var filePaths []string
-	for _, f := range files {
-		filePaths = append(filePaths, f.FullPath)
+	var ordered = make([]*File, 0, len(files))
+	for _, id := range ids {
+		var f = lookup[id]
+		if f != nil {
+			ordered = append(ordered, f)
+			delete(lookup, id)
+		}
 	}
+	return ordered, nil
+}
 
-	var emails []string
-	for _, addr := range addrs {
-		emails = append(emails, addr.String())
+// getFilesByIDs pulls the files for ids in no particular order, using chunked
+// IN queries for small lists and a temporary table for large ones
+func (op *Operation) getFilesByIDs(ids []uint64) ([]*File, error) {
+	if len(ids) > tempTableThreshold {
+		return op.getFilesByIDsTempTable(ids)
 	}
 
-	op.ArchiveJobs.Save(&ArchiveJob{
-		CreatedAt:          time.Now(),
-		NotificationEmails: strings.Join(emails, ","),
-		Files:              strings.Join(filePaths, "\x1E"),
-	})
-	return op.Operation.Err()
+	var files []*File
+	for len(ids) > maxInClauseIDs {
+		files = op.appendFiles(files, ids[:maxInClauseIDs])
+		ids = ids[maxInClauseIDs:]
+	}
+	if len(ids) > 0 {
+		files = op.appendFiles(files, ids)
+	}
+
+	op.PopulateCategories(files, nil)
+	return files, op.Operation.Err()
 }
 
-// ProcessArchiveJob pulls the longest-waiting archive job and runs the
-// callback with it.  If the callback returns success, the archive job is
-// removed from the database.  If no job is found, the callback isn't run.
-func (op *Operation) ProcessArchiveJob(cb func(*ArchiveJob) bool) error {
-	var j = &ArchiveJob{}
-	var sel = op.ArchiveJobs.Select().Where("next_attempt_at < ? AND processed = ?", time.Now(), false)
-	var ok = sel.Order("created_at ASC").Limit(1).First(j)
-	if op.Operation.Err() != nil {
-		return op.Operation.Err()
+// getFilesByIDsTempTable loads ids into a temporary table and joins it to the
+// files table.  Temporary tables only exist on the connection which created
+// them, so this work happens in its own transaction to ensure every statement
+// uses the same connection.  This means uncommitted data in op's transaction
+// (if any) won't be visible, which is fine for the read-only lookups this
+// serves.
+func (op *Operation) getFilesByIDsTempTable(ids []uint64) ([]*File, error) {
+	var tmpOp = op.db.Operation()
+	var mop = tmpOp.Operation
+	mop.BeginTransaction()
+	defer mop.Rollback()
+
+	mop.Exec("CREATE TEMP TABLE IF NOT EXISTS requested_file_ids (file_id integer not null primary key)")
+	mop.Exec("DELETE FROM requested_file_ids")
+	var stmt = mop.Prepare("INSERT OR IGNORE INTO requested_file_ids (file_id) VALUES (?)")
+	for _, id := range ids {
+		stmt.Exec(id)
 	}
-	if !ok {
-		return nil
+	stmt.Close()
+
+	var fields = op.db.mtFiles.FieldNames()
+	for i, f := range fields {
+		fields[i] = "files." + f
+	}
+	var query = "SELECT " + strings.Join(fields, ",") +
+		" FROM files JOIN requested_file_ids r ON r.file_id = files.id"
+	if op.hiding() {
+		query += " WHERE " + op.hiddenClause("files")
 	}
+	var rows = mop.Query(query)
 
-	if cb(j) {
-		j.Processed = true
-	} else {
-		j.NextAttemptAt = time.Now().Add(time.Hour)
+	var files []*File
+	for rows.Next() {
+		var f = &File{}
+		rows.Scan(op.db.mtFiles.ScanStruct(f)...)
+		files = append(files, f)
 	}
+	rows.Close()
+	mop.Exec("DROP TABLE requested_file_ids")
 
-	op.ArchiveJobs.Save(j)
-	return op.Operation.Err()
+	if mop.Err() != nil {
+		return nil, mop.Err()
+	}
+
+	var err = op.PopulateCategories(files, nil)
+	return files, err
 }
 
 // GetRealFolders returns real folders that can get to the given collapsed /
@@ -389,3 +804,20 @@ func (op *Operation) GetRealFolders(f *Folder) ([]*RealFolder, error) {
 	op.RealFolders.Select().Where("folder_id = ?", f.ID).AllObjects(&folders)
 	return folders, op.Operation.Err()
 }
+
+// RecentFiles returns up to limit files indexed at or after since, most
+// recently indexed first, with their categories populated
+func (op *Operation) RecentFiles(since time.Time, limit uint64) ([]*File, error) {
+	var files []*File
+	var where = "indexed_at >= ?"
+	if op.hiding() {
+		where += " AND " + op.hiddenClause("files")
+	}
+	op.Files.Select().Where(where, since.UTC()).Order("indexed_at DESC, id DESC").Limit(limit).AllObjects(&files)
+	if op.Operation.Err() != nil {
+		return nil, op.Operation.Err()
+	}
+
+	var err = op.PopulateCategories(files, nil)
+	return files, err
+}
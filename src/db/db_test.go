@@ -0,0 +1,144 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+func TestCategoryLookups(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var op = dbh.Operation()
+
+	var cats, err = op.AllCategories()
+	if err != nil {
+		t.Fatalf("Unable to read categories: %s", err)
+	}
+	var names []string
+	for _, c := range cats {
+		names = append(names, c.Name)
+	}
+	if !equalStrings(names, []string{"Empty", "Personnel", "Photos"}) {
+		t.Errorf("Expected categories sorted by name, got %q", names)
+	}
+
+	var c = mustCategory(t, op, "Photos")
+	var byID *db.Category
+	byID, err = op.FindCategoryByID(c.ID)
+	if err != nil || byID == nil || byID.Name != "Photos" {
+		t.Errorf("Expected FindCategoryByID to find Photos, got %#v (error: %v)", byID, err)
+	}
+
+	var missing *db.Category
+	missing, err = op.FindCategoryByName("Nope")
+	if err != nil || missing != nil {
+		t.Errorf("Expected no category and no error, got %#v (error: %v)", missing, err)
+	}
+
+	var again *db.Category
+	again, err = op.FindOrCreateCategory("Photos")
+	if err != nil || again.ID != c.ID {
+		t.Errorf("Expected FindOrCreateCategory to return the existing category, got %#v (error: %v)", again, err)
+	}
+}
+
+func TestFolderLookups(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var op = dbh.Operation()
+	var c = mustCategory(t, op, "Photos")
+
+	var top, err = op.GetFolders(c, nil)
+	if err != nil {
+		t.Fatalf("Unable to read top-level folders: %s", err)
+	}
+	if !equalStrings(folderNames(top), []string{"events", "portraits"}) {
+		t.Errorf("Expected top-level folders events and portraits, got %q", folderNames(top))
+	}
+
+	var events = mustFolder(t, op, c, "events")
+	var children []*db.Folder
+	children, err = op.GetFolders(c, events)
+	if err != nil {
+		t.Fatalf("Unable to read child folders: %s", err)
+	}
+	if !equalStrings(folderNames(children), []string{"2018", "embargoed"}) {
+		t.Errorf("Expected events to hold 2018 and embargoed, got %q", folderNames(children))
+	}
+
+	var f = mustFolder(t, op, c, "events/2018")
+	var byID *db.Folder
+	byID, err = op.FindFolderByID(f.ID)
+	if err != nil || byID == nil || byID.PublicPath != "events/2018" {
+		t.Errorf("Expected FindFolderByID to find events/2018, got %#v (error: %v)", byID, err)
+	}
+
+	var missing *db.Folder
+	missing, err = op.FindFolderByPath(c, "events/1999")
+	if err != nil || missing != nil {
+		t.Errorf("Expected no folder and no error, got %#v (error: %v)", missing, err)
+	}
+}
+
+func TestFileLookups(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var op = dbh.Operation()
+	var c = mustCategory(t, op, "Photos")
+	var f2018 = mustFolder(t, op, c, "events/2018")
+
+	var files, count, err = op.GetFiles(c, f2018, db.FileFilter{}, db.FileSort{}, db.Page{Limit: 10})
+	if err != nil {
+		t.Fatalf("Unable to read files: %s", err)
+	}
+	if count != 2 || !equalStrings(fileNames(files), []string{"party.tif", "private.tif"}) {
+		t.Errorf("Expected party.tif and private.tif, got %d: %q", count, fileNames(files))
+	}
+
+	files, count, err = op.GetFiles(c, nil, db.FileFilter{}, db.FileSort{}, db.Page{Limit: 10})
+	if err != nil || count != 1 || !equalStrings(fileNames(files), []string{"top.pdf"}) {
+		t.Errorf("Expected only top.pdf at the top level, got %d: %q (error: %v)", count, fileNames(files), err)
+	}
+
+	var party *db.File
+	party, err = op.FindFileByPublicPath(c.ID, "2018-01-01", "events/2018/party.tif")
+	if err != nil || party == nil {
+		t.Fatalf("Unable to find party.tif by public path (error: %v)", err)
+	}
+	var byID *db.File
+	byID, err = op.FindFileByID(party.ID)
+	if err != nil || byID == nil || byID.PublicPath != party.PublicPath {
+		t.Errorf("Expected FindFileByID to find party.tif, got %#v (error: %v)", byID, err)
+	}
+
+	var wrongDate *db.File
+	wrongDate, err = op.FindFileByPublicPath(c.ID, "2018-02-01", "events/2018/party.tif")
+	if err != nil || wrongDate != nil {
+		t.Errorf("Expected no file from another archive date, got %#v (error: %v)", wrongDate, err)
+	}
+}
+
+func TestUpsertFileUpdatesExisting(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var op = dbh.Operation()
+	var c = mustCategory(t, op, "Photos")
+
+	var party, _ = op.FindFileByPublicPath(c.ID, "2018-01-01", "events/2018/party.tif")
+	var id = party.ID
+	party.ID = 0
+	party.Filesize = 1500
+	var added, err = op.UpsertFile(party)
+	if err != nil {
+		t.Fatalf("Unable to upsert file: %s", err)
+	}
+	if added || party.ID != id {
+		t.Errorf("Expected the existing record (id %d) to be updated, got added=%v, id %d", id, added, party.ID)
+	}
+
+	var stored, _ = op.FindFileByID(id)
+	if stored == nil || stored.Filesize != 1500 {
+		t.Errorf("Expected the new size to be stored, got %#v", stored)
+	}
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"time"
+)
+
+// Kinds of download events we record
+const (
+	DownloadKindView     = "view"
+	DownloadKindDownload = "download"
+	DownloadKindArchive  = "archive"
+	DownloadKindZip      = "zip"
+)
+
+// DownloadEvent maps to the download_events table, recording a single
+// retrieval of a file.  ArchiveJobID is only set for files requested as part
+// of an archive, and UserID is zero for anonymous retrievals.
+type DownloadEvent struct {
+	ID           int `sql:",primary"`
+	FileID       uint64
+	ArchiveJobID int
+	UserID       int
+	Email        string
+	Kind         string
+	Bytes        int64
+	CreatedAt    time.Time
+}
+
+// CategoryDownloadTotal aggregates download events for a single category
+type CategoryDownloadTotal struct {
+	CategoryID   int
+	CategoryName string
+	Events       uint64
+	Bytes        int64
+}
+
+// FileDownloadTotal aggregates download events for a single file
+type FileDownloadTotal struct {
+	File   *File
+	Events uint64
+	Bytes  int64
+}
+
+// RecordDownload stores an event for the given file having been retrieved.
+// The user may be nil for anonymous downloads.
+func (op *Operation) RecordDownload(f *File, u *User, kind string, bytes int64) error {
+	var e = &DownloadEvent{FileID: f.ID, Kind: kind, Bytes: bytes, CreatedAt: time.Now()}
+	if u != nil {
+		e.UserID = u.ID
+		e.Email = u.Email
+	}
+	op.DownloadEvents.Save(e)
+	return op.Operation.Err()
+}
+
+// recordArchiveRequest stores an archive event for each file in the job
+func (op *Operation) recordArchiveRequest(j *ArchiveJob, files []*File) {
+	var now = time.Now()
+	for _, f := range files {
+		op.DownloadEvents.Save(&DownloadEvent{
+			FileID:       f.ID,
+			ArchiveJobID: j.ID,
+			UserID:       j.UserID,
+			Email:        j.NotificationEmails,
+			Kind:         DownloadKindArchive,
+			Bytes:        f.Filesize,
+			CreatedAt:    now,
+		})
+	}
+}
+
+// FileDownloadEvents returns all download events for the given file, newest first
+func (op *Operation) FileDownloadEvents(f *File) ([]*DownloadEvent, error) {
+	var events []*DownloadEvent
+	op.DownloadEvents.Select().Where("file_id = ?", f.ID).Order("created_at DESC").AllObjects(&events)
+	return events, op.Operation.Err()
+}
+
+// DownloadEventsSince returns all download events which occurred at or after
+// the given time, newest first
+func (op *Operation) DownloadEventsSince(t time.Time) ([]*DownloadEvent, error) {
+	var events []*DownloadEvent
+	op.DownloadEvents.Select().Where("created_at >= ?", t).Order("created_at DESC").AllObjects(&events)
+	return events, op.Operation.Err()
+}
+
+// CategoryDownloadTotals summarizes download events since the given time by
+// category, ordered by the total bytes retrieved
+func (op *Operation) CategoryDownloadTotals(since time.Time) ([]*CategoryDownloadTotal, error) {
+	var totals []*CategoryDownloadTotal
+	var rows = op.Operation.Query(`
+		SELECT c.id, c.name, COUNT(*), SUM(e.bytes)
+		FROM download_events e
+		JOIN files f ON f.id = e.file_id
+		JOIN categories c ON c.id = f.category_id
+		WHERE e.created_at >= ?
+		GROUP BY c.id, c.name
+		ORDER BY SUM(e.bytes) DESC
+	`, since)
+	defer rows.Close()
+
+	for rows.Next() {
+		var t = &CategoryDownloadTotal{}
+		rows.Scan(&t.CategoryID, &t.CategoryName, &t.Events, &t.Bytes)
+		totals = append(totals, t)
+	}
+	return totals, op.Operation.Err()
+}
+
+// MostDownloadedFiles returns up to limit files with the most download events
+// since the given time
+func (op *Operation) MostDownloadedFiles(since time.Time, limit uint64) ([]*FileDownloadTotal, error) {
+	var totals []*FileDownloadTotal
+	var ids []uint64
+	var rows = op.Operation.Query(`
+		SELECT file_id, COUNT(*), SUM(bytes)
+		FROM download_events
+		WHERE created_at >= ?
+		GROUP BY file_id
+		ORDER BY COUNT(*) DESC
+		LIMIT ?
+	`, since, limit)
+	for rows.Next() {
+		var t = &FileDownloadTotal{File: &File{}}
+		rows.Scan(&t.File.ID, &t.Events, &t.Bytes)
+		totals = append(totals, t)
+		ids = append(ids, t.File.ID)
+	}
+	rows.Close()
+
+	if len(ids) == 0 {
+		return totals, op.Operation.Err()
+	}
+
+	var files, err = op.GetFilesByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	var lookup = make(map[uint64]*File)
+	for _, f := range files {
+		lookup[f.ID] = f
+	}
+	for _, t := range totals {
+		if lookup[t.File.ID] != nil {
+			t.File = lookup[t.File.ID]
+		}
+	}
+	return totals, op.Operation.Err()
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"encoding/csv"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// exportHeader is the first row of a category export
+var exportHeader = []string{"public_path", "full_path", "archive_date", "filesize", "checksum"}
+
+// resultsHeader is the first row of a search results export
+var resultsHeader = []string{"public_path", "filesize", "checksum", "category"}
+
+// spoolCSV writes the header and whatever rows fill produces to a temporary
+// file, then copies the finished file to w.  The database rows fill reads are
+// closed before anything is sent to w, so a slow client can't hold a read
+// open (and block writers) for the length of the download.
+func spoolCSV(w io.Writer, header []string, fill func(*csv.Writer) error) error {
+	var tmp, err = ioutil.TempFile("", "headlamp-export-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var cw = csv.NewWriter(tmp)
+	err = cw.Write(header)
+	if err != nil {
+		return err
+	}
+	err = fill(cw)
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	err = cw.Error()
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, tmp)
+	return err
+}
+
+// ExportCategory writes every file in the category to w as CSV, one row per
+// file.  Files are streamed from the database to a temporary file, so even
+// the largest categories needn't fit in memory.
+func (op *Operation) ExportCategory(c *Category, w io.Writer) error {
+	var sel = op.FileSelect(c, nil).TreeMode(true)
+	return spoolCSV(w, exportHeader, func(cw *csv.Writer) error {
+		return op.EachFile(sel, func(f *File) error {
+			return cw.Write([]string{
+				f.PublicPath,
+				f.FullPath,
+				f.ArchiveDate,
+				strconv.FormatInt(f.Filesize, 10),
+				f.Checksum,
+			})
+		})
+	})
+}
+
+// ExportQuery writes every file the query matches to w as CSV, one row per
+// file.  The query's page is ignored, so the whole result set is written, and
+// as with ExportCategory, files are spooled rather than loaded all at once.
+func (op *Operation) ExportQuery(q FileQuery, w io.Writer) error {
+	q.Page = Page{}
+	var sel, err = q.Select(op)
+	if err != nil {
+		return err
+	}
+
+	return spoolCSV(w, resultsHeader, func(cw *csv.Writer) error {
+		return op.EachFile(sel, func(f *File) error {
+			var cname string
+			if f.Category != nil {
+				cname = f.Category.Name
+			}
+			return cw.Write([]string{
+				f.PublicPath,
+				strconv.FormatInt(f.Filesize, 10),
+				f.Checksum,
+				cname,
+			})
+		})
+	})
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"time"
+)
+
+// Favorites use the same kinds as permalinks, since a favorite is just a
+// user's bookmark of a file's or folder's permanent id
+const (
+	FavoriteFile   = PermalinkFile
+	FavoriteFolder = PermalinkFolder
+)
+
+// FavoriteIDs returns the ids of every item of the given kind the user has
+// starred, newest first
+func (op *Operation) FavoriteIDs(u *User, kind string) ([]uint64, error) {
+	var rows = op.Operation.Query("SELECT item_id FROM favorites WHERE user_id = ? AND kind = ? "+
+		"ORDER BY added_at DESC, id DESC", u.ID, kind)
+	var ids []uint64
+	for rows.Next() {
+		var id uint64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	return ids, op.Operation.Err()
+}
+
+// AddFavorite stars the item for the user.  Starring an item twice does
+// nothing.  Times are stored in UTC so that they compare correctly as strings.
+func (op *Operation) AddFavorite(u *User, kind string, id uint64) error {
+	op.Operation.Exec("INSERT OR IGNORE INTO favorites (user_id, kind, item_id, added_at) VALUES (?, ?, ?, ?)",
+		u.ID, kind, id, time.Now().UTC())
+	return op.Operation.Err()
+}
+
+// RemoveFavorite takes the item out of the user's favorites
+func (op *Operation) RemoveFavorite(u *User, kind string, id uint64) error {
+	op.Operation.Exec("DELETE FROM favorites WHERE user_id = ? AND kind = ? AND item_id = ?", u.ID, kind, id)
+	return op.Operation.Err()
+}
+
+// FavoriteFiles returns the files the user has starred, newest first, with
+// their categories populated.  Files which have since been removed, or which
+// op's user can no longer see, are skipped.
+func (op *Operation) FavoriteFiles(u *User) ([]*File, error) {
+	var ids, err = op.FavoriteIDs(u, FavoriteFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*File
+	for _, id := range ids {
+		var f *File
+		f, err = op.FindFileByID(id)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+
+	err = op.PopulateCategories(files, nil)
+	return files, err
+}
+
+// FavoriteFolders returns the folders the user has starred, newest first,
+// with their categories populated.  Folders which have since been removed,
+// or which op's user can no longer see, are skipped.
+func (op *Operation) FavoriteFolders(u *User) ([]*Folder, error) {
+	var ids, err = op.FavoriteIDs(u, FavoriteFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	var folders []*Folder
+	for _, id := range ids {
+		var f *Folder
+		f, err = op.FindFolderByPermalink(int(id))
+		if err == nil && f != nil {
+			f.Category, err = op.FindCategoryByID(f.CategoryID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if f != nil && f.Category != nil {
+			folders = append(folders, f)
+		}
+	}
+	return folders, nil
+}
+
+// moveFavorites points everyone's favorites for the given item at its
+// replacement, e.g., when a category merge drops a duplicate.  Users who had
+// starred both keep a single favorite.
+func (op *Operation) moveFavorites(kind string, oldID, newID uint64) {
+	op.Operation.Exec("UPDATE OR IGNORE favorites SET item_id = ? WHERE kind = ? AND item_id = ?", newID, kind, oldID)
+	op.Operation.Exec("DELETE FROM favorites WHERE kind = ? AND item_id = ?", kind, oldID)
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// archiveMimeTypes covers formats common in the dark archive which the
+// system MIME database may not know about (or may report inconsistently from
+// one server to the next)
+var archiveMimeTypes = map[string]string{
+	"tif":  "image/tiff",
+	"tiff": "image/tiff",
+	"jp2":  "image/jp2",
+	"jpg":  "image/jpeg",
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"pdf":  "application/pdf",
+	"xml":  "application/xml",
+	"txt":  "text/plain",
+	"csv":  "text/csv",
+	"wav":  "audio/wav",
+	"mp3":  "audio/mpeg",
+	"flac": "audio/flac",
+	"mov":  "video/quicktime",
+	"mp4":  "video/mp4",
+	"mkv":  "video/x-matroska",
+	"zip":  "application/zip",
+	"tar":  "application/x-tar",
+}
+
+// defaultMimeType is used when we have no idea what a file is
+const defaultMimeType = "application/octet-stream"
+
+// Format families group files by what kind of thing they are, for filters and
+// reports which don't care about the specific format
+const (
+	FamilyImage    = "image"
+	FamilyAudio    = "audio"
+	FamilyVideo    = "video"
+	FamilyDocument = "document"
+	FamilyOther    = "other"
+)
+
+// FormatFamilies lists every format family in the order they're offered to
+// users
+var FormatFamilies = []string{FamilyImage, FamilyAudio, FamilyVideo, FamilyDocument, FamilyOther}
+
+// documentMimeTypes are the non-text MIME types we consider documents
+var documentMimeTypes = map[string]bool{
+	"application/pdf":                         true,
+	"application/rtf":                         true,
+	"application/msword":                      true,
+	"application/xml":                         true,
+	"application/vnd.oasis.opendocument.text": true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": true,
+}
+
+// NormalizeExtension lowercases ext and strips any leading dots and space
+func NormalizeExtension(ext string) string {
+	return strings.ToLower(strings.TrimLeft(strings.TrimSpace(ext), "."))
+}
+
+// FileType returns the normalized extension and best-guess MIME type for the
+// given filename
+func FileType(name string) (ext, mimeType string) {
+	ext = NormalizeExtension(filepath.Ext(name))
+	if ext == "" {
+		return "", defaultMimeType
+	}
+
+	mimeType = archiveMimeTypes[ext]
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension("." + ext)
+	}
+	if mimeType == "" {
+		return ext, defaultMimeType
+	}
+
+	// Strip parameters like "; charset=utf-8"
+	var mediaType, _, err = mime.ParseMediaType(mimeType)
+	if err != nil {
+		return ext, defaultMimeType
+	}
+	return ext, mediaType
+}
+
+// FormatFamily returns the format family for a MIME type as returned by
+// FileType
+func FormatFamily(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return FamilyImage
+	case strings.HasPrefix(mimeType, "audio/"):
+		return FamilyAudio
+	case strings.HasPrefix(mimeType, "video/"):
+		return FamilyVideo
+	case strings.HasPrefix(mimeType, "text/"), documentMimeTypes[mimeType]:
+		return FamilyDocument
+	}
+	return FamilyOther
+}
+
+// BackfillFileTypes sets the extension, MIME type, and format family on any
+// files indexed before we tracked them.  Files are processed in batches so we never hold
+// more than a small slice of records at once.  Returns the number of files
+// updated.
+func (op *Operation) BackfillFileTypes() (int, error) {
+	var total int
+	for {
+		var files []*File
+		op.Files.Select().Where("mime_type = '' OR format_family = ''").Order("id").Limit(1000).AllObjects(&files)
+		if op.Operation.Err() != nil || len(files) == 0 {
+			return total, op.Operation.Err()
+		}
+
+		for _, f := range files {
+			f.Extension, f.MimeType = FileType(f.Name)
+			f.FormatFamily = FormatFamily(f.MimeType)
+			op.Operation.Exec("UPDATE files SET extension = ?, mime_type = ?, format_family = ? WHERE id = ?",
+				f.Extension, f.MimeType, f.FormatFamily, f.ID)
+		}
+		total += len(files)
+	}
+}
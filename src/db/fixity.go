@@ -0,0 +1,89 @@
+package db
+
+import (
+	"strings"
+	"time"
+)
+
+// Results of a fixity check
+const (
+	FixityOK       = "ok"
+	FixityMismatch = "mismatch"
+	FixityMissing  = "missing"
+	FixityError    = "error"
+)
+
+// FixityCheck maps to the fixity_checks table, recording a single re-hash of
+// a file from the dark archive.  Expected is the checksum we had on record at
+// the time, and Actual is what the file hashed to, if it could be read.
+// Message explains FixityError results.
+type FixityCheck struct {
+	ID        int `sql:",primary"`
+	FileID    uint64
+	CheckedAt time.Time
+	Status    string
+	Expected  string
+	Actual    string
+	Message   string
+}
+
+// OK returns true if the file matched its stored checksum
+func (c *FixityCheck) OK() bool {
+	return c.Status == FixityOK
+}
+
+// ChecksumsMatch compares a stored checksum with a computed one.  Inventories
+// don't agree on letter case, so neither do we.
+func ChecksumsMatch(expected, actual string) bool {
+	return strings.EqualFold(strings.TrimSpace(expected), strings.TrimSpace(actual))
+}
+
+// FixityFileIDs returns the ids of files due for a fixity check, never-checked
+// files first and then those checked least recently, so repeated sampled runs
+// eventually cover everything.  c limits the files to a single category if
+// it's non-nil, and limit caps the number of ids returned unless it's zero.
+func (op *Operation) FixityFileIDs(c *Category, limit int) ([]uint64, error) {
+	var sql = "SELECT f.id FROM files f" +
+		" LEFT JOIN (SELECT file_id, MAX(checked_at) AS last_checked FROM fixity_checks GROUP BY file_id) fc" +
+		" ON fc.file_id = f.id"
+	var args []interface{}
+	if c != nil {
+		sql += " WHERE f.category_id = ?"
+		args = append(args, c.ID)
+	}
+	sql += " ORDER BY fc.last_checked IS NOT NULL, fc.last_checked, f.id"
+	if limit > 0 {
+		sql += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	var rows = op.Operation.Query(sql, args...)
+	var ids []uint64
+	for rows.Next() {
+		var id uint64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	return ids, op.Operation.Err()
+}
+
+// WriteFixityCheck stores the result of checking a file
+func (op *Operation) WriteFixityCheck(c *FixityCheck) error {
+	if c.CheckedAt.IsZero() {
+		c.CheckedAt = time.Now().UTC()
+	}
+	op.FixityChecks.Save(c)
+	return op.Operation.Err()
+}
+
+// LatestFixityCheck returns the most recent check of f, or nil if it has
+// never been checked
+func (op *Operation) LatestFixityCheck(f *File) (*FixityCheck, error) {
+	var c = &FixityCheck{}
+	var ok = op.FixityChecks.Select().Where("file_id = ?", f.ID).Order("checked_at DESC, id DESC").First(c)
+	if !ok {
+		return nil, op.Operation.Err()
+	}
+	return c, nil
+}
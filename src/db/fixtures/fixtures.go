@@ -0,0 +1,233 @@
+// Package fixtures loads known sets of categories, folders, and files into a
+// database so code can be exercised against predictable data, typically with
+// a database from db.NewMemory.  Sets can be built as Go structs or read from
+// YAML files, whose keys are the snake_case versions of the field names:
+//
+//	inventory: fixtures/INVENTORY/photos.csv
+//	access_roles:
+//	  Staff Only: staff
+//	folders:
+//	  - category: Photos
+//	    public_path: events/embargoed
+//	    restricted: true
+//	files:
+//	  - category: Photos
+//	    archive_date: "2018-01-01"
+//	    public_path: events/2018/party.tif
+//	    filesize: 1024
+package fixtures
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/uoregon-libraries/headlamp/src/db"
+	"gopkg.in/yaml.v2"
+)
+
+// File describes a single file to load.  Folders are created as needed from
+// the public path.  FullPath defaults to "<category>/<archive date>/<public
+// path>", which matches the "category/date" path format.
+type File struct {
+	Category    string    `yaml:"category"`
+	ArchiveDate string    `yaml:"archive_date"`
+	PublicPath  string    `yaml:"public_path"`
+	FullPath    string    `yaml:"full_path"`
+	Filesize    int64     `yaml:"filesize"`
+	Checksum    string    `yaml:"checksum"`
+	ModifiedAt  time.Time `yaml:"modified_at"`
+	Restricted  bool      `yaml:"restricted"`
+}
+
+// Folder describes a folder to load even if no files live in it, or one
+// which needs to be restricted
+type Folder struct {
+	Category   string `yaml:"category"`
+	PublicPath string `yaml:"public_path"`
+	Restricted bool   `yaml:"restricted"`
+}
+
+// Set is a group of fixtures which are loaded together.  All files in a set
+// are attributed to a single inventory.  AccessRoles maps category names to
+// the role needed to see them.
+type Set struct {
+	Inventory   string            `yaml:"inventory"`
+	Categories  []string          `yaml:"categories"`
+	AccessRoles map[string]string `yaml:"access_roles"`
+	Folders     []Folder          `yaml:"folders"`
+	Files       []File            `yaml:"files"`
+}
+
+// Load stores everything in the set in a single transaction
+func Load(dbh *db.Database, s Set) error {
+	return dbh.InTransaction(func(op *db.Operation) error {
+		var l = &loader{op: op, categories: make(map[string]*db.Category)}
+		return l.load(s)
+	})
+}
+
+// Parse reads a set from YAML.  Unknown keys are an error, so a typo can't
+// quietly leave something out of a test's data.
+func Parse(data []byte) (Set, error) {
+	var s Set
+	var err = yaml.UnmarshalStrict(data, &s)
+	return s, err
+}
+
+// LoadFile reads a set from the given YAML file and loads it
+func LoadFile(dbh *db.Database, filename string) error {
+	var data, err = ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var s Set
+	s, err = Parse(data)
+	if err != nil {
+		return fmt.Errorf("unable to parse fixtures %q: %s", filename, err)
+	}
+	return Load(dbh, s)
+}
+
+type loader struct {
+	op         *db.Operation
+	categories map[string]*db.Category
+}
+
+func (l *loader) load(s Set) error {
+	var inv = &db.Inventory{Path: s.Inventory}
+	if inv.Path == "" {
+		inv.Path = "fixtures/INVENTORY/fixtures.csv"
+	}
+	var err = l.op.WriteInventory(inv)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range s.Categories {
+		_, err = l.category(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	for name, role := range s.AccessRoles {
+		var c *db.Category
+		c, err = l.category(name)
+		if err == nil {
+			err = l.op.SetCategoryAccessRole(c, role)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to set access role of category %q: %s", name, err)
+		}
+	}
+
+	for _, f := range s.Folders {
+		var folder *db.Folder
+		folder, err = l.folder(f.Category, f.PublicPath)
+		if err == nil && f.Restricted {
+			err = l.op.SetFolderRestricted(folder, true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, f := range s.Files {
+		err = l.file(inv, f)
+		if err != nil {
+			return fmt.Errorf("unable to load file %q: %s", f.PublicPath, err)
+		}
+	}
+
+	return nil
+}
+
+func (l *loader) category(name string) (*db.Category, error) {
+	if l.categories[name] != nil {
+		return l.categories[name], nil
+	}
+	var c, err = l.op.FindOrCreateCategory(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create category %q: %s", name, err)
+	}
+	l.categories[name] = c
+	return c, nil
+}
+
+// folder creates the folder at path along with all its ancestors, returning
+// the deepest one
+func (l *loader) folder(catName, path string) (*db.Folder, error) {
+	var c, err = l.category(catName)
+	if err != nil {
+		return nil, err
+	}
+
+	var parent *db.Folder
+	var curPath string
+	for _, part := range strings.Split(path, string(os.PathSeparator)) {
+		curPath = filepath.Join(curPath, part)
+		parent, err = l.op.FindOrCreateFolder(c, parent, curPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create folder %q: %s", curPath, err)
+		}
+	}
+	return parent, nil
+}
+
+func (l *loader) file(inv *db.Inventory, f File) error {
+	var c, err = l.category(f.Category)
+	if err != nil {
+		return err
+	}
+
+	var folder *db.Folder
+	var folderPath = filepath.Dir(f.PublicPath)
+	if folderPath != "." {
+		folder, err = l.folder(f.Category, folderPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if f.FullPath == "" {
+		f.FullPath = filepath.Join(f.Category, f.ArchiveDate, f.PublicPath)
+	}
+	if folder != nil {
+		_, err = l.op.FindOrCreateRealFolder(folder, filepath.Dir(f.FullPath))
+		if err != nil {
+			return err
+		}
+	}
+
+	var name = filepath.Base(f.PublicPath)
+	var ext, mimeType = db.FileType(name)
+	var dbFile = &db.File{
+		Category:     c,
+		CategoryID:   c.ID,
+		Inventory:    inv,
+		InventoryID:  inv.ID,
+		Folder:       folder,
+		Depth:        strings.Count(f.PublicPath, string(os.PathSeparator)),
+		ArchiveDate:  f.ArchiveDate,
+		Checksum:     f.Checksum,
+		Filesize:     f.Filesize,
+		Name:         name,
+		FullPath:     f.FullPath,
+		PublicPath:   f.PublicPath,
+		ModifiedAt:   f.ModifiedAt.UTC(),
+		IndexedAt:    time.Now().UTC(),
+		Extension:    ext,
+		MimeType:     mimeType,
+		FormatFamily: db.FormatFamily(mimeType),
+		Restricted:   f.Restricted,
+	}
+	if folder != nil {
+		dbFile.FolderID = folder.ID
+	}
+	_, err = l.op.UpsertFile(dbFile)
+	return err
+}
@@ -0,0 +1,104 @@
+package fixtures
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+func newDB(t *testing.T) *db.Database {
+	var dbh, err = db.NewMemory("../../../db/migrations")
+	if err != nil {
+		t.Fatalf("Unable to create in-memory database: %s", err)
+	}
+	return dbh
+}
+
+func TestLoad(t *testing.T) {
+	var dbh = newDB(t)
+	defer dbh.Close()
+
+	var modified = time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	var err = Load(dbh, Set{
+		Categories: []string{"Empty"},
+		Folders:    []Folder{{Category: "Photos", PublicPath: "a/b/c"}},
+		Files:      []File{{Category: "Photos", ArchiveDate: "2018-01-01", PublicPath: "a/b/x.tif", Filesize: 10, ModifiedAt: modified}},
+	})
+	if err != nil {
+		t.Fatalf("Unable to load fixtures: %s", err)
+	}
+
+	var op = dbh.Operation()
+	var cats []*db.Category
+	cats, err = op.AllCategories()
+	if err != nil || len(cats) != 2 {
+		t.Fatalf("Expected 2 categories, got %d (error: %v)", len(cats), err)
+	}
+
+	var photos, _ = op.FindCategoryByName("Photos")
+	for _, p := range []string{"a", "a/b", "a/b/c"} {
+		var f, err = op.FindFolderByPath(photos, p)
+		if err != nil || f == nil {
+			t.Errorf("Expected folder %q to be created (error: %v)", p, err)
+		}
+	}
+
+	var f *db.File
+	f, err = op.FindFileByPublicPath(photos.ID, "2018-01-01", "a/b/x.tif")
+	if err != nil || f == nil {
+		t.Fatalf("Expected file to be loaded (error: %v)", err)
+	}
+	if f.FullPath != "Photos/2018-01-01/a/b/x.tif" {
+		t.Errorf("Expected default full path, got %q", f.FullPath)
+	}
+	if f.Name != "x.tif" || f.Filesize != 10 || !f.ModifiedAt.Equal(modified) {
+		t.Errorf("File data wasn't stored as given: %#v", f)
+	}
+}
+
+func TestParseRejectsUnknownKeys(t *testing.T) {
+	var _, err = Parse([]byte("files:\n  - category: Photos\n    pubic_path: x.tif\n"))
+	if err == nil {
+		t.Fatalf("Expected an error for a misspelled key, got none")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	var dbh = newDB(t)
+	defer dbh.Close()
+
+	var err = LoadFile(dbh, "testdata/archive.yml")
+	if err != nil {
+		t.Fatalf("Unable to load fixture file: %s", err)
+	}
+
+	var op = dbh.Operation()
+	var personnel, _ = op.FindCategoryByName("Personnel")
+	if personnel == nil || personnel.AccessRole != db.RoleStaff {
+		t.Errorf("Expected Personnel to need the staff role, got %#v", personnel)
+	}
+
+	var photos, _ = op.FindCategoryByName("Photos")
+	var embargoed, _ = op.FindFolderByPath(photos, "events/embargoed")
+	if embargoed == nil || !embargoed.Restricted {
+		t.Errorf("Expected events/embargoed to be restricted, got %#v", embargoed)
+	}
+	var private, _ = op.FindFileByPublicPath(photos.ID, "2018-01-01", "events/2018/private.tif")
+	if private == nil || !private.Restricted {
+		t.Errorf("Expected private.tif to be restricted, got %#v", private)
+	}
+	var party, _ = op.FindFileByPublicPath(photos.ID, "2018-01-01", "events/2018/party.tif")
+	if party == nil || party.Checksum != "1111" || party.ModifiedAt.IsZero() {
+		t.Errorf("Expected party.tif with its checksum and modification time, got %#v", party)
+	}
+}
+
+func TestLoadFileMissing(t *testing.T) {
+	var dbh = newDB(t)
+	defer dbh.Close()
+
+	if LoadFile(dbh, "testdata/nope.yml") == nil {
+		t.Fatalf("Expected an error loading a missing file, got none")
+	}
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// FolderRedirect maps to the folder_redirects table, which remembers the
+// public paths folders used to have
+type FolderRedirect struct {
+	ID         int `sql:",primary"`
+	CategoryID int
+	OldPath    string
+	FolderID   int
+}
+
+// Kinds of records a permalink redirect can point to
+const (
+	PermalinkFile   = "file"
+	PermalinkFolder = "folder"
+)
+
+// addFolderRedirect points oldPath, in f's category, at f.  Any redirect for
+// f's current path is dropped, since that path is real again.
+func (op *Operation) addFolderRedirect(oldPath string, f *Folder) {
+	op.Operation.Exec("DELETE FROM folder_redirects WHERE category_id = ? AND old_path = ?", f.CategoryID, f.PublicPath)
+	op.Operation.Exec("INSERT OR REPLACE INTO folder_redirects (category_id, old_path, folder_id) VALUES (?, ?, ?)",
+		f.CategoryID, oldPath, f.ID)
+}
+
+// FindFolderByOldPath returns the folder which used to live at path in the
+// given category, or nil if there wasn't one.  Since a moved folder takes its
+// subfolders along, path's ancestors are checked as well: if "a/b" moved to
+// "c", then "a/b/d" is now "c/d".
+func (op *Operation) FindFolderByOldPath(c *Category, path string) (*Folder, error) {
+	path = NormalizePath(path)
+	for p := path; p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		var r = &FolderRedirect{}
+		var ok = op.folderRedirects.Select().Where("category_id = ? AND old_path = ?", c.ID, p).First(r)
+		if op.Operation.Err() != nil {
+			return nil, op.Operation.Err()
+		}
+		if !ok {
+			continue
+		}
+
+		var moved, err = op.FindFolderByID(r.FolderID)
+		if err != nil || moved == nil {
+			return nil, err
+		}
+		var rest = strings.TrimPrefix(path, p)
+		return op.FindFolderByPath(&Category{ID: moved.CategoryID}, moved.PublicPath+rest)
+	}
+	return nil, nil
+}
+
+// addPermalinkRedirect points permalinks for the given kind of record with
+// oldID at newID, including any permalinks which already led to oldID
+func (op *Operation) addPermalinkRedirect(kind string, oldID, newID uint64) {
+	op.Operation.Exec("UPDATE permalink_redirects SET new_id = ? WHERE kind = ? AND new_id = ?", newID, kind, oldID)
+	op.Operation.Exec("INSERT OR REPLACE INTO permalink_redirects (kind, old_id, new_id) VALUES (?, ?, ?)", kind, oldID, newID)
+}
+
+// permalinkTarget returns the id which replaced the given record's id, or
+// zero if it was never replaced
+func (op *Operation) permalinkTarget(kind string, id uint64) (uint64, error) {
+	var newID uint64
+	var rows = op.Operation.Query("SELECT new_id FROM permalink_redirects WHERE kind = ? AND old_id = ?", kind, id)
+	for rows.Next() {
+		rows.Scan(&newID)
+	}
+	rows.Close()
+	return newID, op.Operation.Err()
+}
+
+// FindFileByPermalink returns the file with the given id or, if that file
+// was merged into another, the file which replaced it.  Nil is returned if
+// neither exists or op's user can't see it.
+func (op *Operation) FindFileByPermalink(id uint64) (*File, error) {
+	var f, err = op.FindFileByID(id)
+	if err != nil || f != nil {
+		return f, err
+	}
+
+	var newID uint64
+	newID, err = op.permalinkTarget(PermalinkFile, id)
+	if err != nil || newID == 0 {
+		return nil, err
+	}
+	return op.FindFileByID(newID)
+}
+
+// FindFolderByPermalink returns the folder with the given id or, if that
+// folder was merged into another, the folder which replaced it.  Nil is
+// returned if neither exists or op's user can't see it.  Category and parent
+// folder data are not populated.
+func (op *Operation) FindFolderByPermalink(id int) (*Folder, error) {
+	var f, err = op.FindFolderByID(id)
+	if err == nil && f == nil {
+		var newID uint64
+		newID, err = op.permalinkTarget(PermalinkFolder, uint64(id))
+		if err == nil && newID != 0 {
+			f, err = op.FindFolderByID(int(newID))
+		}
+	}
+	if err != nil || f == nil {
+		return nil, err
+	}
+
+	// FindFolderByID doesn't hide anything, so visibility is checked by looking
+	// the folder up again by path
+	return op.FindFolderByPath(&Category{ID: f.CategoryID}, f.PublicPath)
+}
@@ -0,0 +1,50 @@
+package db
+
+import "time"
+
+// subtreeClause matches the rows of a files or folders query which are
+// beneath a folder.  Its arguments are the folder's category id and its
+// public path twice.
+const subtreeClause = "category_id = ? AND substr(public_path, 1, length(?) + 1) = ? || '/'"
+
+// FolderFiles returns every file beneath f, at any depth
+func (op *Operation) FolderFiles(f *Folder) ([]*File, error) {
+	var files []*File
+	op.Files.Select().Where(subtreeClause, f.CategoryID, f.PublicPath, f.PublicPath).Order("public_path").AllObjects(&files)
+	return files, op.Operation.Err()
+}
+
+// ClearRealFolders removes the real folders of f and every folder beneath
+// it, so that reindexing the subtree only links the folders to the real
+// paths its files are in now
+func (op *Operation) ClearRealFolders(f *Folder) error {
+	op.Operation.Exec("DELETE FROM real_folders WHERE folder_id = ? OR folder_id IN (SELECT id FROM folders WHERE "+subtreeClause+")",
+		f.ID, f.CategoryID, f.PublicPath, f.PublicPath)
+	return op.Operation.Err()
+}
+
+// PruneFolder finishes off a reindex of f's subtree: files beneath it which
+// weren't indexed since the given time are removed, as are folders (f
+// included) left with no files beneath them, and the category's folder
+// totals are rebuilt.  Returns the number of files removed.
+func (op *Operation) PruneFolder(f *Folder, since time.Time) (int, error) {
+	var args = []interface{}{f.CategoryID, f.PublicPath, f.PublicPath, since.UTC()}
+	op.Operation.Exec("DELETE FROM fixity_checks WHERE file_id IN (SELECT id FROM files WHERE "+subtreeClause+" AND indexed_at < ?)", args...)
+	var res = op.Operation.Exec("DELETE FROM files WHERE "+subtreeClause+" AND indexed_at < ?", args...)
+	if op.Operation.Err() != nil {
+		return 0, op.Operation.Err()
+	}
+	var removed = int(res.RowsAffected())
+
+	// A folder is empty when no file is beneath it; its real folders and
+	// totals go with it
+	var empty = "SELECT id FROM folders WHERE (id = ? OR " + subtreeClause + ") AND NOT EXISTS (" +
+		"SELECT 1 FROM files fi WHERE fi.category_id = folders.category_id" +
+		" AND substr(fi.public_path, 1, length(folders.public_path) + 1) = folders.public_path || '/')"
+	args = []interface{}{f.ID, f.CategoryID, f.PublicPath, f.PublicPath}
+	op.Operation.Exec("DELETE FROM real_folders WHERE folder_id IN ("+empty+")", args...)
+	op.Operation.Exec("DELETE FROM folder_totals WHERE folder_id IN ("+empty+")", args...)
+	op.Operation.Exec("DELETE FROM folders WHERE id IN ("+empty+")", args...)
+	op.recomputeFolderTotals(&Category{ID: f.CategoryID})
+	return removed, op.Operation.Err()
+}
@@ -0,0 +1,70 @@
+package db
+
+import "strings"
+
+// FolderTotal maps to the folder_totals table, which keeps a running count of
+// the files and bytes stored beneath a folder, at any depth
+type FolderTotal struct {
+	ID        int `sql:",primary"`
+	FolderID  int
+	FileCount int64
+	ByteCount int64
+}
+
+// FolderTotals returns the aggregate file count and byte size for everything
+// under the given folder.  A folder which has never had a file indexed
+// beneath it gets an empty total rather than nil.
+func (op *Operation) FolderTotals(f *Folder) (*FolderTotal, error) {
+	var t = &FolderTotal{}
+	var ok = op.folderTotals.Select().Where("folder_id = ?", f.ID).First(t)
+	if !ok {
+		t = &FolderTotal{FolderID: f.ID}
+	}
+	return t, op.Operation.Err()
+}
+
+// addToFolderTotals adjusts the totals of the file's folder and all of its
+// ancestors.  The folder chain on f is used where it's present, since the
+// indexer already has it in memory, and we hit the database otherwise.
+func (op *Operation) addToFolderTotals(f *File, files, bytes int64) error {
+	return op.addToFolderChain(f.FolderID, f.Folder, files, bytes)
+}
+
+// addToFolderChain adjusts the totals of the folder with the given id and all
+// of its ancestors.  folder may be nil, or the in-memory folder chain, which
+// is used as far as it matches.
+func (op *Operation) addToFolderChain(folderID int, folder *Folder, files, bytes int64) error {
+	if folderID == 0 || (files == 0 && bytes == 0) {
+		return nil
+	}
+
+	var ids []interface{}
+	var nextID = folderID
+	for nextID != 0 {
+		if folder == nil || folder.ID != nextID {
+			var err error
+			folder, err = op.FindFolderByID(nextID)
+			if err != nil {
+				return err
+			}
+			if folder == nil {
+				break
+			}
+		}
+
+		ids = append(ids, folder.ID)
+		op.Operation.Exec("INSERT OR IGNORE INTO folder_totals (folder_id) VALUES (?)", folder.ID)
+
+		nextID = folder.FolderID
+		folder = folder.Folder
+	}
+
+	if len(ids) == 0 {
+		return op.Operation.Err()
+	}
+
+	var args = append([]interface{}{files, bytes}, ids...)
+	op.Operation.Exec("UPDATE folder_totals SET file_count = file_count + ?, byte_count = byte_count + ?"+
+		" WHERE folder_id IN ("+strings.Repeat("?, ", len(ids)-1)+"?)", args...)
+	return op.Operation.Err()
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"strings"
+	"time"
+)
+
+// HarvestFilter selects the files a metadata harvest covers.  Zero-valued
+// fields aren't applied.
+type HarvestFilter struct {
+	Category *Category
+
+	// From and Until bound the files' index times: From is inclusive, and
+	// Until is exclusive
+	From  time.Time
+	Until time.Time
+}
+
+// HarvestFiles returns the given page of files matching hf, and the total
+// number of matches.  Files are ordered by id rather than path so that
+// paging through a harvest isn't thrown off by newly indexed files.  Category
+// data is filled in on each file.
+func (op *Operation) HarvestFiles(hf HarvestFilter, page Page) ([]*File, uint64, error) {
+	var fields []string
+	var args []interface{}
+	if hf.Category != nil {
+		fields = append(fields, "category_id = ?")
+		args = append(args, hf.Category.ID)
+	}
+	if !hf.From.IsZero() {
+		fields = append(fields, "indexed_at >= ?")
+		args = append(args, hf.From.UTC())
+	}
+	if !hf.Until.IsZero() {
+		fields = append(fields, "indexed_at < ?")
+		args = append(args, hf.Until.UTC())
+	}
+	if op.hiding() {
+		fields = append(fields, op.hiddenClause("files"))
+	}
+	if len(fields) == 0 {
+		fields = append(fields, "1 = 1")
+	}
+
+	var sel = op.Files.Select().Where(strings.Join(fields, " AND "), args...).Order("id")
+	var total = sel.Count().RowCount()
+	if page.Limit > 0 {
+		sel = sel.Limit(page.Limit).Offset(page.Offset)
+	}
+
+	var files []*File
+	sel.AllObjects(&files)
+	if op.Operation.Err() != nil {
+		return nil, 0, op.Operation.Err()
+	}
+
+	var err = op.PopulateCategories(files, nil)
+	return files, total, err
+}
+
+// EarliestIndexedAt returns the index time of the file which was indexed
+// longest ago, or a zero time if there are no files
+func (op *Operation) EarliestIndexedAt() (time.Time, error) {
+	var f = &File{}
+	var where = "indexed_at > ?"
+	if op.hiding() {
+		where += " AND " + op.hiddenClause("files")
+	}
+	var ok = op.Files.Select().Where(where, time.Time{}).Order("indexed_at").Limit(1).First(f)
+	if !ok {
+		return time.Time{}, op.Operation.Err()
+	}
+	return f.IndexedAt, op.Operation.Err()
+}
@@ -0,0 +1,43 @@
+package db
+
+import "time"
+
+// Workers which record heartbeats
+const (
+	WorkerIndexer  = "index"
+	WorkerArchiver = "archive"
+)
+
+// Workers lists every worker the health check expects a heartbeat from
+var Workers = []string{WorkerIndexer, WorkerArchiver}
+
+// HeartbeatInterval is how often workers should record a heartbeat
+const HeartbeatInterval = time.Minute
+
+// Heartbeat records that the named worker is still alive
+func (op *Operation) Heartbeat(worker string) error {
+	op.Operation.Exec("INSERT OR REPLACE INTO heartbeats (worker, beat_at) VALUES (?, ?)", worker, time.Now().UTC())
+	return op.Operation.Err()
+}
+
+// LastHeartbeats returns the time of each worker's most recent heartbeat.
+// Workers which have never recorded one aren't in the map.
+func (op *Operation) LastHeartbeats() (map[string]time.Time, error) {
+	var beats = make(map[string]time.Time)
+	var rows = op.Operation.Query("SELECT worker, beat_at FROM heartbeats")
+	for rows.Next() {
+		var worker string
+		var t time.Time
+		rows.Scan(&worker, &t)
+		beats[worker] = t
+	}
+	rows.Close()
+	return beats, op.Operation.Err()
+}
+
+// Ping runs a trivial query to make sure the database can be read
+func (op *Operation) Ping() error {
+	var rows = op.Operation.Query("SELECT 1 FROM categories LIMIT 1")
+	rows.Close()
+	return op.Operation.Err()
+}
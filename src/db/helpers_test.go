@@ -0,0 +1,71 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/uoregon-libraries/headlamp/src/db"
+	"github.com/uoregon-libraries/headlamp/src/db/fixtures"
+)
+
+// newArchiveDB returns an in-memory database holding the fixtures in
+// fixtures/testdata/archive.yml
+func newArchiveDB(t *testing.T) *db.Database {
+	var dbh, err = db.NewMemory("../../db/migrations")
+	if err != nil {
+		t.Fatalf("Unable to create in-memory database: %s", err)
+	}
+	err = fixtures.LoadFile(dbh, "fixtures/testdata/archive.yml")
+	if err != nil {
+		dbh.Close()
+		t.Fatalf("Unable to load fixtures: %s", err)
+	}
+	return dbh
+}
+
+// mustCategory looks up a category by name, failing the test if it's missing
+func mustCategory(t *testing.T, op *db.Operation, name string) *db.Category {
+	var c, err = op.FindCategoryByName(name)
+	if err != nil || c == nil {
+		t.Fatalf("Unable to find category %q (error: %v)", name, err)
+	}
+	return c
+}
+
+// mustFolder looks up a folder by path, failing the test if it's missing
+func mustFolder(t *testing.T, op *db.Operation, c *db.Category, path string) *db.Folder {
+	var f, err = op.FindFolderByPath(c, path)
+	if err != nil || f == nil {
+		t.Fatalf("Unable to find folder %q (error: %v)", path, err)
+	}
+	return f
+}
+
+// fileNames returns the names of the given files, for easy comparison
+func fileNames(files []*db.File) []string {
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// folderNames returns the names of the given folders
+func folderNames(folders []*db.Folder) []string {
+	var names []string
+	for _, f := range folders {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package db
+
+import "time"
+
+// IndexProgress maps to the index_progress table, which checkpoints how far
+// the indexer has gotten through a large inventory.  Filesize and ModTime are
+// the inventory file's stats when indexing began; if they no longer match,
+// the checkpoint is useless and the inventory has to start over.
+type IndexProgress struct {
+	ID          int        `sql:",primary"`
+	Inventory   *Inventory `sql:"-"`
+	InventoryID int
+	Filesize    int64
+	ModTime     time.Time
+	LinesDone   int
+	BytesDone   int64
+	StartedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Percent returns how much of the inventory file has been indexed
+func (p *IndexProgress) Percent() float64 {
+	if p.Filesize <= 0 {
+		return 100
+	}
+	return float64(p.BytesDone) * 100 / float64(p.Filesize)
+}
+
+// AllIndexProgress returns every checkpoint, with inventory data filled in,
+// most recently updated first
+func (op *Operation) AllIndexProgress() ([]*IndexProgress, error) {
+	var list []*IndexProgress
+	op.IndexProgress.Select().Order("updated_at DESC").AllObjects(&list)
+	for _, p := range list {
+		var err error
+		p.Inventory, err = op.FindInventoryByID(p.InventoryID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return list, op.Operation.Err()
+}
+
+// WriteIndexProgress creates or updates the checkpoint
+func (op *Operation) WriteIndexProgress(p *IndexProgress) error {
+	p.UpdatedAt = time.Now().UTC()
+	op.IndexProgress.Save(p)
+	return op.Operation.Err()
+}
+
+// DeleteIndexProgress removes the checkpoint for a finished inventory
+func (op *Operation) DeleteIndexProgress(p *IndexProgress) error {
+	op.Operation.Exec("DELETE FROM index_progress WHERE id = ?", p.ID)
+	return op.Operation.Err()
+}
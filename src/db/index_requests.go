@@ -0,0 +1,171 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// Index request statuses.  A request starts out pending, moves to in-progress
+// when the indexer picks it up, and ends up succeeded or failed.
+const (
+	IndexRequestPending    = "pending"
+	IndexRequestInProgress = "in_progress"
+	IndexRequestSucceeded  = "succeeded"
+	IndexRequestFailed     = "failed"
+)
+
+// IndexRequest maps to the index_requests table.  Each is a request, made by
+// an admin in the web app, for the indexer to run now instead of waiting for
+// its next regular scan.  An empty Category asks for a full run; otherwise
+// only the named category's inventories are indexed.  A request with a
+// FolderID instead asks for just that folder, in Category, to be rebuilt from
+// its inventories.  IndexRunID points to the summary of the run which handled
+// the request, once there is one.
+type IndexRequest struct {
+	ID         int   `sql:",primary"`
+	User       *User `sql:"-"`
+	UserID     int
+	Category   string
+	FolderID   int
+	FolderPath string
+	Status     string
+	CreatedAt  time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+	LastError  string
+	IndexRunID int
+}
+
+// Active returns true if the request hasn't finished yet
+func (r *IndexRequest) Active() bool {
+	return r.Status == IndexRequestPending || r.Status == IndexRequestInProgress
+}
+
+// Elapsed returns how long the request has been running, or how long it took
+// if it's finished, to the nearest second
+func (r *IndexRequest) Elapsed() time.Duration {
+	if r.StartedAt.IsZero() {
+		return 0
+	}
+	var end = r.FinishedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return end.Sub(r.StartedAt).Round(time.Second)
+}
+
+// String describes what the request asked for
+func (r *IndexRequest) String() string {
+	if r.FolderID != 0 {
+		return fmt.Sprintf("reindex of folder %q in category %q", r.FolderPath, r.Category)
+	}
+	if r.Category == "" {
+		return "full index run"
+	}
+	return fmt.Sprintf("index run for category %q", r.Category)
+}
+
+// QueueIndexRequest asks the indexer to run, attributing the request to the
+// given user (if any).  If a request for the same category is already
+// waiting to start, that request is returned instead of queueing another, and
+// queued is false.
+func (op *Operation) QueueIndexRequest(u *User, category string) (req *IndexRequest, queued bool, err error) {
+	return op.queueIndexRequest(u, &IndexRequest{Category: NormalizePath(category)})
+}
+
+// QueueFolderReindexRequest asks the indexer to rebuild the given folder,
+// which must have its category loaded, the same way QueueIndexRequest asks
+// for a run
+func (op *Operation) QueueFolderReindexRequest(u *User, f *Folder) (req *IndexRequest, queued bool, err error) {
+	return op.queueIndexRequest(u, &IndexRequest{Category: f.Category.Name, FolderID: f.ID, FolderPath: f.PublicPath})
+}
+
+// queueIndexRequest saves req as a pending request unless one asking for the
+// same thing is already waiting
+func (op *Operation) queueIndexRequest(u *User, req *IndexRequest) (*IndexRequest, bool, error) {
+	var existing = &IndexRequest{}
+	var ok = op.IndexRequests.Select().Where("category = ? AND folder_id = ? AND status = ?",
+		req.Category, req.FolderID, IndexRequestPending).First(existing)
+	if op.Operation.Err() != nil {
+		return nil, false, op.Operation.Err()
+	}
+	if ok {
+		return existing, false, nil
+	}
+
+	req.Status = IndexRequestPending
+	req.CreatedAt = time.Now().UTC()
+	if u != nil {
+		req.UserID = u.ID
+	}
+	op.IndexRequests.Save(req)
+	return req, true, op.Operation.Err()
+}
+
+// RecentIndexRequests returns up to limit requests, most recent first, with
+// their users filled in
+func (op *Operation) RecentIndexRequests(limit int) ([]*IndexRequest, error) {
+	var list []*IndexRequest
+	op.IndexRequests.Select().Order("created_at DESC, id DESC").Limit(uint64(limit)).AllObjects(&list)
+	for _, req := range list {
+		if req.UserID == 0 {
+			continue
+		}
+		var err error
+		req.User, err = op.FindUserByID(req.UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return list, op.Operation.Err()
+}
+
+// StartIndexRequest finds the oldest pending request and moves it to
+// in-progress.  Returns nil if nothing is waiting.
+func (op *Operation) StartIndexRequest() (*IndexRequest, error) {
+	var req = &IndexRequest{}
+	var ok = op.IndexRequests.Select().Where("status = ?", IndexRequestPending).Order("created_at ASC, id ASC").Limit(1).First(req)
+	if op.Operation.Err() != nil || !ok {
+		return nil, op.Operation.Err()
+	}
+
+	req.Status = IndexRequestInProgress
+	req.StartedAt = time.Now().UTC()
+	op.IndexRequests.Save(req)
+	return req, op.Operation.Err()
+}
+
+// FinishIndexRequest records the outcome of a request the indexer ran: the
+// run which handled it, if any, and whether it failed
+func (op *Operation) FinishIndexRequest(req *IndexRequest, run *IndexRun, runErr error) error {
+	req.Status = IndexRequestSucceeded
+	req.LastError = ""
+	if runErr != nil {
+		req.Status = IndexRequestFailed
+		req.LastError = runErr.Error()
+	}
+	if run != nil {
+		req.IndexRunID = run.ID
+	}
+	req.FinishedAt = time.Now().UTC()
+	op.IndexRequests.Save(req)
+	return op.Operation.Err()
+}
+
+// RequeueIndexRequest puts a request back in the queue, for runs which were
+// stopped before they finished
+func (op *Operation) RequeueIndexRequest(req *IndexRequest) error {
+	req.Status = IndexRequestPending
+	req.StartedAt = time.Time{}
+	op.IndexRequests.Save(req)
+	return op.Operation.Err()
+}
+
+// ResetIndexRequests puts requests which were in progress back in the queue.
+// The indexer calls this at startup, since anything still in progress then
+// was interrupted when the indexer last exited.
+func (op *Operation) ResetIndexRequests() (int64, error) {
+	var res = op.Operation.Exec("UPDATE index_requests SET status = ? WHERE status = ?",
+		IndexRequestPending, IndexRequestInProgress)
+	return res.RowsAffected(), op.Operation.Err()
+}
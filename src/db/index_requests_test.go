@@ -0,0 +1,51 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+func TestQueueIndexRequests(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var op = dbh.Operation()
+
+	var c = mustCategory(t, op, "Photos")
+	var f = mustFolder(t, op, c, "events")
+	f.Category = c
+
+	var run, queued, err = op.QueueIndexRequest(nil, "Photos")
+	if err != nil || !queued {
+		t.Fatalf("Expected a category run to be queued (queued: %v, error: %v)", queued, err)
+	}
+
+	var folderReq *db.IndexRequest
+	folderReq, queued, err = op.QueueFolderReindexRequest(nil, f)
+	if err != nil || !queued {
+		t.Fatalf("Expected a folder reindex to be queued alongside the category run (queued: %v, error: %v)", queued, err)
+	}
+	if folderReq.FolderID != f.ID || folderReq.FolderPath != "events" || folderReq.Category != "Photos" {
+		t.Errorf("Expected a request for folder %d in Photos, got %#v", f.ID, folderReq)
+	}
+
+	var again *db.IndexRequest
+	again, queued, err = op.QueueFolderReindexRequest(nil, f)
+	if err != nil || queued || again.ID != folderReq.ID {
+		t.Errorf("Expected the waiting folder request to be returned (queued: %v, error: %v)", queued, err)
+	}
+	again, queued, err = op.QueueIndexRequest(nil, "Photos")
+	if err != nil || queued || again.ID != run.ID {
+		t.Errorf("Expected the waiting category run to be returned (queued: %v, error: %v)", queued, err)
+	}
+
+	var started *db.IndexRequest
+	started, err = op.StartIndexRequest()
+	if err != nil || started == nil || started.ID != run.ID {
+		t.Fatalf("Expected the category run to start first, got %#v (error: %v)", started, err)
+	}
+	started, err = op.StartIndexRequest()
+	if err != nil || started == nil || started.FolderID != f.ID {
+		t.Errorf("Expected the folder reindex to start next, got %#v (error: %v)", started, err)
+	}
+}
@@ -0,0 +1,59 @@
+package db
+
+import "time"
+
+// IndexRunStatus maps to the index_run_status table, whose single row
+// describes the indexer's current run, or its last one once FinishedAt is
+// set.  Bytes count the inventory data to be read, which is the best measure
+// of how much work is left; EstimatedFinish is zero until the indexer can
+// make a guess.
+type IndexRunStatus struct {
+	ID               int `sql:",primary"`
+	StartedAt        time.Time
+	UpdatedAt        time.Time
+	FinishedAt       time.Time
+	InventoriesTotal int
+	InventoriesDone  int
+	CurrentInventory string
+	FilesProcessed   int
+	BytesTotal       int64
+	BytesDone        int64
+	EstimatedFinish  time.Time
+}
+
+// Percent returns how much of the run's inventory data has been indexed
+func (s *IndexRunStatus) Percent() float64 {
+	if s.BytesTotal <= 0 {
+		return 100
+	}
+	return float64(s.BytesDone) * 100 / float64(s.BytesTotal)
+}
+
+// Finished returns true if the run is over
+func (s *IndexRunStatus) Finished() bool {
+	return !s.FinishedAt.IsZero()
+}
+
+// WriteIndexRunStatus replaces the stored run status with s
+func (op *Operation) WriteIndexRunStatus(s *IndexRunStatus) error {
+	if s.ID == 0 {
+		var existing = &IndexRunStatus{}
+		if op.IndexRunStatus.Select().First(existing) {
+			s.ID = existing.ID
+		}
+	}
+	s.UpdatedAt = time.Now().UTC()
+	op.IndexRunStatus.Save(s)
+	return op.Operation.Err()
+}
+
+// FindIndexRunStatus returns the status of the indexer's current or most
+// recent run, or nil if it hasn't reported one
+func (op *Operation) FindIndexRunStatus() (*IndexRunStatus, error) {
+	var s = &IndexRunStatus{}
+	var ok = op.IndexRunStatus.Select().First(s)
+	if !ok {
+		s = nil
+	}
+	return s, op.Operation.Err()
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// IndexRun maps to the index_runs table, which summarizes a single pass of
+// the indexer.  Inventories are "skipped" when they haven't changed since
+// they were indexed, or when the run was limited to another category.  Files
+// are skipped when exclude globs or the link policy leave them out, and
+// record errors count the inventory lines which couldn't be parsed.  Stopped
+// is set if the run was interrupted before it finished.  ErrorReport is the
+// path to the CSV file listing the run's problems, if one was written.
+type IndexRun struct {
+	ID                 int `sql:",primary"`
+	StartedAt          time.Time
+	FinishedAt         time.Time
+	InventoriesIndexed int
+	InventoriesSkipped int
+	InventoriesFailed  int
+	FilesAdded         int
+	FilesUpdated       int
+	FilesSkipped       int
+	RecordErrors       int
+	Stopped            bool
+	ErrorReport        string
+}
+
+// Elapsed returns how long the run took, to the nearest second
+func (r *IndexRun) Elapsed() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt).Round(time.Second)
+}
+
+// Errors returns the total of failed inventories and bad records
+func (r *IndexRun) Errors() int {
+	return r.InventoriesFailed + r.RecordErrors
+}
+
+// String summarizes the run for logging
+func (r *IndexRun) String() string {
+	return fmt.Sprintf("%d inventories indexed, %d skipped, %d failed; "+
+		"%d files added, %d updated, %d skipped; %d bad records; took %s",
+		r.InventoriesIndexed, r.InventoriesSkipped, r.InventoriesFailed,
+		r.FilesAdded, r.FilesUpdated, r.FilesSkipped, r.RecordErrors,
+		r.Elapsed())
+}
+
+// WriteIndexRun stores the run's summary
+func (op *Operation) WriteIndexRun(r *IndexRun) error {
+	op.IndexRuns.Save(r)
+	return op.Operation.Err()
+}
+
+// RecentIndexRuns returns up to limit runs, most recent first
+func (op *Operation) RecentIndexRuns(limit int) ([]*IndexRun, error) {
+	var list []*IndexRun
+	op.IndexRuns.Select().Order("started_at DESC").Limit(uint64(limit)).AllObjects(&list)
+	return list, op.Operation.Err()
+}
+
+// IndexRunTotals returns how many index runs have been recorded and the
+// total number of seconds they took
+func (op *Operation) IndexRunTotals() (count int64, seconds float64, err error) {
+	var rows = op.Operation.Query("SELECT COUNT(*), " +
+		"COALESCE(SUM((julianday(finished_at) - julianday(started_at)) * 86400), 0) FROM index_runs")
+	for rows.Next() {
+		rows.Scan(&count, &seconds)
+	}
+	rows.Close()
+	return count, seconds, op.Operation.Err()
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// memoryDBCount gives each in-memory database a unique name so they don't
+// share data with one another
+var memoryDBCount int64
+
+// NewMemory returns a Database backed by a private in-memory SQLite database,
+// with the schema built by running the "Up" section of every goose migration
+// in migrationsPath (normally "db/migrations").  This is meant for tests and
+// other throwaway work; nothing is ever written to disk.
+func NewMemory(migrationsPath string) (*Database, error) {
+	// The database is shared by every connection in the pool via SQLite's
+	// shared cache, and it vanishes when the last of them closes, so we hold
+	// one connection open for the life of the process
+	var n = atomic.AddInt64(&memoryDBCount, 1)
+	var dsn = fmt.Sprintf("file:headlamp-memory-%d?mode=memory&cache=shared", n)
+	var _db, err = sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	var keepalive *sql.Conn
+	keepalive, err = _db.Conn(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to in-memory database: %s", err)
+	}
+
+	err = migrate(_db, migrationsPath)
+	if err != nil {
+		_db.Close()
+		return nil, err
+	}
+
+	var db = wrap(_db)
+	db.keepalive = keepalive
+	return db, nil
+}
+
+// migrate applies the "Up" section of each goose migration, in order
+func migrate(_db *sql.DB, migrationsPath string) error {
+	var files, err = filepath.Glob(filepath.Join(migrationsPath, "*.sql"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no migrations found in %q", migrationsPath)
+	}
+	sort.Strings(files)
+
+	for _, fname := range files {
+		var data, err = ioutil.ReadFile(fname)
+		if err != nil {
+			return err
+		}
+
+		var up = string(data)
+		var downIndex = strings.Index(up, "-- +goose Down")
+		if downIndex >= 0 {
+			up = up[:downIndex]
+		}
+
+		_, err = _db.Exec(up)
+		if err != nil {
+			return fmt.Errorf("unable to apply migration %q: %s", filepath.Base(fname), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package db
+
+import "testing"
+
+// migrationsPath is where the goose migrations live, relative to this package
+const migrationsPath = "../../db/migrations"
+
+func TestNewMemory(t *testing.T) {
+	var dbh, err = NewMemory(migrationsPath)
+	if err != nil {
+		t.Fatalf("Unable to create in-memory database: %s", err)
+	}
+	defer dbh.Close()
+
+	// Every table the Database knows about has to exist for its operations to
+	// work, so a missed or failed migration shows up here
+	var op = dbh.Operation()
+	for _, table := range []string{
+		"files", "folders", "real_folders", "categories", "inventories", "archive_jobs", "saved_searches",
+		"users", "sessions", "download_events", "folder_totals", "category_redirects", "folder_redirects",
+		"fixity_checks", "index_progress", "index_runs", "index_requests", "index_run_status", "api_tokens",
+	} {
+		var rows = op.Operation.Query("SELECT COUNT(*) FROM " + table)
+		if op.Operation.Err() != nil {
+			t.Fatalf("Table %q wasn't created: %s", table, op.Operation.Err())
+		}
+		var n int
+		for rows.Next() {
+			rows.Scan(&n)
+		}
+		rows.Close()
+		if n != 0 {
+			t.Errorf("Table %q should start empty, but has %d rows", table, n)
+		}
+	}
+}
+
+func TestNewMemoryIsPrivate(t *testing.T) {
+	var a, err = NewMemory(migrationsPath)
+	if err != nil {
+		t.Fatalf("Unable to create in-memory database: %s", err)
+	}
+	defer a.Close()
+	var b *Database
+	b, err = NewMemory(migrationsPath)
+	if err != nil {
+		t.Fatalf("Unable to create second in-memory database: %s", err)
+	}
+	defer b.Close()
+
+	_, err = a.Operation().FindOrCreateCategory("Photos")
+	if err != nil {
+		t.Fatalf("Unable to create category: %s", err)
+	}
+	var c *Category
+	c, err = b.Operation().FindCategoryByName("Photos")
+	if err != nil {
+		t.Fatalf("Unable to look up category: %s", err)
+	}
+	if c != nil {
+		t.Errorf("Category created in one in-memory database was visible in another")
+	}
+}
+
+func TestNewMemoryMissingMigrations(t *testing.T) {
+	var dbh, err = NewMemory("testdata/no-such-dir")
+	if err == nil {
+		dbh.Close()
+		t.Fatalf("Expected an error without migrations, got none")
+	}
+}
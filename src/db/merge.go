@@ -0,0 +1,156 @@
+package db
+
+import "fmt"
+
+// MergeReport describes what MergeCategories did
+type MergeReport struct {
+	// MovedFolders and MovedFiles count records which were simply reassigned
+	// to the destination category
+	MovedFolders int
+	MovedFiles   int
+
+	// MergedFolders counts source folders whose public path already existed in
+	// the destination; their contents were moved into the existing folder
+	MergedFolders int
+
+	// DuplicateFiles lists the public paths of source files which the
+	// destination already had (same archive date and public path).  The
+	// destination's record is kept.
+	DuplicateFiles []string
+
+	// Conflicts lists the duplicate files whose checksums didn't match, which
+	// likely warrants a closer look
+	Conflicts []string
+}
+
+// MergeCategories moves all of src's folders and files into dest, then
+// deletes src.  Folders with the same public path in both categories are
+// combined, and files which exist in both (same archive date and public path)
+// keep dest's record.  Saved searches, download history, and permalinks follow
+// the records they point to, and a restricted source folder leaves its twin
+// restricted.  src's name (and any names it used to have) redirect to dest
+// afterward.
+//
+// This should always be run via Database.InTransaction so a failure partway
+// through doesn't leave the categories half-merged.
+func (op *Operation) MergeCategories(src, dest *Category) (*MergeReport, error) {
+	if src.ID == dest.ID {
+		return nil, fmt.Errorf("cannot merge category %q into itself", src.Name)
+	}
+
+	var r = &MergeReport{}
+	var err = op.mergeFolders(src, dest, r)
+	if err != nil {
+		return nil, err
+	}
+	err = op.mergeFiles(src, dest, r)
+	if err != nil {
+		return nil, err
+	}
+
+	op.Operation.Exec("UPDATE saved_searches SET category_id = ? WHERE category_id = ?", dest.ID, src.ID)
+	op.Operation.Exec("UPDATE category_redirects SET category_id = ? WHERE category_id = ?", dest.ID, src.ID)
+	op.Operation.Exec("UPDATE OR IGNORE folder_redirects SET category_id = ? WHERE category_id = ?", dest.ID, src.ID)
+	op.Operation.Exec("DELETE FROM folder_redirects WHERE category_id = ?", src.ID)
+	op.addCategoryRedirect(src.Name, dest)
+	op.Operation.Exec("DELETE FROM categories WHERE id = ?", src.ID)
+	op.recomputeFolderTotals(dest)
+	return r, op.Operation.Err()
+}
+
+// mergeFolders reassigns src's folders to dest, collapsing any whose public
+// path dest already has into dest's folder
+func (op *Operation) mergeFolders(src, dest *Category, r *MergeReport) error {
+	var destFolders []*Folder
+	op.Folders.Select().Where("category_id = ?", dest.ID).AllObjects(&destFolders)
+	var srcFolders []*Folder
+	op.Folders.Select().Where("category_id = ?", src.ID).AllObjects(&srcFolders)
+	if op.Operation.Err() != nil {
+		return op.Operation.Err()
+	}
+
+	var destIDs = make(map[string]int, len(destFolders))
+	for _, f := range destFolders {
+		destIDs[f.PublicPath] = f.ID
+	}
+
+	for _, f := range srcFolders {
+		var destID, exists = destIDs[f.PublicPath]
+		if !exists {
+			op.Operation.Exec("UPDATE folders SET category_id = ? WHERE id = ?", dest.ID, f.ID)
+			r.MovedFolders++
+			continue
+		}
+
+		// Everything pointing at the source folder now points at its twin.  Real
+		// folders dest already has are dropped rather than duplicated.
+		op.Operation.Exec("UPDATE folders SET folder_id = ? WHERE folder_id = ?", destID, f.ID)
+		op.Operation.Exec("UPDATE files SET folder_id = ? WHERE folder_id = ?", destID, f.ID)
+		op.Operation.Exec("UPDATE OR IGNORE real_folders SET folder_id = ? WHERE folder_id = ?", destID, f.ID)
+		op.Operation.Exec("DELETE FROM real_folders WHERE folder_id = ?", f.ID)
+		op.Operation.Exec("UPDATE saved_searches SET folder_id = ? WHERE folder_id = ?", destID, f.ID)
+		op.Operation.Exec("DELETE FROM folder_totals WHERE folder_id = ?", f.ID)
+		op.Operation.Exec("DELETE FROM folders WHERE id = ?", f.ID)
+		op.Operation.Exec("UPDATE folder_redirects SET folder_id = ? WHERE folder_id = ?", destID, f.ID)
+		op.addPermalinkRedirect(PermalinkFolder, uint64(f.ID), uint64(destID))
+		op.moveFavorites(FavoriteFolder, uint64(f.ID), uint64(destID))
+		if f.Restricted {
+			op.Operation.Exec("UPDATE folders SET restricted = 1 WHERE id = ?", destID)
+		}
+		r.MergedFolders++
+	}
+
+	return op.Operation.Err()
+}
+
+// mergeFiles reassigns src's files to dest, dropping those dest already has
+func (op *Operation) mergeFiles(src, dest *Category, r *MergeReport) error {
+	var rows = op.Operation.Query("SELECT s.id, d.id, s.public_path, s.checksum <> d.checksum"+
+		" FROM files s JOIN files d ON d.category_id = ? AND d.archive_date = s.archive_date"+
+		" AND d.public_path = s.public_path WHERE s.category_id = ? ORDER BY s.public_path", dest.ID, src.ID)
+
+	var dupes = make(map[uint64]uint64)
+	for rows.Next() {
+		var srcID, destID uint64
+		var path string
+		var conflict bool
+		rows.Scan(&srcID, &destID, &path, &conflict)
+		dupes[srcID] = destID
+		r.DuplicateFiles = append(r.DuplicateFiles, path)
+		if conflict {
+			r.Conflicts = append(r.Conflicts, path)
+		}
+	}
+	rows.Close()
+	if op.Operation.Err() != nil {
+		return op.Operation.Err()
+	}
+
+	for srcID, destID := range dupes {
+		op.Operation.Exec("UPDATE download_events SET file_id = ? WHERE file_id = ?", destID, srcID)
+		op.Operation.Exec("DELETE FROM fixity_checks WHERE file_id = ?", srcID)
+		op.Operation.Exec("DELETE FROM files WHERE id = ?", srcID)
+		op.addPermalinkRedirect(PermalinkFile, srcID, destID)
+		op.moveFavorites(FavoriteFile, srcID, destID)
+	}
+
+	var res = op.Operation.Exec("UPDATE files SET category_id = ? WHERE category_id = ?", dest.ID, src.ID)
+	if op.Operation.Err() != nil {
+		return op.Operation.Err()
+	}
+	r.MovedFiles = int(res.RowsAffected())
+	return nil
+}
+
+// recomputeFolderTotals rebuilds the folder totals for every folder in the
+// category from scratch
+func (op *Operation) recomputeFolderTotals(c *Category) {
+	op.Operation.Exec("DELETE FROM folder_totals WHERE folder_id IN (SELECT id FROM folders WHERE category_id = ?)", c.ID)
+	op.Operation.Exec("INSERT INTO folder_totals (folder_id, file_count, byte_count)"+
+		" SELECT fo.id, COUNT(fi.id), COALESCE(SUM(fi.filesize), 0)"+
+		" FROM folders fo"+
+		" LEFT JOIN files fi ON fi.category_id = fo.category_id"+
+		" AND substr(fi.public_path, 1, length(fo.public_path) + 1) = fo.public_path || '/'"+
+		" WHERE fo.category_id = ?"+
+		" GROUP BY fo.id", c.ID)
+}
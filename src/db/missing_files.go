@@ -0,0 +1,73 @@
+package db
+
+import "time"
+
+// MissingCategoryTotal counts a single category's missing files
+type MissingCategoryTotal struct {
+	Category *Category
+	Files    uint64
+	Bytes    int64
+}
+
+// UnlistedFiles returns the files attributed to inv which weren't indexed
+// since the given time, and aren't already flagged as missing.  After a
+// reindex, these are the files the inventory no longer lists.
+func (op *Operation) UnlistedFiles(inv *Inventory, since time.Time) ([]*File, error) {
+	var files []*File
+	op.Files.Select().Where("inventory_id = ? AND indexed_at < ? AND missing_since = ?",
+		inv.ID, since.UTC(), time.Time{}).Order("public_path").AllObjects(&files)
+	return files, op.Operation.Err()
+}
+
+// FlagMissingFiles marks the files as missing as of now
+func (op *Operation) FlagMissingFiles(files []*File) error {
+	var now = time.Now().UTC()
+	var stmt = op.Operation.Prepare("UPDATE files SET missing_since = ? WHERE id = ?")
+	for _, f := range files {
+		f.MissingSince = now
+		stmt.Exec(now, f.ID)
+	}
+	return op.Operation.Err()
+}
+
+// MissingFiles returns the files flagged as missing, in path order,
+// optionally limited to a single category.  Category data is filled
+// in on each file.
+func (op *Operation) MissingFiles(c *Category) ([]*File, error) {
+	var sel = op.FileSelect(c, nil).TreeMode(true).Search("missing_since > ?", time.Time{})
+	var files []*File
+	var err = op.EachFile(sel, func(f *File) error {
+		files = append(files, f)
+		return nil
+	})
+	return files, err
+}
+
+// MissingFileTotals counts the missing files in each category which has any
+func (op *Operation) MissingFileTotals() ([]*MissingCategoryTotal, error) {
+	var rows = op.Operation.Query("SELECT category_id, COUNT(*), COALESCE(SUM(filesize), 0)"+
+		" FROM files WHERE missing_since > ? GROUP BY category_id", time.Time{})
+
+	var totals []*MissingCategoryTotal
+	var ids []int
+	for rows.Next() {
+		var id int
+		var t = &MissingCategoryTotal{}
+		rows.Scan(&id, &t.Files, &t.Bytes)
+		ids = append(ids, id)
+		totals = append(totals, t)
+	}
+	rows.Close()
+
+	for n, id := range ids {
+		var c, err = op.FindCategoryByID(id)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			c = &Category{ID: id, Name: "(deleted category)"}
+		}
+		totals[n].Category = c
+	}
+	return totals, op.Operation.Err()
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// MoveFolder moves f beneath parent (or to the top of its category if parent
+// is nil) and gives it the new name, rewriting the public path and depth of
+// every folder and file beneath it.  Folder totals move along with it.  Real
+// folders and full paths are unchanged, since nothing on disk moves.  The old
+// path redirects to the folder afterward, so browse links to it (or anything
+// beneath it) keep working.
+//
+// This should always be run via Database.InTransaction so a failure partway
+// through doesn't leave a half-moved subtree.  Note that inventories indexed
+// later still derive public paths from the filesystem, so new files in the
+// old location will recreate the old folder.
+func (op *Operation) MoveFolder(f *Folder, parent *Folder, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid folder name %q", name)
+	}
+
+	var newPath = name
+	var parentID = 0
+	if parent != nil {
+		if parent.CategoryID != f.CategoryID {
+			return fmt.Errorf("folders can't be moved to another category")
+		}
+		if parent.ID == f.ID || strings.HasPrefix(parent.PublicPath+"/", f.PublicPath+"/") {
+			return fmt.Errorf("%q can't be moved inside itself", f.PublicPath)
+		}
+		newPath = filepath.Join(parent.PublicPath, name)
+		parentID = parent.ID
+	}
+	if newPath == f.PublicPath {
+		return nil
+	}
+
+	var existing, err = op.FindFolderByPath(&Category{ID: f.CategoryID}, newPath)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("folder %q already exists", newPath)
+	}
+
+	var totals *FolderTotal
+	totals, err = op.FolderTotals(f)
+	if err != nil {
+		return err
+	}
+	err = op.addToFolderChain(f.FolderID, nil, -totals.FileCount, -totals.ByteCount)
+	if err != nil {
+		return err
+	}
+
+	// Descendants get the new prefix.  Depth is a count of path separators, so
+	// every descendant shifts by the same amount as f itself.
+	var oldPath = f.PublicPath
+	var depthChange = strings.Count(newPath, string(filepath.Separator)) - f.Depth
+	for _, table := range []string{"folders", "files"} {
+		op.Operation.Exec("UPDATE "+table+" SET public_path = ? || substr(public_path, length(?) + 1), depth = depth + ?"+
+			" WHERE category_id = ? AND substr(public_path, 1, length(?) + 1) = ? || '/'",
+			newPath, oldPath, depthChange, f.CategoryID, oldPath, oldPath)
+	}
+
+	f.FolderID = parentID
+	f.Folder = parent
+	f.Depth += depthChange
+	f.Name = name
+	f.PublicPath = newPath
+	op.Folders.Save(f)
+	op.addFolderRedirect(oldPath, f)
+	if op.Operation.Err() != nil {
+		return op.Operation.Err()
+	}
+
+	return op.addToFolderChain(f.FolderID, parent, totals.FileCount, totals.ByteCount)
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"fmt"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizePath converts a public path, file or folder name, or category
+// name to Unicode NFC.  Files deposited from macOS often have decomposed
+// (NFD) names, which look identical to their composed (NFC) Linux
+// counterparts but don't compare as equal, so everything is stored and
+// looked up in NFC form.
+func NormalizePath(s string) string {
+	return norm.NFC.String(s)
+}
+
+// NormalizeReport lists what NormalizeStoredPaths changed, and the records it
+// couldn't change because a record with the normalized name already exists
+type NormalizeReport struct {
+	Categories int
+	Folders    int
+	Files      int
+	Conflicts  []string
+}
+
+// normalizeTarget describes a table's name and path columns for
+// NormalizeStoredPaths.  exists, if set, counts the other rows which already
+// use a normalized name, for tables with no unique index to stop duplicates.
+type normalizeTarget struct {
+	table   string
+	columns []string
+	exists  string
+	count   *int
+}
+
+// NormalizeStoredPaths converts the names and public paths of categories,
+// folders, and files indexed before paths were normalized.  Records whose
+// normalized form conflicts with an existing record are left alone and
+// reported, as they need a human to decide which one is right.
+func (op *Operation) NormalizeStoredPaths() (*NormalizeReport, error) {
+	var r = &NormalizeReport{}
+	var targets = []normalizeTarget{
+		{"categories", []string{"name"}, "SELECT COUNT(*) FROM categories WHERE name = ? AND id <> ?", &r.Categories},
+		{"folders", []string{"public_path", "name"}, "", &r.Folders},
+		{"files", []string{"public_path", "name"}, "", &r.Files},
+	}
+
+	for _, t := range targets {
+		var err = op.normalizeTable(t, r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// normalizeTable finds the rows in the target table with non-NFC values and
+// rewrites them.  All the rows are gathered before any are updated, since
+// SQLite can't write while a query's rows are still open.
+func (op *Operation) normalizeTable(t normalizeTarget, r *NormalizeReport) error {
+	type change struct {
+		id     uint64
+		values []interface{}
+	}
+	var changes []change
+
+	var cols = t.columns[0]
+	for _, col := range t.columns[1:] {
+		cols += ", " + col
+	}
+	var rows = op.Operation.Query("SELECT id, " + cols + " FROM " + t.table)
+	for rows.Next() {
+		var id uint64
+		var values = make([]string, len(t.columns))
+		var dest = []interface{}{&id}
+		for n := range values {
+			dest = append(dest, &values[n])
+		}
+		rows.Scan(dest...)
+
+		var c = change{id: id}
+		var differs bool
+		for _, v := range values {
+			var nv = NormalizePath(v)
+			differs = differs || nv != v
+			c.values = append(c.values, nv)
+		}
+		if differs {
+			changes = append(changes, c)
+		}
+	}
+	rows.Close()
+	if op.Operation.Err() != nil {
+		return op.Operation.Err()
+	}
+
+	var set = t.columns[0] + " = ?"
+	for _, col := range t.columns[1:] {
+		set += ", " + col + " = ?"
+	}
+	for _, c := range changes {
+		if t.exists != "" && op.countRows(t.exists, c.values[0], c.id) > 0 {
+			r.Conflicts = append(r.Conflicts, fmt.Sprintf("%s %d (%q)", t.table, c.id, c.values[0]))
+			continue
+		}
+		var res = op.Operation.Exec("UPDATE OR IGNORE "+t.table+" SET "+set+" WHERE id = ?", append(c.values, c.id)...)
+		if op.Operation.Err() != nil {
+			return op.Operation.Err()
+		}
+		if res.RowsAffected() == 0 {
+			r.Conflicts = append(r.Conflicts, fmt.Sprintf("%s %d (%q)", t.table, c.id, c.values[0]))
+			continue
+		}
+		*t.count++
+	}
+	return nil
+}
+
+// countRows runs a COUNT query and returns its result
+func (op *Operation) countRows(query string, args ...interface{}) int {
+	var n int
+	var rows = op.Operation.Query(query, args...)
+	for rows.Next() {
+		rows.Scan(&n)
+	}
+	rows.Close()
+	return n
+}
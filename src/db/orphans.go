@@ -0,0 +1,66 @@
+package db
+
+import "strings"
+
+// OrphanReport lists the ids of records whose parent records no longer exist
+type OrphanReport struct {
+	// Folders whose category is gone
+	Folders []int
+
+	// RealFolders whose public folder is gone
+	RealFolders []int
+
+	// Files whose folder is gone.  Top-level files (folder id of zero) are never
+	// considered orphans.
+	Files []uint64
+}
+
+// Total returns the number of orphaned records in the report
+func (r *OrphanReport) Total() int {
+	return len(r.Folders) + len(r.RealFolders) + len(r.Files)
+}
+
+const orphanedFoldersSQL = "FROM folders WHERE category_id NOT IN (SELECT id FROM categories)"
+const orphanedRealFoldersSQL = "FROM real_folders WHERE folder_id NOT IN (SELECT id FROM folders)"
+const orphanedFilesSQL = "FROM files WHERE folder_id <> 0 AND folder_id NOT IN (SELECT id FROM folders)"
+
+// CleanOrphans finds folders referencing deleted categories, real folders
+// with no public folder, and files pointing at missing folders.  If remove is
+// true, the orphans are deleted.  Folders are removed first so that anything
+// they orphan (real folders, files, and folder totals) is caught in the same
+// run; those records are included in the returned report.  Fixity checks of
+// removed files are deleted with them.
+func (op *Operation) CleanOrphans(remove bool) (*OrphanReport, error) {
+	var r = &OrphanReport{}
+	r.Folders = op.orphanIDs(orphanedFoldersSQL)
+	if remove {
+		op.Operation.Exec("DELETE " + orphanedFoldersSQL)
+		op.Operation.Exec("DELETE FROM folder_totals WHERE folder_id NOT IN (SELECT id FROM folders)")
+	}
+
+	r.RealFolders = op.orphanIDs(orphanedRealFoldersSQL)
+	for _, id := range op.orphanIDs(orphanedFilesSQL) {
+		r.Files = append(r.Files, uint64(id))
+	}
+	if remove {
+		op.Operation.Exec("DELETE " + orphanedRealFoldersSQL)
+		op.Operation.Exec("DELETE " + orphanedFilesSQL)
+		op.Operation.Exec("DELETE FROM fixity_checks WHERE file_id NOT IN (SELECT id FROM files)")
+	}
+
+	return r, op.Operation.Err()
+}
+
+// orphanIDs returns the ids selected by the given FROM/WHERE clause
+func (op *Operation) orphanIDs(fromWhere string) []int {
+	var rows = op.Operation.Query("SELECT id " + strings.TrimSpace(fromWhere) + " ORDER BY id")
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,21 @@
+package db
+
+// Provenance tells where a file's record came from
+type Provenance struct {
+	// Inventory is the manifest which last described the file.  It's nil if
+	// the inventory record no longer exists.
+	Inventory *Inventory
+
+	// Line is the line number within the inventory, or zero if unknown
+	Line int
+}
+
+// FileProvenance returns the inventory and line which produced f's record.
+// When a file appears in more than one inventory, the last one indexed wins.
+func (op *Operation) FileProvenance(f *File) (*Provenance, error) {
+	var inv, err = op.FindInventoryByID(f.InventoryID)
+	if err != nil {
+		return nil, err
+	}
+	return &Provenance{Inventory: inv, Line: f.InventoryLine}, nil
+}
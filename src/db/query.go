@@ -0,0 +1,49 @@
+package db
+
+// FileQuery combines every criterion a file search can use.  Zero-valued
+// fields aren't applied: a query with nothing set finds every file.
+type FileQuery struct {
+	// Category and Folder limit the search to files beneath them
+	Category *Category
+	Folder   *Folder
+
+	// Term is matched against files' public paths, interpreted according to
+	// Mode (SearchLike if Mode is empty)
+	Term string
+	Mode SearchMode
+
+	Filter FileFilter
+	Sort   FileSort
+	Page   Page
+}
+
+// Select builds the FSelect for the query.  An invalid term returns an error
+// rather than failing inside the query.
+func (q FileQuery) Select(op *Operation) (*FSelect, error) {
+	var sel = op.FileSelect(q.Category, q.Folder).TreeMode(true)
+	if q.Term != "" {
+		var mode = q.Mode
+		if mode == "" {
+			mode = SearchLike
+		}
+		var where, arg, err = mode.clause("public_path", q.Term)
+		if err != nil {
+			return nil, err
+		}
+		sel.Search(where, arg)
+	}
+	return sel.Filter(q.Filter).Sort(q.Sort).Page(q.Page), nil
+}
+
+// QueryFiles returns the query's page of files and the total number of
+// matches.  As with SearchFiles, folder data isn't filled in on the files.
+func (op *Operation) QueryFiles(q FileQuery) ([]*File, uint64, error) {
+	var sel, err = q.Select(op)
+	if err != nil {
+		return nil, 0, err
+	}
+	var files []*File
+	var count uint64
+	count, err = sel.AllObjects(&files)
+	return files, count, err
+}
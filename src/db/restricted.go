@@ -0,0 +1,114 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// restrictedClause returns a WHERE clause which excludes rows of the given
+// table (files or folders) that are restricted themselves or live under a
+// restricted folder
+func restrictedClause(table string) string {
+	return fmt.Sprintf("%[1]s.restricted = 0 AND NOT EXISTS ("+
+		"SELECT 1 FROM folders rf WHERE rf.restricted = 1 AND rf.category_id = %[1]s.category_id"+
+		" AND substr(%[1]s.public_path, 1, length(rf.public_path) + 1) = rf.public_path || '/')", table)
+}
+
+// HideRestricted tells op to leave restricted files and folders out of
+// everything it returns, as if they didn't exist.  This is meant for
+// operations run on behalf of non-staff users.  Unless ForRole says
+// otherwise, categories with an access role are hidden as well.
+func (op *Operation) HideRestricted() *Operation {
+	op.hideRestricted = true
+	op.limitAccess = true
+	return op
+}
+
+// ForRole tells op it's running on behalf of a user with the given role (an
+// empty role for anonymous users): categories whose access role is beyond
+// theirs are left out of everything it returns, and restricted items are
+// hidden if they aren't staff.
+func (op *Operation) ForRole(role string) *Operation {
+	op.limitAccess = true
+	op.role = role
+	if RoleRank(role) < RoleRank(RoleStaff) {
+		op.hideRestricted = true
+	}
+	return op
+}
+
+// deniedRoles returns the access roles which op's user doesn't have
+func (op *Operation) deniedRoles() []string {
+	if !op.limitAccess {
+		return nil
+	}
+	var denied []string
+	for _, role := range Roles {
+		if RoleRank(role) > RoleRank(op.role) {
+			denied = append(denied, role)
+		}
+	}
+	return denied
+}
+
+// hiding returns true if op leaves anything out of what it returns
+func (op *Operation) hiding() bool {
+	return op.hideRestricted || len(op.deniedRoles()) > 0
+}
+
+// hiddenClause returns a WHERE clause which excludes rows of the given table
+// (files or folders) that op's user can't see: restricted items, if op hides
+// them, and anything in a category whose access role they don't have
+func (op *Operation) hiddenClause(table string) string {
+	var clauses []string
+	if op.hideRestricted {
+		clauses = append(clauses, restrictedClause(table))
+	}
+	var denied = op.deniedRoles()
+	if len(denied) > 0 {
+		clauses = append(clauses, fmt.Sprintf("%s.category_id NOT IN (SELECT id FROM categories WHERE %s)",
+			table, accessRoleClause(denied)))
+	}
+	return strings.Join(clauses, " AND ")
+}
+
+// categoryClause returns a WHERE clause which excludes the categories op's
+// user can't see, or an empty string if they can see all of them
+func (op *Operation) categoryClause() string {
+	var denied = op.deniedRoles()
+	if len(denied) == 0 {
+		return ""
+	}
+	return "NOT (" + accessRoleClause(denied) + ")"
+}
+
+// visibleCategory adds categoryClause, if there is one, to a WHERE clause
+// for the categories table
+func (op *Operation) visibleCategory(where string) string {
+	var clause = op.categoryClause()
+	if clause == "" {
+		return where
+	}
+	return where + " AND " + clause
+}
+
+// accessRoleClause matches categories with any of the given access roles.
+// Roles are our own constants, so they're safe to put in the query as-is.
+func accessRoleClause(roles []string) string {
+	return "access_role IN ('" + strings.Join(roles, "', '") + "')"
+}
+
+// SetFolderRestricted flags or unflags the folder as restricted, which
+// affects the folder and everything beneath it
+func (op *Operation) SetFolderRestricted(f *Folder, restricted bool) error {
+	f.Restricted = restricted
+	op.Folders.Save(f)
+	return op.Operation.Err()
+}
+
+// SetFileRestricted flags or unflags a single file as restricted
+func (op *Operation) SetFileRestricted(f *File, restricted bool) error {
+	f.Restricted = restricted
+	op.Files.Save(f)
+	return op.Operation.Err()
+}
@@ -0,0 +1,153 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+func TestHideRestrictedFiles(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var c = mustCategory(t, dbh.Operation(), "Photos")
+	var f2018 = mustFolder(t, dbh.Operation(), c, "events/2018")
+	var private, _ = dbh.Operation().FindFileByPublicPath(c.ID, "2018-01-01", "events/2018/private.tif")
+	var secret, _ = dbh.Operation().FindFileByPublicPath(c.ID, "2018-01-01", "events/embargoed/secret.tif")
+
+	var op = dbh.Operation().HideRestricted()
+	var files, count, err = op.GetFiles(c, f2018, db.FileFilter{}, db.FileSort{}, db.Page{Limit: 10})
+	if err != nil {
+		t.Fatalf("Unable to read files: %s", err)
+	}
+	if count != 1 || !equalStrings(fileNames(files), []string{"party.tif"}) {
+		t.Errorf("Expected only party.tif, got %d: %q", count, fileNames(files))
+	}
+
+	for _, f := range []*db.File{private, secret} {
+		var found, err = op.FindFileByID(f.ID)
+		if err != nil || found != nil {
+			t.Errorf("Expected %q to be hidden, got %#v (error: %v)", f.PublicPath, found, err)
+		}
+	}
+
+	var ids = []uint64{private.ID, secret.ID}
+	files, err = op.GetFilesByIDs(ids)
+	if err != nil || len(files) != 0 {
+		t.Errorf("Expected GetFilesByIDs to hide restricted files, got %q (error: %v)", fileNames(files), err)
+	}
+}
+
+func TestHideRestrictedFolders(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var c = mustCategory(t, dbh.Operation(), "Photos")
+	var events = mustFolder(t, dbh.Operation(), c, "events")
+
+	var op = dbh.Operation().HideRestricted()
+	var children, err = op.GetFolders(c, events)
+	if err != nil {
+		t.Fatalf("Unable to read folders: %s", err)
+	}
+	if !equalStrings(folderNames(children), []string{"2018"}) {
+		t.Errorf("Expected the embargoed folder to be hidden, got %q", folderNames(children))
+	}
+
+	var embargoed *db.Folder
+	embargoed, err = op.FindFolderByPath(c, "events/embargoed")
+	if err != nil || embargoed != nil {
+		t.Errorf("Expected FindFolderByPath to hide the embargoed folder, got %#v (error: %v)", embargoed, err)
+	}
+}
+
+func TestStaffSeeRestricted(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var c = mustCategory(t, dbh.Operation(), "Photos")
+	var f2018 = mustFolder(t, dbh.Operation(), c, "events/2018")
+
+	for _, role := range []string{db.RoleStaff, db.RoleAdmin} {
+		var op = dbh.Operation().ForRole(role)
+		var _, count, err = op.GetFiles(c, f2018, db.FileFilter{}, db.FileSort{}, db.Page{Limit: 10})
+		if err != nil || count != 2 {
+			t.Errorf("Expected %s to see both files, got %d (error: %v)", role, count, err)
+		}
+		var embargoed *db.Folder
+		embargoed, err = op.FindFolderByPath(c, "events/embargoed")
+		if err != nil || embargoed == nil {
+			t.Errorf("Expected %s to see the embargoed folder (error: %v)", role, err)
+		}
+	}
+
+	var _, count, err = dbh.Operation().ForRole(db.RoleViewer).GetFiles(c, f2018, db.FileFilter{}, db.FileSort{}, db.Page{Limit: 10})
+	if err != nil || count != 1 {
+		t.Errorf("Expected a viewer to see one file, got %d (error: %v)", count, err)
+	}
+}
+
+func TestCategoryAccessRoles(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var personnel = mustCategory(t, dbh.Operation(), "Personnel")
+	var jdoe, _ = dbh.Operation().FindFileByPublicPath(personnel.ID, "2018-01-01", "files/jdoe.pdf")
+
+	for _, role := range []string{"", db.RoleViewer} {
+		var op = dbh.Operation().ForRole(role)
+		var c, err = op.FindCategoryByName("Personnel")
+		if err != nil || c != nil {
+			t.Errorf("Expected role %q not to see Personnel, got %#v (error: %v)", role, c, err)
+		}
+		c, err = op.FindCategoryByID(personnel.ID)
+		if err != nil || c != nil {
+			t.Errorf("Expected role %q not to find Personnel by id, got %#v (error: %v)", role, c, err)
+		}
+		var f *db.File
+		f, err = op.FindFileByID(jdoe.ID)
+		if err != nil || f != nil {
+			t.Errorf("Expected role %q not to see files in Personnel, got %#v (error: %v)", role, f, err)
+		}
+		var cats []*db.Category
+		cats, err = op.AllCategories()
+		for _, c := range cats {
+			if c.Name == "Personnel" {
+				t.Errorf("Expected role %q not to have Personnel listed", role)
+			}
+		}
+	}
+
+	var op = dbh.Operation().ForRole(db.RoleStaff)
+	var c, err = op.FindCategoryByName("Personnel")
+	if err != nil || c == nil {
+		t.Errorf("Expected staff to see Personnel (error: %v)", err)
+	}
+	var f *db.File
+	f, err = op.FindFileByID(jdoe.ID)
+	if err != nil || f == nil {
+		t.Errorf("Expected staff to see files in Personnel (error: %v)", err)
+	}
+}
+
+func TestListedCategoriesHidesHidden(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var empty = mustCategory(t, dbh.Operation(), "Empty")
+	var err = dbh.Operation().SetCategoryHidden(empty, true)
+	if err != nil {
+		t.Fatalf("Unable to hide category: %s", err)
+	}
+
+	var cats []*db.Category
+	cats, err = dbh.Operation().HideRestricted().ListedCategories()
+	if err != nil {
+		t.Fatalf("Unable to list categories: %s", err)
+	}
+	for _, c := range cats {
+		if c.Name == "Empty" {
+			t.Errorf("Expected the hidden category not to be listed")
+		}
+	}
+
+	cats, err = dbh.Operation().ListedCategories()
+	if err != nil || len(cats) != 3 {
+		t.Errorf("Expected all three categories to be listed without hiding, got %d (error: %v)", len(cats), err)
+	}
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SavedSearch maps to the saved_searches table, which stores a search term and
+// its category/folder scope so frequently used queries can be recalled
+type SavedSearch struct {
+	ID           int       `sql:",primary"`
+	Category     *Category `sql:"-"`
+	Folder       *Folder   `sql:"-"`
+	UserID       int
+	Name         string
+	Term         string
+	Mode         string
+	FolderSearch bool
+	CategoryID   int
+	FolderID     int
+	CreatedAt    time.Time
+
+	// Subscribed searches email their owner about newly indexed matches.
+	// NotifiedAt is when the last check for new matches ended.
+	Subscribed bool
+	NotifiedAt time.Time
+
+	// Unresolved is true when the search is scoped to a category or folder
+	// which couldn't be loaded, because it's gone or is hidden from whoever
+	// loaded the search.  Unresolved searches can't be run, since dropping the
+	// scope would search everything.
+	Unresolved bool `sql:"-"`
+}
+
+// ErrUnresolvedSearch is returned when running a saved search whose category
+// or folder can't be found
+var ErrUnresolvedSearch = errors.New("the saved search's category or folder is no longer available")
+
+// SaveSearch stores a new saved search for the given term, mode, and scope.  A nil
+// category or folder means the search isn't restricted to one.  A nil user
+// means the search is saved anonymously.
+func (op *Operation) SaveSearch(u *User, name, term string, mode SearchMode, folderSearch bool, c *Category, f *Folder) (*SavedSearch, error) {
+	if term == "" {
+		return nil, fmt.Errorf("no search term given")
+	}
+	if name == "" {
+		name = term
+	}
+
+	var s = &SavedSearch{
+		Category:     c,
+		Folder:       f,
+		Name:         name,
+		Term:         term,
+		Mode:         string(mode),
+		FolderSearch: folderSearch,
+		CreatedAt:    time.Now(),
+	}
+	if u != nil {
+		s.UserID = u.ID
+	}
+	if c != nil {
+		s.CategoryID = c.ID
+	}
+	if f != nil {
+		s.FolderID = f.ID
+	}
+
+	op.SavedSearches.Save(s)
+	return s, op.Operation.Err()
+}
+
+// FindSavedSearchByID returns the saved search with the given id, or nil if
+// none is found.  The search's category and folder are populated.
+func (op *Operation) FindSavedSearchByID(id int) (*SavedSearch, error) {
+	var s = &SavedSearch{}
+	var ok = op.SavedSearches.Select().Where("id = ?", id).First(s)
+	if !ok {
+		return nil, op.Operation.Err()
+	}
+
+	var err = op.populateSavedSearches([]*SavedSearch{s})
+	return s, err
+}
+
+// UserSavedSearches returns the given user's saved searches, newest first,
+// with their categories and folders populated
+func (op *Operation) UserSavedSearches(u *User) ([]*SavedSearch, error) {
+	var searches []*SavedSearch
+	op.SavedSearches.Select().Where("user_id = ?", u.ID).Order("created_at DESC").AllObjects(&searches)
+	if op.Operation.Err() != nil {
+		return nil, op.Operation.Err()
+	}
+
+	var err = op.populateSavedSearches(searches)
+	return searches, err
+}
+
+// DeleteSavedSearch removes the given saved search from the database
+func (op *Operation) DeleteSavedSearch(s *SavedSearch) error {
+	op.Operation.Exec("DELETE FROM saved_searches WHERE id = ?", s.ID)
+	return op.Operation.Err()
+}
+
+// populateSavedSearches fills in the category and folder for each search,
+// as op's user sees them.  Searches whose category or folder op can't see
+// are flagged as unresolved.
+func (op *Operation) populateSavedSearches(searches []*SavedSearch) error {
+	for _, s := range searches {
+		var err error
+		if s.CategoryID != 0 {
+			s.Category, err = op.FindCategoryByID(s.CategoryID)
+			if err != nil {
+				return err
+			}
+			s.Unresolved = s.Category == nil
+		}
+		if s.FolderID != 0 && !s.Unresolved {
+			s.Folder, err = op.findVisibleFolder(s.Category, s.FolderID)
+			if err != nil {
+				return err
+			}
+			s.Unresolved = s.Folder == nil
+			if s.Folder != nil {
+				s.Folder.Category = s.Category
+			}
+		}
+	}
+
+	return nil
+}
+
+// findVisibleFolder returns the folder with the given id in c, or nil if it
+// doesn't exist there or op hides it
+func (op *Operation) findVisibleFolder(c *Category, id int) (*Folder, error) {
+	if c == nil {
+		return nil, nil
+	}
+	var f, err = op.FindFolderByID(id)
+	if err != nil || f == nil || f.CategoryID != c.ID {
+		return nil, err
+	}
+	if !op.hiding() {
+		return f, nil
+	}
+	return op.FindFolderByPath(c, f.PublicPath)
+}
+
+// SetSavedSearchSubscription turns alerts for the saved search on or off.
+// Subscribing starts the clock over, so only files indexed from now on are
+// reported.
+func (op *Operation) SetSavedSearchSubscription(s *SavedSearch, subscribed bool) error {
+	if subscribed && s.FolderSearch {
+		return fmt.Errorf("folder searches can't have alerts")
+	}
+	s.Subscribed = subscribed
+	if subscribed {
+		s.NotifiedAt = time.Now()
+	}
+	op.Operation.Exec("UPDATE saved_searches SET subscribed = ?, notified_at = ? WHERE id = ?",
+		s.Subscribed, s.NotifiedAt, s.ID)
+	return op.Operation.Err()
+}
+
+// SubscribedSearches returns every saved search with alerts turned on,
+// oldest first, with their categories and folders populated
+func (op *Operation) SubscribedSearches() ([]*SavedSearch, error) {
+	var searches []*SavedSearch
+	op.SavedSearches.Select().Where("subscribed = ?", true).Order("created_at").AllObjects(&searches)
+	if op.Operation.Err() != nil {
+		return nil, op.Operation.Err()
+	}
+
+	var err = op.populateSavedSearches(searches)
+	return searches, err
+}
+
+// NewMatches returns the given page of files matching the saved search which
+// were indexed after its last notification and before until, along with the
+// total number of such files.  Unresolved searches return
+// ErrUnresolvedSearch.
+func (op *Operation) NewMatches(s *SavedSearch, until time.Time, page Page) ([]*File, uint64, error) {
+	if s.Unresolved {
+		return nil, 0, ErrUnresolvedSearch
+	}
+	var q = FileQuery{Category: s.Category, Folder: s.Folder, Term: s.Term, Mode: SearchMode(s.Mode), Page: page}
+	q.Filter.Dates.IndexedAfter = s.NotifiedAt
+	q.Filter.Dates.IndexedBefore = until
+	var files, total, err = op.QueryFiles(q)
+	if err == nil {
+		err = op.PopulateCategories(files, nil)
+	}
+	return files, total, err
+}
+
+// MarkSavedSearchNotified records that the saved search's owner has been
+// told about every match indexed before t
+func (op *Operation) MarkSavedSearchNotified(s *SavedSearch, t time.Time) error {
+	s.NotifiedAt = t
+	op.Operation.Exec("UPDATE saved_searches SET notified_at = ? WHERE id = ?", s.NotifiedAt, s.ID)
+	return op.Operation.Err()
+}
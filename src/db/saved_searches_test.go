@@ -0,0 +1,77 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+func TestSavedSearchScopes(t *testing.T) {
+	var dbh = newArchiveDB(t)
+	defer dbh.Close()
+	var op = dbh.Operation()
+
+	var personnel = mustCategory(t, op, "Personnel")
+	var photos = mustCategory(t, op, "Photos")
+	var embargoed = mustFolder(t, op, photos, "events/embargoed")
+
+	var staffOnly, err = op.SaveSearch(nil, "", "jdoe", db.SearchLike, false, personnel, nil)
+	if err != nil {
+		t.Fatalf("Unable to save search: %s", err)
+	}
+	var restricted *db.SavedSearch
+	restricted, err = op.SaveSearch(nil, "", "secret", db.SearchLike, false, photos, embargoed)
+	if err != nil {
+		t.Fatalf("Unable to save search: %s", err)
+	}
+
+	var tests = map[string]struct {
+		op         *db.Operation
+		search     *db.SavedSearch
+		unresolved bool
+	}{
+		"staff category, anonymous":  {dbh.Operation().ForRole(""), staffOnly, true},
+		"staff category, staff":      {dbh.Operation().ForRole(db.RoleStaff), staffOnly, false},
+		"restricted folder, viewer":  {dbh.Operation().ForRole(db.RoleViewer), restricted, true},
+		"restricted folder, staff":   {dbh.Operation().ForRole(db.RoleStaff), restricted, false},
+		"restricted folder, hidden":  {dbh.Operation().ForRole(db.RoleAdmin).HideRestricted(), restricted, true},
+		"staff category, unlimited":  {dbh.Operation(), staffOnly, false},
+		"restricted folder, no role": {dbh.Operation(), restricted, false},
+	}
+	for name, tc := range tests {
+		var s, err = tc.op.FindSavedSearchByID(tc.search.ID)
+		if err != nil || s == nil {
+			t.Errorf("%s: unable to load saved search (error: %v)", name, err)
+			continue
+		}
+		if s.Unresolved != tc.unresolved {
+			t.Errorf("%s: expected unresolved to be %v", name, tc.unresolved)
+		}
+
+		_, _, err = tc.op.NewMatches(s, time.Now(), db.Page{Limit: 10})
+		if tc.unresolved && err != db.ErrUnresolvedSearch {
+			t.Errorf("%s: expected running an unresolved search to fail, got %v", name, err)
+		}
+		if !tc.unresolved && err != nil {
+			t.Errorf("%s: unable to run search: %s", name, err)
+		}
+	}
+}
+
+func TestEffectiveRole(t *testing.T) {
+	var u = &db.User{Email: "jdoe@example.org", Role: db.RoleViewer}
+	if role := u.EffectiveRole("", ""); role != db.RoleViewer {
+		t.Errorf("Expected the assigned role, got %q", role)
+	}
+	if role := u.EffectiveRole("", "x@example.org, JDOE@example.org"); role != db.RoleStaff {
+		t.Errorf("Expected STAFF_EMAILS to make jdoe staff, got %q", role)
+	}
+	if role := u.EffectiveRole("jdoe@example.org", ""); role != db.RoleAdmin {
+		t.Errorf("Expected ADMIN_EMAILS to make jdoe an admin, got %q", role)
+	}
+	u.Role = db.RoleCurator
+	if role := u.EffectiveRole("", "jdoe@example.org"); role != db.RoleCurator {
+		t.Errorf("Expected STAFF_EMAILS not to demote a curator, got %q", role)
+	}
+}
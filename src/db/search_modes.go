@@ -0,0 +1,238 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SearchMode tells the search functions how to interpret a search term
+type SearchMode string
+
+// Available search modes.  All modes are case-insensitive.
+const (
+	// SearchLike is the default mode: SQL "LIKE" patterns, where "%" matches
+	// any run of characters and "_" matches any single character
+	SearchLike SearchMode = "like"
+
+	// SearchGlob uses shell-style patterns, e.g., "*_master.tif".  Note that
+	// "*" matches slashes, so a glob must account for the full path.
+	SearchGlob SearchMode = "glob"
+
+	// SearchRegex uses Go regular expressions, which match anywhere in the
+	// path unless anchored
+	SearchRegex SearchMode = "regex"
+)
+
+// ParseSearchMode returns the mode named by s, defaulting to SearchLike when
+// s is empty
+func ParseSearchMode(s string) (SearchMode, error) {
+	switch SearchMode(s) {
+	case "", SearchLike:
+		return SearchLike, nil
+	case SearchGlob, SearchRegex:
+		return SearchMode(s), nil
+	}
+	return "", fmt.Errorf("unknown search mode %q", s)
+}
+
+// Validate returns an error if term can't be used in this mode, e.g., an
+// invalid regular expression
+func (m SearchMode) Validate(term string) error {
+	if m == SearchRegex {
+		var _, err = compileRegexp(term)
+		if err != nil {
+			return fmt.Errorf("invalid regular expression: %s", err)
+		}
+	}
+	return nil
+}
+
+// clause returns the where clause and argument for matching field against
+// term in this mode.  Invalid terms are reported here rather than failing
+// inside the query.
+func (m SearchMode) clause(field, term string) (string, interface{}, error) {
+	term = NormalizePath(term)
+	var err = m.Validate(term)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch m {
+	case SearchGlob:
+		return "LOWER(" + field + ") GLOB LOWER(?)", term, nil
+	case SearchRegex:
+		return field + " REGEXP ?", term, nil
+	}
+	return field + " LIKE ?", term, nil
+}
+
+// Matcher returns a case-insensitive expression which finds the part of a
+// path that term matched in this mode, for highlighting search results.  LIKE
+// and glob patterns match whole paths, so their leading and trailing
+// wildcards are dropped, and the expression is anchored only where the
+// pattern was.
+func (m SearchMode) Matcher(term string) (*regexp.Regexp, error) {
+	term = NormalizePath(term)
+	var err = m.Validate(term)
+	if err != nil {
+		return nil, err
+	}
+
+	switch m {
+	case SearchRegex:
+		return compileRegexp(term)
+	case SearchGlob:
+		return regexp.Compile("(?i)" + anchorPattern(term, "*", globToRegexp))
+	}
+	return regexp.Compile("(?i)" + anchorPattern(term, "%", likeToRegexp))
+}
+
+// anchorPattern trims leading and trailing runs of wildcard from term,
+// converts what's left with conv, and anchors each end which didn't have a
+// wildcard
+func anchorPattern(term, wildcard string, conv func(string) string) string {
+	var trimmed = strings.TrimLeft(term, wildcard)
+	var prefix = "^"
+	if trimmed != term {
+		prefix = ""
+	}
+	var core = strings.TrimRight(trimmed, wildcard)
+	var suffix = "$"
+	if core != trimmed {
+		suffix = ""
+	}
+	return prefix + conv(core) + suffix
+}
+
+// likeToRegexp converts a SQL LIKE pattern to a regular expression
+func likeToRegexp(pattern string) string {
+	var b strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
+
+// globToRegexp converts a SQLite GLOB pattern to a regular expression.
+// Character classes are passed through, with SQLite's "[^...]" negation
+// matching Go's.
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	var runes = []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			var end = globClassEnd(runes, i)
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			b.WriteRune('[')
+			for _, r := range runes[i+1 : end] {
+				if r == '\\' || r == '[' {
+					b.WriteRune('\\')
+				}
+				b.WriteRune(r)
+			}
+			b.WriteRune(']')
+			i = end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+	return b.String()
+}
+
+// globClassEnd returns the index of the "]" closing the character class
+// which opens at runes[start], or -1 if it's never closed.  A "]" right after
+// the opening "[" or "[^" is part of the class.
+func globClassEnd(runes []rune, start int) int {
+	var i = start + 1
+	if i < len(runes) && runes[i] == '^' {
+		i++
+	}
+	if i < len(runes) && runes[i] == ']' {
+		i++
+	}
+	for ; i < len(runes); i++ {
+		if runes[i] == ']' {
+			return i
+		}
+	}
+	return -1
+}
+
+// driverName is the name of our sqlite driver, which is the stock driver with
+// a REGEXP implementation added to each connection, and its statements timed
+const driverName = "sqlite3_headlamp"
+
+func init() {
+	sql.Register(driverName, timedDriver{&sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("regexp", sqlRegexp, true)
+		},
+	}})
+}
+
+// regexpCache holds compiled expressions; a search runs the same expression
+// against every row, so compiling per row would be painfully slow
+var regexpCache = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+// maxCachedRegexps keeps the cache from growing forever on a long-running
+// server; when it fills up, we just start over
+const maxCachedRegexps = 100
+
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	regexpCache.Lock()
+	defer regexpCache.Unlock()
+
+	var re = regexpCache.m[pattern]
+	if re != nil {
+		return re, nil
+	}
+
+	var err error
+	re, err = regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		// Report the error against what the user actually typed
+		var _, rawErr = regexp.Compile(pattern)
+		if rawErr != nil {
+			err = rawErr
+		}
+		return nil, err
+	}
+	if len(regexpCache.m) >= maxCachedRegexps {
+		regexpCache.m = make(map[string]*regexp.Regexp)
+	}
+	regexpCache.m[pattern] = re
+	return re, nil
+}
+
+// sqlRegexp implements SQLite's "X REGEXP Y" operator, which calls
+// regexp(Y, X)
+func sqlRegexp(pattern, value string) (bool, error) {
+	var re, err = compileRegexp(pattern)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(value), nil
+}
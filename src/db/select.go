@@ -1,7 +1,9 @@
 package db
 
 import (
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/Nerdmaster/magicsql"
 )
@@ -15,17 +17,20 @@ type FSelect struct {
 	whereFields []string
 	whereArgs   []interface{}
 	limit       uint64
+	offset      uint64
+	sort        FileSort
 	tree        bool
+	table       string
 }
 
 // FileSelect creates a new FSelect for querying/searching files
 func (op *Operation) FileSelect(c *Category, f *Folder) *FSelect {
-	return &FSelect{op: op, sel: op.Files.Select(), category: c, folder: f}
+	return &FSelect{op: op, sel: op.Files.Select(), category: c, folder: f, table: "files"}
 }
 
 // FolderSelect creates a new FSelect for querying/searching folders
 func (op *Operation) FolderSelect(c *Category, f *Folder) *FSelect {
-	return &FSelect{op: op, sel: op.Folders.Select(), category: c, folder: f}
+	return &FSelect{op: op, sel: op.Folders.Select(), category: c, folder: f, table: "folders"}
 }
 
 // TreeMode defaults to false, but if set to true will recurse through all
@@ -49,6 +54,206 @@ func (s *FSelect) Limit(l uint64) *FSelect {
 	return s
 }
 
+// Page restricts the select to the given page of results
+func (s *FSelect) Page(p Page) *FSelect {
+	s.limit = p.Limit
+	s.offset = p.Offset
+	return s
+}
+
+// FileSort says how to order a file select.  The zero value uses the
+// default order: shallowest files first, then by path.
+type FileSort struct {
+	Field string
+	Desc  bool
+}
+
+// Fields files can be sorted on
+const (
+	SortName     = "name"
+	SortSize     = "size"
+	SortModified = "modified"
+	SortType     = "type"
+)
+
+// FileSortFields lists every field files can be sorted on
+var FileSortFields = []string{SortName, SortSize, SortModified, SortType}
+
+// fileSortColumns maps each sort field to the columns it orders by
+var fileSortColumns = map[string]string{
+	SortName:     "LOWER(name)",
+	SortSize:     "filesize",
+	SortModified: "modified_at",
+	SortType:     "mime_type",
+}
+
+// ParseFileSort reads a sort parameter: a field name, optionally prefixed
+// with "-" for descending order.  An empty string gives the default sort.
+func ParseFileSort(s string) (FileSort, error) {
+	var fs FileSort
+	if strings.HasPrefix(s, "-") {
+		fs.Desc = true
+		s = s[1:]
+	}
+	if s == "" && !fs.Desc {
+		return fs, nil
+	}
+	if fileSortColumns[s] == "" {
+		return FileSort{}, fmt.Errorf("invalid sort %q: must be one of %s, optionally prefixed with \"-\"",
+			s, strings.Join(FileSortFields, ", "))
+	}
+	fs.Field = s
+	return fs, nil
+}
+
+// String returns the sort as ParseFileSort reads it
+func (fs FileSort) String() string {
+	if fs.Desc {
+		return "-" + fs.Field
+	}
+	return fs.Field
+}
+
+// Sort orders the select by the given field.  Ties are broken by path, then
+// id, so paging through sorted results never repeats or skips a row.  This
+// only makes sense for file selects.
+func (s *FSelect) Sort(fs FileSort) *FSelect {
+	s.sort = fs
+	return s
+}
+
+// order returns the ORDER BY clause for the select
+func (s *FSelect) order() string {
+	var col = fileSortColumns[s.sort.Field]
+	if col == "" {
+		return "depth, LOWER(public_path)"
+	}
+	if s.sort.Desc {
+		col += " DESC"
+	}
+	return col + ", LOWER(public_path), id"
+}
+
+// Page selects part of a larger result set: up to Limit rows (all of them if
+// Limit is zero), after skipping the first Offset rows.  Offset is ignored
+// without a limit.
+type Page struct {
+	Offset uint64
+	Limit  uint64
+}
+
+// DateRange holds optional bounds on files' modification and index times.
+// Zero-valued bounds aren't applied.
+type DateRange struct {
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	IndexedAfter   time.Time
+	IndexedBefore  time.Time
+}
+
+// IsZero returns true if none of the bounds are set
+func (dr DateRange) IsZero() bool {
+	return dr.ModifiedAfter.IsZero() && dr.ModifiedBefore.IsZero() &&
+		dr.IndexedAfter.IsZero() && dr.IndexedBefore.IsZero()
+}
+
+// DateRange limits the select to files within the given date bounds.  Files
+// with an unknown (zero) date never match a bound on that date.  This only
+// makes sense for file selects; folders have no dates.
+func (s *FSelect) DateRange(dr DateRange) *FSelect {
+	s.dateBounds("modified_at", dr.ModifiedAfter, dr.ModifiedBefore)
+	s.dateBounds("indexed_at", dr.IndexedAfter, dr.IndexedBefore)
+	return s
+}
+
+// dateBounds adds where clauses for a single date field.  Dates are always
+// compared in UTC since that's how they're stored.
+func (s *FSelect) dateBounds(field string, after, before time.Time) {
+	if !after.IsZero() {
+		s.Search(field+" >= ?", after.UTC())
+	}
+	if !before.IsZero() {
+		s.Search(field+" < ?", before.UTC())
+		s.Search(field+" > ?", time.Time{})
+	}
+}
+
+// ExtensionCount is the number of files sharing an extension
+type ExtensionCount struct {
+	Extension string
+	Files     int64
+}
+
+// FileFilter combines all the optional restrictions which can be put on a
+// file select
+type FileFilter struct {
+	Dates      DateRange
+	Sizes      SizeRange
+	Extensions []string
+	MimeType   string
+	Family     string
+}
+
+// Filter applies all of ff's restrictions to the select
+func (s *FSelect) Filter(ff FileFilter) *FSelect {
+	return s.DateRange(ff.Dates).SizeRange(ff.Sizes).FileTypes(ff.Extensions, ff.MimeType).FormatFamily(ff.Family)
+}
+
+// SizeRange holds optional inclusive bounds, in bytes, on files' sizes.
+// Zero-valued bounds aren't applied.
+type SizeRange struct {
+	Min int64
+	Max int64
+}
+
+// SizeRange limits the select to files within the given size bounds.  This
+// only makes sense for file selects.
+func (s *FSelect) SizeRange(sr SizeRange) *FSelect {
+	if sr.Min > 0 {
+		s.Search("filesize >= ?", sr.Min)
+	}
+	if sr.Max > 0 {
+		s.Search("filesize <= ?", sr.Max)
+	}
+	return s
+}
+
+// FormatFamily limits the select to files in the given format family.  An
+// empty family isn't applied.
+func (s *FSelect) FormatFamily(family string) *FSelect {
+	family = strings.ToLower(strings.TrimSpace(family))
+	if family != "" {
+		s.Search("format_family = ?", family)
+	}
+	return s
+}
+
+// FileTypes limits the select to files with any of the given extensions
+// (case-insensitive, without the leading dot) and/or the given MIME type.  If
+// mimeType ends in a slash, e.g., "audio/", it matches every subtype.  Empty
+// values aren't applied.
+func (s *FSelect) FileTypes(exts []string, mimeType string) *FSelect {
+	var args []interface{}
+	for _, ext := range exts {
+		ext = NormalizeExtension(ext)
+		if ext != "" {
+			args = append(args, ext)
+		}
+	}
+	if len(args) > 0 {
+		s.whereFields = append(s.whereFields, "extension IN ("+strings.Repeat("?, ", len(args)-1)+"?)")
+		s.whereArgs = append(s.whereArgs, args...)
+	}
+
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+	if strings.HasSuffix(mimeType, "/") {
+		s.Search("mime_type LIKE ?", mimeType+"%")
+	} else if mimeType != "" {
+		s.Search("mime_type = ?", mimeType)
+	}
+	return s
+}
+
 func (s *FSelect) setCategory(data interface{}) {
 	var files []*File
 	var folders []*Folder
@@ -73,33 +278,70 @@ func (s *FSelect) setCategory(data interface{}) {
 	}
 }
 
-// AllObjects runs the query based on all the data, sending obj to the
-// underlying Select's AllObjects function.  Returns the total number of
-// objects found via a COUNT query if Limit was set in order to know if more
-// objects were available.
-func (s *FSelect) AllObjects(data interface{}) (total uint64, err error) {
+// where returns the select's full WHERE clause and its arguments
+func (s *FSelect) where() (string, []interface{}) {
+	var fields = append([]string{}, s.whereFields...)
+	var args = append([]interface{}{}, s.whereArgs...)
 	if s.category != nil {
-		s.whereFields = append(s.whereFields, "category_id = ?")
-		s.whereArgs = append(s.whereArgs, s.category.ID)
+		fields = append(fields, "category_id = ?")
+		args = append(args, s.category.ID)
 	}
 	if s.tree == false {
 		var folderID int
 		if s.folder != nil {
 			folderID = s.folder.ID
 		}
-		s.whereFields = append(s.whereFields, "folder_id = ?")
-		s.whereArgs = append(s.whereArgs, folderID)
+		fields = append(fields, "folder_id = ?")
+		args = append(args, folderID)
 	} else {
 		if s.folder != nil {
-			s.whereFields = append(s.whereFields, "public_path like ?")
-			s.whereArgs = append(s.whereArgs, s.folder.PublicPath+"/%")
+			fields = append(fields, "public_path like ?")
+			args = append(args, s.folder.PublicPath+"/%")
 		}
 	}
 
-	var sel = s.sel.Where(strings.Join(s.whereFields, " AND "), s.whereArgs...)
-	sel = sel.Order("depth, LOWER(public_path)")
+	if s.op.hiding() {
+		fields = append(fields, s.op.hiddenClause(s.table))
+	}
+
+	return strings.Join(fields, " AND "), args
+}
+
+// buildSelect returns the underlying magicsql Select with all where clauses
+// and ordering applied
+func (s *FSelect) buildSelect() magicsql.Select {
+	var where, args = s.where()
+	return s.sel.Where(where, args...).Order(s.order())
+}
+
+// ExtensionCounts returns how many files the select matches with each
+// extension, ordered by extension.  Files without an extension are counted
+// under an empty string.  Limits and sorting are ignored.
+func (s *FSelect) ExtensionCounts() ([]*ExtensionCount, error) {
+	var where, args = s.where()
+	var rows = s.op.Operation.Query("SELECT extension, COUNT(*) FROM "+s.table+
+		" WHERE "+where+" GROUP BY extension ORDER BY extension", args...)
 
+	var counts []*ExtensionCount
+	for rows.Next() {
+		var c = &ExtensionCount{}
+		rows.Scan(&c.Extension, &c.Files)
+		counts = append(counts, c)
+	}
+	rows.Close()
+	return counts, s.op.Operation.Err()
+}
+
+// AllObjects runs the query based on all the data, sending obj to the
+// underlying Select's AllObjects function.  Returns the total number of
+// objects found via a COUNT query if Limit was set in order to know if more
+// objects were available.
+func (s *FSelect) AllObjects(data interface{}) (total uint64, err error) {
+	var sel = s.buildSelect()
 	var count = sel.Count().RowCount()
+	if s.limit > 0 {
+		sel = sel.Limit(s.limit).Offset(s.offset)
+	}
 	sel.AllObjects(data)
 
 	s.setCategory(data)
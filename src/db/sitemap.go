@@ -0,0 +1,59 @@
+package db
+
+import (
+	"strings"
+)
+
+// DiscoverableCategories returns the categories search engines may crawl, in
+// name order
+func (op *Operation) DiscoverableCategories() ([]*Category, error) {
+	var all, err = op.AllCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	var categories []*Category
+	for _, c := range all {
+		if c.Discoverable() {
+			categories = append(categories, c)
+		}
+	}
+	return categories, nil
+}
+
+// SitemapFolders returns the given page of folders in the given categories,
+// ordered by id so pages stay stable as folders are added, and the total
+// number of them.  Folders op hides are left out, so a sitemap should be
+// built with an anonymous user's operation.  Category data is filled in on
+// each folder.
+func (op *Operation) SitemapFolders(categories []*Category, page Page) ([]*Folder, uint64, error) {
+	if len(categories) == 0 {
+		return nil, 0, nil
+	}
+
+	var lookup = make(map[int]*Category)
+	var placeholders []string
+	var args []interface{}
+	for _, c := range categories {
+		lookup[c.ID] = c
+		placeholders = append(placeholders, "?")
+		args = append(args, c.ID)
+	}
+	var where = "category_id IN (" + strings.Join(placeholders, ",") + ")"
+	if op.hiding() {
+		where += " AND " + op.hiddenClause("folders")
+	}
+
+	var sel = op.Folders.Select().Where(where, args...).Order("id")
+	var total = sel.Count().RowCount()
+	if page.Limit > 0 {
+		sel = sel.Limit(page.Limit).Offset(page.Offset)
+	}
+
+	var folders []*Folder
+	sel.AllObjects(&folders)
+	for _, f := range folders {
+		f.Category = lookup[f.CategoryID]
+	}
+	return folders, total, op.Operation.Err()
+}
@@ -0,0 +1,56 @@
+package db
+
+import "time"
+
+// Stats holds overall counts of what's in the database, for the admin
+// dashboard
+type Stats struct {
+	Categories    int64
+	Folders       int64
+	Files         int64
+	Bytes         int64
+	MissingFiles  int64
+	Users         int64
+	SavedSearches int64
+}
+
+// Stats counts the records in the main tables
+func (op *Operation) Stats() (*Stats, error) {
+	var s = &Stats{}
+	var rows = op.Operation.Query("SELECT "+
+		"(SELECT COUNT(*) FROM categories), "+
+		"(SELECT COUNT(*) FROM folders), "+
+		"(SELECT COUNT(*) FROM files), "+
+		"(SELECT COALESCE(SUM(filesize), 0) FROM files), "+
+		"(SELECT COUNT(*) FROM files WHERE missing_since > ?), "+
+		"(SELECT COUNT(*) FROM users), "+
+		"(SELECT COUNT(*) FROM saved_searches)", time.Time{})
+	if rows.Next() {
+		rows.Scan(&s.Categories, &s.Folders, &s.Files, &s.Bytes, &s.MissingFiles, &s.Users, &s.SavedSearches)
+	}
+	rows.Close()
+	return s, op.Operation.Err()
+}
+
+// ArchiveJobCounts returns the number of archive jobs in each status
+func (op *Operation) ArchiveJobCounts() (map[string]int, error) {
+	var counts = make(map[string]int)
+	var rows = op.Operation.Query("SELECT status, COUNT(*) FROM archive_jobs GROUP BY status")
+	for rows.Next() {
+		var status string
+		var n int
+		rows.Scan(&status, &n)
+		counts[status] = n
+	}
+	rows.Close()
+	return counts, op.Operation.Err()
+}
+
+// RecentArchiveJobErrors returns up to limit jobs which have failed, or
+// which are waiting to be retried after an error, most recent first
+func (op *Operation) RecentArchiveJobErrors(limit int) ([]*ArchiveJob, error) {
+	var jobs []*ArchiveJob
+	op.ArchiveJobs.Select().Where("status = ? OR (status = ? AND last_error != '')", JobStatusFailed, JobStatusPending).
+		Order("created_at DESC, id DESC").Limit(uint64(limit)).AllObjects(&jobs)
+	return jobs, op.Operation.Err()
+}
@@ -1,7 +1,6 @@
 package db
 
 import (
-	"net/mail"
 	"path/filepath"
 	"strings"
 	"time"
@@ -13,13 +12,49 @@ import (
 type Category struct {
 	ID   int `sql:",primary"`
 	Name string
+
+	// Hidden categories are left off the category list for non-staff users,
+	// though their files can still be found by searching or by direct links
+	Hidden bool
+
+	// AccessRole is the role a user needs to see anything in the category; if
+	// it's empty, everybody can
+	AccessRole string
+
+	// Crawlable categories are opened to search engines, but only while
+	// they're public: see Discoverable
+	Crawlable bool
+}
+
+// Discoverable returns true if search engines should be allowed to crawl and
+// index the category: it's been marked crawlable, and anonymous visitors can
+// both see and list it
+func (c *Category) Discoverable() bool {
+	return c.Crawlable && !c.Hidden && c.AccessRole == ""
 }
 
 // Inventory maps to the inventories database table, which represents a
 // manifest file in an INVENTORY folder
 type Inventory struct {
 	ID   int    `sql:",primary"`
+	Root string // Root names the dark archive root; the main root has no name
 	Path string // Path is relative to the dark archive root
+
+	// Filesize and ModTime are the inventory file's stats when it was last
+	// indexed, used to detect changes
+	Filesize int64
+	ModTime  time.Time
+
+	// BagInfo holds the contents of bag-info.txt for inventories read from a
+	// BagIt bag, in which case Path is the bag's directory
+	BagInfo string
+
+	// ContentHash is the SHA-256 of the inventory file (or bag manifest) when
+	// it was last indexed.  DuplicateOf is the id of the inventory this one
+	// turned out to be a copy of, in which case none of its files were
+	// indexed.
+	ContentHash string
+	DuplicateOf int
 }
 
 // Folder maps to the folders table, and is effectively a giant list of our
@@ -34,6 +69,13 @@ type Folder struct {
 	Depth      int
 	Name       string
 	PublicPath string
+
+	// Restricted folders, and everything under them, are hidden from
+	// non-staff users
+	Restricted bool
+
+	// Description is curator-supplied context shown when browsing the folder
+	Description string
 }
 
 // A RealFolder lets us see what path(s) point to a given public folder
@@ -59,8 +101,39 @@ type File struct {
 	Checksum    string
 	Filesize    int64
 	Name        string
+	Root        string // Root names the dark archive root FullPath is relative to
 	FullPath    string
 	PublicPath  string
+
+	// ModifiedAt is the file's modification time as reported by its inventory,
+	// or zero if the inventory didn't include it
+	ModifiedAt time.Time
+
+	// IndexedAt is the last time the indexer wrote this file's record
+	IndexedAt time.Time
+
+	// Extension is the lowercased filename extension without its leading dot,
+	// MimeType is our best guess at the file's type based on it, and
+	// FormatFamily is the coarse grouping (see FormatFamilies) that type is in
+	Extension    string
+	MimeType     string
+	FormatFamily string
+
+	// Restricted files are hidden from non-staff users
+	Restricted bool
+
+	// MissingSince is set when a reindexed inventory stops listing the file,
+	// and cleared if an inventory lists it again
+	MissingSince time.Time
+
+	// LinkTarget is set for files indexed with the "alias" link policy whose
+	// path is a symlink or an extra hard link.  It's the path the link points
+	// to, relative to the dark archive root when possible.
+	LinkTarget string
+
+	// InventoryLine is the line number (starting at 1) of the record in the
+	// file's inventory, or zero if it was indexed before lines were tracked
+	InventoryLine int
 }
 
 // ContainingFolder returns the path to the file's folder for cases where
@@ -69,36 +142,36 @@ func (f *File) ContainingFolder() string {
 	return filepath.Dir(f.PublicPath)
 }
 
-// The ArchiveJob structure maps to archive_jobs, storing RS-separated files and
-// comma-separated notification email(s).  The record represents a single
-// archive creation request.
-type ArchiveJob struct {
-	ID                 int `sql:",primary"`
-	CreatedAt          time.Time
-	NextAttemptAt      time.Time
-	NotificationEmails string
-	Files              string
-	Processed          bool
+// archivePathSep separates a file's root name from its path in archive job
+// file lists
+const archivePathSep = "\x1F"
+
+// ArchivePath returns the file's path as stored in an archive job's file
+// list: just the full path for files in the main dark archive root, or the
+// root name and full path otherwise
+func (f *File) ArchivePath() string {
+	if f.Root == "" {
+		return f.FullPath
+	}
+	return f.Root + archivePathSep + f.FullPath
 }
 
-// Emails parses the email addresses as mail.Addr instances and returns them as
-// a list of strings, ensuring the strings are split properly in cases where
-// the email addressee has a comma in the name, e.g.:
-//
-//     "John Doe, III" <jdoeiii@example.org>, Alice <alice@example.org>
-//
-// Errors are ignored, as the database shouldn't get emails in any way other
-// than from a pre-validated email list
-func (j *ArchiveJob) Emails() []string {
-	var eList, _ = mail.ParseAddressList(j.NotificationEmails)
-	var sList = make([]string, len(eList))
-	for i, e := range eList {
-		sList[i] = e.String()
+// SplitArchivePath splits a path from an archive job's file list into the
+// root name and the path relative to that root
+func SplitArchivePath(p string) (root, fullPath string) {
+	var parts = strings.SplitN(p, archivePathSep, 2)
+	if len(parts) == 1 {
+		return "", p
 	}
-	return sList
+	return parts[0], parts[1]
+}
+
+// IsLink returns true if the file was recorded as an alias of another path
+func (f *File) IsLink() bool {
+	return f.LinkTarget != ""
 }
 
-// FileList splits the files field and returns them as a list
-func (j *ArchiveJob) FileList() []string {
-	return strings.Split(j.Files, "\x1E")
+// Missing returns true if the file's inventory stopped listing it
+func (f *File) Missing() bool {
+	return !f.MissingSince.IsZero()
 }
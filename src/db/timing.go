@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"time"
+
+	"github.com/uoregon-libraries/headlamp/src/metrics"
+)
+
+// queryDuration times every statement run against the database.  Queries are
+// timed from the moment they're sent until their rows are closed, since
+// SQLite does most of its work while rows are being read.
+var queryDuration = metrics.NewHistogram("headlamp_db_query_duration_seconds",
+	"Time spent running database statements", metrics.DefaultBuckets, "kind")
+
+// timedDriver wraps a database driver so the statements run on its
+// connections are timed
+type timedDriver struct {
+	driver.Driver
+}
+
+func (d timedDriver) Open(dsn string) (driver.Conn, error) {
+	var c, err = d.Driver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return timedConn{c}, nil
+}
+
+// timedConn times statements run directly on a connection as well as those
+// it prepares
+type timedConn struct {
+	driver.Conn
+}
+
+func (c timedConn) Prepare(query string) (driver.Stmt, error) {
+	var s, err = c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return timedStmt{s}, nil
+}
+
+func (c timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	var e, ok = c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer queryDuration.ObserveDuration(time.Now(), "exec")
+	return e.ExecContext(ctx, query, args)
+}
+
+func (c timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	var q, ok = c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	var start = time.Now()
+	var rows, err = q.QueryContext(ctx, query, args)
+	if err != nil {
+		queryDuration.ObserveDuration(start, "query")
+		return nil, err
+	}
+	return &timedRows{Rows: rows, start: start}, nil
+}
+
+type timedStmt struct {
+	driver.Stmt
+}
+
+func (s timedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	defer queryDuration.ObserveDuration(time.Now(), "exec")
+	return s.Stmt.Exec(args)
+}
+
+func (s timedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	var start = time.Now()
+	var rows, err = s.Stmt.Query(args)
+	if err != nil {
+		queryDuration.ObserveDuration(start, "query")
+		return nil, err
+	}
+	return &timedRows{Rows: rows, start: start}, nil
+}
+
+// timedRows records its query's duration when closed
+type timedRows struct {
+	driver.Rows
+	start  time.Time
+	closed bool
+}
+
+func (r *timedRows) Close() error {
+	if !r.closed {
+		r.closed = true
+		queryDuration.ObserveDuration(r.start, "query")
+	}
+	return r.Rows.Close()
+}
@@ -0,0 +1,277 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// User maps to the users table, identifying a person who has logged into
+// Headlamp so their actions can be attributed to them
+type User struct {
+	ID          int `sql:",primary"`
+	Login       string
+	Name        string
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+
+	// Role is the role an admin assigned the user
+	Role string
+
+	// Roles is a comma-separated list of the roles the user's single sign-on
+	// attributes granted at their last login
+	Roles string
+}
+
+// User roles, from least to most access.  Each role can do everything the
+// roles before it can: viewers browse, staff also request archives and see
+// restricted items, curators also edit categories, and admins also manage
+// jobs and users.
+const (
+	RoleViewer  = "viewer"
+	RoleStaff   = "staff"
+	RoleCurator = "curator"
+	RoleAdmin   = "admin"
+)
+
+// Roles lists every role in order of increasing access
+var Roles = []string{RoleViewer, RoleStaff, RoleCurator, RoleAdmin}
+
+// RoleRank returns the role's position in Roles, or -1 if it isn't a role
+func RoleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// HasRole returns true if the user was granted the given role by single
+// sign-on
+func (u *User) HasRole(role string) bool {
+	for _, r := range strings.Split(u.Roles, ",") {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxRole returns the most powerful of the user's assigned and single
+// sign-on roles
+func (u *User) MaxRole() string {
+	var role = RoleViewer
+	for _, r := range append([]string{u.Role}, strings.Split(u.Roles, ",")...) {
+		if RoleRank(r) > RoleRank(role) {
+			role = r
+		}
+	}
+	return role
+}
+
+// EffectiveRole returns the most powerful of u's assigned and single sign-on
+// roles and the roles implied by the given comma-separated lists of admin and
+// staff email addresses (ADMIN_EMAILS and STAFF_EMAILS)
+func (u *User) EffectiveRole(adminEmails, staffEmails string) string {
+	if emailListed(adminEmails, u.Email) {
+		return RoleAdmin
+	}
+	var role = u.MaxRole()
+	if emailListed(staffEmails, u.Email) && RoleRank(role) < RoleRank(RoleStaff) {
+		role = RoleStaff
+	}
+	return role
+}
+
+// emailListed returns true if addr is in the comma-separated list
+func emailListed(list, addr string) bool {
+	if addr == "" {
+		return false
+	}
+	for _, item := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(item), addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Session maps to the sessions table.  A session belongs to a user unless
+// UserID is zero, and is considered gone once ExpiresAt has passed.
+type Session struct {
+	ID        int   `sql:",primary"`
+	User      *User `sql:"-"`
+	Token     string
+	UserID    int
+	Data      string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Expired returns true if the session's expiration time has passed
+func (s *Session) Expired() bool {
+	return time.Now().After(s.ExpiresAt)
+}
+
+// CreateUser stores a new user with the given login, name, and email.  It is
+// an error to create a user whose login already exists.
+func (op *Operation) CreateUser(login, name, email string) (*User, error) {
+	if login == "" {
+		return nil, fmt.Errorf("no login given")
+	}
+
+	var existing, err = op.FindUserByLogin(login)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("user %q already exists", login)
+	}
+
+	var u = &User{Login: login, Name: name, Email: email, Role: RoleViewer, CreatedAt: time.Now()}
+	op.Users.Save(u)
+	return u, op.Operation.Err()
+}
+
+// FindUserByID returns the user with the given id, or nil if none is found
+func (op *Operation) FindUserByID(id int) (*User, error) {
+	var u = &User{}
+	var ok = op.Users.Select().Where("id = ?", id).First(u)
+	if !ok {
+		u = nil
+	}
+	return u, op.Operation.Err()
+}
+
+// FindUserByLogin returns the user with the given login, or nil if none is found
+func (op *Operation) FindUserByLogin(login string) (*User, error) {
+	var u = &User{}
+	var ok = op.Users.Select().Where("login = ?", login).First(u)
+	if !ok {
+		u = nil
+	}
+	return u, op.Operation.Err()
+}
+
+// AllUsers returns all users, sorted by login
+func (op *Operation) AllUsers() ([]*User, error) {
+	var users []*User
+	op.Users.Select().Order("LOWER(login)").AllObjects(&users)
+	return users, op.Operation.Err()
+}
+
+// SaveUser writes the user's current data to the database
+func (op *Operation) SaveUser(u *User) error {
+	op.Users.Save(u)
+	return op.Operation.Err()
+}
+
+// SetUserRole assigns the given role to the user
+func (op *Operation) SetUserRole(u *User, role string) error {
+	if RoleRank(role) < 0 {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	u.Role = role
+	return op.SaveUser(u)
+}
+
+// RecordLogin sets the user's last login time to now
+func (op *Operation) RecordLogin(u *User) error {
+	u.LastLoginAt = time.Now()
+	return op.SaveUser(u)
+}
+
+// CreateSession generates a new random token and stores a session for it.
+// The user may be nil for an anonymous session.
+func (op *Operation) CreateSession(u *User, lifetime time.Duration) (*Session, error) {
+	var token, err = randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var now = time.Now()
+	var s = &Session{User: u, Token: token, CreatedAt: now, ExpiresAt: now.Add(lifetime)}
+	if u != nil {
+		s.UserID = u.ID
+	}
+	op.Sessions.Save(s)
+	return s, op.Operation.Err()
+}
+
+// FindSessionByToken returns the session with the given token, or nil if none
+// is found or the session has expired.  The session's user is populated if
+// there is one.
+func (op *Operation) FindSessionByToken(token string) (*Session, error) {
+	var s = &Session{}
+	var ok = op.Sessions.Select().Where("token = ?", token).First(s)
+	if !ok || s.Expired() {
+		return nil, op.Operation.Err()
+	}
+
+	if s.UserID != 0 {
+		var err error
+		s.User, err = op.FindUserByID(s.UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, op.Operation.Err()
+}
+
+// SaveSession writes the session's current data to the database
+func (op *Operation) SaveSession(s *Session) error {
+	op.Sessions.Save(s)
+	return op.Operation.Err()
+}
+
+// DeleteSession removes the given session, e.g., on logout
+func (op *Operation) DeleteSession(s *Session) error {
+	op.Operation.Exec("DELETE FROM sessions WHERE id = ?", s.ID)
+	return op.Operation.Err()
+}
+
+// StoreSession saves data for the session with the given token, creating the
+// session if it doesn't exist yet.  This is how the web server's session
+// manager persists sessions, so the token is whatever the manager generated.
+func (op *Operation) StoreSession(token string, userID int, data string, expires time.Time) error {
+	var s = &Session{}
+	var ok = op.Sessions.Select().Where("token = ?", token).First(s)
+	if op.Operation.Err() != nil {
+		return op.Operation.Err()
+	}
+	if !ok {
+		s = &Session{Token: token, CreatedAt: time.Now()}
+	}
+	s.UserID = userID
+	s.Data = data
+	s.ExpiresAt = expires
+	return op.SaveSession(s)
+}
+
+// DeleteSessionByToken removes the session with the given token, if there is
+// one
+func (op *Operation) DeleteSessionByToken(token string) error {
+	op.Operation.Exec("DELETE FROM sessions WHERE token = ?", token)
+	return op.Operation.Err()
+}
+
+// DeleteExpiredSessions removes all sessions which have expired, returning
+// the number of sessions removed
+func (op *Operation) DeleteExpiredSessions() (int64, error) {
+	var res = op.Operation.Exec("DELETE FROM sessions WHERE expires_at < ?", time.Now())
+	return res.RowsAffected(), op.Operation.Err()
+}
+
+// randomToken returns a hex-encoded string of 32 random bytes
+func randomToken() (string, error) {
+	var b = make([]byte, 32)
+	var _, err = rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("unable to generate token: %s", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,93 @@
+package indexer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// BagManifestName is the BagIt payload manifest the indexer reads from each bag
+const BagManifestName = "manifest-sha256.txt"
+
+// bagInfoName is the BagIt file holding a bag's descriptive metadata
+const bagInfoName = "bag-info.txt"
+
+// bagPathDecoder undoes the percent-encoding BagIt uses for the characters
+// which can't otherwise appear in a manifest's file paths
+var bagPathDecoder = strings.NewReplacer("%0A", "\n", "%0a", "\n", "%0D", "\r", "%0d", "\r", "%25", "%")
+
+// readBag reads a bag's SHA-256 payload manifest and its bag-info.txt.  The
+// bag's directory is recorded as the inventory, with bag-info.txt's contents
+// kept alongside it.  Payload manifests don't list sizes or modification
+// times, so each payload file is looked up on disk; files which can't be
+// found are logged and skipped.  root is the dark archive root the bag is in.
+func (i *Indexer) readBag(inv *db.Inventory, bagPath, root string) ([]*inventoryRecord, error) {
+	var data, err = ioutil.ReadFile(filepath.Join(bagPath, BagManifestName))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read bag manifest in %q: %s", bagPath, err)
+	}
+
+	var info []byte
+	info, err = ioutil.ReadFile(filepath.Join(bagPath, bagInfoName))
+	switch {
+	case os.IsNotExist(err):
+		logger.Debugf("Bag %q has no %s", bagPath, bagInfoName)
+	case err != nil:
+		return nil, fmt.Errorf("unable to read %s in %q: %s", bagInfoName, bagPath, err)
+	}
+	inv.BagInfo = strings.TrimSpace(string(info))
+
+	var records []*inventoryRecord
+	var offset int64
+	for index, line := range bytes.Split(data, []byte("\n")) {
+		offset += int64(len(line)) + 1
+		line = bytes.TrimRight(line, "\r")
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var ir, err = i.parseBagRecord(string(line), inv.Path, root)
+		if err != nil {
+			records = append(records, badRecord(index+1, offset, err))
+			continue
+		}
+		ir.line = index + 1
+		ir.offset = offset
+		records = append(records, ir)
+	}
+
+	return records, nil
+}
+
+// parseBagRecord splits a payload manifest line into its checksum and path,
+// then reads the file's size and modification time from disk
+func (i *Indexer) parseBagRecord(line, bagPath, root string) (*inventoryRecord, error) {
+	line = strings.TrimLeft(line, " \t")
+	var parts = strings.Fields(line)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("there must be a checksum and a path")
+	}
+	var checksum = strings.ToLower(parts[0])
+
+	// The path is everything after the checksum and its whitespace, which
+	// keeps any spaces within the filename intact
+	var relPath = strings.TrimLeft(strings.TrimPrefix(line, parts[0]), " \t")
+	relPath = bagPathDecoder.Replace(strings.TrimPrefix(relPath, "*"))
+	var fullPath = filepath.Clean(filepath.Join(bagPath, relPath))
+	if !strings.HasPrefix(fullPath, bagPath+string(filepath.Separator)) {
+		return nil, fmt.Errorf("path %q is outside the bag", relPath)
+	}
+
+	var info, err = os.Stat(filepath.Join(root, fullPath))
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat payload file: %s", err)
+	}
+
+	return &inventoryRecord{fullPath: fullPath, filesize: info.Size(), checksum: checksum, modTime: info.ModTime().UTC()}, nil
+}
@@ -0,0 +1,114 @@
+package indexer
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// dryRunSampleSize is how many examples a DryRunReport keeps for each kind of
+// change
+const dryRunSampleSize = 10
+
+// errDryRun is returned from the dry run's transaction so that everything it
+// wrote is rolled back
+var errDryRun = errors.New("dry run: rolling back")
+
+// DryRunChanges counts a single kind of change and keeps a few examples
+type DryRunChanges struct {
+	Count  int
+	Sample []string
+}
+
+func (c *DryRunChanges) add(s string) {
+	c.Count++
+	if len(c.Sample) < dryRunSampleSize {
+		c.Sample = append(c.Sample, s)
+	}
+}
+
+// DryRunReport describes what an Index call would have done
+type DryRunReport struct {
+	NewInventories     DryRunChanges
+	ChangedInventories DryRunChanges
+	FailedInventories  DryRunChanges
+	BadRecords         DryRunChanges
+	Categories         DryRunChanges
+	Folders            DryRunChanges
+	NewFiles           DryRunChanges
+	ChangedFiles       DryRunChanges
+	MissingFiles       DryRunChanges
+	UnchangedFiles     int
+}
+
+// DryRun parses inventories exactly as Index would and reports what it would
+// create or change.  The indexing is really done, but in a single
+// transaction which is then rolled back, so the counts are exact and the
+// database is untouched.
+func (i *Indexer) DryRun() (*DryRunReport, error) {
+	var r = &DryRunReport{}
+	i.dryRun = r
+	defer func() {
+		i.dryRun = nil
+
+		// The cached categories may include records which were rolled back
+		i.categories = make(map[string]*category)
+	}()
+
+	var err = i.Index()
+	return r, err
+}
+
+// relativePath strips the dark archive root from an inventory's path for
+// reporting, prefixing it with the root's name if it isn't the main root
+func (i *Indexer) relativePath(f inventoryFile) string {
+	var rel = strings.TrimLeft(strings.TrimPrefix(f.path, f.root.Path), "/")
+	if f.root.Name != "" {
+		rel = f.root.Name + ":" + rel
+	}
+	return rel
+}
+
+// recordCategory notes a category which doesn't exist yet
+func (i *indexerOperation) recordCategory(name string) error {
+	var c, err = i.op.FindCategoryByName(name)
+	if c == nil && err == nil {
+		c, err = i.op.FindCategoryByOldName(name)
+	}
+	if c == nil && err == nil {
+		i.dryRun.Categories.add(name)
+	}
+	return err
+}
+
+// recordFolder notes a folder which doesn't exist yet
+func (i *indexerOperation) recordFolder(c *category, path string) error {
+	var f, err = i.op.FindFolderByPath(c.Category, path)
+	if f == nil && err == nil {
+		i.dryRun.Folders.add(c.Name + "/" + path)
+	}
+	return err
+}
+
+// recordFile notes whether the file is new, changed, or the same as what's
+// already in the database.  A file is only considered changed if its
+// contents or location differ, not if a different inventory lists it.
+func (i *indexerOperation) recordFile(f *db.File) error {
+	var existing, err = i.op.FindFileByPublicPath(f.CategoryID, f.ArchiveDate, f.PublicPath)
+	if err != nil {
+		return err
+	}
+
+	var desc = f.Category.Name + "/" + f.ArchiveDate + "/" + f.PublicPath
+	switch {
+	case existing == nil:
+		i.dryRun.NewFiles.add(desc)
+	case existing.Checksum != f.Checksum, existing.Filesize != f.Filesize,
+		existing.FullPath != f.FullPath, !existing.ModifiedAt.Equal(f.ModifiedAt):
+		i.dryRun.ChangedFiles.add(desc)
+	default:
+		i.dryRun.UnchangedFiles++
+	}
+	return nil
+}
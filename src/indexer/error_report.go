@@ -0,0 +1,53 @@
+package indexer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// recordProblem is an inventory line which couldn't be indexed
+type recordProblem struct {
+	line int
+	err  error
+}
+
+// errorReport collects every bad record and unreadable inventory seen in a
+// run, so they can all be fixed from a single list instead of being dug out
+// of the logs
+type errorReport struct {
+	rows [][]string
+}
+
+// add records the parsed inventory's problems, if it had any
+func (r *errorReport) add(relPath string, p *parsedInventory) {
+	if p.err != nil {
+		r.rows = append(r.rows, []string{relPath, "", p.err.Error()})
+	}
+	for _, prob := range p.problems {
+		r.rows = append(r.rows, []string{relPath, strconv.Itoa(prob.line), prob.err.Error()})
+	}
+}
+
+// write stores the report as a CSV file in dir, named after the run's start
+// time, and returns the file's path
+func (r *errorReport) write(dir string, started time.Time) (string, error) {
+	var fname = filepath.Join(dir, fmt.Sprintf("index-errors-%s.csv", started.Format("20060102-150405")))
+	var f, err = os.Create(fname)
+	if err != nil {
+		return "", err
+	}
+
+	var w = csv.NewWriter(f)
+	w.Write([]string{"inventory", "line", "error"})
+	w.WriteAll(r.rows)
+	err = w.Error()
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	return fname, f.Close()
+}
@@ -0,0 +1,30 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// excluded returns true if any of the globs matches the given path.  A glob
+// is checked against every run of path elements as long as itself, so
+// "Thumbs.db" matches that file in any directory, and "*/derivatives/*"
+// matches anything directly inside a derivatives directory.
+func excluded(fullPath string, globs []string) bool {
+	if len(globs) == 0 {
+		return false
+	}
+
+	var sep = string(os.PathSeparator)
+	var pathParts = strings.Split(fullPath, sep)
+	for _, glob := range globs {
+		var size = strings.Count(glob, sep) + 1
+		for start := 0; start+size <= len(pathParts); start++ {
+			var matched, _ = filepath.Match(glob, strings.Join(pathParts[start:start+size], sep))
+			if matched {
+				return true
+			}
+		}
+	}
+	return false
+}
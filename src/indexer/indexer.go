@@ -2,6 +2,7 @@
 package indexer
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -32,20 +33,29 @@ func (c *category) buildFile(i *db.Inventory, f *db.Folder, r *fileRecord) *db.F
 	}
 
 	var _, fname = filepath.Split(r.fullPath)
+	var ext, mimeType = db.FileType(fname)
 	return &db.File{
-		Category:    c.Category,
-		CategoryID:  c.Category.ID,
-		Inventory:   i,
-		InventoryID: i.ID,
-		Folder:      f,
-		FolderID:    fid,
-		Depth:       strings.Count(r.publicPath, string(os.PathSeparator)),
-		ArchiveDate: r.archiveDate,
-		Checksum:    r.checksum,
-		Filesize:    r.filesize,
-		FullPath:    r.fullPath,
-		PublicPath:  r.publicPath,
-		Name:        fname,
+		Category:      c.Category,
+		CategoryID:    c.Category.ID,
+		Inventory:     i,
+		InventoryID:   i.ID,
+		InventoryLine: r.line,
+		Folder:        f,
+		FolderID:      fid,
+		Depth:         strings.Count(r.publicPath, string(os.PathSeparator)),
+		ArchiveDate:   r.archiveDate,
+		Checksum:      r.checksum,
+		Filesize:      r.filesize,
+		FullPath:      r.fullPath,
+		PublicPath:    r.publicPath,
+		Name:          db.NormalizePath(fname),
+		Root:          i.Root,
+		ModifiedAt:    r.modTime,
+		IndexedAt:     time.Now().UTC(),
+		Extension:     ext,
+		MimeType:      mimeType,
+		FormatFamily:  db.FormatFamily(mimeType),
+		LinkTarget:    r.linkTarget,
 	}
 }
 
@@ -67,24 +77,140 @@ type Indexer struct {
 	// millions of unnecessary lookups in the db
 	categories map[string]*category
 
-	// seenInventoryFiles caches the files we've processed in the past so we
-	// don't hit the DB each time we're looking at a new inventory file
-	seenInventoryFiles map[string]bool
+	// seenInventoryFiles caches the inventories we've processed in the past,
+	// keyed by their full path, so we don't hit the DB each time we're looking
+	// at a new inventory file
+	seenInventoryFiles map[string]*db.Inventory
 
 	// state is set via async calls to tell us what the indexer is currently
 	// doing (if anything).  This allows running an indexing operation in the
 	// background and waiting for it to finish while also being able to request
 	// it to stop at the next opportunity.
 	state int32
+
+	// minAge is how long an inventory file must go unmodified before we trust
+	// that it's complete
+	minAge time.Duration
+
+	// progress holds the checkpoints of partly indexed inventories, keyed by
+	// inventory id
+	progress map[int]*db.IndexProgress
+
+	// links says what to do with inventory entries which are symlinks or hard
+	// links, and tracks the hard-linked files seen in the current run
+	links *linkChecker
+
+	// onlyCategory, if set via SetCategory, limits indexing to inventories
+	// listing files in that category.  categoryNames holds the names its
+	// inventories could use: its current name and any old ones.
+	onlyCategory  string
+	categoryNames map[string]bool
+
+	// force, if set via SetForce, is the full path of the one inventory to
+	// index; it's cleared out and indexed again even if it hasn't changed
+	force string
+
+	// run summarizes the current Index() call; it's nil during dry runs.
+	// lastRun holds the summary of the most recent call once it's finished.
+	run     *db.IndexRun
+	lastRun *db.IndexRun
+
+	// errors collects the current run's bad records and unreadable
+	// inventories for the error report
+	errors *errorReport
+
+	// dryRun is set while DryRun is running, and collects what would have
+	// been written
+	dryRun *DryRunReport
+
+	// reporter, if set via SetProgressReporter, is told how each run is
+	// going; status is the current run's progress
+	reporter ProgressReporter
+	status   *Progress
 }
 
+// DefaultMinAge is how long inventory files must go unmodified before
+// they're indexed unless SetMinAge says otherwise
+const DefaultMinAge = time.Hour
+
 // New sets up a scanner for use in indexing dark-archive file data
 func New(dbh *db.Database, conf *config.Config) *Indexer {
-	return &Indexer{dbh: dbh, c: conf, categories: make(map[string]*category)}
+	return &Indexer{dbh: dbh, c: conf, categories: make(map[string]*category), minAge: DefaultMinAge, links: newLinkChecker(LinkFollow)}
 }
 
-// Index searches for inventory files not previously seen and indexes the files
-// described therein
+// SetMinAge changes how long inventory files must go unmodified before
+// they're indexed.  Callers which know when files stop changing, such as a
+// filesystem watcher, can use a much shorter wait than the default.
+func (i *Indexer) SetMinAge(d time.Duration) {
+	i.minAge = d
+}
+
+// SetCategory restricts indexing to inventories which list files in the
+// named category, so one collection can be refreshed without waiting on all
+// the others.  Inventories for other categories are left for a later run.
+// An empty name removes the restriction.
+func (i *Indexer) SetCategory(name string) {
+	i.onlyCategory = db.NormalizePath(name)
+}
+
+// SetForce limits indexing to the single inventory at path, which is indexed
+// from scratch whether or not it has changed: its record and all its files
+// are removed first.  This is for manifests which were corrected in place.
+// For bags, path may be the bag's directory or its payload manifest.  An
+// empty path removes the restriction.
+func (i *Indexer) SetForce(path string) error {
+	if path == "" {
+		i.force = ""
+		return nil
+	}
+	var abs, err = filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if filepath.Base(abs) == BagManifestName {
+		abs = filepath.Dir(abs)
+	}
+	i.force = abs
+	return nil
+}
+
+// inventoryKind tells us how to read an inventory file
+type inventoryKind int
+
+// All the kinds of inventory the indexer understands
+const (
+	kindInventory inventoryKind = iota // our own inventory format
+	kindManifest                       // a CSV manifest, read via the manifest column settings
+	kindBag                            // a BagIt bag's payload manifest
+	kindJSON                           // a JSON-lines inventory
+)
+
+// inventoryFile is an inventory found on disk.  For bags, path is the bag's
+// directory, while size and modTime come from its payload manifest.
+type inventoryFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+	kind    inventoryKind
+	root    config.Root
+}
+
+// hash returns the SHA-256 of the inventory's contents; for bags, that's the
+// payload manifest
+func (f inventoryFile) hash() (string, error) {
+	var path = f.path
+	if f.kind == kindBag {
+		path = filepath.Join(f.path, BagManifestName)
+	}
+	var h, err = hashFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to hash %q: %s", path, err)
+	}
+	return h, nil
+}
+
+// Index searches for inventory files which are new or have changed since they
+// were last indexed and indexes the files described therein
 func (i *Indexer) Index() error {
 	// It's not an error if we're already running, but we don't want to start again
 	if i.getState() == iStateRunning {
@@ -96,40 +222,354 @@ func (i *Indexer) Index() error {
 
 	i.setState(iStateRunning)
 	defer i.setState(iStateStopped)
+	i.links.reset()
+
+	// Maintenance commands can move or remove folders between runs, so the
+	// category cache only lasts for one
+	i.categories = make(map[string]*category)
+
+	if i.dryRun == nil {
+		i.run = &db.IndexRun{StartedAt: time.Now().UTC()}
+		i.errors = &errorReport{}
+		defer i.finishRun()
+	}
 
 	var files, err = i.findInventoryFiles()
 	if err != nil {
 		return err
 	}
+	if i.force != "" {
+		files, err = i.forcedInventoryFile(files)
+		if err != nil {
+			return err
+		}
+	}
 
 	err = i.dbh.InTransaction(func(op *db.Operation) error {
 		var iop = &indexerOperation{i, op}
-		return iop.findAlreadyIndexedInventoryFiles()
+		var err = iop.findAlreadyIndexedInventoryFiles()
+		if err == nil {
+			err = iop.findCategoryNames()
+		}
+		return err
 	})
 	if err != nil {
 		return err
 	}
 
-	for _, fname := range files {
-		if i.seenInventoryFile(fname) {
-			logger.Debugf("Skipping %q; already indexed this file", fname)
+	if i.dryRun == nil {
+		err = i.dbh.InTransaction(func(op *db.Operation) error {
+			var n, err = op.BackfillFileTypes()
+			if n > 0 {
+				logger.Infof("Set file types on %d previously indexed file(s)", n)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var jobs []*parseJob
+	for _, invFile := range files {
+		var inv = i.seenInventoryFile(invFile.path)
+		var progress *db.IndexProgress
+		if inv != nil {
+			progress = i.progress[inv.ID]
+		}
+		switch {
+		case i.force != "":
+			logger.Infof("Reindexing %q from scratch", invFile.path)
+			if inv == nil {
+				inv = &db.Inventory{}
+			}
+			progress = nil
+		case progress != nil && progress.Filesize == invFile.size && progress.ModTime.Equal(invFile.modTime):
+			logger.Infof("Resuming %q after line %d (%.1f%% done)", invFile.path, progress.LinesDone, progress.Percent())
+			if i.dryRun != nil {
+				i.dryRun.ChangedInventories.add(i.relativePath(invFile))
+			}
+		case progress != nil:
+			logger.Infof("Restarting %q; it has changed since it was partly indexed", invFile.path)
+			progress.Filesize, progress.ModTime = invFile.size, invFile.modTime
+			progress.LinesDone, progress.BytesDone = 0, 0
+			progress.StartedAt = time.Now().UTC().Truncate(time.Second)
+			if i.dryRun != nil {
+				i.dryRun.ChangedInventories.add(i.relativePath(invFile))
+			}
+		case inv == nil:
+			inv = &db.Inventory{}
+			if i.dryRun != nil {
+				i.dryRun.NewInventories.add(i.relativePath(invFile))
+			}
+		case inv.ModTime.IsZero():
+			if i.dryRun == nil {
+				i.recordInventoryStats(inv, invFile)
+				i.run.InventoriesSkipped++
+			}
+			continue
+		case inv.Filesize == invFile.size && inv.ModTime.Equal(invFile.modTime):
+			logger.Debugf("Skipping %q; already indexed this file", invFile.path)
+			if i.run != nil {
+				if inv.ContentHash == "" {
+					i.recordInventoryStats(inv, invFile)
+				}
+				i.run.InventoriesSkipped++
+			}
 			continue
+		default:
+			logger.Infof("Reindexing %q; it has changed since it was last indexed", invFile.path)
+			if i.dryRun != nil {
+				i.dryRun.ChangedInventories.add(i.relativePath(invFile))
+			}
 		}
+		jobs = append(jobs, newParseJob(inv, invFile, progress))
+	}
 
-		err = i.dbh.InTransaction(func(op *db.Operation) error {
+	// Inventories are parsed in parallel, but written one at a time, in order,
+	// so that a file listed in several inventories always ends up attributed
+	// to the same one
+	var done = make(chan struct{})
+	defer close(done)
+	var pending = i.parseInventories(jobs, done)
+	if i.dryRun != nil {
+		return i.writeDryRun(pending)
+	}
+	i.startProgress(jobs)
+	for job := range pending {
+		var p = <-job.result
+		i.progressStarted(p)
+		if p.err == nil && !i.wantInventory(p) {
+			i.run.InventoriesSkipped++
+			i.progressFinished(p)
+			continue
+		}
+		if p.err == nil && i.force != "" {
+			p.err = i.clearInventory(p)
+		}
+		if p.err == nil {
+			p.err = i.writeInventory(p)
+		}
+		i.run.FilesSkipped += p.skipped
+		i.run.RecordErrors += len(p.problems)
+		i.errors.add(i.relativePath(p.file), p)
+		switch {
+		case p.err != nil:
+			logger.Errorf("Error processing %q: %s", p.file.path, p.err)
+			i.run.InventoriesFailed++
+		case p.duplicate:
+			i.run.InventoriesSkipped++
+		default:
+			i.run.InventoriesIndexed++
+		}
+		i.progressFinished(p)
+
+		if i.getState() == iStateStopping {
+			i.run.Stopped = true
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// forcedInventoryFile returns just the inventory set via SetForce from the
+// list of inventories found on disk, or an error if it isn't there
+func (i *Indexer) forcedInventoryFile(files []inventoryFile) ([]inventoryFile, error) {
+	for _, f := range files {
+		if f.path == i.force {
+			return []inventoryFile{f}, nil
+		}
+	}
+	return nil, fmt.Errorf("%q isn't an inventory in any dark archive root", i.force)
+}
+
+// clearInventory removes a forced inventory's record and files ahead of
+// indexing it again.  This is its own transaction, since large inventories
+// are written in several; if indexing then fails, the inventory looks new to
+// the next run, which indexes it normally.
+func (i *Indexer) clearInventory(p *parsedInventory) error {
+	if p.inventory.ID == 0 {
+		return nil
+	}
+	return i.dbh.InTransaction(func(op *db.Operation) error {
+		var n, err = op.ClearInventory(p.inventory)
+		if err == nil {
+			logger.Infof("Removed %d file(s) previously indexed from %q", n, p.file.path)
+			p.inventory.ID = 0
+		}
+		return err
+	})
+}
+
+// wantInventory returns false if indexing is limited to a category and the
+// parsed inventory doesn't list any files in it
+func (i *Indexer) wantInventory(p *parsedInventory) bool {
+	if i.onlyCategory == "" {
+		return true
+	}
+	for _, fr := range p.records {
+		if i.categoryNames[fr.categoryName] {
+			return true
+		}
+	}
+	logger.Debugf("Skipping %q; it lists no files in category %q", p.file.path, i.onlyCategory)
+	return false
+}
+
+// finishRun logs the current run's summary and stores it in the database
+func (i *Indexer) finishRun() {
+	i.run.FinishedAt = time.Now().UTC()
+	if i.c.IndexErrorReportDir != "" && len(i.errors.rows) > 0 {
+		var fname, err = i.errors.write(i.c.IndexErrorReportDir, i.run.StartedAt)
+		if err != nil {
+			logger.Errorf("Unable to write index error report: %s", err)
+		} else {
+			logger.Warnf("Wrote %d problem(s) to index error report %q", len(i.errors.rows), fname)
+			i.run.ErrorReport = fname
+		}
+	}
+	i.finishProgress()
+	logger.Infof("Index run summary: %s", i.run)
+	var err = i.dbh.InTransaction(func(op *db.Operation) error {
+		return op.WriteIndexRun(i.run)
+	})
+	if err != nil {
+		logger.Errorf("Unable to store index run summary: %s", err)
+	}
+	i.lastRun = i.run
+	i.run = nil
+	i.errors = nil
+}
+
+// inTransaction runs the callback in a transaction, as db.InTransaction
+// does, but also takes back the run's file counts if the transaction fails,
+// since none of those files were actually written
+func (i *Indexer) inTransaction(cb func(*db.Operation) error) error {
+	var added, updated = i.run.FilesAdded, i.run.FilesUpdated
+	var err = i.dbh.InTransaction(cb)
+	if err != nil {
+		i.run.FilesAdded, i.run.FilesUpdated = added, updated
+	}
+	return err
+}
+
+// checkpointRecords is how many records a large inventory's chunks hold
+// unless indexing is throttled; progress is checkpointed after each chunk is
+// written
+const checkpointRecords = 10000
+
+// writeInventory stores a parsed inventory.  Most inventories are written in
+// a single transaction, but large ones are written in chunks, checkpointing
+// progress after each chunk so an interrupted run can resume.  A stop
+// request is honored between chunks.  When indexing is throttled, each
+// transaction is followed by a pause.
+func (i *Indexer) writeInventory(p *parsedInventory) error {
+	var skip bool
+	var err = i.dbh.InTransaction(func(op *db.Operation) error {
+		var iop = &indexerOperation{i, op}
+		var err error
+		skip, err = iop.skipDuplicate(p)
+		return err
+	})
+	if err != nil || skip {
+		return err
+	}
+
+	var records = p.remainingRecords()
+	var size = i.chunkSize()
+	var started = time.Now()
+	if len(records) <= size {
+		err = i.inTransaction(func(op *db.Operation) error {
 			var iop = &indexerOperation{i, op}
-			return iop.indexInventoryFile(fname)
+			return iop.indexInventoryFile(p)
+		})
+		i.throttle(started, len(records))
+		return err
+	}
+
+	if p.progress == nil {
+		p.progress = &db.IndexProgress{
+			Filesize:  p.file.size,
+			ModTime:   p.file.modTime,
+			StartedAt: time.Now().UTC().Truncate(time.Second),
+		}
+	}
+	for len(records) > size {
+		var chunk = records[:size]
+		records = records[size:]
+		started = time.Now()
+		var err = i.inTransaction(func(op *db.Operation) error {
+			var iop = &indexerOperation{i, op}
+			return iop.indexChunk(p, chunk)
 		})
 		if err != nil {
-			logger.Errorf("Error processing %q: %s", fname, err)
+			return err
 		}
+		logger.Debugf("Indexed %q through line %d (%.1f%% done)", p.file.path, p.progress.LinesDone, p.progress.Percent())
+		i.progressCheckpoint(p)
+		i.throttle(started, len(chunk))
 
 		if i.getState() == iStateStopping {
+			logger.Infof("Stopped partway through %q; indexing will resume after line %d", p.file.path, p.progress.LinesDone)
 			return nil
 		}
 	}
 
-	return nil
+	// The last chunk finishes the inventory off just like a small one
+	started = time.Now()
+	err = i.inTransaction(func(op *db.Operation) error {
+		var iop = &indexerOperation{i, op}
+		return iop.indexInventoryFile(p)
+	})
+	i.throttle(started, len(records))
+	return err
+}
+
+// writeDryRun indexes every parsed inventory in a single transaction, then
+// rolls it all back.  Inventories which can't be read are reported and
+// skipped, but a database error ends the dry run, since the transaction is
+// no longer usable.
+func (i *Indexer) writeDryRun(pending <-chan *parseJob) error {
+	var err = i.dbh.InTransaction(func(op *db.Operation) error {
+		var iop = &indexerOperation{i, op}
+		for job := range pending {
+			var p = <-job.result
+			if p.err != nil {
+				logger.Errorf("Error processing %q: %s", p.file.path, p.err)
+				i.dryRun.FailedInventories.add(i.relativePath(p.file))
+				continue
+			}
+			for _, prob := range p.problems {
+				i.dryRun.BadRecords.add(fmt.Sprintf("%s line %d: %s", i.relativePath(p.file), prob.line, prob.err))
+			}
+			if !i.wantInventory(p) {
+				continue
+			}
+			var skip, err = iop.skipDuplicate(p)
+			if err == nil && !skip {
+				err = iop.indexInventoryFile(p)
+			}
+			if err != nil {
+				return fmt.Errorf("error processing %q: %s", p.file.path, err)
+			}
+
+			if i.getState() == iStateStopping {
+				break
+			}
+		}
+		return errDryRun
+	})
+	if err == errDryRun {
+		return nil
+	}
+	return err
+}
+
+// LastRun returns the summary of the most recent Index() call, or nil if it
+// hasn't finished one
+func (i *Indexer) LastRun() *db.IndexRun {
+	return i.lastRun
 }
 
 // Stop tells the indexer to stop running Index() when it can do so without
@@ -160,36 +600,120 @@ func (i *Indexer) setState(state int32) {
 	atomic.StoreInt32(&i.state, state)
 }
 
-// findInventoryFiles gathers a list of files matching the Indexer's
-// InventoryPattern that haven't been modified in at least an hour
-func (i *Indexer) findInventoryFiles() ([]string, error) {
-	logger.Debugf("Searching for files matching %q (skipping manifest.csv)", i.c.InventoryPattern)
-	var allFiles, err = filepath.Glob(filepath.Join(i.c.DARoot, i.c.InventoryPattern))
+// recordInventoryStats stores the stats and content hash of an inventory
+// which was indexed before we kept track of them.  We assume it hasn't
+// changed since, because reindexing every old inventory would take hours.
+func (i *Indexer) recordInventoryStats(inv *db.Inventory, invFile inventoryFile) {
+	var hash, err = invFile.hash()
+	if err != nil {
+		logger.Errorf("Unable to record stats for %q: %s", invFile.path, err)
+		return
+	}
+
+	inv.Filesize = invFile.size
+	inv.ModTime = invFile.modTime
+	inv.ContentHash = hash
+	err = i.dbh.Operation().WriteInventory(inv)
+	if err != nil {
+		logger.Errorf("Unable to record stats for %q: %s", invFile.path, err)
+	}
+}
+
+// findInventoryFiles gathers a list of files in each dark archive root
+// matching the Indexer's InventoryPattern, ManifestPattern,
+// JSONInventoryPattern, or BagPattern that haven't been modified recently
+func (i *Indexer) findInventoryFiles() ([]inventoryFile, error) {
+	var files []inventoryFile
+	for _, root := range i.c.Roots {
+		var rootFiles, err = i.findRootInventoryFiles(root)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, rootFiles...)
+	}
+	return files, nil
+}
+
+// findRootInventoryFiles gathers the inventory files in a single dark
+// archive root
+func (i *Indexer) findRootInventoryFiles(root config.Root) ([]inventoryFile, error) {
+	logger.Debugf("Searching %q for files matching %q (skipping manifest.csv)", root.Path, i.c.InventoryPattern)
+	var inventories, err = filepath.Glob(filepath.Join(root.Path, i.c.InventoryPattern))
 	if err != nil {
 		return nil, err
 	}
-	var files []string
-	for _, fname := range allFiles {
-		if strings.HasSuffix(fname, "manifest.csv") {
-			logger.Debugf("Skipping manifest file (%q)", fname)
-			continue
+	var manifests []string
+	if i.c.ManifestPattern != "" {
+		logger.Debugf("Searching %q for manifests matching %q", root.Path, i.c.ManifestPattern)
+		manifests, err = filepath.Glob(filepath.Join(root.Path, i.c.ManifestPattern))
+		if err != nil {
+			return nil, err
+		}
+	}
+	var jsonInventories []string
+	if i.c.JSONInventoryPattern != "" {
+		logger.Debugf("Searching %q for JSON inventories matching %q", root.Path, i.c.JSONInventoryPattern)
+		jsonInventories, err = filepath.Glob(filepath.Join(root.Path, i.c.JSONInventoryPattern))
+		if err != nil {
+			return nil, err
+		}
+	}
+	var bagManifests []string
+	if i.c.BagPattern != "" {
+		logger.Debugf("Searching %q for bags matching %q", root.Path, i.c.BagPattern)
+		bagManifests, err = filepath.Glob(filepath.Join(root.Path, i.c.BagPattern, BagManifestName))
+		if err != nil {
+			return nil, err
+		}
+	}
+	var files []inventoryFile
+	var seen = make(map[string]bool)
+	var add = func(fname string, kind inventoryKind) {
+		if seen[fname] {
+			logger.Debugf("Skipping %q: already found as an inventory", fname)
+			return
 		}
+		seen[fname] = true
+
 		var info, err = os.Stat(fname)
 		if err != nil {
 			logger.Errorf("Skipping %q: could not stat: %s", fname, err)
-			continue
+			return
 		}
-		if time.Since(info.ModTime()) < time.Hour {
+		if time.Since(info.ModTime()) < i.minAge {
 			logger.Debugf("Skipping %q: modified too recently (%s)", fname, info.ModTime())
-			continue
+			return
 		}
 
-		files = append(files, fname)
+		var path = fname
+		if kind == kindBag {
+			path = filepath.Dir(fname)
+		}
+		files = append(files, inventoryFile{path, info.Size(), info.ModTime().UTC(), kind, root})
+	}
+
+	for _, fname := range inventories {
+		if strings.HasSuffix(fname, "manifest.csv") {
+			logger.Debugf("Skipping manifest file (%q)", fname)
+			continue
+		}
+		add(fname, kindInventory)
+	}
+	for _, fname := range manifests {
+		add(fname, kindManifest)
+	}
+	for _, fname := range jsonInventories {
+		add(fname, kindJSON)
+	}
+	for _, fname := range bagManifests {
+		add(fname, kindBag)
 	}
 
 	return files, nil
 }
 
-func (i *Indexer) seenInventoryFile(fname string) bool {
+// seenInventoryFile returns the stored inventory for fname, or nil if it has
+// never been indexed
+func (i *Indexer) seenInventoryFile(fname string) *db.Inventory {
 	return i.seenInventoryFiles[fname]
 }
@@ -1,12 +1,11 @@
 package indexer
 
 import (
-	"bytes"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/uoregon-libraries/gopkg/logger"
 	"github.com/uoregon-libraries/headlamp/src/db"
@@ -23,76 +22,207 @@ type indexerOperation struct {
 // findAlreadyIndexedInventoryFiles caches the list of inventory files already processed
 func (i *indexerOperation) findAlreadyIndexedInventoryFiles() error {
 	var allInventories, err = i.op.AllInventories()
+	if err != nil {
+		return err
+	}
+	var allProgress []*db.IndexProgress
+	allProgress, err = i.op.AllIndexProgress()
+	if err != nil {
+		return err
+	}
 
 	i.Lock()
 	defer i.Unlock()
-	i.seenInventoryFiles = make(map[string]bool)
+	i.progress = make(map[int]*db.IndexProgress)
+	for _, p := range allProgress {
+		i.progress[p.InventoryID] = p
+	}
+	i.seenInventoryFiles = make(map[string]*db.Inventory)
 	for _, inv := range allInventories {
 		// The database indexes everything relative to the dark archive so that the
 		// mount point doesn't have to be immutable.  Pretty great, right?  But
 		// that means we have to prepend the current root here....
-		i.seenInventoryFiles[filepath.Join(i.c.DARoot, inv.Path)] = true
+		var root, err = i.c.RootPath(inv.Root)
+		if err != nil {
+			logger.Warnf("Ignoring inventory %q: %s", inv.Path, err)
+			continue
+		}
+		i.seenInventoryFiles[filepath.Join(root, inv.Path)] = inv
+	}
+	return nil
+}
+
+// findCategoryNames works out which category names the inventories of the
+// category set via SetCategory might use.  A category which doesn't exist yet
+// can only be found by the given name.
+func (i *indexerOperation) findCategoryNames() error {
+	i.categoryNames = make(map[string]bool)
+	if i.onlyCategory == "" {
+		return nil
+	}
+
+	i.categoryNames[i.onlyCategory] = true
+	var c, err = i.op.FindCategoryByName(i.onlyCategory)
+	if err == nil && c == nil {
+		c, err = i.op.FindCategoryByOldName(i.onlyCategory)
+	}
+	if err != nil || c == nil {
+		return err
+	}
+
+	i.categoryNames[c.Name] = true
+	var names []string
+	names, err = i.op.CategoryOldNames(c)
+	for _, name := range names {
+		i.categoryNames[name] = true
 	}
 	return err
 }
 
-// indexInventoryFile stores the given inventory file in the database and then
-// crawls through its contents to index the described archive files
-func (i *indexerOperation) indexInventoryFile(fname string) error {
-	var relativePath = strings.TrimLeft(strings.Replace(fname, i.c.DARoot, "", 1), "/")
-	logger.Debugf("Indexing inventory file %q as %q", fname, relativePath)
+// indexInventoryFile stores the parsed inventory in the database and then
+// indexes the archive files its records describe.  The inventory is the
+// existing record when reindexing a changed file, or an empty one for a new
+// file.
+//
+// Files which a changed inventory no longer lists are flagged as missing by
+// flagUnlistedFiles rather than deleted, since another inventory may still
+// describe them.
+//
+// When resuming from a checkpoint, only the remaining records are indexed,
+// and the checkpoint is removed.
+func (i *indexerOperation) indexInventoryFile(p *parsedInventory) error {
+	logger.Debugf("Indexing inventory file %q as %q", p.file.path, p.inventory.Path)
+
+	var started = time.Now().UTC().Truncate(time.Second)
+	if p.progress != nil {
+		started = p.progress.StartedAt
+	}
 
-	var data, err = ioutil.ReadFile(fname)
+	p.inventory.Filesize = p.file.size
+	p.inventory.ModTime = p.file.modTime
+	p.inventory.ContentHash = p.hash
+	p.inventory.DuplicateOf = 0
+	var err = i.op.WriteInventory(p.inventory)
 	if err != nil {
-		return fmt.Errorf("unable to read inventory file %q: %s", fname, err)
+		return err
 	}
 
-	var inventory = &db.Inventory{Path: relativePath}
-	i.op.WriteInventory(inventory)
-	var records = bytes.Split(data, []byte("\n"))
-	for index, record := range records {
-		i.index(inventory, index, record)
+	for _, fr := range p.remainingRecords() {
+		err = i.index(p.inventory, fr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.progress != nil && p.progress.ID != 0 {
+		err = i.op.DeleteIndexProgress(p.progress)
+		if err != nil {
+			return err
+		}
 	}
 
-	return i.op.Operation.Err()
+	return i.flagUnlistedFiles(p, started)
 }
 
-// index takes the inventory record to create all the folders (real and
-// collapsed) in the database, and then parses the file record data to index
-// the file.  If any database errors occur, the operation halts and the first
-// such error is returned.
-func (i *indexerOperation) index(inventory *db.Inventory, index int, record []byte) (err error) {
-	// Get the inventory record split up and processed
-	var ir *inventoryRecord
-	ir, err = parseInventoryRecord(record, inventory.Path)
-	if err != nil {
-		return fmt.Errorf("unable to parse record #%d (inventory %q): %s", index, inventory.Path, err)
+// skipDuplicate checks the parsed inventory's content hash, and if its files
+// are already indexed, just records the inventory's new stats and returns
+// true.  That's the case when a changed inventory's contents are actually
+// the same as they were (it was re-copied or touched), or when a new
+// inventory is a copy of one we've already indexed (a renamed or duplicated
+// manifest).  Inventories resumed from a checkpoint, and those set via
+// SetForce, are never skipped.
+func (i *indexerOperation) skipDuplicate(p *parsedInventory) (bool, error) {
+	if p.progress != nil || p.hash == "" || i.force != "" {
+		return false, nil
+	}
+
+	if p.inventory.ID != 0 {
+		if p.inventory.ContentHash != p.hash {
+			return false, nil
+		}
+		logger.Infof("Skipping %q; its contents haven't changed", p.file.path)
+	} else {
+		var orig, err = i.op.FindInventoryByContentHash(p.hash)
+		if err != nil || orig == nil {
+			return false, err
+		}
+		logger.Warnf("Skipping %q; it has the same contents as already-indexed inventory %q", p.file.path, orig.Path)
+		p.inventory.ContentHash = p.hash
+		p.inventory.DuplicateOf = orig.ID
 	}
 
-	// Skip headers / empty records
-	if ir == nil {
+	p.duplicate = true
+	p.inventory.Filesize = p.file.size
+	p.inventory.ModTime = p.file.modTime
+	return true, i.op.WriteInventory(p.inventory)
+}
+
+// flagUnlistedFiles marks the files which the inventory used to list, but
+// didn't this time, as missing.  Since files are credited to the last
+// inventory indexed which lists them, a file flagged here could still be in
+// some older, unchanged inventory; it's cleared as soon as an inventory
+// listing it is indexed again.
+//
+// Checkpoints from before we tracked start times have no start time, so
+// inventories resumed from those can't be reconciled.
+func (i *indexerOperation) flagUnlistedFiles(p *parsedInventory, started time.Time) error {
+	if started.IsZero() {
 		return nil
 	}
 
-	var pp *parsedPath
-	pp, err = parsePath(ir.fullPath, i.c.PathFormat)
-	if err != nil {
-		return fmt.Errorf("unable to parse paths in record #%d (inventory %q): %s", index, inventory.Path, err)
+	var files, err = i.op.UnlistedFiles(p.inventory, started)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+	logger.Warnf("Flagging %d file(s) as missing: %q no longer lists them", len(files), p.inventory.Path)
+	if i.dryRun != nil {
+		for _, f := range files {
+			i.dryRun.MissingFiles.add(f.PublicPath)
+		}
+	}
+	return i.op.FlagMissingFiles(files)
+}
+
+// indexChunk indexes a batch of records from a large inventory, then
+// checkpoints the inventory's progress.  The inventory record is created if
+// this is its first chunk, but its stats are left alone until the last chunk
+// is done, so an interrupted run never makes it look fully indexed.
+func (i *indexerOperation) indexChunk(p *parsedInventory, chunk []*fileRecord) error {
+	if p.inventory.ID == 0 {
+		var err = i.op.WriteInventory(p.inventory)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, fr := range chunk {
+		var err = i.index(p.inventory, fr)
+		if err != nil {
+			return err
+		}
 	}
 
-	var category *category
-	category, err = i.findOrCreateCategory(pp.categoryName)
+	var last = chunk[len(chunk)-1]
+	p.progress.InventoryID = p.inventory.ID
+	p.progress.LinesDone = last.line
+	p.progress.BytesDone = last.offset
+	return i.op.WriteIndexProgress(p.progress)
+}
+
+// index takes a parsed file record to create all the folders (real and
+// collapsed) in the database, and then indexes the file itself
+func (i *indexerOperation) index(inventory *db.Inventory, fr *fileRecord) error {
+	var category, err = i.findOrCreateCategory(fr.categoryName)
 	if err != nil {
 		return err
 	}
 
 	var lastFolder *db.Folder
-	lastFolder, err = i.indexPaths(category, ir.fullPath)
+	lastFolder, err = i.indexPaths(category, fr)
 	if err != nil {
 		return err
 	}
 
-	var fr = &fileRecord{ir, pp}
 	return i.indexFile(inventory, category, lastFolder, fr)
 }
 
@@ -103,6 +233,12 @@ func (i *indexerOperation) findOrCreateCategory(cName string) (*category, error)
 	defer i.Unlock()
 
 	if i.categories[cName] == nil {
+		if i.dryRun != nil {
+			var err = i.recordCategory(cName)
+			if err != nil {
+				return nil, err
+			}
+		}
 		var c, err = i.op.FindOrCreateCategory(cName)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't create category %q: %s", cName, err)
@@ -121,15 +257,14 @@ func (i *indexerOperation) findOrCreateCategory(cName string) (*category, error)
 // order to know that for a given collapsed folder, there are a given set of
 // real folders.  Returns the last Folder record created so the file indexer
 // can reuse the work done here.
-func (i *indexerOperation) indexPaths(c *category, fullPath string) (lastPublicFolder *db.Folder, err error) {
-	var pathParts = strings.Split(fullPath, string(os.PathSeparator))
-	var pfLen = len(i.c.PathFormat)
+func (i *indexerOperation) indexPaths(c *category, fr *fileRecord) (lastPublicFolder *db.Folder, err error) {
+	var pathParts = strings.Split(fr.fullPath, string(os.PathSeparator))
 	var publicFolder *db.Folder
 	var realFolder *db.RealFolder
 	var curPath string
 
 	for index, part := range pathParts[:len(pathParts)-1] {
-		var level = index - pfLen
+		var level = index - fr.collapsed
 		curPath = filepath.Join(curPath, part)
 
 		// Since we can't expose files in the ignored/archive date directories, we
@@ -138,20 +273,23 @@ func (i *indexerOperation) indexPaths(c *category, fullPath string) (lastPublicF
 			continue
 		}
 
-		var pp, err = parsePath(curPath, i.c.PathFormat)
-		if err != nil {
-			return nil, err
-		}
+		var publicPath = db.NormalizePath(filepath.Join(pathParts[fr.collapsed : index+1]...))
 
 		// Index the public folder first
-		publicFolder = c.folders[pp.publicPath]
+		publicFolder = c.folders[publicPath]
 		if publicFolder == nil {
-			publicFolder, err = i.op.FindOrCreateFolder(c.Category, lastPublicFolder, pp.publicPath)
+			if i.dryRun != nil {
+				err = i.recordFolder(c, publicPath)
+				if err != nil {
+					return nil, err
+				}
+			}
+			publicFolder, err = i.op.FindOrCreateFolder(c.Category, lastPublicFolder, publicPath)
 			if err != nil {
-				return nil, fmt.Errorf("couldn't build folder %q: %s", pp.publicPath, err)
+				return nil, fmt.Errorf("couldn't build folder %q: %s", publicPath, err)
 			}
 			if level <= 2 {
-				c.folders[pp.publicPath] = publicFolder
+				c.folders[publicPath] = publicFolder
 			}
 		}
 		lastPublicFolder = publicFolder
@@ -174,9 +312,22 @@ func (i *indexerOperation) indexPaths(c *category, fullPath string) (lastPublicF
 
 func (i *indexerOperation) indexFile(inv *db.Inventory, c *category, folder *db.Folder, fr *fileRecord) error {
 	var f = c.buildFile(inv, folder, fr)
-	i.op.Files.Save(f)
-	if i.op.Operation.Err() != nil {
-		return fmt.Errorf("couldn't store file %#v: %s", f, i.op.Operation.Err())
+	if i.dryRun != nil {
+		var err = i.recordFile(f)
+		if err != nil {
+			return err
+		}
+	}
+	var added, err = i.op.UpsertFile(f)
+	if err != nil {
+		return fmt.Errorf("couldn't store file %#v: %s", f, err)
+	}
+	if i.run != nil {
+		if added {
+			i.run.FilesAdded++
+		} else {
+			i.run.FilesUpdated++
+		}
 	}
 	return nil
 }
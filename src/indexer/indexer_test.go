@@ -0,0 +1,195 @@
+package indexer_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/headlamp/src/config"
+	"github.com/uoregon-libraries/headlamp/src/db"
+	"github.com/uoregon-libraries/headlamp/src/indexer"
+)
+
+// testSettings is a minimal settings file; the paths are filled in with
+// fmt.Sprintf
+const testSettings = `
+WEBPATH="http://localhost"
+APPROOT=%q
+DARK_ARCHIVE_PATH=%q
+ARCHIVE_PATH_FORMAT="category/date"
+INVENTORY_FILE_GLOB="*/*/INVENTORY/*.csv"
+ARCHIVE_OUTPUT_LOCATION=%q
+ARCHIVE_LIFETIME_DAYS=7
+SMTP_USER="headlamp@example.org"
+SMTP_PASS="password"
+SMTP_HOST="localhost"
+SMTP_PORT=25
+`
+
+// testArchive is a dark archive in a temporary directory, along with an
+// in-memory database to index it into
+type testArchive struct {
+	t    *testing.T
+	root string
+	conf *config.Config
+	dbh  *db.Database
+}
+
+// newTestArchive sets up an empty dark archive and database
+func newTestArchive(t *testing.T) *testArchive {
+	var dir = t.TempDir()
+	var root = filepath.Join(dir, "dark-archive")
+	var err = os.Mkdir(root, 0700)
+	if err != nil {
+		t.Fatalf("Unable to create dark archive: %s", err)
+	}
+
+	var settings = filepath.Join(dir, "settings")
+	err = ioutil.WriteFile(settings, []byte(fmt.Sprintf(testSettings, dir, root, dir)), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write settings: %s", err)
+	}
+
+	var a = &testArchive{t: t, root: root}
+	a.conf, err = config.Read(settings)
+	if err != nil {
+		t.Fatalf("Unable to read settings: %s", err)
+	}
+	a.dbh, err = db.NewMemory("../../db/migrations")
+	if err != nil {
+		t.Fatalf("Unable to create in-memory database: %s", err)
+	}
+	t.Cleanup(func() { a.dbh.Close() })
+	return a
+}
+
+// write stores the given files, keyed by public path, under the category and
+// archive date, along with an inventory listing them.  The inventory's
+// modification time is set to mtime so tests can tell the indexer it changed.
+func (a *testArchive) write(category, date string, files map[string]string, mtime time.Time) {
+	var base = filepath.Join(a.root, category, date)
+	var lines = []string{"sha256sum,filesize,filename"}
+	for name, contents := range files {
+		var path = filepath.Join(base, name)
+		var err = os.MkdirAll(filepath.Dir(path), 0700)
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(contents), 0600)
+		}
+		if err != nil {
+			a.t.Fatalf("Unable to write %q: %s", path, err)
+		}
+		lines = append(lines, fmt.Sprintf("%064x,%d,%s", len(contents), len(contents), name))
+	}
+
+	var inv = filepath.Join(base, "INVENTORY", "inventory.csv")
+	var err = os.MkdirAll(filepath.Dir(inv), 0700)
+	if err == nil {
+		err = ioutil.WriteFile(inv, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	}
+	if err == nil {
+		err = os.Chtimes(inv, mtime, mtime)
+	}
+	if err != nil {
+		a.t.Fatalf("Unable to write inventory %q: %s", inv, err)
+	}
+}
+
+// index runs the indexer over the archive and returns the run's summary
+func (a *testArchive) index() *db.IndexRun {
+	var i = indexer.New(a.dbh, a.conf)
+	i.SetMinAge(0)
+	var err = i.Index()
+	if err != nil {
+		a.t.Fatalf("Unable to index: %s", err)
+	}
+	return i.LastRun()
+}
+
+// file returns the indexed file at the given path, or nil if there isn't one
+func (a *testArchive) file(category, date, publicPath string) *db.File {
+	var op = a.dbh.Operation()
+	var c, err = op.FindCategoryByName(category)
+	if err != nil || c == nil {
+		a.t.Fatalf("Unable to find category %q (error: %v)", category, err)
+	}
+	var f *db.File
+	f, err = op.FindFileByPublicPath(c.ID, date, publicPath)
+	if err != nil {
+		a.t.Fatalf("Unable to look up %q: %s", publicPath, err)
+	}
+	return f
+}
+
+func TestIndex(t *testing.T) {
+	var a = newTestArchive(t)
+	var old = time.Now().Add(-time.Hour)
+	a.write("Photos", "2018-01-01", map[string]string{
+		"events/party.tif": "party",
+		"top.pdf":          "top",
+	}, old)
+
+	var run = a.index()
+	if run.InventoriesIndexed != 1 || run.FilesAdded != 2 || run.Errors() != 0 {
+		t.Errorf("Expected one inventory with two new files and no errors, got %s", run)
+	}
+
+	var f = a.file("Photos", "2018-01-01", "events/party.tif")
+	if f == nil {
+		t.Fatalf("Expected party.tif to be indexed")
+	}
+	if f.Filesize != 5 || f.Checksum != fmt.Sprintf("%064x", 5) {
+		t.Errorf("Expected party.tif's size and checksum from the inventory, got %d and %q", f.Filesize, f.Checksum)
+	}
+	var op = a.dbh.Operation()
+	var c, err = op.FindCategoryByName("Photos")
+	var folder *db.Folder
+	if err == nil {
+		folder, err = op.FindFolderByPath(c, "events")
+	}
+	if err != nil || folder == nil {
+		t.Errorf("Expected the events folder to be indexed (error: %v)", err)
+	}
+
+	run = a.index()
+	if run.InventoriesIndexed != 0 || run.InventoriesSkipped != 1 {
+		t.Errorf("Expected the unchanged inventory to be skipped, got %s", run)
+	}
+}
+
+func TestReindexFlagsUnlistedFiles(t *testing.T) {
+	var a = newTestArchive(t)
+	a.write("Photos", "2018-01-01", map[string]string{
+		"events/party.tif": "party",
+		"top.pdf":          "top",
+	}, time.Now().Add(-time.Hour*2))
+	a.index()
+
+	// Both runs can land in the same second, so backdate the first run's
+	// files to keep them from looking like the second run indexed them
+	var op = a.dbh.Operation()
+	op.Operation.Exec("UPDATE files SET indexed_at = ?", time.Now().UTC().Add(-time.Hour))
+	if op.Operation.Err() != nil {
+		t.Fatalf("Unable to backdate indexed files: %s", op.Operation.Err())
+	}
+
+	a.write("Photos", "2018-01-01", map[string]string{
+		"events/party.tif": "party",
+	}, time.Now().Add(-time.Hour))
+	var run = a.index()
+	if run.InventoriesIndexed != 1 {
+		t.Errorf("Expected the changed inventory to be reindexed, got %s", run)
+	}
+
+	var top = a.file("Photos", "2018-01-01", "top.pdf")
+	if top == nil || top.MissingSince.IsZero() {
+		t.Errorf("Expected top.pdf to be kept but flagged as missing, got %#v", top)
+	}
+	var party = a.file("Photos", "2018-01-01", "events/party.tif")
+	if party == nil || !party.MissingSince.IsZero() {
+		t.Errorf("Expected party.tif to be kept and not flagged, got %#v", party)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package indexer
+
+import (
+	"os"
+	"syscall"
+)
+
+// inode identifies a file on disk regardless of how many paths lead to it
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// fileInode returns the inode of a file with more than one hard link, or
+// false if it has just the one (or the platform doesn't say)
+func fileInode(info os.FileInfo) (inode, bool) {
+	var st, ok = info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return inode{}, false
+	}
+	return inode{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -0,0 +1,15 @@
+package indexer
+
+import "os"
+
+// inode identifies a file on disk regardless of how many paths lead to it
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// fileInode always returns false, as hard links can't be detected from an
+// os.FileInfo on Windows
+func fileInode(info os.FileInfo) (inode, bool) {
+	return inode{}, false
+}
@@ -0,0 +1,110 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// jsonRecord is a single line of a JSON-lines inventory.  MTime may be a
+// number of Unix seconds or an RFC 3339 string, and may be omitted.
+type jsonRecord struct {
+	Path     string          `json:"path"`
+	Size     *int64          `json:"size"`
+	Checksum string          `json:"checksum"`
+	MTime    json.RawMessage `json:"mtime"`
+}
+
+// readJSONInventoryFile reads a JSON-lines inventory from disk.  Each
+// non-blank line is a JSON object describing one file.  Lines which can't be
+// parsed are returned as bad records.  root is the dark archive root the
+// inventory is in.
+func (i *Indexer) readJSONInventoryFile(inv *db.Inventory, path, root string) ([]*inventoryRecord, error) {
+	var data, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read JSON inventory %q: %s", path, err)
+	}
+
+	var records []*inventoryRecord
+	var offset int64
+	for index, line := range bytes.Split(data, []byte("\n")) {
+		offset += int64(len(line)) + 1
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var ir, err = i.parseJSONRecord(line, inv.Path, root)
+		if err != nil {
+			records = append(records, badRecord(index+1, offset, err))
+			continue
+		}
+		ir.line = index + 1
+		ir.offset = offset
+		records = append(records, ir)
+	}
+
+	return records, nil
+}
+
+// parseJSONRecord converts one JSON object to an inventoryRecord.  Paths work
+// the same as they do in CSV manifests.
+func (i *Indexer) parseJSONRecord(line []byte, inventoryPath, root string) (*inventoryRecord, error) {
+	var jr jsonRecord
+	var err = json.Unmarshal(line, &jr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %s", err)
+	}
+
+	var checksum = strings.ToLower(strings.TrimSpace(jr.Checksum))
+	if checksum == "" {
+		return nil, fmt.Errorf("missing checksum")
+	}
+	if jr.Size == nil || *jr.Size < 0 {
+		return nil, fmt.Errorf("missing or invalid size")
+	}
+	if jr.Path == "" {
+		return nil, fmt.Errorf("missing path")
+	}
+
+	var modTime time.Time
+	modTime, err = parseJSONTime(jr.MTime)
+	if err != nil {
+		return nil, err
+	}
+
+	var fullPath string
+	fullPath, err = i.listedFullPath(jr.Path, inventoryPath, root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &inventoryRecord{fullPath: fullPath, filesize: *jr.Size, checksum: checksum, modTime: modTime}, nil
+}
+
+// parseJSONTime reads an mtime value, which is either a JSON number or a
+// string holding anything parseManifestTime understands
+func parseJSONTime(raw json.RawMessage) (time.Time, error) {
+	var s = string(bytes.TrimSpace(raw))
+	if s == "" || s == "null" {
+		return time.Time{}, nil
+	}
+	if s[0] == '"' {
+		var err = json.Unmarshal(raw, &s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid mtime value %s", raw)
+		}
+		return parseManifestTime(s)
+	}
+
+	var secs, err = strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid mtime value %s", raw)
+	}
+	return time.Unix(int64(secs), 0).UTC(), nil
+}
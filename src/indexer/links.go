@@ -0,0 +1,109 @@
+package indexer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// LinkPolicy says what the indexer does with inventory entries which are
+// symlinks or hard links
+type LinkPolicy int
+
+// The available link policies.  LinkFollow indexes every entry as listed
+// without looking at the disk, which is how the indexer has always worked.
+// LinkSkip leaves out symlinks and all but the first path seen for a
+// hard-linked file.  LinkAlias indexes those entries but records what they
+// link to.
+const (
+	LinkFollow LinkPolicy = iota
+	LinkSkip
+	LinkAlias
+)
+
+// ParseLinkPolicy returns the policy named by s: "follow", "skip", or "alias"
+func ParseLinkPolicy(s string) (LinkPolicy, error) {
+	switch s {
+	case "follow":
+		return LinkFollow, nil
+	case "skip":
+		return LinkSkip, nil
+	case "alias":
+		return LinkAlias, nil
+	}
+	return LinkFollow, fmt.Errorf("unknown link policy %q", s)
+}
+
+// SetLinkPolicy changes how the indexer treats symlinks and hard links
+func (i *Indexer) SetLinkPolicy(p LinkPolicy) {
+	i.links = newLinkChecker(p)
+}
+
+// linkChecker applies a link policy to inventory records.  Parsing happens in
+// several goroutines at once, so the hard-linked files seen so far are
+// guarded by a mutex.
+type linkChecker struct {
+	sync.Mutex
+	policy LinkPolicy
+	seen   map[inode]string
+}
+
+func newLinkChecker(p LinkPolicy) *linkChecker {
+	return &linkChecker{policy: p, seen: make(map[inode]string)}
+}
+
+// reset forgets the hard links seen, so each run starts fresh
+func (lc *linkChecker) reset() {
+	lc.Lock()
+	lc.seen = make(map[inode]string)
+	lc.Unlock()
+}
+
+// check returns false if the record should be skipped.  Under LinkAlias, the
+// record's linkTarget is set when its path is a link.  Entries which can't be
+// found on disk are left alone, as reporting those is the fixity audit's job.
+func (lc *linkChecker) check(root string, ir *inventoryRecord) bool {
+	if lc.policy == LinkFollow {
+		return true
+	}
+
+	var realPath = filepath.Join(root, ir.fullPath)
+	var info, err = os.Lstat(realPath)
+	if err != nil {
+		return true
+	}
+
+	var target string
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err = filepath.EvalSymlinks(realPath)
+		if err != nil {
+			target, _ = os.Readlink(realPath)
+		} else if rel, err := filepath.Rel(root, target); err == nil && !strings.HasPrefix(rel, "..") {
+			target = rel
+		}
+	} else if ino, ok := fileInode(info); ok {
+		lc.Lock()
+		var first, found = lc.seen[ino]
+		if !found {
+			lc.seen[ino] = ir.fullPath
+		}
+		lc.Unlock()
+		if found && first != ir.fullPath {
+			target = first
+		}
+	}
+
+	if target == "" {
+		return true
+	}
+	if lc.policy == LinkSkip {
+		logger.Debugf("Skipping %q: it links to %q", ir.fullPath, target)
+		return false
+	}
+	ir.linkTarget = target
+	return true
+}
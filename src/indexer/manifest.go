@@ -0,0 +1,192 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uoregon-libraries/headlamp/src/config"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// manifestColumns holds the position of each configured column in a
+// manifest's header.  modTime is -1 if the manifest doesn't record it.
+type manifestColumns struct {
+	path     int
+	filesize int
+	checksum int
+	modTime  int
+}
+
+// readManifestHeader finds the configured columns in a manifest's header row
+func readManifestHeader(header []string, mc config.ManifestColumns) (*manifestColumns, error) {
+	var positions = make(map[string]int, len(header))
+	for n, name := range header {
+		positions[strings.ToLower(strings.TrimSpace(name))] = n
+	}
+	var find = func(name string) int {
+		var n, ok = positions[strings.ToLower(name)]
+		if !ok {
+			return -1
+		}
+		return n
+	}
+
+	var cols = &manifestColumns{
+		path:     find(mc.Path),
+		filesize: find(mc.Filesize),
+		checksum: find(mc.Checksum),
+		modTime:  -1,
+	}
+	if mc.ModTime != "" {
+		cols.modTime = find(mc.ModTime)
+	}
+	for name, n := range map[string]int{mc.Path: cols.path, mc.Filesize: cols.filesize, mc.Checksum: cols.checksum} {
+		if n < 0 {
+			return nil, fmt.Errorf("header has no %q column", name)
+		}
+	}
+	return cols, nil
+}
+
+// readManifestFile reads a CSV manifest from disk.  root is the dark archive
+// root the manifest is in.
+func (i *Indexer) readManifestFile(inv *db.Inventory, path, root string) ([]*inventoryRecord, error) {
+	var data, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest %q: %s", path, err)
+	}
+	var records []*inventoryRecord
+	records, err = i.readManifest(data, inv.Path, root)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest %q: %s", path, err)
+	}
+	return records, nil
+}
+
+// readManifest returns the records in a CSV manifest.  The first non-blank
+// line must be the header.  Fields may be quoted, but a record can't span
+// multiple lines, so that every record's line number is known.
+func (i *Indexer) readManifest(data []byte, manifestPath, root string) ([]*inventoryRecord, error) {
+	var cols *manifestColumns
+	var records []*inventoryRecord
+	var offset int64
+	for index, line := range bytes.Split(data, []byte("\n")) {
+		offset += int64(len(line)) + 1
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 {
+			continue
+		}
+
+		var fields, err = csv.NewReader(bytes.NewReader(line)).Read()
+		if err != nil {
+			records = append(records, badRecord(index+1, offset, err))
+			continue
+		}
+
+		if cols == nil {
+			cols, err = readManifestHeader(fields, i.c.ManifestColumns)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var ir *inventoryRecord
+		ir, err = i.parseManifestRecord(fields, cols, manifestPath, root)
+		if err != nil {
+			records = append(records, badRecord(index+1, offset, err))
+			continue
+		}
+		ir.line = index + 1
+		ir.offset = offset
+		records = append(records, ir)
+	}
+
+	return records, nil
+}
+
+// parseManifestRecord converts one manifest record's fields to an
+// inventoryRecord.  Relative paths are relative to the manifest's directory.
+func (i *Indexer) parseManifestRecord(fields []string, cols *manifestColumns, manifestPath, root string) (*inventoryRecord, error) {
+	var field = func(n int) string {
+		if n < 0 || n >= len(fields) {
+			return ""
+		}
+		return strings.TrimSpace(fields[n])
+	}
+
+	var checksum = strings.ToLower(field(cols.checksum))
+	if checksum == "" {
+		return nil, fmt.Errorf("missing checksum")
+	}
+
+	var filesizeString = field(cols.filesize)
+	var filesize, err = strconv.ParseInt(filesizeString, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filesize value %q", filesizeString)
+	}
+
+	var modTime time.Time
+	if cols.modTime >= 0 {
+		modTime, err = parseManifestTime(field(cols.modTime))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var relPath = field(cols.path)
+	if relPath == "" {
+		return nil, fmt.Errorf("missing path")
+	}
+	var fullPath string
+	fullPath, err = i.listedFullPath(relPath, manifestPath, root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &inventoryRecord{fullPath: fullPath, filesize: filesize, checksum: checksum, modTime: modTime}, nil
+}
+
+// listedFullPath converts a path listed in a manifest to a path relative to
+// the dark archive root.  Relative paths are relative to the manifest's
+// directory, and absolute paths must be within the manifest's dark archive
+// root.
+func (i *Indexer) listedFullPath(relPath, manifestPath, root string) (string, error) {
+	if filepath.IsAbs(relPath) {
+		var rootPrefix = filepath.Clean(root) + string(filepath.Separator)
+		if !strings.HasPrefix(relPath, rootPrefix) {
+			return "", fmt.Errorf("path %q is outside the dark archive", relPath)
+		}
+		return filepath.Clean(strings.TrimPrefix(relPath, rootPrefix)), nil
+	}
+
+	var fullPath = filepath.Clean(filepath.Join(filepath.Dir(manifestPath), relPath))
+	if strings.HasPrefix(fullPath, "..") {
+		return "", fmt.Errorf("path %q is outside the dark archive", relPath)
+	}
+	return fullPath, nil
+}
+
+// parseManifestTime accepts either Unix seconds or an RFC 3339 timestamp.
+// Blank values are allowed and leave the time unset.
+func parseManifestTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	var secs, err = strconv.ParseInt(s, 10, 64)
+	if err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	var t time.Time
+	t, err = time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid mtime value %q", s)
+	}
+	return t.UTC(), nil
+}
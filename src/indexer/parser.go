@@ -0,0 +1,221 @@
+package indexer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// parsedInventory holds everything read from a single inventory file, ready
+// to be written to the database
+type parsedInventory struct {
+	inventory *db.Inventory
+	file      inventoryFile
+	records   []*fileRecord
+	err       error
+
+	// progress is the checkpoint for an inventory which was partly indexed,
+	// or one started for a large inventory; it's nil otherwise
+	progress *db.IndexProgress
+
+	// hash is the SHA-256 of the inventory file's contents, and duplicate is
+	// set once we find that an already-indexed inventory had the same hash
+	hash      string
+	duplicate bool
+
+	// skipped counts the records left out due to exclude globs or the link
+	// policy, and problems lists those which couldn't be parsed
+	skipped  int
+	problems []recordProblem
+}
+
+// remainingRecords returns the records which haven't been indexed yet: all
+// of them unless we're resuming from a checkpoint
+func (p *parsedInventory) remainingRecords() []*fileRecord {
+	if p.progress == nil {
+		return p.records
+	}
+	for n, fr := range p.records {
+		if fr.line > p.progress.LinesDone {
+			return p.records[n:]
+		}
+	}
+	return nil
+}
+
+// parseJob is a single inventory waiting to be parsed.  The parser sends the
+// result to the job's channel when it's done.
+type parseJob struct {
+	inventory *db.Inventory
+	file      inventoryFile
+	progress  *db.IndexProgress
+	result    chan *parsedInventory
+}
+
+// parseInventories starts the configured number of parser goroutines and
+// feeds them the jobs.  The returned channel yields each job, in the order
+// given, as soon as it has been handed off; readers wait on the job's result
+// channel to get its parsed data.  The channel's buffer bounds how far the
+// parsers can get ahead of the reader, so we never hold more than a handful
+// of parsed inventories in memory.
+//
+// Closing done tells the feeder to stop handing out jobs.  Parsers finish
+// whatever they're working on and exit.
+func (i *Indexer) parseInventories(jobs []*parseJob, done <-chan struct{}) <-chan *parseJob {
+	var workers = i.c.IndexWorkers
+	var work = make(chan *parseJob)
+	var pending = make(chan *parseJob, workers)
+
+	for n := 0; n < workers; n++ {
+		go func() {
+			for job := range work {
+				var p = i.parseInventoryFile(job.inventory, job.file)
+				p.progress = job.progress
+				job.result <- p
+			}
+		}()
+	}
+
+	go func() {
+		defer close(pending)
+		defer close(work)
+		for _, job := range jobs {
+			select {
+			case pending <- job:
+			case <-done:
+				return
+			}
+			select {
+			case work <- job:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return pending
+}
+
+// newParseJob creates a job with a result channel big enough that a parser
+// never blocks sending to it, even if nobody ends up reading the result
+func newParseJob(inv *db.Inventory, f inventoryFile, progress *db.IndexProgress) *parseJob {
+	return &parseJob{inventory: inv, file: f, progress: progress, result: make(chan *parsedInventory, 1)}
+}
+
+// parseInventoryFile reads and parses every record in the inventory.  Records
+// which can't be parsed are logged and skipped, as they have always been;
+// only a failure to read the file is returned as an error.
+func (i *Indexer) parseInventoryFile(inv *db.Inventory, f inventoryFile) *parsedInventory {
+	var p = &parsedInventory{inventory: inv, file: f}
+	inv.Root = f.root.Name
+	inv.Path = strings.TrimLeft(strings.Replace(f.path, f.root.Path, "", 1), "/")
+
+	var records []*inventoryRecord
+	var err error
+	switch f.kind {
+	case kindBag:
+		records, err = i.readBag(inv, f.path, f.root.Path)
+	case kindManifest:
+		records, err = i.readManifestFile(inv, f.path, f.root.Path)
+	case kindJSON:
+		records, err = i.readJSONInventoryFile(inv, f.path, f.root.Path)
+	default:
+		records, err = readInventoryFile(inv, f.path)
+	}
+	if err != nil {
+		p.err = err
+		return p
+	}
+
+	p.hash, err = f.hash()
+	if err != nil {
+		p.err = err
+		return p
+	}
+
+	for _, ir := range records {
+		if ir.err != nil {
+			logger.Errorf("Unable to parse record #%d (inventory %q): %s", ir.line-1, inv.Path, ir.err)
+			p.problems = append(p.problems, recordProblem{ir.line, ir.err})
+			continue
+		}
+		if excluded(ir.fullPath, i.c.ExcludeGlobs) || !i.links.check(f.root.Path, ir) {
+			p.skipped++
+			continue
+		}
+
+		var pp, err = parsePath(ir.fullPath, i.c)
+		if err != nil {
+			logger.Errorf("Unable to parse paths in record #%d (inventory %q): %s", ir.line-1, inv.Path, err)
+			p.problems = append(p.problems, recordProblem{ir.line, fmt.Errorf("invalid path %q: %s", ir.fullPath, err)})
+			continue
+		}
+
+		p.records = append(p.records, &fileRecord{ir, pp})
+	}
+	if p.skipped > 0 {
+		logger.Debugf("Skipped %d excluded or linked record(s) in inventory %q", p.skipped, inv.Path)
+	}
+
+	return p
+}
+
+// hashFile returns the hex-encoded SHA-256 of the file's contents
+func hashFile(path string) (string, error) {
+	var f, err = os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var h = sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readInventoryFile reads one of our own inventory files from disk
+func readInventoryFile(inv *db.Inventory, path string) ([]*inventoryRecord, error) {
+	var data, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read inventory file %q: %s", path, err)
+	}
+	return readInventory(data, inv.Path), nil
+}
+
+// readInventory returns the records in one of our own inventory files.
+// Records which can't be parsed are returned as bad records.
+func readInventory(data []byte, inventoryPath string) []*inventoryRecord {
+	var records []*inventoryRecord
+	var lines = bytes.Split(data, []byte("\n"))
+	var withModTime = string(bytes.TrimSpace(lines[0])) == modTimeHeader
+	var offset int64
+	for index, line := range lines {
+		offset += int64(len(line)) + 1
+		var ir, err = parseInventoryRecord(line, inventoryPath, withModTime)
+		if err != nil {
+			records = append(records, badRecord(index+1, offset, err))
+			continue
+		}
+
+		// Skip headers / empty records
+		if ir == nil {
+			continue
+		}
+		ir.line = index + 1
+		ir.offset = offset
+		records = append(records, ir)
+	}
+
+	return records
+}
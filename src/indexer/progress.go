@@ -0,0 +1,109 @@
+package indexer
+
+import (
+	"time"
+)
+
+// Progress describes how far along an index run is.  Bytes count the
+// inventory data the run has to get through, which tracks the work left far
+// better than a count of inventories does when their sizes vary widely.
+type Progress struct {
+	StartedAt        time.Time
+	InventoriesTotal int
+	InventoriesDone  int
+	CurrentInventory string
+	FilesProcessed   int
+	BytesTotal       int64
+	BytesDone        int64
+	Finished         bool
+
+	// bytesFinished counts the inventories which are done, while
+	// currentOffset is where the current one started if it was resumed from
+	// a checkpoint; together they let chunk checkpoints add to BytesDone
+	bytesFinished int64
+	currentOffset int64
+}
+
+// ETA estimates when the run will finish from how fast it's gone so far.  A
+// zero time is returned until there's enough to go on.
+func (p Progress) ETA() time.Time {
+	if p.Finished || p.BytesDone <= 0 || p.BytesTotal <= 0 {
+		return time.Time{}
+	}
+	var elapsed = time.Since(p.StartedAt)
+	var left = float64(p.BytesTotal-p.BytesDone) / float64(p.BytesDone) * float64(elapsed)
+	return time.Now().Add(time.Duration(left))
+}
+
+// A ProgressReporter is told how each index run is going: once the run knows
+// which inventories it has to index, whenever it starts or finishes one (and
+// after each checkpoint of a large inventory), and when the run is over.
+// Reports come from the goroutine doing the writing, so a slow reporter slows
+// indexing down.  Dry runs aren't reported.
+type ProgressReporter interface {
+	ReportProgress(p Progress)
+}
+
+// SetProgressReporter sets the reporter told about each run's progress, or
+// removes it if r is nil
+func (i *Indexer) SetProgressReporter(r ProgressReporter) {
+	i.reporter = r
+}
+
+// startProgress sets up the run's progress once the inventories to index
+// are known
+func (i *Indexer) startProgress(jobs []*parseJob) {
+	i.status = &Progress{StartedAt: i.run.StartedAt, InventoriesTotal: len(jobs)}
+	for _, job := range jobs {
+		i.status.BytesTotal += job.file.size
+		if job.progress != nil {
+			i.status.BytesTotal -= job.progress.BytesDone
+		}
+	}
+	i.reportProgress()
+}
+
+// progressStarted notes that the given inventory is being written
+func (i *Indexer) progressStarted(p *parsedInventory) {
+	i.status.CurrentInventory = i.relativePath(p.file)
+	i.status.currentOffset = 0
+	if p.progress != nil {
+		i.status.currentOffset = p.progress.BytesDone
+	}
+	i.reportProgress()
+}
+
+// progressCheckpoint notes that a chunk of a large inventory was written
+func (i *Indexer) progressCheckpoint(p *parsedInventory) {
+	i.status.BytesDone = i.status.bytesFinished + p.progress.BytesDone - i.status.currentOffset
+	i.reportProgress()
+}
+
+// progressFinished notes that the given inventory is done, whether it was
+// indexed, skipped, or failed
+func (i *Indexer) progressFinished(p *parsedInventory) {
+	i.status.InventoriesDone++
+	i.status.bytesFinished += p.file.size - i.status.currentOffset
+	i.status.BytesDone = i.status.bytesFinished
+	i.status.CurrentInventory = ""
+	i.reportProgress()
+}
+
+// finishProgress reports the end of the run
+func (i *Indexer) finishProgress() {
+	if i.status == nil {
+		return
+	}
+	i.status.Finished = true
+	i.status.CurrentInventory = ""
+	i.reportProgress()
+	i.status = nil
+}
+
+func (i *Indexer) reportProgress() {
+	if i.reporter == nil || i.status == nil {
+		return
+	}
+	i.status.FilesProcessed = i.run.FilesAdded + i.run.FilesUpdated + i.run.FilesSkipped
+	i.reporter.ReportProgress(*i.status)
+}
@@ -10,13 +10,32 @@ import (
 	"time"
 
 	"github.com/uoregon-libraries/headlamp/src/config"
+	"github.com/uoregon-libraries/headlamp/src/db"
 )
 
+// modTimeHeader is the header line for inventories which include each file's
+// modification time (as Unix seconds) between the filesize and filename
+const modTimeHeader = "sha256sum,filesize,mtime,filename"
+
 // inventoryRecord stores the raw data found on a single line of an inventory file
 type inventoryRecord struct {
 	fullPath string
 	filesize int64
 	checksum string
+	modTime  time.Time
+	line     int
+
+	// offset is the byte offset just past the record's line, used to track
+	// how far through an inventory we've gotten
+	offset int64
+
+	// err is set on a bad record: a line which couldn't be parsed, and which
+	// only carries its position in the inventory
+	err error
+
+	// linkTarget is what the record's path links to, if it's a link and the
+	// indexer's link policy is LinkAlias
+	linkTarget string
 }
 
 // parsedPath holds the processed / extracted data created by running a full
@@ -25,6 +44,10 @@ type parsedPath struct {
 	categoryName string
 	archiveDate  string
 	publicPath   string
+
+	// collapsed is how many leading elements of the real path were removed
+	// to form the public path
+	collapsed int
 }
 
 // a fileRecord holds all the inventory and path data for the construction of a
@@ -34,18 +57,30 @@ type fileRecord struct {
 	*parsedPath
 }
 
-// parseInventoryRecord splits the three components of the inventory file line,
+// badRecord returns a record for a line which couldn't be parsed, so the
+// error can be logged and counted along with the inventory's other problems
+func badRecord(line int, offset int64, err error) *inventoryRecord {
+	return &inventoryRecord{line: line, offset: offset, err: err}
+}
+
+// parseInventoryRecord splits the components of the inventory file line,
 // performs some validation, translates the full path (since that's relative to
-// the inventory file location) and returns the data
-func parseInventoryRecord(record []byte, inventoryPath string) (*inventoryRecord, error) {
+// the inventory file location) and returns the data.  If withModTime is true,
+// the line is expected to have a modification time between the filesize and
+// filename.
+func parseInventoryRecord(record []byte, inventoryPath string, withModTime bool) (*inventoryRecord, error) {
 	// Skip the blank record at the end
 	if len(record) == 0 {
 		return nil, nil
 	}
 
-	// We sometimes have filenames with commas, but the sha and filesize are
-	// always safe, so we just split to 3 elements
-	var recParts = bytes.SplitN(record, []byte(","), 3)
+	// We sometimes have filenames with commas, but the other fields are always
+	// safe, so we just split to the expected number of elements
+	var fieldCount = 3
+	if withModTime {
+		fieldCount = 4
+	}
+	var recParts = bytes.SplitN(record, []byte(","), fieldCount)
 
 	// Skip headers
 	var checksum = string(recParts[0])
@@ -53,9 +88,8 @@ func parseInventoryRecord(record []byte, inventoryPath string) (*inventoryRecord
 		return nil, nil
 	}
 
-	// We should always have exactly 3 fields
-	if len(recParts) != 3 {
-		return nil, fmt.Errorf("there must be exactly 3 fields")
+	if len(recParts) != fieldCount {
+		return nil, fmt.Errorf("there must be exactly %d fields", fieldCount)
 	}
 
 	var filesizeString = string(recParts[1])
@@ -64,16 +98,97 @@ func parseInventoryRecord(record []byte, inventoryPath string) (*inventoryRecord
 		return nil, fmt.Errorf("invalid filesize value %q", filesizeString)
 	}
 
+	var modTime time.Time
+	if withModTime {
+		var mtimeString = string(recParts[2])
+		var mtime, err = strconv.ParseInt(mtimeString, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mtime value %q", mtimeString)
+		}
+		modTime = time.Unix(mtime, 0).UTC()
+	}
+
 	// The filename is relative to the inventory file's parent directory
-	var relPath = string(recParts[2])
+	var relPath = string(recParts[fieldCount-1])
 	var fullPath = filepath.Clean(filepath.Join(filepath.Dir(inventoryPath), "..", relPath))
 
-	return &inventoryRecord{fullPath: fullPath, filesize: filesize, checksum: checksum}, nil
+	return &inventoryRecord{fullPath: fullPath, filesize: filesize, checksum: checksum, modTime: modTime}, nil
+}
+
+// parsePath runs the full path through the first custom path rule which
+// matches it, falling back to the path format when no rule does, to get the
+// category, archive date, and public path.  The category and public path are
+// normalized to NFC so that files deposited from different operating systems
+// end up in the same folders.
+func parsePath(fullPath string, c *config.Config) (*parsedPath, error) {
+	var pp, err = parseRawPath(fullPath, c)
+	if pp != nil {
+		pp.categoryName = db.NormalizePath(pp.categoryName)
+		pp.publicPath = db.NormalizePath(pp.publicPath)
+	}
+	return pp, err
+}
+
+// parseRawPath does the work for parsePath, leaving names exactly as they
+// appear in the real path
+func parseRawPath(fullPath string, c *config.Config) (*parsedPath, error) {
+	for _, rule := range c.PathRules {
+		var pp, err = parseRulePath(fullPath, rule)
+		if pp != nil || err != nil {
+			return pp, err
+		}
+	}
+	return parseFormattedPath(fullPath, c.PathFormat)
+}
+
+// parseRulePath processes the full path against a custom path rule.  If the
+// rule doesn't match, the returned parsedPath and error are both nil.
+func parseRulePath(fullPath string, rule *config.PathRule) (*parsedPath, error) {
+	var m = rule.Pattern.FindStringSubmatchIndex(fullPath)
+	if m == nil {
+		return nil, nil
+	}
+
+	var pp = &parsedPath{categoryName: rule.Category}
+	var publicStart, publicEnd = -1, -1
+	for n, name := range rule.Pattern.SubexpNames() {
+		var start, end = m[n*2], m[n*2+1]
+		if start < 0 {
+			continue
+		}
+		switch name {
+		case "category":
+			pp.categoryName = fullPath[start:end]
+		case "date":
+			pp.archiveDate = fullPath[start:end]
+		case "public":
+			publicStart, publicEnd = start, end
+		}
+	}
+
+	// The public path has to be whole path elements at the end of the real
+	// path so that folders can be collapsed the same way as their files
+	var sep = string(os.PathSeparator)
+	if publicStart < 0 || publicStart == publicEnd || publicEnd != len(fullPath) ||
+		(publicStart > 0 && fullPath[publicStart-1:publicStart] != sep) {
+		return nil, fmt.Errorf("path rule %q must capture the end of path %q as its public path", rule.Pattern, fullPath)
+	}
+	if pp.categoryName == "" || strings.Contains(pp.categoryName, sep) {
+		return nil, fmt.Errorf("path rule %q captured invalid category name %q from path %q", rule.Pattern, pp.categoryName, fullPath)
+	}
+	var err = validateArchiveDate(pp.archiveDate)
+	if err != nil {
+		return nil, err
+	}
+
+	pp.publicPath = fullPath[publicStart:]
+	pp.collapsed = strings.Count(fullPath[:publicStart], sep)
+	return pp, nil
 }
 
-// parsePath splits apart the full path and processes it against the given path
-// tokens to get the category, archive data, and public path
-func parsePath(fullPath string, pf []config.PathToken) (*parsedPath, error) {
+// parseFormattedPath splits apart the full path and processes it against the
+// given path tokens to get the category, archive data, and public path
+func parseFormattedPath(fullPath string, pf []config.PathToken) (*parsedPath, error) {
 	var partCount = len(pf) + 1
 	var pathParts = strings.SplitN(fullPath, string(os.PathSeparator), partCount)
 	if len(pathParts) != partCount {
@@ -93,12 +208,20 @@ func parsePath(fullPath string, pf []config.PathToken) (*parsedPath, error) {
 		}
 	}
 
-	// Make sure the date matches our expected format
+	var err = validateArchiveDate(dateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &parsedPath{categoryName: categoryName, archiveDate: dateDir, publicPath: publicPath, collapsed: len(pf)}, nil
+}
+
+// validateArchiveDate makes sure the date matches our expected format
+func validateArchiveDate(dateDir string) error {
 	var timeFormat = "2006-01-02"
 	var _, err = time.Parse(timeFormat, dateDir)
 	if err != nil {
-		return nil, fmt.Errorf("archive date directory %q must be formatted as a date (YYYY-MM-DD)", dateDir)
+		return fmt.Errorf("archive date directory %q must be formatted as a date (YYYY-MM-DD)", dateDir)
 	}
-
-	return &parsedPath{categoryName: categoryName, archiveDate: dateDir, publicPath: publicPath}, nil
+	return nil
 }
@@ -0,0 +1,181 @@
+package indexer
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+	"github.com/uoregon-libraries/headlamp/src/db"
+)
+
+// FolderReindexReport describes what ReindexFolder did
+type FolderReindexReport struct {
+	// Inventories lists the inventories which were read again, and Missing
+	// lists those which couldn't be found on disk
+	Inventories []string
+	Missing     []string
+
+	// Indexed counts the records written, and Removed counts the files beneath
+	// the folder which no inventory puts there any more
+	Indexed int
+	Removed int
+}
+
+// diskPath identifies a file on disk by its dark archive root's name and its
+// path within that root
+type diskPath struct {
+	root string
+	path string
+}
+
+// ReindexFolder rebuilds the records beneath f from the inventories that
+// describe its files, for fixing isolated indexing mistakes without a full
+// reindex.  Every record in those inventories for a file beneath f, or whose
+// public path now puts it beneath f, is indexed again; whatever's left beneath
+// f afterward is removed, along with any folders which end up empty.  Since
+// files keep their records when they're reindexed, restrictions on files and
+// folders which are still there are kept.
+//
+// Everything is parsed before anything is written, and it's all written in a
+// single transaction, so a failure leaves the folder as it was.
+func (i *Indexer) ReindexFolder(f *db.Folder) (*FolderReindexReport, error) {
+	if i.getState() == iStateRunning {
+		return nil, fmt.Errorf("the indexer is already running")
+	}
+	i.setState(iStateRunning)
+	defer i.setState(iStateStopped)
+	i.links.reset()
+	i.categories = make(map[string]*category)
+
+	var r = &FolderReindexReport{}
+	var c *db.Category
+	var listed = make(map[diskPath]bool)
+	var inventories []*db.Inventory
+	var err = i.dbh.InTransaction(func(op *db.Operation) error {
+		var err error
+		c, err = op.FindCategoryByID(f.CategoryID)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			return fmt.Errorf("folder %q has no category", f.PublicPath)
+		}
+
+		var files []*db.File
+		files, err = op.FolderFiles(f)
+		if err != nil {
+			return err
+		}
+		var ids = make(map[int]bool)
+		for _, file := range files {
+			listed[diskPath{file.Root, file.FullPath}] = true
+			ids[file.InventoryID] = true
+		}
+		inventories, err = findInventories(op, ids)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed []*parsedInventory
+	for _, inv := range inventories {
+		var invFile, err = i.locateInventory(inv)
+		if err != nil {
+			return nil, err
+		}
+		if invFile == nil {
+			r.Missing = append(r.Missing, inv.Path)
+			continue
+		}
+		var p = i.parseInventoryFile(inv, *invFile)
+		if p.err != nil {
+			return nil, p.err
+		}
+		r.Inventories = append(r.Inventories, i.relativePath(*invFile))
+		parsed = append(parsed, p)
+	}
+
+	// Reindexed files get a new IndexedAt, so anything beneath f which is
+	// older than this afterward wasn't reindexed
+	var started = time.Now().UTC()
+	var prefix = f.PublicPath + string(filepath.Separator)
+	err = i.dbh.InTransaction(func(op *db.Operation) error {
+		var iop = &indexerOperation{i, op}
+		var err = op.ClearRealFolders(f)
+		if err != nil {
+			return err
+		}
+		for _, p := range parsed {
+			for _, fr := range p.records {
+				var beneath = fr.categoryName == c.Name && strings.HasPrefix(fr.publicPath, prefix)
+				if !beneath && !listed[diskPath{p.inventory.Root, fr.fullPath}] {
+					continue
+				}
+				err = iop.index(p.inventory, fr)
+				if err != nil {
+					return err
+				}
+				r.Indexed++
+			}
+		}
+		r.Removed, err = op.PruneFolder(f, started)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Reindexed %q in category %q: %d record(s) from %d inventories, %d file(s) removed",
+		f.PublicPath, c.Name, r.Indexed, len(r.Inventories), r.Removed)
+	return r, nil
+}
+
+// findInventories loads the inventories with the given ids, in path order
+func findInventories(op *db.Operation, ids map[int]bool) ([]*db.Inventory, error) {
+	var inventories []*db.Inventory
+	for id := range ids {
+		var inv, err = op.FindInventoryByID(id)
+		if err != nil {
+			return nil, err
+		}
+		if inv != nil {
+			inventories = append(inventories, inv)
+		}
+	}
+	sort.Slice(inventories, func(a, b int) bool {
+		return inventories[a].Root+inventories[a].Path < inventories[b].Root+inventories[b].Path
+	})
+	return inventories, nil
+}
+
+// locateInventory finds an already-indexed inventory on disk.  Inventories
+// don't record what kind they are, so we look for the inventory among the
+// files matching the configured globs.  Returns nil if it isn't there.
+func (i *Indexer) locateInventory(inv *db.Inventory) (*inventoryFile, error) {
+	// The inventory has been indexed before, so there's no reason to wait for
+	// it to settle
+	var minAge = i.minAge
+	i.minAge = 0
+	defer func() { i.minAge = minAge }()
+
+	for _, root := range i.c.Roots {
+		if root.Name != inv.Root {
+			continue
+		}
+		var files, err = i.findRootInventoryFiles(root)
+		if err != nil {
+			return nil, err
+		}
+		var path = filepath.Join(root.Path, inv.Path)
+		for _, f := range files {
+			if f.path == path {
+				return &f, nil
+			}
+		}
+	}
+	return nil, nil
+}
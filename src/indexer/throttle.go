@@ -0,0 +1,47 @@
+package indexer
+
+import (
+	"time"
+)
+
+// throttleSeconds is about how long each transaction's worth of records
+// should take to write when indexing is throttled.  Keeping it short means
+// the indexer never holds the database for long, and pauses often enough
+// that other processes get their turn at the storage system.
+const throttleSeconds = 10
+
+// throttlePoll is how often a throttle pause checks for a stop request
+const throttlePoll = time.Second
+
+// chunkSize returns how many records a large inventory's chunks hold.
+// Throttled runs use smaller chunks so that the pauses come between short
+// transactions rather than after long ones.
+func (i *Indexer) chunkSize() int {
+	var rate = i.c.IndexFilesPerSecond
+	if rate > 0 && rate*throttleSeconds < checkpointRecords {
+		return rate * throttleSeconds
+	}
+	return checkpointRecords
+}
+
+// throttle pauses after n records were written, starting at started, until
+// they've taken as long as INDEX_FILES_PER_SECOND allows.  A stop request
+// cuts the pause short.
+func (i *Indexer) throttle(started time.Time, n int) {
+	var rate = i.c.IndexFilesPerSecond
+	if rate <= 0 || n == 0 {
+		return
+	}
+
+	var until = started.Add(time.Duration(n) * time.Second / time.Duration(rate))
+	for i.getState() != iStateStopping {
+		var wait = time.Until(until)
+		if wait <= 0 {
+			return
+		}
+		if wait > throttlePoll {
+			wait = throttlePoll
+		}
+		time.Sleep(wait)
+	}
+}
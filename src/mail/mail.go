@@ -0,0 +1,19 @@
+// Package mail sends the plain-text notifications headlamp's commands email
+// out: archive confirmations and results, failure notices, and search alerts
+package mail
+
+import (
+	"fmt"
+	"net/smtp"
+
+	"github.com/uoregon-libraries/headlamp/src/config"
+)
+
+// Send emails body to every address in to, using the SMTP server and account
+// from conf.  The SMTP user is also the sender.
+func Send(conf *config.Config, to []string, subject, body string) error {
+	var auth = smtp.PlainAuth("", conf.SMTPUser, conf.SMTPPass, conf.SMTPHost)
+	var msg = fmt.Sprintf("Subject: %s\r\n\r\n%s\r\n", subject, body)
+	var server = fmt.Sprintf("%s:%d", conf.SMTPHost, conf.SMTPPort)
+	return smtp.SendMail(server, auth, conf.SMTPUser, to, []byte(msg))
+}
@@ -0,0 +1,276 @@
+// Package metrics collects counters and timings in memory and writes them in
+// the Prometheus text format, so headlamp can be monitored without pulling in
+// a full client library
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the histogram bucket boundaries, in seconds, used for
+// request and query timings
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metric is anything which can write itself out for a scrape
+type metric interface {
+	write(w *bufio.Writer) error
+}
+
+// registry holds every metric in the order it was created, so scrapes are
+// always written the same way
+var registry struct {
+	sync.Mutex
+	metrics []metric
+}
+
+func register(m metric) {
+	registry.Lock()
+	registry.metrics = append(registry.metrics, m)
+	registry.Unlock()
+}
+
+// WriteAll writes every metric to w.  If a scrape-time function fails, its
+// error is returned and the output should be thrown away.
+func WriteAll(w io.Writer) error {
+	registry.Lock()
+	var list = make([]metric, len(registry.metrics))
+	copy(list, registry.metrics)
+	registry.Unlock()
+
+	var bw = bufio.NewWriter(w)
+	for _, m := range list {
+		var err = m.write(bw)
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// desc is the name, help text, type, and label names every metric has
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, strings.Replace(d.help, "\n", " ", -1))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.typ)
+}
+
+// labelString formats label values as {name="value",...}, with extra
+// name/value pairs appended after the metric's own labels
+func (d desc) labelString(values []string, extra ...string) string {
+	var parts []string
+	for i, name := range d.labels {
+		var v string
+		if i < len(values) {
+			v = values[i]
+		}
+		parts = append(parts, name+`="`+escape(v)+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+`="`+escape(extra[i+1])+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// key joins label values into a map key; the separator can't appear in
+// any sane label value
+func key(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// Counter is a value which only goes up, optionally split out by labels
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+	order  map[string][]string
+}
+
+// NewCounter creates and registers a counter with the given label names
+func NewCounter(name, help string, labels ...string) *Counter {
+	var c = &Counter{
+		desc:   desc{name: name, help: help, typ: "counter", labels: labels},
+		values: make(map[string]float64),
+		order:  make(map[string][]string),
+	}
+	register(c)
+	return c
+}
+
+// Inc adds one to the counter for the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds n to the counter for the given label values
+func (c *Counter) Add(n float64, labelValues ...string) {
+	var k = key(labelValues)
+	c.mu.Lock()
+	if _, ok := c.order[k]; !ok {
+		c.order[k] = append([]string(nil), labelValues...)
+	}
+	c.values[k] += n
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w *bufio.Writer) error {
+	c.writeHeader(w)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range sortedKeys(c.order) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(c.order[k]), formatFloat(c.values[k]))
+	}
+	return nil
+}
+
+// series is one label combination's histogram data
+type series struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Histogram counts observations into buckets, optionally split out by labels
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*series
+}
+
+// NewHistogram creates and registers a histogram with the given bucket upper
+// bounds, which must be sorted, and label names
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	var h = &Histogram{
+		desc:    desc{name: name, help: help, typ: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	register(h)
+	return h
+}
+
+// Observe records a single value for the given label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	var k = key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var s = h.series[k]
+	if s == nil {
+		s = &series{labels: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// ObserveDuration records the time elapsed since start, in seconds
+func (h *Histogram) ObserveDuration(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *Histogram) write(w *bufio.Writer) error {
+	h.writeHeader(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var keys []string
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var s = h.series[k]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(s.labels, "le", formatFloat(upper)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(s.labels), s.count)
+	}
+	return nil
+}
+
+// Sample is a single value read at scrape time
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// funcMetric reads its values from a function at scrape time, for data which
+// lives somewhere else, like the database
+type funcMetric struct {
+	desc
+	fn func() ([]Sample, error)
+}
+
+// NewGaugeFunc registers a gauge whose samples come from fn on each scrape
+func NewGaugeFunc(name, help string, fn func() ([]Sample, error), labels ...string) {
+	register(&funcMetric{desc: desc{name: name, help: help, typ: "gauge", labels: labels}, fn: fn})
+}
+
+// NewCounterFunc registers a counter whose samples come from fn on each
+// scrape; fn must never report a smaller value than it did before
+func NewCounterFunc(name, help string, fn func() ([]Sample, error), labels ...string) {
+	register(&funcMetric{desc: desc{name: name, help: help, typ: "counter", labels: labels}, fn: fn})
+}
+
+func (m *funcMetric) write(w *bufio.Writer) error {
+	var samples, err = m.fn()
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %s", m.name, err)
+	}
+	m.writeHeader(w)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, m.labelString(s.Labels), formatFloat(s.Value))
+	}
+	return nil
+}
+
+func sortedKeys(m map[string][]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
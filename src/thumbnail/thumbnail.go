@@ -0,0 +1,207 @@
+// Package thumbnail makes small JPEG previews of archived images, caching
+// them on disk so each master only has to be decoded once
+package thumbnail
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/image/draw"
+
+	// Decoders for the other master formats we can thumbnail; image/jpeg is
+	// already imported for encoding
+	_ "golang.org/x/image/tiff"
+	_ "image/png"
+)
+
+// ErrUnsupported is returned for files which aren't an image format we can
+// decode
+var ErrUnsupported = errors.New("unsupported image format")
+
+// ErrTooLarge is returned for images with more pixels than we're willing to
+// hold in memory at once
+var ErrTooLarge = errors.New("image too large to thumbnail")
+
+// maxPixels caps how big a master can be before we refuse to decode it.
+// Decoded images take at least four bytes per pixel, so this keeps a single
+// thumbnail under roughly a gigabyte of memory.
+const maxPixels = 250 * 1000 * 1000
+
+// maxConcurrent is how many thumbnails are generated at once; more requests
+// wait their turn so a page full of new thumbnails can't exhaust memory
+const maxConcurrent = 2
+
+// supportedTypes are the MIME types we can decode
+var supportedTypes = map[string]bool{
+	"image/tiff": true,
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+// Supported returns true if files of the given MIME type can be thumbnailed
+func Supported(mimeType string) bool {
+	return supportedTypes[mimeType]
+}
+
+// Cache generates thumbnails on demand and keeps them in a directory.  A
+// cached thumbnail is reused until its master is modified.
+type Cache struct {
+	Dir  string
+	Size int // Size is the longest edge of a thumbnail, in pixels
+
+	m     sync.Mutex
+	locks map[string]*keyLock
+	slots chan struct{}
+}
+
+// keyLock serializes work on one thumbnail, so two requests for the same new
+// thumbnail don't both generate it
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// New returns a cache storing size-pixel thumbnails in dir
+func New(dir string, size int) *Cache {
+	return &Cache{Dir: dir, Size: size, locks: make(map[string]*keyLock), slots: make(chan struct{}, maxConcurrent)}
+}
+
+// Get returns the path to the thumbnail for the image at src, generating it
+// first if there isn't a current one.  key must uniquely identify the image,
+// such as its file ID.
+func (c *Cache) Get(key, src string) (string, error) {
+	var srcInfo, err = os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+
+	var thumbPath = filepath.Join(c.Dir, fmt.Sprintf("%s-%d.jpg", key, c.Size))
+	var unlock = c.lock(key)
+	defer unlock()
+
+	var info os.FileInfo
+	info, err = os.Stat(thumbPath)
+	if err == nil && !info.ModTime().Before(srcInfo.ModTime()) {
+		return thumbPath, nil
+	}
+
+	c.slots <- struct{}{}
+	defer func() { <-c.slots }()
+
+	err = c.generate(src, thumbPath)
+	if err != nil {
+		return "", err
+	}
+	return thumbPath, nil
+}
+
+// lock takes the lock for key, returning the function which releases it
+func (c *Cache) lock(key string) func() {
+	c.m.Lock()
+	var l = c.locks[key]
+	if l == nil {
+		l = &keyLock{}
+		c.locks[key] = l
+	}
+	l.refs++
+	c.m.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		c.m.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(c.locks, key)
+		}
+		c.m.Unlock()
+	}
+}
+
+// generate scales the image at src down and writes it to dst as a JPEG.  The
+// thumbnail is written to a temp file and renamed so a half-written file is
+// never served.
+func (c *Cache) generate(src, dst string) error {
+	var f, err = os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var cfg image.Config
+	cfg, _, err = image.DecodeConfig(f)
+	if err == image.ErrFormat {
+		return ErrUnsupported
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read image header: %s", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return ErrUnsupported
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return ErrTooLarge
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	var img image.Image
+	img, _, err = image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("unable to decode image: %s", err)
+	}
+
+	var thumb = image.NewRGBA(thumbBounds(img.Bounds(), c.Size))
+	draw.ApproxBiLinear.Scale(thumb, thumb.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var out *os.File
+	out, err = ioutil.TempFile(c.Dir, ".wip-thumb-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	err = jpeg.Encode(out, thumb, &jpeg.Options{Quality: 80})
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write thumbnail: %s", err)
+	}
+	return os.Rename(out.Name(), dst)
+}
+
+// thumbBounds returns the bounds of a thumbnail for an image with the given
+// bounds: scaled so the longest edge is size, keeping the aspect ratio.
+// Images already smaller than that aren't enlarged.
+func thumbBounds(b image.Rectangle, size int) image.Rectangle {
+	var w, h = b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return image.Rect(0, 0, w, h)
+	}
+	if w >= h {
+		h = h * size / w
+		w = size
+	} else {
+		w = w * size / h
+		h = size
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return image.Rect(0, 0, w, h)
+}
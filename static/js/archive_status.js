@@ -0,0 +1,16 @@
+document.addEventListener('DOMContentLoaded', function () {
+  var status = document.getElementById("archive-status");
+  if (status == null || status.dataset["events"] == null || window.EventSource == null) {
+    return;
+  }
+
+  // Each message is the status section, rendered fresh by the server whenever
+  // the request moves along; "done" means it won't change again
+  var source = new EventSource(status.dataset["events"]);
+  source.onmessage = function(e) {
+    status.innerHTML = e.data;
+  };
+  source.addEventListener("done", function() {
+    source.close();
+  });
+})
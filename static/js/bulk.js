@@ -7,17 +7,37 @@ document.addEventListener('DOMContentLoaded', function () {
   }
 })
 
+// csrfToken returns the token the server put in the page, which has to go
+// along with anything that changes data
+function csrfToken() {
+  var meta = document.querySelector("meta[name=csrf-token]");
+  return meta == null ? "" : meta.getAttribute("content");
+}
+
 function clickCallback(btn) {
   return function(e) {
     btn.setAttribute("disabled", "disabled");
     var postLocation = btn.dataset["action"];
-    fetch(postLocation, {method: "POST", credentials: "same-origin"}).then(function(response) {
+    var headers = {"X-CSRF-Token": csrfToken()};
+    fetch(postLocation, {method: "POST", credentials: "same-origin", headers: headers}).then(function(response) {
+      if (response.status == 409) {
+        btn.removeAttribute("disabled");
+        return response.text().then(function(msg) {
+          alert(msg);
+          return null;
+        });
+      }
       if (response.status != 200) {
         btn.removeAttribute("disabled");
         alert("Error!  Please try again or contact support.");
         return;
       }
 
+      var summary = document.getElementById("queue-summary");
+      if (summary != null && response.headers.get("X-Queue-Summary") != null) {
+        summary.textContent = response.headers.get("X-Queue-Summary");
+      }
+
       var id = btn.dataset["toggleOnSuccess"];
       var el = document.getElementById(id);
 
@@ -0,0 +1,13 @@
+document.addEventListener('DOMContentLoaded', function () {
+  var status = document.getElementById("index-run-status");
+  if (status == null || status.dataset["events"] == null || window.EventSource == null) {
+    return;
+  }
+
+  // Each message is the index run section, rendered fresh by the server
+  // whenever the indexer reports more progress
+  var source = new EventSource(status.dataset["events"]);
+  source.onmessage = function(e) {
+    status.innerHTML = e.data;
+  };
+})
@@ -0,0 +1,31 @@
+document.addEventListener('DOMContentLoaded', function () {
+  var status = document.getElementById("index-status");
+  if (status != null && status.dataset["refresh"] != null) {
+    setTimeout(refreshIndexStatus, parseInt(status.dataset["refresh"], 10));
+  }
+})
+
+// refreshIndexStatus reloads the page in the background and swaps in its
+// status section, continuing for as long as a request is waiting or running
+function refreshIndexStatus() {
+  fetch(window.location.href, {credentials: "same-origin"}).then(function(response) {
+    if (response.status != 200) {
+      return null;
+    }
+    return response.text();
+  }).then(function(body) {
+    if (body == null) {
+      return;
+    }
+    var doc = new DOMParser().parseFromString(body, "text/html");
+    var fresh = doc.getElementById("index-status");
+    var status = document.getElementById("index-status");
+    if (fresh == null || status == null) {
+      return;
+    }
+    status.innerHTML = fresh.innerHTML;
+    if (fresh.dataset["refresh"] != null) {
+      setTimeout(refreshIndexStatus, parseInt(fresh.dataset["refresh"], 10));
+    }
+  });
+}
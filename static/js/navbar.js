@@ -0,0 +1,21 @@
+// Opens and closes the collapsed navigation menu on narrow screens, which
+// bootstrap would otherwise need jQuery and its own scripts for
+document.addEventListener('DOMContentLoaded', function () {
+  var toggles = document.querySelectorAll(".navbar-toggle[data-target]");
+  for (var i = 0; i < toggles.length; i++) {
+    var btn = toggles[i];
+    btn.addEventListener("click", toggleCallback(btn));
+  }
+})
+
+function toggleCallback(btn) {
+  return function(e) {
+    var menu = document.querySelector(btn.getAttribute("data-target"));
+    if (menu == null) {
+      return;
+    }
+    var open = menu.classList.toggle("in");
+    btn.classList.toggle("collapsed", !open);
+    btn.setAttribute("aria-expanded", open ? "true" : "false");
+  }
+}